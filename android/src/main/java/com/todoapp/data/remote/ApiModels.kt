@@ -95,6 +95,8 @@ data class UpdateTaskRequest(
 
 data class SyncRequest(
     @SerializedName("last_sync_at") val lastSyncAt: String,
+    @SerializedName("last_seq") val lastSeq: Int?,
+    @SerializedName("device_id") val deviceId: String,
     @SerializedName("changes") val changes: List<DeltaChangeRequest>
 )
 
@@ -109,6 +111,7 @@ data class SyncResponse(
     @SerializedName("server_changes") val serverChanges: List<ServerChange>,
     @SerializedName("client_changes") val clientChanges: List<ClientChange>,
     @SerializedName("last_sync_at") val lastSyncAt: String,
+    @SerializedName("last_seq") val lastSeq: Int,
     @SerializedName("conflicts") val conflicts: List<Conflict>
 )
 
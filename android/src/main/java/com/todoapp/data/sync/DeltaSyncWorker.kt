@@ -31,6 +31,8 @@ class DeltaSyncWorker(
             val userId = getCurrentUserId()
             val syncMeta = database.syncMetaDao().getSyncMeta(userId)
             val lastSyncAt = syncMeta?.lastSyncAt ?: ""
+            // Omit the cursor on the first sync so the server resumes from this device's progress
+            val lastSeq = syncMeta?.lastServerVersion
 
             val changes: List<DeltaChangeRequest> = pendingDeltas.map { delta ->
                 DeltaChangeRequest(
@@ -41,7 +43,7 @@ class DeltaSyncWorker(
                 )
             }
 
-            val request = SyncRequest(lastSyncAt, changes)
+            val request = SyncRequest(lastSyncAt, lastSeq, getDeviceId(), changes)
             val response = apiService.sync(request)
 
             if (!response.isSuccessful || response.body() == null) {
@@ -87,7 +89,8 @@ class DeltaSyncWorker(
             database.syncMetaDao().insertSyncMeta(
                 SyncMeta(
                     userId = userId,
-                    lastSyncAt = syncResponse.lastSyncAt
+                    lastSyncAt = syncResponse.lastSyncAt,
+                    lastServerVersion = syncResponse.lastSeq
                 )
             )
 
@@ -101,6 +104,20 @@ class DeltaSyncWorker(
         }
     }
 
+    // Same device id as used for pairing; the server tracks sync progress per device
+    private fun getDeviceId(): String {
+        val prefs = applicationContext.getSharedPreferences("TodoAppPrefs", Context.MODE_PRIVATE)
+        var deviceId = prefs.getString("device_id", null)
+        if (deviceId.isNullOrEmpty()) {
+            deviceId = android.provider.Settings.Secure.getString(
+                applicationContext.contentResolver,
+                android.provider.Settings.Secure.ANDROID_ID
+            ) ?: java.util.UUID.randomUUID().toString()
+            prefs.edit().putString("device_id", deviceId).apply()
+        }
+        return deviceId
+    }
+
     private fun getCurrentUserId(): String {
         return try {
             // Extract user ID from access token (JWT payload)
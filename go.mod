@@ -6,11 +6,9 @@ require (
 	github.com/golang-jwt/jwt/v4 v4.4.3
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/mattn/go-sqlite3 v1.14.13
 	golang.org/x/crypto v0.14.0
 )
 
-require (
-	github.com/felixge/httpsnoop v1.0.1 // indirect
-	github.com/gorilla/websocket v1.5.3 // indirect
-)
+require github.com/felixge/httpsnoop v1.0.1 // indirect
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"todoapp/internal/types"
+)
+
+// ErrInvalidCursor 游标格式错误或签名校验失败
+var ErrInvalidCursor = errors.New("无效的分页游标")
+
+// SignCursor 将分页游标编码为带 HMAC 签名的不透明令牌
+func SignCursor(c *types.Cursor) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + cursorSignature(encoded), nil
+}
+
+// ParseCursor 校验签名并解析分页游标令牌
+func ParseCursor(token string) (*types.Cursor, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(cursorSignature(parts[0]))) {
+		return nil, ErrInvalidCursor
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c types.Cursor
+	if err := json.Unmarshal(payload, &c); err != nil || c.Key == "" {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// cursorSignature 使用 JWT 密钥计算游标签名（与令牌签名使用不同前缀以区分用途）
+func cursorSignature(encoded string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("cursor:" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,64 @@
+// Package blobstore 提供附件等二进制对象的存储：本地文件系统实现与 S3 兼容实现
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("blobstore: object not found")
+
+// ErrInvalidKey 对象键为空、以 / 开头或包含 . / .. 路径段
+var ErrInvalidKey = errors.New("blobstore: invalid key")
+
+// Store 按键存取二进制对象，键由 / 分隔的路径段组成
+type Store interface {
+	// Put 写入对象，已存在时覆盖；body 需恰好包含 size 个字节
+	Put(ctx context.Context, key string, body io.ReadSeeker, size int64, contentType string) error
+	// Get 读取对象，不存在时返回 ErrNotFound，调用方负责关闭
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不视为错误
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidateKey 检查对象键能否安全地映射为文件路径与 URL 路径
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// NewFromEnv 按环境变量创建存储：BLOB_STORE 为 local（默认，目录为 BLOB_DIR，默认 ./attachments）或 s3
+// s3 使用 S3_ENDPOINT、S3_REGION、S3_BUCKET、S3_ACCESS_KEY_ID、S3_SECRET_ACCESS_KEY，S3_PATH_STYLE=true 时使用路径风格的 URL
+func NewFromEnv() (Store, error) {
+	switch kind := os.Getenv("BLOB_STORE"); kind {
+	case "", "local":
+		dir := os.Getenv("BLOB_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		return NewLocal(dir)
+	case "s3":
+		return NewS3(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+		})
+	default:
+		return nil, fmt.Errorf("blobstore: unknown BLOB_STORE %q", kind)
+	}
+}
@@ -0,0 +1,137 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"a.txt", true},
+		{"1/2/report.pdf", true},
+		{"1/2/..hidden", true},
+		{"1/会议纪要.md", true},
+		{"", false},
+		{"/etc/passwd", false},
+		{"..", false},
+		{"../x", false},
+		{"1/../../x", false},
+		{"1/./x", false},
+		{"1//x", false},
+		{"1/x/", false},
+		{"..\\x", false},
+		{"1/x\x00.txt", false},
+	}
+	for _, tt := range tests {
+		err := ValidateKey(tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("ValidateKey(%q) = %v, want nil", tt.key, err)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("ValidateKey(%q) = %v, want ErrInvalidKey", tt.key, err)
+		}
+	}
+}
+
+func TestLocalPutGetDelete(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "blobs")
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	key := "1/2/report.pdf"
+
+	for _, data := range [][]byte{[]byte("first"), []byte("overwritten")} {
+		if err := l.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/pdf"); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		rc, err := l.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Get = %q, %v; want %q", got, err, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "1", "2", "report.pdf")); err != nil {
+		t.Errorf("object not stored under root: %v", err)
+	}
+
+	if err := l.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := l.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after delete: err = %v, want ErrNotFound", err)
+	}
+	if err := l.Delete(ctx, key); err != nil {
+		t.Errorf("Delete twice: %v", err)
+	}
+}
+
+func TestLocalPutSizeMismatch(t *testing.T) {
+	root := t.TempDir()
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := l.Put(ctx, "1/short.txt", strings.NewReader("abc"), 10, ""); err == nil {
+		t.Fatal("Put with wrong size succeeded")
+	}
+	// 写了一半的对象和临时文件都不应留下
+	if _, err := l.Get(ctx, "1/short.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after failed Put: err = %v, want ErrNotFound", err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(root, "1"))
+	if len(entries) != 0 {
+		t.Errorf("failed Put left %d files behind", len(entries))
+	}
+}
+
+func TestLocalRejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "blobs")
+	l, err := NewLocal(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// 根目录之外已有的文件不能被读取、覆盖或删除
+	outside := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"../secret.txt", "1/../../secret.txt", "..", "/" + outside, "1/./x", "..\\secret.txt"} {
+		if err := l.Put(ctx, key, strings.NewReader("pwned"), 5, ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if rc, err := l.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			if rc != nil {
+				rc.Close()
+			}
+			t.Errorf("Get(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+		if err := l.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q): err = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	if data, err := os.ReadFile(outside); err != nil || string(data) != "secret" {
+		t.Errorf("file outside root changed: %q, %v", data, err)
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// recordActivityTx 记录任务的系统事件（创建、状态变化、改派、恢复），评论单独保存在 task_comments 中
+func recordActivityTx(tx *sql.Tx, actorID int, taskID int64, activityType string, data map[string]interface{}) error {
+	var encoded interface{}
+	if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		encoded = string(b)
+	}
+	_, err := tx.Exec("INSERT INTO task_activity (task_id, actor_id, type, data, created_at) VALUES (?, ?, ?, ?, ?)",
+		taskID, actorID, activityType, encoded, time.Now().UTC())
+	return err
+}
+
+// recordStatusChangeTx 任务状态将变为 status 时记录 status_changed 事件，状态不变时不记录；需在写入新状态之前调用
+func recordStatusChangeTx(tx *sql.Tx, actorID int, taskID int64, status string) error {
+	var current sql.NullString
+	if err := tx.QueryRow("SELECT status FROM tasks WHERE id = ?", taskID).Scan(&current); err != nil {
+		return err
+	}
+	if current.String == status {
+		return nil
+	}
+	return recordActivityTx(tx, actorID, taskID, types.ActivityStatusChanged, map[string]interface{}{"from": current.String, "to": status})
+}
+
+// userIDValue 将用户 ID 转换为事件数据中的取值，0 对应 null
+func userIDValue(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// LatestActivityID 获取当前最大的系统事件 ID，写入前记录，写入后据此推送新产生的事件
+func LatestActivityID(q Querier) (int64, error) {
+	var id sql.NullInt64
+	err := q.QueryRow("SELECT MAX(id) FROM task_activity").Scan(&id)
+	return id.Int64, err
+}
+
+// CommentActivityItem 将评论包装为任务动态中的条目
+func CommentActivityItem(c *types.TaskComment) types.ActivityItem {
+	return types.ActivityItem{
+		ID:         c.ID,
+		Type:       types.ActivityComment,
+		TaskID:     c.TaskID,
+		ActorID:    c.UserID,
+		ActorEmail: c.Email,
+		Comment:    c,
+		CreatedAt:  c.CreatedAt,
+	}
+}
+
+// GetActivitySince 获取 actorID 在 afterID 之后产生的系统事件，按产生顺序排列
+func GetActivitySince(actorID int, afterID int64) ([]types.ActivityItem, error) {
+	rows, err := DB.Query(`
+		SELECT a.id, a.task_id, a.actor_id, COALESCE(u.email, ''), a.type, a.data, a.created_at
+		FROM task_activity a LEFT JOIN users u ON u.id = a.actor_id
+		WHERE a.id > ? AND a.actor_id = ? ORDER BY a.id`, afterID, actorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []types.ActivityItem{}
+	for rows.Next() {
+		var item types.ActivityItem
+		var data sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.TaskID, &item.ActorID, &item.ActorEmail, &item.Type, &data, &createdAt); err != nil {
+			return nil, err
+		}
+		if data.Valid {
+			if err := json.Unmarshal([]byte(data.String), &item.Data); err != nil {
+				return nil, err
+			}
+		}
+		item.CreatedAt = formatDueAt(createdAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// GetTaskActivity 分页获取任务动态：未删除的评论与系统事件按时间倒序合并，返回当前页与总数
+func GetTaskActivity(userID int, taskID int64, page, pageSize int) ([]types.ActivityItem, int, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := DB.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM task_comments WHERE task_id = ? AND is_deleted = 0) +
+		       (SELECT COUNT(*) FROM task_activity WHERE task_id = ?)`, taskID, taskID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT f.id, f.actor_id, COALESCE(u.email, ''), f.type, f.data, f.created_at
+		FROM (
+			SELECT id, user_id AS actor_id, 'comment' AS type, NULL AS data, created_at FROM task_comments WHERE task_id = ? AND is_deleted = 0
+			UNION ALL
+			SELECT id, actor_id, type, data, created_at FROM task_activity WHERE task_id = ?
+		) f LEFT JOIN users u ON u.id = f.actor_id
+		ORDER BY f.created_at DESC, f.type = 'comment' DESC, f.id DESC
+		LIMIT ? OFFSET ?`, taskID, taskID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items := []types.ActivityItem{}
+	for rows.Next() {
+		item := types.ActivityItem{TaskID: taskID}
+		var data sql.NullString
+		var createdAt sql.NullTime
+		if err := rows.Scan(&item.ID, &item.ActorID, &item.ActorEmail, &item.Type, &data, &createdAt); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		if data.Valid {
+			if err := json.Unmarshal([]byte(data.String), &item.Data); err != nil {
+				rows.Close()
+				return nil, 0, err
+			}
+		}
+		item.CreatedAt = formatDueAt(createdAt)
+		items = append(items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// 评论条目附带完整的评论内容
+	for i := range items {
+		if items[i].Type != types.ActivityComment {
+			continue
+		}
+		c, err := getComment(DB, items[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		items[i].Comment = c
+	}
+	return items, total, nil
+}
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// ErrInvalidAssignee 负责人不存在或对任务没有编辑权限
+var ErrInvalidAssignee = errors.New("负责人必须是对任务有编辑权限的用户")
+
+// TaskAssignment 任务的创建者、负责人与状态，处理器在写入前后各读取一次，据此发送指派与完成通知
+type TaskAssignment struct {
+	TaskID     int64
+	Title      string
+	CreatorID  int
+	AssigneeID int // 0 表示未指派
+	Status     string
+}
+
+// setTaskAssigneeTx 设置任务的负责人（0 表示取消指派），负责人需对任务有编辑权限；任务的版本号由调用方分配
+// 负责人的权限取决于任务所在的项目，调用方需在设置项目之后调用；负责人变化时以 actorID 记录 reassigned 事件
+func setTaskAssigneeTx(tx *sql.Tx, actorID int, taskID int64, assigneeID int) error {
+	var current sql.NullInt64
+	if err := tx.QueryRow("SELECT assignee_id FROM tasks WHERE id = ?", taskID).Scan(&current); err != nil {
+		return err
+	}
+	if int(current.Int64) == assigneeID {
+		return nil
+	}
+	if assigneeID == 0 {
+		if _, err := tx.Exec("UPDATE tasks SET assignee_id = NULL WHERE id = ?", taskID); err != nil {
+			return err
+		}
+		return recordReassignTx(tx, actorID, taskID, int(current.Int64), 0)
+	}
+	if err := checkTaskAccessAny(tx, assigneeID, taskID, types.ProjectRoleEditor); err != nil {
+		if err == ErrTaskNotFound || err == ErrTaskForbidden {
+			return ErrInvalidAssignee
+		}
+		return err
+	}
+	if _, err := tx.Exec("UPDATE tasks SET assignee_id = ? WHERE id = ?", assigneeID, taskID); err != nil {
+		return err
+	}
+	return recordReassignTx(tx, actorID, taskID, int(current.Int64), assigneeID)
+}
+
+// recordReassignTx 记录负责人从 from 变为 to 的 reassigned 事件，0 表示未指派
+func recordReassignTx(tx *sql.Tx, actorID int, taskID int64, from, to int) error {
+	return recordActivityTx(tx, actorID, taskID, types.ActivityReassigned, map[string]interface{}{
+		"from": userIDValue(from),
+		"to":   userIDValue(to),
+	})
+}
+
+// SetSyncTaskAssignee 在同步事务中设置任务的负责人，任务的版本号已由同步写入分配
+func SetSyncTaskAssignee(tx *sql.Tx, actorID int, taskID int64, assigneeID int) error {
+	return setTaskAssigneeTx(tx, actorID, taskID, assigneeID)
+}
+
+// unassignLostTx 取消已失去编辑权限的负责人（如被移出项目、任务移出共享项目），返回其中未删除的任务
+// 任务的版本号由调用方分配，取消指派以 actorID 记录 reassigned 事件
+func unassignLostTx(tx *sql.Tx, actorID int, taskIDs []int64) ([]int64, error) {
+	var cleared []int64
+	for _, id := range taskIDs {
+		var assigneeID sql.NullInt64
+		var isDeleted sql.NullBool
+		if err := tx.QueryRow("SELECT assignee_id, is_deleted FROM tasks WHERE id = ?", id).Scan(&assigneeID, &isDeleted); err != nil {
+			return nil, err
+		}
+		if !assigneeID.Valid {
+			continue
+		}
+		err := checkTaskAccessAny(tx, int(assigneeID.Int64), id, types.ProjectRoleEditor)
+		if err == nil {
+			continue
+		}
+		if err != ErrTaskForbidden {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET assignee_id = NULL WHERE id = ?", id); err != nil {
+			return nil, err
+		}
+		if err := recordReassignTx(tx, actorID, id, int(assigneeID.Int64), 0); err != nil {
+			return nil, err
+		}
+		if !isDeleted.Bool {
+			cleared = append(cleared, id)
+		}
+	}
+	return cleared, nil
+}
+
+// unassignMemberTx 成员被移出项目或降为 viewer 后取消其在项目中的指派，受影响的未删除任务分配新版本号
+func unassignMemberTx(tx *sql.Tx, actorID int, deviceID string, projectID int64, memberID int) error {
+	ids, err := queryIDs(tx, "SELECT id FROM tasks WHERE project_id = ? AND assignee_id = ? ORDER BY id", projectID, memberID)
+	if err != nil {
+		return err
+	}
+	cleared, err := unassignLostTx(tx, actorID, ids)
+	if err != nil {
+		return err
+	}
+	return touchTasks(tx, actorID, deviceID, cleared)
+}
+
+// GetTaskAssignments 读取任务的指派状态，不存在的任务不包含在结果中
+func GetTaskAssignments(q Querier, taskIDs []int64) (map[int64]TaskAssignment, error) {
+	result := make(map[int64]TaskAssignment, len(taskIDs))
+	for _, id := range taskIDs {
+		a := TaskAssignment{TaskID: id}
+		var title, status sql.NullString
+		var assigneeID sql.NullInt64
+		err := q.QueryRow("SELECT title, user_id, assignee_id, status FROM tasks WHERE id = ?", id).
+			Scan(&title, &a.CreatorID, &assigneeID, &status)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.Title = title.String
+		a.AssigneeID = int(assigneeID.Int64)
+		a.Status = status.String
+		result[id] = a
+	}
+	return result, nil
+}
+
+// GetAssigneeWorkload 统计每个用户被指派的未删除任务：未完成数、逾期数与 since 之后的完成数，按未完成数降序排列
+// 同时返回尚未指派的未完成任务数
+func GetAssigneeWorkload(since time.Time) ([]types.UserWorkload, int, error) {
+	now := time.Now().UTC()
+	rows, err := DB.Query(`
+		SELECT u.id, u.email,
+		       COUNT(CASE WHEN t.status IN ('todo', 'in_progress') THEN 1 END),
+		       COUNT(CASE WHEN t.status IN ('todo', 'in_progress') AND t.due_at IS NOT NULL AND t.due_at < ? THEN 1 END),
+		       COUNT(CASE WHEN t.status = 'done' AND t.completed_at >= ? THEN 1 END)
+		FROM users u LEFT JOIN tasks t ON t.assignee_id = u.id AND t.is_deleted = 0
+		GROUP BY u.id, u.email
+		ORDER BY 3 DESC, 4 DESC, u.id`, now, since.UTC())
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	workload := []types.UserWorkload{}
+	for rows.Next() {
+		var w types.UserWorkload
+		if err := rows.Scan(&w.UserID, &w.Email, &w.Open, &w.Overdue, &w.Completed); err != nil {
+			return nil, 0, err
+		}
+		workload = append(workload, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var unassigned int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE assignee_id IS NULL AND is_deleted = 0 AND status IN ('todo', 'in_progress')").
+		Scan(&unassigned); err != nil {
+		return nil, 0, err
+	}
+	return workload, unassigned, nil
+}
@@ -0,0 +1,241 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+const (
+	// defaultAttachmentMaxBytes 未配置 attachment_max_bytes 时单个附件的大小上限
+	defaultAttachmentMaxBytes = 10 * 1024 * 1024
+	// defaultAttachmentQuotaBytes 未配置 attachment_quota_bytes 时每个用户的附件配额
+	defaultAttachmentQuotaBytes = 100 * 1024 * 1024
+)
+
+// ErrAttachmentNotFound 附件不存在、已删除或不属于该任务
+var ErrAttachmentNotFound = errors.New("附件不存在")
+
+// QuotaExceededError 上传后将超过用户的附件配额
+type QuotaExceededError struct {
+	Used  int64
+	Quota int64
+	Size  int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("附件配额不足：已使用 %d 字节，配额 %d 字节，本次上传 %d 字节", e.Used, e.Quota, e.Size)
+}
+
+// taskAttachmentsColumn 任务未删除附件的元数据（JSON 数组，按上传顺序），随任务列表、详情与 /sync 下发
+const taskAttachmentsColumn = `(SELECT json_group_array(json_object('id', a.id, 'task_id', a.task_id, 'uploaded_by', a.user_id,
+	'filename', a.filename, 'content_type', a.content_type, 'size', a.size, 'sha256', a.sha256,
+	'created_at', strftime('%Y-%m-%dT%H:%M:%SZ', a.created_at)))
+	FROM (SELECT * FROM task_attachments WHERE task_id = tasks.id AND is_deleted = 0 ORDER BY id) a) AS attachments`
+
+// parseAttachmentList 解析 taskAttachmentsColumn 的取值
+func parseAttachmentList(s string) []types.TaskAttachment {
+	attachments := []types.TaskAttachment{}
+	if s == "" {
+		return attachments
+	}
+	if err := json.Unmarshal([]byte(s), &attachments); err != nil {
+		log.Printf("解析任务附件失败: %v", err)
+		return []types.TaskAttachment{}
+	}
+	return attachments
+}
+
+// configBytes 读取以字节为单位的正整数配置，未配置或无效时返回 fallback
+func configBytes(key string, fallback int64) int64 {
+	value, found, err := GetConfigValue(key)
+	if err != nil {
+		log.Printf("读取配置 %s 失败: %v", key, err)
+		return fallback
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if !found || err != nil || n < 1 {
+		return fallback
+	}
+	return n
+}
+
+// AttachmentMaxBytes 读取单个附件的大小上限（system_config 中的 attachment_max_bytes）
+func AttachmentMaxBytes() int64 {
+	return configBytes("attachment_max_bytes", defaultAttachmentMaxBytes)
+}
+
+// AttachmentQuotaBytes 读取每个用户的附件配额（system_config 中的 attachment_quota_bytes）
+func AttachmentQuotaBytes() int64 {
+	return configBytes("attachment_quota_bytes", defaultAttachmentQuotaBytes)
+}
+
+// attachmentUsage 统计用户上传的未删除附件占用的字节数（包括回收站中任务的附件）
+func attachmentUsage(q queryRower, userID int) (int64, error) {
+	var used int64
+	err := q.QueryRow("SELECT COALESCE(SUM(size), 0) FROM task_attachments WHERE user_id = ? AND is_deleted = 0", userID).Scan(&used)
+	return used, err
+}
+
+// GetAttachmentUsage 获取用户的附件用量与配额
+func GetAttachmentUsage(userID int) (*types.AttachmentUsage, error) {
+	used, err := attachmentUsage(DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AttachmentUsage{Used: used, Quota: AttachmentQuotaBytes()}, nil
+}
+
+// checkAttachmentQuota 检查用户再上传 size 字节后是否超过配额
+func checkAttachmentQuota(q queryRower, userID int, size int64) error {
+	used, err := attachmentUsage(q, userID)
+	if err != nil {
+		return err
+	}
+	if quota := AttachmentQuotaBytes(); used+size > quota {
+		return &QuotaExceededError{Used: used, Quota: quota, Size: size}
+	}
+	return nil
+}
+
+// CheckAttachmentUpload 在写入存储之前检查用户能编辑任务且配额足够，写入元数据时会再次检查
+func CheckAttachmentUpload(userID int, taskID int64, size int64) error {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return err
+	}
+	return checkAttachmentQuota(DB, userID, size)
+}
+
+// CreateAttachment 记录已写入存储的附件，任务分配新版本号，使附件随同步下发
+func CreateAttachment(userID int, deviceID string, taskID int64, a types.TaskAttachment) (*types.TaskAttachment, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+	if err := checkAttachmentQuota(tx, userID, a.Size); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(`INSERT INTO task_attachments (task_id, user_id, filename, content_type, size, sha256, storage_key, is_deleted, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)`,
+		taskID, userID, a.Filename, a.ContentType, a.Size, a.SHA256, a.StorageKey, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if err := touchTasks(tx, userID, deviceID, []int64{taskID}); err != nil {
+		return nil, err
+	}
+
+	created, err := getAttachment(tx, taskID, id)
+	if err != nil {
+		return nil, err
+	}
+	return created, tx.Commit()
+}
+
+// getAttachment 读取任务的未删除附件
+func getAttachment(q queryRower, taskID, attachmentID int64) (*types.TaskAttachment, error) {
+	a := &types.TaskAttachment{}
+	var createdAt sql.NullTime
+	err := q.QueryRow(`SELECT id, task_id, user_id, filename, content_type, size, sha256, storage_key, created_at
+		FROM task_attachments WHERE id = ? AND task_id = ? AND is_deleted = 0`, attachmentID, taskID).
+		Scan(&a.ID, &a.TaskID, &a.UploadedBy, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.StorageKey, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.CreatedAt = formatDueAt(createdAt)
+	return a, nil
+}
+
+// GetAttachment 获取附件的元数据（含存储键），用户需能查看任务
+func GetAttachment(userID int, taskID, attachmentID int64) (*types.TaskAttachment, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	return getAttachment(DB, taskID, attachmentID)
+}
+
+// ListAttachments 按上传顺序获取任务的附件，用户需能查看任务
+func ListAttachments(userID int, taskID int64) ([]types.TaskAttachment, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	var raw sql.NullString
+	if err := DB.QueryRow("SELECT "+taskAttachmentsColumn+" FROM tasks WHERE id = ?", taskID).Scan(&raw); err != nil {
+		return nil, err
+	}
+	return parseAttachmentList(raw.String), nil
+}
+
+// DeleteAttachment 删除任务的附件并为任务分配新版本号，用户需能编辑任务
+// 存储中的内容由 PendingBlobDeletions 交给调用方清理，返回被删除附件的存储键
+func DeleteAttachment(userID int, deviceID string, taskID, attachmentID int64) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return "", err
+	}
+	a, err := getAttachment(tx, taskID, attachmentID)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec("UPDATE task_attachments SET is_deleted = 1, deleted_at = ? WHERE id = ?", time.Now().UTC(), attachmentID); err != nil {
+		return "", err
+	}
+	if err := touchTasks(tx, userID, deviceID, []int64{taskID}); err != nil {
+		return "", err
+	}
+	return a.StorageKey, tx.Commit()
+}
+
+// PendingBlob 元数据已删除、存储中的内容尚待清理的附件
+type PendingBlob struct {
+	ID         int64
+	StorageKey string
+}
+
+// PendingBlobDeletions 获取最多 limit 个待清理存储内容的附件（附件被删除或所属任务被永久删除）
+func PendingBlobDeletions(limit int) ([]PendingBlob, error) {
+	rows, err := DB.Query("SELECT id, storage_key FROM task_attachments WHERE is_deleted = 1 ORDER BY id LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blobs := []PendingBlob{}
+	for rows.Next() {
+		var b PendingBlob
+		if err := rows.Scan(&b.ID, &b.StorageKey); err != nil {
+			return nil, err
+		}
+		blobs = append(blobs, b)
+	}
+	return blobs, rows.Err()
+}
+
+// FinishBlobDeletion 存储中的内容删除后移除附件记录
+func FinishBlobDeletion(attachmentID int64) error {
+	_, err := DB.Exec("DELETE FROM task_attachments WHERE id = ? AND is_deleted = 1", attachmentID)
+	return err
+}
@@ -3,28 +3,49 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
+
+	"todoapp/internal/types"
 )
 
+// MaxBatchTasks 单次批量更新允许的最大任务数
+const MaxBatchTasks = 500
+
+// ErrBatchTooLarge 批量更新涉及的任务过多
+var ErrBatchTooLarge = fmt.Errorf("单次批量更新最多 %d 个任务", MaxBatchTasks)
+
+// BatchUpdate 批量更新请求
+type BatchUpdate struct {
+	TaskIDs  []int64           // 指定任务 ID，为空时按 Filters 选择
+	Filters  map[string]string // 与任务列表相同的过滤条件
+	Fields   TaskFields
+	DueShift time.Duration // 顺延已有截止时间，没有截止时间的任务不受影响
+	Versions map[int64]int // 可选的逐项乐观锁版本号
+}
+
 // BatchDeleteTasks 批量软删除任务
-func BatchDeleteTasks(userID int, taskIDs []int64) (int, error) {
+func BatchDeleteTasks(userID int, deviceID string, taskIDs []int64) (int, error) {
 	tx, err := DB.Begin()
 	if err != nil {
 		return 0, err
 	}
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
-		}
-	}()
+	defer tx.Rollback()
 
 	count := 0
+	var deleted []int64
 	now := time.Now().UTC()
 
 	for _, taskID := range taskIDs {
+		// 跳过不存在、已删除或没有编辑权限的任务
+		if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+			if err == ErrTaskNotFound || err == ErrTaskForbidden {
+				continue
+			}
+			return 0, err
+		}
+
 		// 1. 获取任务数据用于恢复
 		var localID string
 		var serverVersion int
@@ -32,14 +53,10 @@ func BatchDeleteTasks(userID int, taskIDs []int64) (int, error) {
 		var createdAt string
 
 		err := tx.QueryRow(
-			"SELECT local_id, server_version, title, description, status, priority, due_at, created_at FROM tasks WHERE id = ? AND user_id = ? AND is_deleted = 0",
-			taskID, userID,
+			"SELECT COALESCE(local_id, ''), COALESCE(server_version, 0), COALESCE(title, ''), COALESCE(description, ''), COALESCE(status, ''), COALESCE(priority, ''), COALESCE(due_at, ''), COALESCE(created_at, '') FROM tasks WHERE id = ?",
+			taskID,
 		).Scan(&localID, &serverVersion, &title, &description, &status, &priority, &dueAt, &createdAt)
-
 		if err != nil {
-			if err == sql.ErrNoRows {
-				continue
-			}
 			return 0, err
 		}
 
@@ -58,8 +75,12 @@ func BatchDeleteTasks(userID int, taskIDs []int64) (int, error) {
 		taskJSON, _ := json.Marshal(taskMap)
 
 		// 2. 软删除任务
-		_, err = tx.Exec("UPDATE tasks SET is_deleted=1, updated_at=?, last_modified=? WHERE id=?",
-			now, now, taskID)
+		version, err := logTaskChange(tx, userID, taskID, ChangeDelete, deviceID)
+		if err != nil {
+			return 0, err
+		}
+		_, err = tx.Exec("UPDATE tasks SET is_deleted=1, deleted_at=?, server_version=?, updated_at=?, last_modified=? WHERE id=?",
+			now, version, now, now, taskID)
 		if err != nil {
 			return 0, err
 		}
@@ -71,14 +92,143 @@ func BatchDeleteTasks(userID int, taskIDs []int64) (int, error) {
 			return 0, err
 		}
 
+		deleted = append(deleted, taskID)
 		count++
 	}
 
-	return count, nil
+	// 子任务随父任务一起删除，删除时间相同以便撤销时一起恢复
+	descendants, err := deleteDescendantsTx(tx, userID, deviceID, deleted, now)
+	if err != nil {
+		return 0, err
+	}
+	return count + descendants, tx.Commit()
 }
 
-// RestoreDeletedTask 恢复删除的任务（撤销删除）
-func RestoreDeletedTask(taskID int64, userID int) error {
+// BatchUpdateTasks 在单个事务中对一组任务应用相同的部分更新，逐项返回处理结果
+// 不存在、无权访问或版本冲突的任务跳过并记录原因，其余任务照常更新并分配新版本号
+func BatchUpdateTasks(userID int, deviceID string, req BatchUpdate) ([]types.BatchItemResult, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	taskIDs := req.TaskIDs
+	if len(taskIDs) == 0 {
+		if taskIDs, err = filterTaskIDs(tx, userID, req.Filters); err != nil {
+			return nil, err
+		}
+	}
+	if len(taskIDs) > MaxBatchTasks {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]types.BatchItemResult, 0, len(taskIDs))
+	for _, taskID := range taskIDs {
+		f := req.Fields
+		if req.DueShift != 0 {
+			var dueAt sql.NullTime
+			err := tx.QueryRow("SELECT due_at FROM tasks WHERE id = ?", taskID).Scan(&dueAt)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, err
+			}
+			if dueAt.Valid {
+				shifted := dueAt.Time.Add(req.DueShift)
+				f.DueAt = &shifted
+			}
+		}
+
+		result := types.BatchItemResult{ID: taskID}
+		if f.IsEmpty() {
+			// 仅顺延截止时间而任务没有截止时间，无需修改
+			version, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor)
+			if err == nil {
+				result.Success = true
+				result.ServerVersion = int64(version)
+				results = append(results, result)
+				continue
+			}
+			if batchItemError(&result, err) {
+				results = append(results, result)
+				continue
+			}
+			return nil, err
+		}
+
+		task, err := updateTaskFieldsTx(tx, userID, deviceID, taskID, f, req.Versions[taskID])
+		if err != nil {
+			if batchItemError(&result, err) {
+				results = append(results, result)
+				continue
+			}
+			return nil, err
+		}
+		result.Success = true
+		result.ServerVersion = task["server_version"].(int64)
+		results = append(results, result)
+	}
+
+	return results, tx.Commit()
+}
+
+// batchItemError 将单项失败转换为结果中的错误原因，返回 false 表示需要中止整个批量操作
+func batchItemError(result *types.BatchItemResult, err error) bool {
+	var conflictErr *VersionConflictError
+	var depthErr *TaskDepthError
+	var blockedErr *BlockedError
+	switch {
+	case errors.As(err, &conflictErr):
+		result.Error = types.BatchErrorVersionConflict
+		result.CurrentVersion = conflictErr.Current
+	case err == ErrInvalidParent, errors.As(err, &depthErr):
+		result.Error = types.BatchErrorInvalidParent
+	case errors.As(err, &blockedErr):
+		result.Error = types.BatchErrorBlocked
+	case err == ErrInvalidProject:
+		result.Error = types.BatchErrorInvalidProject
+	case err == ErrInvalidAssignee:
+		result.Error = types.BatchErrorInvalidAssignee
+	case err == ErrTaskNotFound:
+		result.Error = types.BatchErrorNotFound
+	case err == ErrTaskForbidden:
+		result.Error = types.BatchErrorForbidden
+	default:
+		return false
+	}
+	return true
+}
+
+// FilterTaskIDs 按列表过滤条件选出用户的任务 ID，供处理器在批量更新前读取受影响的任务
+func FilterTaskIDs(userID int, filters map[string]string) ([]int64, error) {
+	return filterTaskIDs(DB, userID, filters)
+}
+
+// filterTaskIDs 按列表过滤条件选出用户的任务 ID
+func filterTaskIDs(q Querier, userID int, filters map[string]string) ([]int64, error) {
+	where, args, err := buildTaskFilter(userID, filters)
+	if err != nil {
+		return nil, err
+	}
+	// 多取一条用于判断是否超过上限
+	rows, err := q.Query("SELECT id FROM tasks WHERE "+where+" ORDER BY id LIMIT ?", append(args, MaxBatchTasks+1)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// RestoreDeletedTask 恢复删除的任务（撤销删除），同一次删除中连带删除的子任务一并恢复
+func RestoreDeletedTask(taskID int64, userID int, deviceID string) error {
 	// 1. 查找删除记录
 	var taskJSON string
 	var deletedAt time.Time
@@ -116,17 +266,52 @@ func RestoreDeletedTask(taskID int64, userID int) error {
 	status := taskJSONValue(taskData["status"])
 	priority := taskJSONValue(taskData["priority"])
 
-	_, err = DB.Exec(
-		"UPDATE tasks SET is_deleted=0, title=?, description=?, status=?, priority=?, updated_at=?, last_modified=? WHERE id=?",
-		title, description, status, priority, now, now, taskID,
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 删除后可能已失去项目的编辑权限
+	if err := checkTaskAccessAny(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return err
+	}
+	var taskDeletedAt sql.NullTime
+	if err := tx.QueryRow("SELECT deleted_at FROM tasks WHERE id = ?", taskID).Scan(&taskDeletedAt); err != nil {
+		return err
+	}
+	if err := recordActivityTx(tx, userID, taskID, types.ActivityRestored, nil); err != nil {
+		return err
+	}
+	if err := recordStatusChangeTx(tx, userID, taskID, status); err != nil {
+		return err
+	}
+
+	version, err := logTaskChange(tx, userID, taskID, ChangeRestore, deviceID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"UPDATE tasks SET is_deleted=0, deleted_at=NULL, title=?, description=?, status=?, priority=?, server_version=?, updated_at=?, last_modified=? WHERE id=?",
+		title, description, status, priority, version, now, now, taskID,
 	)
 	if err != nil {
 		return err
 	}
+	if err := detachFromDeletedParent(tx, taskID); err != nil {
+		return err
+	}
+	if taskDeletedAt.Valid {
+		if err := restoreDescendantsTx(tx, userID, deviceID, taskID, taskDeletedAt.Time); err != nil {
+			return err
+		}
+	}
 
-	// 5. 标记为已恢复
-	_, err = DB.Exec("UPDATE deleted_tasks SET is_restorable=0 WHERE task_id=? AND user_id=?", taskID, userID)
-	return err
+	// 5. 标记为已恢复（包括其他成员对同一任务的删除记录）
+	if _, err = tx.Exec("UPDATE deleted_tasks SET is_restorable=0 WHERE task_id=?", taskID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetRestorableTask 获取可恢复的任务
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// 变更日志操作类型
+const (
+	ChangeInsert  = "insert"
+	ChangeUpdate  = "update"
+	ChangeDelete  = "delete"
+	ChangeRestore = "restore"
+	ChangeRevert  = "revert"
+)
+
+// 变更日志记录的实体类型
+const (
+	EntityTask      = "task"
+	EntityProject   = "project"
+	EntityView      = "view"
+	EntityTimeEntry = "time_entry"
+)
+
+// Querier 统一 *sql.DB 与 *sql.Tx 的查询接口
+type Querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// LatestChangeSeq 获取用户当前最大的变更序号
+// 变更日志启用前写入的任务版本号也计入，保证序号不回退
+func LatestChangeSeq(q Querier, userID int) (int, error) {
+	var seq int
+	err := q.QueryRow(`SELECT MAX(
+		COALESCE((SELECT MAX(seq) FROM change_log WHERE user_id = ?), 0),
+		COALESCE((SELECT MAX(server_version) FROM tasks WHERE user_id = ?), 0))`,
+		userID, userID,
+	).Scan(&seq)
+	return seq, err
+}
+
+// ChangeSeqBefore 获取用户在 at 之前写入的最大变更序号，供只携带 last_sync_at 的旧客户端换算拉取游标
+// 与 at 同一时刻写入的变更会再次下发，客户端按版本号去重
+func ChangeSeqBefore(q Querier, userID int, at time.Time) (int, error) {
+	var seq sql.NullInt64
+	err := q.QueryRow("SELECT MAX(seq) FROM change_log WHERE user_id = ? AND created_at < ?", userID, at.UTC()).Scan(&seq)
+	return int(seq.Int64), err
+}
+
+// nextChangeSeq 分配用户级单调递增的变更序号，同时作为任务的 server_version
+func nextChangeSeq(q Querier, userID int) (int, error) {
+	seq, err := LatestChangeSeq(q, userID)
+	return seq + 1, err
+}
+
+// appendTaskChange 以指定序号追加一条任务变更，执行者即任务所属用户
+func appendTaskChange(tx *sql.Tx, userID int, seq int, taskID int64, op, deviceID string) error {
+	return appendChange(tx, userID, userID, seq, EntityTask, taskID, op, deviceID)
+}
+
+// appendChange 以指定序号在 userID 的变更日志中追加一条实体变更，actorID 为执行者
+func appendChange(tx *sql.Tx, userID, actorID int, seq int, entityType string, entityID int64, op, deviceID string) error {
+	_, err := tx.Exec(
+		"INSERT INTO change_log (user_id, seq, task_id, entity_type, op, device_id, actor_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, seq, entityID, entityType, op, deviceID, actorID, time.Now().UTC(),
+	)
+	return err
+}
+
+// logTaskChange 分配序号并记录已有任务的变更，userID 为执行者，返回的序号应写入任务的 server_version
+// 序号在任务所有者的变更日志中分配，任务位于共享项目时在其他成员的变更日志中各追加一条
+func logTaskChange(tx *sql.Tx, userID int, taskID int64, op, deviceID string) (int, error) {
+	ownerID, shared, err := taskAudience(tx, taskID)
+	if err != nil {
+		return 0, err
+	}
+	seq, err := nextChangeSeq(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, ownerID, userID, seq, EntityTask, taskID, op, deviceID); err != nil {
+		return 0, err
+	}
+	return seq, shareChangeTx(tx, shared, userID, EntityTask, taskID, op, deviceID)
+}
+
+// LatestChangeLogID 获取变更日志当前最大的记录 ID，用于在写入后找出受影响的其他用户
+func LatestChangeLogID(q Querier) (int64, error) {
+	var id int64
+	err := q.QueryRow("SELECT COALESCE(MAX(id), 0) FROM change_log").Scan(&id)
+	return id, err
+}
+
+// ChangedUsersSince 获取记录 ID 大于 afterID 的变更涉及的用户（不含 exceptUserID），以及每个用户在这些变更之前的序号
+func ChangedUsersSince(q Querier, afterID int64, exceptUserID int) (map[int]int, error) {
+	rows, err := q.Query("SELECT user_id, MIN(seq) - 1 FROM change_log WHERE id > ? AND user_id != ? GROUP BY user_id", afterID, exceptUserID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := map[int]int{}
+	for rows.Next() {
+		var userID, seq int
+		if err := rows.Scan(&userID, &seq); err != nil {
+			return nil, err
+		}
+		users[userID] = seq
+	}
+	return users, rows.Err()
+}
+
+// GetTaskChangesSince 获取用户在 sinceSeq 之后变更过的任务（含共享项目中的任务），每个任务只返回最新状态，按序号升序
+// 已删除或已失去访问权限的任务以墓碑形式返回；sinceSeq 为 0 表示全量拉取，此时只返回可访问的未删除任务
+// limit 大于 0 时最多返回 limit 条，more 表示还有剩余变更；lastSeq 为已返回的变更在用户变更日志中的最大序号
+// 全量拉取的结果没有对应的变更日志序号可作为分页游标，因此不受 limit 限制，more 总为 false
+func GetTaskChangesSince(q Querier, userID int, sinceSeq int, limit int) (changes []map[string]interface{}, lastSeq int, more bool, err error) {
+	if sinceSeq == 0 {
+		// 共享任务的版本号来自其他用户的变更日志，全量拉取时以读取前的序号作为游标
+		if lastSeq, err = LatestChangeSeq(q, userID); err != nil {
+			return nil, 0, false, err
+		}
+		rows, err := q.Query("SELECT "+taskColumns+" FROM tasks WHERE "+taskAccessClause+" AND is_deleted = 0 ORDER BY server_version ASC, id ASC", taskAccessArgs(userID)...)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		defer rows.Close()
+
+		changes = []map[string]interface{}{}
+		for rows.Next() {
+			task, err := scanTask(rows)
+			if err != nil {
+				return nil, 0, false, err
+			}
+			changes = append(changes, task)
+		}
+		return changes, lastSeq, false, rows.Err()
+	}
+
+	query := "SELECT task_id, MAX(seq) AS last_seq FROM change_log WHERE user_id = ? AND seq > ? AND entity_type = 'task' GROUP BY task_id ORDER BY last_seq ASC"
+	args := []interface{}{userID, sinceSeq}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	type entry struct {
+		taskID int64
+		seq    int
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.taskID, &e.seq); err != nil {
+			rows.Close()
+			return nil, 0, false, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, false, err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries, more = entries[:limit], true
+	}
+
+	changes = make([]map[string]interface{}, 0, len(entries))
+	for _, e := range entries {
+		lastSeq = e.seq
+		task, err := getTask(q, e.taskID)
+		if err != nil && err != ErrTaskNotFound {
+			return nil, 0, false, err
+		}
+		if task != nil {
+			if err := checkTaskAccessAny(q, userID, e.taskID, types.ProjectRoleViewer); err == ErrTaskForbidden {
+				task = nil
+			} else if err != nil {
+				return nil, 0, false, err
+			}
+		}
+		// 任务已删除、已不存在或用户已失去访问权限时返回墓碑
+		if task == nil || task["is_deleted"].(bool) {
+			tombstone := map[string]interface{}{
+				"id":             e.taskID,
+				"server_version": int64(e.seq),
+				"is_deleted":     true,
+			}
+			if task != nil {
+				tombstone["server_version"] = task["server_version"]
+				tombstone["local_id"] = task["local_id"]
+				tombstone["updated_at"] = task["updated_at"]
+			}
+			task = tombstone
+		}
+		changes = append(changes, task)
+	}
+	return changes, lastSeq, more, nil
+}
@@ -0,0 +1,363 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"todoapp/internal/mention"
+	"todoapp/internal/types"
+)
+
+// MaxCommentLength 评论正文的最大长度（字符）
+const MaxCommentLength = 5000
+
+var (
+	// ErrCommentNotFound 评论不存在、已删除或不属于该任务
+	ErrCommentNotFound = errors.New("评论不存在")
+	// ErrCommentForbidden 只有作者可以修改评论，作者与任务的 owner 可以删除评论
+	ErrCommentForbidden = errors.New("无权修改此评论")
+	// ErrInvalidCommentParent 回复的评论不存在或不属于同一任务
+	ErrInvalidCommentParent = errors.New("回复的评论不存在")
+)
+
+// NormalizeCommentBody 去除评论正文首尾空白并检查长度
+func NormalizeCommentBody(body string) (string, error) {
+	body = strings.TrimSpace(body)
+	if body == "" {
+		return "", errors.New("评论内容不能为空")
+	}
+	if utf8.RuneCountInString(body) > MaxCommentLength {
+		return "", fmt.Errorf("评论内容不能超过 %d 个字符", MaxCommentLength)
+	}
+	return body, nil
+}
+
+// commentColumns 评论查询的标准列，与 scanComment 的扫描顺序一致
+const commentColumns = `c.id, c.task_id, c.parent_id, c.root_id, c.user_id, COALESCE(u.email, ''), c.body, c.is_deleted, c.created_at, c.updated_at
+	FROM task_comments c LEFT JOIN users u ON u.id = c.user_id`
+
+// scanComment 将一行评论记录转换为 TaskComment
+func scanComment(s rowScanner) (*types.TaskComment, error) {
+	c := &types.TaskComment{Mentions: []types.CommentMention{}}
+	var parentID, rootID sql.NullInt64
+	var createdAt, updatedAt sql.NullTime
+	if err := s.Scan(&c.ID, &c.TaskID, &parentID, &rootID, &c.UserID, &c.Email, &c.Body, &c.IsDeleted, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		c.ParentID = &parentID.Int64
+	}
+	if rootID.Valid {
+		c.RootID = &rootID.Int64
+	}
+	c.CreatedAt = formatDueAt(createdAt)
+	c.UpdatedAt = formatDueAt(updatedAt)
+	c.Edited = !c.IsDeleted && updatedAt.Valid && createdAt.Valid && updatedAt.Time.After(createdAt.Time)
+	return c, nil
+}
+
+// getComment 读取单条评论及其提及的用户
+func getComment(q Querier, commentID int64) (*types.TaskComment, error) {
+	c, err := scanComment(q.QueryRow("SELECT "+commentColumns+" WHERE c.id = ?", commentID))
+	if err == sql.ErrNoRows {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Mentions, err = commentMentions(q, commentID); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// commentMentions 读取评论提及的用户
+func commentMentions(q Querier, commentID int64) ([]types.CommentMention, error) {
+	rows, err := q.Query(`
+		SELECT m.user_id, u.email FROM task_comment_mentions m JOIN users u ON u.id = m.user_id
+		WHERE m.comment_id = ? ORDER BY u.email`, commentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := []types.CommentMention{}
+	for rows.Next() {
+		var m types.CommentMention
+		if err := rows.Scan(&m.UserID, &m.Email); err != nil {
+			return nil, err
+		}
+		mentions = append(mentions, m)
+	}
+	return mentions, rows.Err()
+}
+
+// resolveMentions 将正文中的提及解析为能看到该任务的用户（任务所有者与项目成员）
+// 按邮箱用户名提及时须唯一匹配，无法解析的提及按普通文本处理
+func resolveMentions(q Querier, taskID int64, body string) ([]int, error) {
+	handles := mention.Parse(body)
+	if len(handles) == 0 {
+		return nil, nil
+	}
+	ownerID, others, err := taskAudience(q, taskID)
+	if err != nil {
+		return nil, err
+	}
+	emails := map[int]string{}
+	for _, id := range append([]int{ownerID}, others...) {
+		var email string
+		err := q.QueryRow("SELECT email FROM users WHERE id = ?", id).Scan(&email)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		emails[id] = email
+	}
+
+	seen := map[int]bool{}
+	var users []int
+	for _, handle := range handles {
+		match := 0
+		for id, email := range emails {
+			if mention.Matches(handle, email) {
+				if match != 0 {
+					// 多个用户的邮箱用户名相同，无法确定提及的是谁
+					match = -1
+					break
+				}
+				match = id
+			}
+		}
+		if match > 0 && !seen[match] {
+			seen[match] = true
+			users = append(users, match)
+		}
+	}
+	return users, nil
+}
+
+// setCommentMentionsTx 替换评论提及的用户，返回此前未被提及的用户
+func setCommentMentionsTx(tx *sql.Tx, commentID int64, users []int) ([]int, error) {
+	previous, err := queryIDs(tx, "SELECT user_id FROM task_comment_mentions WHERE comment_id = ?", commentID)
+	if err != nil {
+		return nil, err
+	}
+	before := map[int]bool{}
+	for _, id := range previous {
+		before[int(id)] = true
+	}
+	if _, err := tx.Exec("DELETE FROM task_comment_mentions WHERE comment_id = ?", commentID); err != nil {
+		return nil, err
+	}
+	var added []int
+	for _, id := range users {
+		if _, err := tx.Exec("INSERT INTO task_comment_mentions (comment_id, user_id) VALUES (?, ?)", commentID, id); err != nil {
+			return nil, err
+		}
+		if !before[id] {
+			added = append(added, id)
+		}
+	}
+	return added, nil
+}
+
+// ListTaskComments 按话题分页获取任务的评论：首条评论按时间顺序分页，每条附带话题中的全部回复
+// 已删除但仍有回复的评论保留为空正文的占位，使话题结构完整
+func ListTaskComments(userID int, taskID int64, page, pageSize int) ([]types.TaskComment, int, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, 0, err
+	}
+
+	const visible = `(c.is_deleted = 0 OR EXISTS (SELECT 1 FROM task_comments r WHERE r.parent_id = c.id AND r.is_deleted = 0))`
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM task_comments c WHERE c.task_id = ? AND c.parent_id IS NULL AND "+visible, taskID).
+		Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	threads, err := queryComments(DB, "c.task_id = ? AND c.parent_id IS NULL AND "+visible+" ORDER BY c.created_at, c.id LIMIT ? OFFSET ?",
+		taskID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range threads {
+		replies, err := queryComments(DB, "c.root_id = ? AND "+visible+" ORDER BY c.created_at, c.id", threads[i].ID)
+		if err != nil {
+			return nil, 0, err
+		}
+		threads[i].Replies = replies
+	}
+	return threads, total, nil
+}
+
+// queryComments 按条件读取评论及其提及的用户
+func queryComments(q Querier, cond string, args ...interface{}) ([]types.TaskComment, error) {
+	rows, err := q.Query("SELECT "+commentColumns+" WHERE "+cond, args...)
+	if err != nil {
+		return nil, err
+	}
+	comments := []types.TaskComment{}
+	for rows.Next() {
+		c, err := scanComment(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		comments = append(comments, *c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range comments {
+		if comments[i].Mentions, err = commentMentions(q, comments[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return comments, nil
+}
+
+// CreateTaskComment 在任务上发表评论（parentID 为 0 时开启新话题，否则回复该评论），能查看任务的用户都可以评论
+// 返回评论与其中提及的用户（不含作者本人）
+func CreateTaskComment(userID int, taskID, parentID int64, body string) (*types.TaskComment, []int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, nil, err
+	}
+	var parent, root interface{}
+	if parentID != 0 {
+		var parentTask int64
+		var parentRoot sql.NullInt64
+		var parentDeleted bool
+		err := tx.QueryRow("SELECT task_id, root_id, is_deleted FROM task_comments WHERE id = ?", parentID).
+			Scan(&parentTask, &parentRoot, &parentDeleted)
+		if err == sql.ErrNoRows || (err == nil && (parentTask != taskID || parentDeleted)) {
+			return nil, nil, ErrInvalidCommentParent
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		parent = parentID
+		root = parentID
+		if parentRoot.Valid {
+			root = parentRoot.Int64
+		}
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec("INSERT INTO task_comments (task_id, user_id, parent_id, root_id, body, is_deleted, created_at, updated_at) VALUES (?, ?, ?, ?, ?, 0, ?, ?)",
+		taskID, userID, parent, root, body, now, now)
+	if err != nil {
+		return nil, nil, err
+	}
+	commentID, err := res.LastInsertId()
+	if err != nil {
+		return nil, nil, err
+	}
+	mentioned, err := resolveMentions(tx, taskID, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	added, err := setCommentMentionsTx(tx, commentID, mentioned)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c, err := getComment(tx, commentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, excludeUsers(added, userID), tx.Commit()
+}
+
+// UpdateTaskComment 修改评论正文，只有作者可以修改；返回新增提及的用户（不含作者本人）
+func UpdateTaskComment(userID int, taskID, commentID int64, body string) (*types.TaskComment, []int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	c, err := checkCommentTx(tx, userID, taskID, commentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.UserID != userID {
+		return nil, nil, ErrCommentForbidden
+	}
+	if _, err := tx.Exec("UPDATE task_comments SET body = ?, updated_at = ? WHERE id = ?", body, time.Now().UTC(), commentID); err != nil {
+		return nil, nil, err
+	}
+	mentioned, err := resolveMentions(tx, taskID, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	added, err := setCommentMentionsTx(tx, commentID, mentioned)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c, err = getComment(tx, commentID); err != nil {
+		return nil, nil, err
+	}
+	return c, excludeUsers(added, userID), tx.Commit()
+}
+
+// DeleteTaskComment 删除评论，作者与任务的 owner（任务创建者或项目 owner）可以删除
+// 评论保留为空正文的占位，回复仍挂在原话题下
+func DeleteTaskComment(userID int, taskID, commentID int64) (*types.TaskComment, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	c, err := checkCommentTx(tx, userID, taskID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserID != userID {
+		if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleOwner); err == ErrTaskForbidden {
+			return nil, ErrCommentForbidden
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("UPDATE task_comments SET body = '', is_deleted = 1, updated_at = ? WHERE id = ?", time.Now().UTC(), commentID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM task_comment_mentions WHERE comment_id = ?", commentID); err != nil {
+		return nil, err
+	}
+
+	if c, err = getComment(tx, commentID); err != nil {
+		return nil, err
+	}
+	return c, tx.Commit()
+}
+
+// checkCommentTx 检查用户能查看任务，且评论属于该任务并未删除
+func checkCommentTx(tx *sql.Tx, userID int, taskID, commentID int64) (*types.TaskComment, error) {
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	c, err := getComment(tx, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if c.TaskID != taskID || c.IsDeleted {
+		return nil, ErrCommentNotFound
+	}
+	return c, nil
+}
@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// 冲突记录状态
+const (
+	ConflictStatusOpen     = "open"
+	ConflictStatusResolved = "resolved"
+)
+
+var (
+	// ErrConflictNotFound 冲突记录不存在或不属于当前用户
+	ErrConflictNotFound = errors.New("冲突记录不存在")
+	// ErrConflictResolved 冲突已被解决
+	ErrConflictResolved = errors.New("冲突已解决")
+)
+
+// conflictColumns 冲突查询的标准列，与 scanConflict 的扫描顺序一致
+const conflictColumns = "id, local_id, server_id, reason, options, field_conflicts, status, resolution, resolved_at, created_at"
+
+// RecordConflict 在同步事务中记录冲突及各字段的服务器值、客户端值与合并结果
+func RecordConflict(tx *sql.Tx, userID int, rec types.ConflictRecord) (int64, error) {
+	optionsJSON, err := json.Marshal(rec.Options)
+	if err != nil {
+		return 0, err
+	}
+	fieldsJSON, err := json.Marshal(rec.Conflicts)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := tx.Exec(
+		"INSERT INTO conflicts (user_id, local_id, server_id, reason, options, field_conflicts, status, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, rec.LocalID, rec.ServerID, rec.Reason, string(optionsJSON), string(fieldsJSON), ConflictStatusOpen, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// scanConflict 将一行冲突记录转换为 ConflictRecord
+func scanConflict(s rowScanner) (*types.ConflictRecord, error) {
+	var rec types.ConflictRecord
+	var localID, reason, options, fields, status, resolution, resolvedAt, createdAt sql.NullString
+	var serverID sql.NullInt64
+	if err := s.Scan(&rec.ID, &localID, &serverID, &reason, &options, &fields, &status, &resolution, &resolvedAt, &createdAt); err != nil {
+		return nil, err
+	}
+
+	rec.LocalID = localID.String
+	rec.ServerID = serverID.Int64
+	rec.Reason = reason.String
+	rec.Status = status.String
+	if rec.Status == "" {
+		rec.Status = ConflictStatusOpen
+	}
+	rec.Resolution = resolution.String
+	rec.ResolvedAt = resolvedAt.String
+	rec.CreatedAt = createdAt.String
+	rec.Options = []types.ConflictResolution{}
+	if options.String != "" {
+		if err := json.Unmarshal([]byte(options.String), &rec.Options); err != nil {
+			return nil, err
+		}
+	}
+	if fields.String != "" {
+		if err := json.Unmarshal([]byte(fields.String), &rec.Conflicts); err != nil {
+			return nil, err
+		}
+	}
+	return &rec, nil
+}
+
+// GetConflicts 分页获取用户的冲突记录，status 为空时返回全部
+func GetConflicts(userID int, status string, page, pageSize int) ([]*types.ConflictRecord, int, error) {
+	where := "user_id = ?"
+	args := []interface{}{userID}
+	if status != "" {
+		where += " AND COALESCE(status, 'open') = ?"
+		args = append(args, status)
+	}
+
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM conflicts WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query("SELECT "+conflictColumns+" FROM conflicts WHERE "+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	records := []*types.ConflictRecord{}
+	for rows.Next() {
+		rec, err := scanConflict(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		records = append(records, rec)
+	}
+	return records, total, rows.Err()
+}
+
+// getConflict 获取用户的单条冲突记录
+func getConflict(q queryRower, userID int, conflictID int64) (*types.ConflictRecord, error) {
+	rec, err := scanConflict(q.QueryRow("SELECT "+conflictColumns+" FROM conflicts WHERE id = ? AND user_id = ?", conflictID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrConflictNotFound
+	}
+	return rec, err
+}
+
+// ResolveConflict 按策略解决冲突：将冲突字段改写为服务器值、客户端值或合并值，
+// overrides 中显式指定的字段值优先生效。返回更新后的冲突记录与任务（无需改写任务时任务为 nil）
+func ResolveConflict(userID int, deviceID string, conflictID int64, resolution types.ConflictResolution, overrides TaskFields, expectedVersion int) (*types.ConflictRecord, map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rec, err := getConflict(tx, userID, conflictID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rec.Status == ConflictStatusResolved {
+		return nil, nil, ErrConflictResolved
+	}
+	if expectedVersion > 0 {
+		// 共享项目中的编辑者同样可以解决冲突；删除冲突中的任务可能已被软删除，因此不用 checkTaskAccess
+		if err := checkTaskAccessAny(tx, userID, rec.ServerID, types.ProjectRoleEditor); err != nil {
+			return nil, nil, err
+		}
+		var current sql.NullInt64
+		if err := tx.QueryRow("SELECT server_version FROM tasks WHERE id = ?", rec.ServerID).Scan(&current); err != nil {
+			return nil, nil, err
+		}
+		if int(current.Int64) != expectedVersion {
+			return nil, nil, &VersionConflictError{Current: int(current.Int64)}
+		}
+	}
+
+	// 按策略选取各冲突字段的取值
+	chosen := map[string]interface{}{}
+	for _, fc := range rec.Conflicts {
+		switch resolution {
+		case types.ConflictKeepServer:
+			chosen[fc.FieldName] = fc.ServerValue
+		case types.ConflictKeepClient:
+			chosen[fc.FieldName] = fc.ClientValue
+		case types.ConflictMerge:
+			chosen[fc.FieldName] = fc.MergedValue
+		}
+	}
+	for field, v := range map[string]*string{
+		"title":       overrides.Title,
+		"description": overrides.Description,
+		"status":      overrides.Status,
+		"priority":    overrides.Priority,
+	} {
+		if v != nil {
+			chosen[field] = *v
+		}
+	}
+
+	// 删除冲突：选择保留服务器版本时恢复任务
+	if restore, ok := chosen["is_deleted"].(bool); ok && !restore {
+		if err := restoreTaskTx(tx, userID, deviceID, rec.ServerID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var f TaskFields
+	fieldPtrs := map[string]**string{
+		"title":       &f.Title,
+		"description": &f.Description,
+		"status":      &f.Status,
+		"priority":    &f.Priority,
+	}
+	changed := false
+	for field, ptr := range fieldPtrs {
+		if v, ok := chosen[field].(string); ok {
+			value := v
+			*ptr = &value
+			changed = true
+		}
+	}
+	if v, ok := chosen["due_at"].(string); ok {
+		// 截止时间以 RFC3339 字符串记录，空字符串表示清除
+		dueAt, _ := time.Parse(time.RFC3339, v)
+		f.DueAt = &dueAt
+		changed = true
+	}
+	if overrides.DueAt != nil {
+		f.DueAt = overrides.DueAt
+		changed = true
+	}
+
+	var task map[string]interface{}
+	if changed {
+		if task, err = updateTaskFieldsTx(tx, userID, deviceID, rec.ServerID, f, 0); err != nil {
+			return nil, nil, err
+		}
+	} else if _, restored := chosen["is_deleted"]; restored {
+		if task, err = getTask(tx, rec.ServerID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if _, err := tx.Exec("UPDATE conflicts SET status = ?, resolution = ?, resolved_at = ? WHERE id = ?",
+		ConflictStatusResolved, string(resolution), time.Now().UTC(), conflictID); err != nil {
+		return nil, nil, err
+	}
+
+	rec, err = getConflict(tx, userID, conflictID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rec, task, tx.Commit()
+}
+
+// restoreTaskTx 在事务中恢复已软删除的任务，用户需对任务有编辑权限；任务未删除时不做任何修改
+func restoreTaskTx(tx *sql.Tx, userID int, deviceID string, taskID int64) error {
+	if err := checkTaskAccessAny(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return err
+	}
+	var isDeleted sql.NullBool
+	if err := tx.QueryRow("SELECT is_deleted FROM tasks WHERE id = ?", taskID).Scan(&isDeleted); err != nil {
+		return err
+	}
+	if !isDeleted.Bool {
+		return nil
+	}
+	if err := recordActivityTx(tx, userID, taskID, types.ActivityRestored, nil); err != nil {
+		return err
+	}
+
+	version, err := logTaskChange(tx, userID, taskID, ChangeRestore, deviceID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if _, err := tx.Exec("UPDATE tasks SET is_deleted = 0, deleted_at = NULL, server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?",
+		version, now, now, taskID); err != nil {
+		return err
+	}
+	return detachFromDeletedParent(tx, taskID)
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// extraScanner 在标准列之后追加扫描额外列（如游标排序值）
+type extraScanner struct {
+	rowScanner
+	extra []interface{}
+}
+
+func (s extraScanner) Scan(dest ...interface{}) error {
+	return s.rowScanner.Scan(append(dest, s.extra...)...)
+}
+
+// newCursor 根据最后一行的排序值生成下一页游标
+func newCursor(key string, raw interface{}, id int64) *types.Cursor {
+	c := &types.Cursor{Key: key, ID: id}
+	switch v := raw.(type) {
+	case time.Time:
+		c.Value = v.UTC().Format(time.RFC3339Nano)
+		c.Kind = "time"
+	case int64:
+		c.Value = strconv.FormatInt(v, 10)
+		c.Kind = "int"
+	case []byte:
+		c.Value = string(v)
+	case string:
+		c.Value = v
+	case nil:
+		c.Value = ""
+	default:
+		c.Value = fmt.Sprint(v)
+	}
+	return c
+}
+
+// keysetClause 生成 (排序表达式, id) 的游标定位条件
+func keysetClause(key, expr string, desc bool, c *types.Cursor) (string, []interface{}, error) {
+	if c.Key != key {
+		return "", nil, &FilterError{Field: "cursor", Message: "游标与当前排序条件不匹配"}
+	}
+
+	var value interface{} = c.Value
+	switch c.Kind {
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return "", nil, &FilterError{Field: "cursor", Message: "无效的游标"}
+		}
+		value = t.UTC()
+	case "int":
+		n, err := strconv.ParseInt(c.Value, 10, 64)
+		if err != nil {
+			return "", nil, &FilterError{Field: "cursor", Message: "无效的游标"}
+		}
+		value = n
+	}
+
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	return fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", expr, op, expr, op), []interface{}{value, value, c.ID}, nil
+}
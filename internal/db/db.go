@@ -10,6 +10,8 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"todoapp/internal/types"
 )
 
 var DB *sql.DB
@@ -17,6 +19,10 @@ var DB *sql.DB
 // InitDB initializes the SQLite database and creates core tables if not existing.
 func InitDB(dataSourceName string) error {
 	var err error
+	// 写事务在 BEGIN 时即获取写锁，保证按用户分配的版本号不会因并发写入而冲突
+	if !strings.Contains(dataSourceName, "?") {
+		dataSourceName += "?_busy_timeout=5000&_txlock=immediate"
+	}
 	DB, err = sql.Open("sqlite3", dataSourceName)
 	if err != nil {
 		return err
@@ -105,7 +111,15 @@ func InitDB(dataSourceName string) error {
             updated_at DATETIME,
             completed_at DATETIME,
             is_deleted BOOLEAN,
+            deleted_at DATETIME,
             last_modified DATETIME,
+            series_id INTEGER,
+            occurrence INTEGER,
+            parent_id INTEGER,
+            position INTEGER,
+            project_id INTEGER,
+            project_rank TEXT,
+            assignee_id INTEGER,
             FOREIGN KEY(user_id) REFERENCES users(id)
         );`,
 		`CREATE TABLE IF NOT EXISTS delta_queue (
@@ -116,18 +130,31 @@ func InitDB(dataSourceName string) error {
             client_version INTEGER,
             timestamp DATETIME
         );`,
-		`CREATE TABLE IF NOT EXISTS sync_meta (
-            user_id INTEGER PRIMARY KEY,
-            last_sync_at DATETIME,
-            last_server_version INTEGER,
+		syncMetaSchema,
+		`CREATE TABLE IF NOT EXISTS change_log (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            seq INTEGER NOT NULL,
+            task_id INTEGER NOT NULL,
+            entity_type TEXT NOT NULL DEFAULT 'task',
+            op TEXT NOT NULL,
+            device_id TEXT DEFAULT '',
+            actor_id INTEGER,
+            created_at DATETIME,
+            UNIQUE(user_id, seq),
             FOREIGN KEY(user_id) REFERENCES users(id)
         );`,
 		`CREATE TABLE IF NOT EXISTS conflicts (
             id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER,
             local_id TEXT,
             server_id INTEGER,
             reason TEXT,
             options TEXT,
+            field_conflicts TEXT,
+            status TEXT DEFAULT 'open',
+            resolution TEXT,
+            resolved_at DATETIME,
             created_at DATETIME
         );`,
 		`CREATE TABLE IF NOT EXISTS devices (
@@ -154,6 +181,8 @@ func InitDB(dataSourceName string) error {
 		// Indexes for performance
 		`CREATE INDEX IF NOT EXISTS idx_tasks_user_id ON tasks(user_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_server_version ON tasks(server_version);`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_user_version ON tasks(user_id, server_version);`,
+		`CREATE INDEX IF NOT EXISTS idx_change_log_task ON change_log(task_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_last_modified ON tasks(last_modified);`,
 		`CREATE INDEX IF NOT EXISTS idx_tasks_is_deleted ON tasks(is_deleted);`,
@@ -200,6 +229,200 @@ func InitDB(dataSourceName string) error {
 		`CREATE INDEX IF NOT EXISTS idx_devices_user_id ON devices(user_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_devices_device_id ON devices(device_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_deleted_tasks_user ON deleted_tasks(user_id, is_restorable);`,
+		`CREATE TABLE IF NOT EXISTS task_reminders (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            offset_minutes INTEGER NOT NULL,
+            created_at DATETIME,
+            UNIQUE(task_id, offset_minutes),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS reminder_deliveries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            kind TEXT NOT NULL,
+            offset_minutes INTEGER NOT NULL DEFAULT 0,
+            due_at DATETIME NOT NULL,
+            fired_at DATETIME,
+            UNIQUE(task_id, kind, offset_minutes, due_at)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_due_at ON tasks(due_at);`,
+		`CREATE TABLE IF NOT EXISTS task_dependencies (
+            task_id INTEGER NOT NULL,
+            blocker_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            created_at DATETIME,
+            PRIMARY KEY(task_id, blocker_id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_dependencies_blocker ON task_dependencies(blocker_id);`,
+		`CREATE TABLE IF NOT EXISTS task_series (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            rule TEXT NOT NULL,
+            dtstart DATETIME NOT NULL,
+            created_at DATETIME,
+            updated_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS projects (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            local_id TEXT,
+            server_version INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            color TEXT NOT NULL DEFAULT '#808080',
+            archived BOOLEAN NOT NULL DEFAULT 0,
+            rank TEXT NOT NULL,
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            updated_at DATETIME,
+            deleted_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_projects_user_version ON projects(user_id, server_version);`,
+		`CREATE TABLE IF NOT EXISTS project_members (
+            project_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            role TEXT NOT NULL,
+            invited_by INTEGER,
+            created_at DATETIME,
+            PRIMARY KEY(project_id, user_id),
+            FOREIGN KEY(project_id) REFERENCES projects(id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_project_members_user ON project_members(user_id);`,
+		`CREATE TABLE IF NOT EXISTS task_comments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            parent_id INTEGER,
+            root_id INTEGER,
+            body TEXT NOT NULL,
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            updated_at DATETIME,
+            FOREIGN KEY(task_id) REFERENCES tasks(id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_comments_task ON task_comments(task_id, root_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS task_comment_mentions (
+            comment_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            PRIMARY KEY(comment_id, user_id),
+            FOREIGN KEY(comment_id) REFERENCES task_comments(id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS task_activity (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            actor_id INTEGER,
+            type TEXT NOT NULL,
+            data TEXT,
+            created_at DATETIME,
+            FOREIGN KEY(task_id) REFERENCES tasks(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_activity_task ON task_activity(task_id, created_at);`,
+		`CREATE TABLE IF NOT EXISTS task_attachments (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            user_id INTEGER NOT NULL,
+            filename TEXT NOT NULL,
+            content_type TEXT NOT NULL,
+            size INTEGER NOT NULL,
+            sha256 TEXT NOT NULL,
+            storage_key TEXT NOT NULL UNIQUE,
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            deleted_at DATETIME,
+            FOREIGN KEY(task_id) REFERENCES tasks(id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_attachments_task ON task_attachments(task_id, is_deleted);`,
+		`CREATE INDEX IF NOT EXISTS idx_task_attachments_user ON task_attachments(user_id, is_deleted);`,
+		// last_count 为最近一次记录（并推送）的视图成员数，用于判断成员数是否变化
+		`CREATE TABLE IF NOT EXISTS saved_views (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            local_id TEXT,
+            server_version INTEGER NOT NULL,
+            name TEXT NOT NULL,
+            filters TEXT NOT NULL DEFAULT '{}',
+            sort TEXT NOT NULL DEFAULT 'created_at',
+            sort_order TEXT NOT NULL DEFAULT 'desc',
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            last_count INTEGER NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            updated_at DATETIME,
+            deleted_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_saved_views_user ON saved_views(user_id, is_deleted);`,
+		`CREATE TABLE IF NOT EXISTS time_entries (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            task_id INTEGER NOT NULL,
+            local_id TEXT,
+            server_version INTEGER NOT NULL,
+            started_at DATETIME NOT NULL,
+            ended_at DATETIME,
+            note TEXT NOT NULL DEFAULT '',
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            updated_at DATETIME,
+            deleted_at DATETIME,
+            FOREIGN KEY(task_id) REFERENCES tasks(id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_time_entries_user ON time_entries(user_id, is_deleted, started_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_time_entries_task ON time_entries(task_id, is_deleted);`,
+		// 每个用户最多一个正在运行（ended_at 为空）的计时器
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_time_entries_running ON time_entries(user_id) WHERE ended_at IS NULL AND is_deleted = 0;`,
+		// 任务模板，user_id 为空表示管理员维护的全局模板；tags 与 subtasks 为 JSON
+		`CREATE TABLE IF NOT EXISTS task_templates (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER,
+            name TEXT NOT NULL,
+            title TEXT NOT NULL,
+            description TEXT NOT NULL DEFAULT '',
+            priority TEXT NOT NULL DEFAULT 'medium',
+            tags TEXT NOT NULL DEFAULT '[]',
+            due_offset TEXT NOT NULL DEFAULT '',
+            subtasks TEXT NOT NULL DEFAULT '[]',
+            created_by INTEGER NOT NULL,
+            is_deleted BOOLEAN NOT NULL DEFAULT 0,
+            created_at DATETIME,
+            updated_at DATETIME,
+            deleted_at DATETIME,
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_templates_user ON task_templates(user_id, is_deleted);`,
+		`CREATE TABLE IF NOT EXISTS tags (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            user_id INTEGER NOT NULL,
+            name TEXT NOT NULL COLLATE NOCASE,
+            color TEXT NOT NULL DEFAULT '#808080',
+            created_at DATETIME,
+            UNIQUE(user_id, name),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`,
+		`CREATE TABLE IF NOT EXISTS task_tags (
+            task_id INTEGER NOT NULL,
+            tag_id INTEGER NOT NULL,
+            PRIMARY KEY(task_id, tag_id)
+        );`,
+		`CREATE INDEX IF NOT EXISTS idx_task_tags_tag ON task_tags(tag_id);`,
+		// 状态变为 done 时记录完成时间，离开 done 时清除
+		`CREATE TRIGGER IF NOT EXISTS tasks_completed_at_ai AFTER INSERT ON tasks
+        WHEN new.status = 'done' AND new.completed_at IS NULL BEGIN
+            UPDATE tasks SET completed_at = new.updated_at WHERE id = new.id;
+        END;`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_completed_at_au AFTER UPDATE OF status ON tasks
+        WHEN new.status IS NOT old.status BEGIN
+            UPDATE tasks SET completed_at = CASE WHEN new.status = 'done' THEN new.updated_at ELSE NULL END WHERE id = new.id;
+        END;`,
 	}
 
 	for _, s := range stmts {
@@ -207,6 +430,13 @@ func InitDB(dataSourceName string) error {
 			return err
 		}
 	}
+	if err := migrateSchema(); err != nil {
+		return err
+	}
+	if err := initTaskRevisions(); err != nil {
+		return err
+	}
+	initTaskSearch()
 	// Seed default data if empty
 	seedIfEmpty()
 	return nil
@@ -240,7 +470,7 @@ func seedIfEmpty() {
 		}
 		userID, _ := res.LastInsertId()
 		// sample task
-		DB.Exec("INSERT INTO tasks (user_id, local_id, server_version, title, status, created_at, updated_at, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?)", userID, "sample-1", 0, "Sample Task", "todo", now, now, now)
+		DB.Exec("INSERT INTO tasks (user_id, local_id, server_version, title, status, priority, created_at, updated_at, is_deleted, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, 0, ?)", userID, "sample-1", 1, "Sample Task", "todo", "medium", now, now, now)
 
 		// Initialize default system config
 		initSystemConfig(now)
@@ -262,6 +492,14 @@ func initSystemConfig(now time.Time) {
 		{"access_token_duration_minutes", "15", "Access token validity in minutes"},
 		{"refresh_token_duration_days", "7", "Refresh token validity in days"},
 		{"allow_public_registration", "false", "Allow public user registration"},
+		{"trash_retention_days", "30", "Days a deleted task stays in the trash before it is purged"},
+		{"attachment_max_bytes", "10485760", "Maximum size in bytes of a single task attachment"},
+		{"attachment_quota_bytes", "104857600", "Total attachment storage in bytes allowed per user"},
+		{"merge_strategy.title", "server_wins", "Sync conflict merge strategy for task title"},
+		{"merge_strategy.description", "text_merge", "Sync conflict merge strategy for task description"},
+		{"merge_strategy.status", "status_priority", "Sync conflict merge strategy for task status"},
+		{"merge_strategy.priority", "client_wins", "Sync conflict merge strategy for task priority"},
+		{"merge_strategy.due_at", "three_way", "Sync conflict merge strategy for task due date"},
 	}
 
 	for _, cfg := range configs {
@@ -467,20 +705,20 @@ func GetUserEmail(userID int64) (string, error) {
 	return email, nil
 }
 
-// GetTasksStreaming 流式获取任务（用于大规模导出）
-func GetTasksStreaming(userID int, batchSize int, processFunc func([]map[string]interface{}) error) error {
+// GetTasksStreaming 流式获取用户可访问的任务（用于大规模导出），projectID 大于 0 时只导出该项目中的任务
+func GetTasksStreaming(userID int, projectID int64, batchSize int, processFunc func([]map[string]interface{}) error) error {
 	offset := 0
 
 	for {
 		query := `
 			SELECT id, local_id, server_version, title, description, status, priority,
-			       due_at, created_at, updated_at, completed_at, is_deleted, last_modified
+			       due_at, created_at, updated_at, completed_at, is_deleted, last_modified, ` + taskTagsColumn + `
 			FROM tasks
-			WHERE user_id = ? AND is_deleted = 0
+			WHERE ` + taskAccessClause + ` AND is_deleted = 0 AND (? = 0 OR project_id = ?)
 			ORDER BY created_at DESC
 			LIMIT ? OFFSET ?
 		`
-		rows, err := DB.Query(query, userID, batchSize, offset)
+		rows, err := DB.Query(query, append(taskAccessArgs(userID), projectID, projectID, batchSize, offset)...)
 		if err != nil {
 			return err
 		}
@@ -500,7 +738,8 @@ func GetTasksStreaming(userID int, batchSize int, processFunc func([]map[string]
 			var completedAt sql.NullString
 			var isDeleted sql.NullBool
 			var lastModified sql.NullString
-			if err := rows.Scan(&id, &localID, &serverVersion, &title, &description, &status, &priority, &dueAt, &createdAt, &updatedAt, &completedAt, &isDeleted, &lastModified); err != nil {
+			var tags sql.NullString
+			if err := rows.Scan(&id, &localID, &serverVersion, &title, &description, &status, &priority, &dueAt, &createdAt, &updatedAt, &completedAt, &isDeleted, &lastModified, &tags); err != nil {
 				rows.Close()
 				return err
 			}
@@ -523,6 +762,7 @@ func GetTasksStreaming(userID int, batchSize int, processFunc func([]map[string]
 				"completed_at":  completedAt.String,
 				"is_deleted":    isDeleted.Bool,
 				"last_modified": lastModified.String,
+				"tags":          parseTagList(tags.String),
 			}
 			batch = append(batch, row)
 		}
@@ -542,75 +782,6 @@ func GetTasksStreaming(userID int, batchSize int, processFunc func([]map[string]
 	return nil
 }
 
-// GetTasksPaginated 分页获取任务
-func GetTasksPaginated(userID int, page, pageSize int) ([]map[string]interface{}, int, error) {
-	offset := (page - 1) * pageSize
-
-	// 获取总数
-	var total int
-	err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE user_id = ? AND is_deleted = 0", userID).Scan(&total)
-	if err != nil {
-		return nil, 0, err
-	}
-
-	// 获取分页任务
-	query := `
-		SELECT id, local_id, server_version, title, description, status, priority,
-		       due_at, created_at, updated_at, completed_at, is_deleted, last_modified
-		FROM tasks
-		WHERE user_id = ? AND is_deleted = 0
-		ORDER BY created_at DESC
-		LIMIT ? OFFSET ?
-	`
-	rows, err := DB.Query(query, userID, pageSize, offset)
-	if err != nil {
-		return nil, 0, err
-	}
-	defer rows.Close()
-
-	var results []map[string]interface{}
-	for rows.Next() {
-		var id int64
-		var localID sql.NullString
-		var serverVersion sql.NullInt64
-		var title sql.NullString
-		var description sql.NullString
-		var status sql.NullString
-		var priority sql.NullString
-		var dueAt sql.NullString
-		var createdAt sql.NullString
-		var updatedAt sql.NullString
-		var completedAt sql.NullString
-		var isDeleted sql.NullBool
-		var lastModified sql.NullString
-		if err := rows.Scan(&id, &localID, &serverVersion, &title, &description, &status, &priority, &dueAt, &createdAt, &updatedAt, &completedAt, &isDeleted, &lastModified); err != nil {
-			return nil, 0, err
-		}
-		row := map[string]interface{}{
-			"id":       id,
-			"local_id": localID.String,
-			"server_version": func() interface{} {
-				if serverVersion.Valid {
-					return serverVersion.Int64
-				}
-				return nil
-			}(),
-			"title":         title.String,
-			"description":   description.String,
-			"status":        status.String,
-			"priority":      priority.String,
-			"due_at":        dueAt.String,
-			"created_at":    createdAt.String,
-			"updated_at":    updatedAt.String,
-			"completed_at":  completedAt.String,
-			"is_deleted":    isDeleted.Bool,
-			"last_modified": lastModified.String,
-		}
-		results = append(results, row)
-	}
-	return results, total, nil
-}
-
 // CreateTask 创建新任务
 func CreateTask(userID int, localID, title string) (int64, error) {
 	now := time.Now().UTC()
@@ -640,9 +811,9 @@ func DeleteTask(taskID int64) error {
 	now := time.Now().UTC()
 	_, err := DB.Exec(`
 		UPDATE tasks
-		SET is_deleted = 1, updated_at = ?, last_modified = ?
+		SET is_deleted = 1, deleted_at = ?, updated_at = ?, last_modified = ?
 		WHERE id = ?
-	`, now, now, taskID)
+	`, now, now, now, taskID)
 	return err
 }
 
@@ -719,7 +890,8 @@ func GetAllUsers() ([]map[string]interface{}, error) {
 }
 
 // GetUsersPaginated 分页获取用户列表
-func GetUsersPaginated(page, pageSize int, email, role string) ([]map[string]interface{}, int, error) {
+// cursor 非空时按 (created_at, id) 游标定位并忽略页码，返回下一页游标（没有更多数据时为 nil）
+func GetUsersPaginated(page, pageSize int, email, role string, cursor *types.Cursor) ([]map[string]interface{}, int, *types.Cursor, error) {
 	// 验证分页参数
 	if page < 1 {
 		page = 1
@@ -746,12 +918,12 @@ func GetUsersPaginated(page, pageSize int, email, role string) ([]map[string]int
 	var total int
 	err := DB.QueryRow(query, args...).Scan(&total)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 
 	// 获取分页数据
 	query = `
-		SELECT id, email, role, failed_attempts, locked_until, must_change_password, created_at, updated_at
+		SELECT id, email, role, failed_attempts, locked_until, must_change_password, created_at, updated_at, created_at
 		FROM users WHERE 1=1
 	`
 
@@ -762,16 +934,29 @@ func GetUsersPaginated(page, pageSize int, email, role string) ([]map[string]int
 		query += " AND role = ?"
 	}
 
-	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
-	results := append(args, pageSize, offset)
+	if cursor != nil {
+		clause, clauseArgs, err := keysetClause("created_at", "created_at", true, cursor)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		query += " AND " + clause
+		args = append(args, clauseArgs...)
+		offset = 0
+	}
+
+	// 多取一行用于判断是否还有下一页
+	query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	results := append(args, pageSize+1, offset)
 
 	rows, err := DB.Query(query, results...)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	defer rows.Close()
 
 	var userResults []map[string]interface{}
+	var next *types.Cursor
+	var lastSortValue interface{}
 	for rows.Next() {
 		var id int64
 		var emailStr, userRole string
@@ -779,11 +964,18 @@ func GetUsersPaginated(page, pageSize int, email, role string) ([]map[string]int
 		var lockedUntil sql.NullTime
 		var mustChangePassword bool
 		var createdAt, updatedAt string
+		var sortValue interface{}
 
-		if err := rows.Scan(&id, &emailStr, &userRole, &failedAttempts, &lockedUntil, &mustChangePassword, &createdAt, &updatedAt); err != nil {
-			return nil, 0, err
+		if err := rows.Scan(&id, &emailStr, &userRole, &failedAttempts, &lockedUntil, &mustChangePassword, &createdAt, &updatedAt, &sortValue); err != nil {
+			return nil, 0, nil, err
 		}
 
+		if len(userResults) == pageSize {
+			next = newCursor("created_at", lastSortValue, userResults[len(userResults)-1]["id"].(int64))
+			break
+		}
+		lastSortValue = sortValue
+
 		userResults = append(userResults, map[string]interface{}{
 			"id":              id,
 			"email":           emailStr,
@@ -802,7 +994,7 @@ func GetUsersPaginated(page, pageSize int, email, role string) ([]map[string]int
 		})
 	}
 
-	return userResults, total, nil
+	return userResults, total, next, nil
 }
 
 // CreateUser 创建新用户
@@ -917,8 +1109,9 @@ func LogExportAction(userID int, exportType, format string, count int) error {
 }
 
 // GetAdminLogs 获取管理员操作日志
-func GetAdminLogs(filters map[string]string) ([]map[string]interface{}, error) {
-	query := "SELECT * FROM admin_logs WHERE 1=1"
+// cursor 非空时按 (timestamp, id) 游标定位并忽略页码，返回下一页游标（没有更多数据时为 nil）
+func GetAdminLogs(filters map[string]string, cursor *types.Cursor) ([]map[string]interface{}, *types.Cursor, error) {
+	query := "SELECT id, admin_id, admin_email, action, target_user_id, target_email, details, ip_address, timestamp, timestamp FROM admin_logs WHERE 1=1"
 	args := []interface{}{}
 
 	if email, ok := filters["email"]; ok && email != "" {
@@ -946,7 +1139,6 @@ func GetAdminLogs(filters map[string]string) ([]map[string]interface{}, error) {
 		args = append(args, endTime)
 	}
 
-	query += " ORDER BY timestamp DESC LIMIT ? OFFSET ?"
 	pageSize, _ := strconv.Atoi(filters["page_size"])
 	page, _ := strconv.Atoi(filters["page"])
 	if pageSize <= 0 {
@@ -955,21 +1147,43 @@ func GetAdminLogs(filters map[string]string) ([]map[string]interface{}, error) {
 	if page <= 0 {
 		page = 1
 	}
-	args = append(args, pageSize, (page-1)*pageSize)
+	offset := (page - 1) * pageSize
+
+	if cursor != nil {
+		clause, clauseArgs, err := keysetClause("timestamp", "timestamp", true, cursor)
+		if err != nil {
+			return nil, nil, err
+		}
+		query += " AND " + clause
+		args = append(args, clauseArgs...)
+		offset = 0
+	}
+
+	// 多取一行用于判断是否还有下一页
+	query += " ORDER BY timestamp DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, pageSize+1, offset)
 
 	rows, err := DB.Query(query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var results []map[string]interface{}
+	var next *types.Cursor
+	var lastSortValue interface{}
 	for rows.Next() {
 		var id, adminID, targetUserID int
 		var adminEmail, action, targetEmail, details, ipAddress, timestamp string
-		if err := rows.Scan(&id, &adminID, &adminEmail, &action, &targetUserID, &targetEmail, &details, &ipAddress, &timestamp); err != nil {
-			return nil, err
+		var sortValue interface{}
+		if err := rows.Scan(&id, &adminID, &adminEmail, &action, &targetUserID, &targetEmail, &details, &ipAddress, &timestamp, &sortValue); err != nil {
+			return nil, nil, err
+		}
+		if len(results) == pageSize {
+			next = newCursor("timestamp", lastSortValue, int64(results[len(results)-1]["id"].(int)))
+			break
 		}
+		lastSortValue = sortValue
 		results = append(results, map[string]interface{}{
 			"id":             id,
 			"admin_id":       adminID,
@@ -982,7 +1196,7 @@ func GetAdminLogs(filters map[string]string) ([]map[string]interface{}, error) {
 			"timestamp":      timestamp,
 		})
 	}
-	return results, nil
+	return results, next, nil
 }
 
 // GetLoginLogsWithFilters 获取登录日志（带过滤）
@@ -1065,6 +1279,19 @@ func GetSystemConfig() (map[string]string, error) {
 	return config, nil
 }
 
+// GetConfigValue 获取单个系统配置项，found 为 false 表示未配置
+func GetConfigValue(key string) (string, bool, error) {
+	var value sql.NullString
+	err := DB.QueryRow("SELECT value FROM system_config WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value.String, true, nil
+}
+
 // SetSystemConfig 设置系统配置
 func SetSystemConfig(key, value, description, updatedBy string) error {
 	now := time.Now().UTC()
@@ -1097,7 +1324,8 @@ func CreateNotification(userID int, ntype, title, content, priority string, expi
 }
 
 // GetNotificationsPaginated 分页获取通知
-func GetNotificationsPaginated(userID int, page, pageSize int, filters map[string]string) ([]map[string]interface{}, int, error) {
+// cursor 非空时按 (created_at, id) 游标定位并忽略页码，返回下一页游标（没有更多数据时为 nil）
+func GetNotificationsPaginated(userID int, page, pageSize int, filters map[string]string, cursor *types.Cursor) ([]map[string]interface{}, int, *types.Cursor, error) {
 	offset := (page - 1) * pageSize
 	query := "SELECT count(*) FROM notifications WHERE user_id = ?"
 	args := []interface{}{userID}
@@ -1119,10 +1347,10 @@ func GetNotificationsPaginated(userID int, page, pageSize int, filters map[strin
 	var total int
 	err := DB.QueryRow(query, args...).Scan(&total)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 
-	query = `SELECT id, user_id, type, title, content, priority, is_read, read_at, created_at, expires_at
+	query = `SELECT id, user_id, type, title, content, priority, is_read, read_at, created_at, expires_at, created_at
 	          FROM notifications WHERE user_id = ?`
 
 	if read, ok := filters["read"]; ok && read != "" {
@@ -1135,16 +1363,29 @@ func GetNotificationsPaginated(userID int, page, pageSize int, filters map[strin
 		query += " AND priority = ?"
 	}
 
-	query += " ORDER BY created_at DESC LIMIT ? OFFSET ?"
-	args = append(args, pageSize, offset)
+	if cursor != nil {
+		clause, clauseArgs, err := keysetClause("created_at", "created_at", true, cursor)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		query += " AND " + clause
+		args = append(args, clauseArgs...)
+		offset = 0
+	}
+
+	// 多取一行用于判断是否还有下一页
+	query += " ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?"
+	args = append(args, pageSize+1, offset)
 
 	rows, err := DB.Query(query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, nil, err
 	}
 	defer rows.Close()
 
 	var results []map[string]interface{}
+	var next *types.Cursor
+	var lastSortValue interface{}
 	for rows.Next() {
 		var id int64
 		var userIDInt int
@@ -1152,10 +1393,17 @@ func GetNotificationsPaginated(userID int, page, pageSize int, filters map[strin
 		var isRead bool
 		var readAt, createdAt sql.NullString
 		var expiresAt sql.NullString
+		var sortValue interface{}
 
-		if err := rows.Scan(&id, &userIDInt, &ntype, &title, &content, &priority, &isRead, &readAt, &createdAt, &expiresAt); err != nil {
-			return nil, 0, err
+		if err := rows.Scan(&id, &userIDInt, &ntype, &title, &content, &priority, &isRead, &readAt, &createdAt, &expiresAt, &sortValue); err != nil {
+			return nil, 0, nil, err
+		}
+
+		if len(results) == pageSize {
+			next = newCursor("created_at", lastSortValue, results[len(results)-1]["id"].(int64))
+			break
 		}
+		lastSortValue = sortValue
 
 		results = append(results, map[string]interface{}{
 			"id":       id,
@@ -1181,7 +1429,7 @@ func GetNotificationsPaginated(userID int, page, pageSize int, filters map[strin
 		})
 	}
 
-	return results, total, nil
+	return results, total, next, nil
 }
 
 // MarkNotificationAsRead 标记通知为已读
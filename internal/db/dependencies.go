@@ -0,0 +1,342 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// maxGraphNodes 依赖图最多返回的任务数
+const maxGraphNodes = 500
+
+var (
+	// ErrDependencyCycle 添加的依赖会形成环
+	ErrDependencyCycle = errors.New("添加该依赖会形成循环依赖")
+	// ErrDependencyNotFound 依赖关系不存在
+	ErrDependencyNotFound = errors.New("依赖关系不存在")
+)
+
+// BlockedError 前置任务未完成时不允许开始或完成任务
+type BlockedError struct {
+	Blockers []int64
+}
+
+func (e *BlockedError) Error() string {
+	return "存在未完成的前置任务: " + joinIDs(e.Blockers)
+}
+
+// joinIDs 以逗号连接任务 ID
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// DependencyEnforcement 读取前置任务未完成时的处理方式（system_config 中的 dependency_enforcement），默认仅警告
+func DependencyEnforcement() string {
+	value, found, err := GetConfigValue("dependency_enforcement")
+	if err != nil {
+		log.Printf("读取依赖检查方式失败: %v", err)
+		return types.DependencyWarn
+	}
+	if found && value == types.DependencyReject {
+		return types.DependencyReject
+	}
+	return types.DependencyWarn
+}
+
+// queryIDs 执行返回单列任务 ID 的查询
+func queryIDs(q Querier, query string, args ...interface{}) ([]int64, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// openBlockers 获取任务未完成（且未删除）的前置任务
+func openBlockers(q Querier, taskID int64) ([]int64, error) {
+	return queryIDs(q, `
+		SELECT b.id FROM task_dependencies d JOIN tasks b ON b.id = d.blocker_id
+		WHERE d.task_id = ? AND b.is_deleted = 0 AND b.status NOT IN ('done', 'archived')
+		ORDER BY b.id`, taskID)
+}
+
+// checkBlockersTx 任务将变为 in_progress 或 done 时检查前置任务
+// reject 模式下存在未完成的前置任务返回 BlockedError，warn 模式下返回这些前置任务供调用方提示
+func checkBlockersTx(tx *sql.Tx, taskID int64, status string) ([]int64, error) {
+	if status != "in_progress" && status != "done" {
+		return nil, nil
+	}
+	blockers, err := openBlockers(tx, taskID)
+	if err != nil || len(blockers) == 0 {
+		return nil, err
+	}
+	if DependencyEnforcement() == types.DependencyReject {
+		return nil, &BlockedError{Blockers: blockers}
+	}
+	return blockers, nil
+}
+
+// GetTaskDependencies 获取任务的前置任务与被其阻塞的任务
+func GetTaskDependencies(userID int, taskID int64) (*types.TaskDependencies, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	return taskDependencies(DB, taskID)
+}
+
+func taskDependencies(q Querier, taskID int64) (*types.TaskDependencies, error) {
+	blockedBy, err := queryIDs(q, `
+		SELECT d.blocker_id FROM task_dependencies d JOIN tasks t ON t.id = d.blocker_id
+		WHERE d.task_id = ? AND t.is_deleted = 0 ORDER BY d.blocker_id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	blocking, err := queryIDs(q, `
+		SELECT d.task_id FROM task_dependencies d JOIN tasks t ON t.id = d.task_id
+		WHERE d.blocker_id = ? AND t.is_deleted = 0 ORDER BY d.task_id`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return &types.TaskDependencies{TaskID: taskID, BlockedBy: blockedBy, Blocking: blocking}, nil
+}
+
+// AddTaskDependency 将 blockerID 设为任务的前置任务，形成环时返回 ErrDependencyCycle
+// 任务分配新版本号，使 blocked_by 的变化同步到客户端
+func AddTaskDependency(userID int, deviceID string, taskID, blockerID int64) (*types.TaskDependencies, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+	if _, err := checkTaskAccess(tx, userID, blockerID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+
+	// blockerID 已（直接或间接）依赖 taskID 时，新的边会形成环
+	var cycle bool
+	err = tx.QueryRow(`
+		WITH RECURSIVE up(id) AS (
+			SELECT ?
+			UNION
+			SELECT d.blocker_id FROM task_dependencies d JOIN up ON d.task_id = up.id
+		)
+		SELECT EXISTS(SELECT 1 FROM up WHERE id = ?)`,
+		blockerID, taskID,
+	).Scan(&cycle)
+	if err != nil {
+		return nil, err
+	}
+	if cycle {
+		return nil, ErrDependencyCycle
+	}
+
+	res, err := tx.Exec("INSERT OR IGNORE INTO task_dependencies (task_id, blocker_id, user_id, created_at) VALUES (?, ?, ?, ?)",
+		taskID, blockerID, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		if err := touchTasks(tx, userID, deviceID, []int64{taskID}); err != nil {
+			return nil, err
+		}
+	}
+
+	deps, err := taskDependencies(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return deps, tx.Commit()
+}
+
+// RemoveTaskDependency 移除任务的前置任务
+func RemoveTaskDependency(userID int, deviceID string, taskID, blockerID int64) (*types.TaskDependencies, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec("DELETE FROM task_dependencies WHERE task_id = ? AND blocker_id = ?", taskID, blockerID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrDependencyNotFound
+	}
+	if err := touchTasks(tx, userID, deviceID, []int64{taskID}); err != nil {
+		return nil, err
+	}
+
+	deps, err := taskDependencies(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return deps, tx.Commit()
+}
+
+// GetTaskGraph 获取任务所在的依赖图：沿前置与后续方向可达的全部未删除任务及其之间的依赖
+// 同时给出拓扑顺序与由未完成任务组成的最长依赖链（关键路径）
+func GetTaskGraph(userID int, taskID int64) (*types.TaskGraph, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+
+	graph := &types.TaskGraph{TaskID: taskID, Nodes: []types.GraphNode{}, Edges: []types.GraphEdge{}}
+	index := map[int64]int{}
+	queue := []int64{taskID}
+	seen := map[int64]bool{taskID: true}
+	for len(queue) > 0 && len(graph.Nodes) < maxGraphNodes {
+		id := queue[0]
+		queue = queue[1:]
+
+		var node types.GraphNode
+		var title, status sql.NullString
+		var dueAt sql.NullTime
+		err := DB.QueryRow("SELECT id, title, status, due_at FROM tasks WHERE id = ? AND is_deleted = 0 AND "+taskAccessClause, append([]interface{}{id}, taskAccessArgs(userID)...)...).
+			Scan(&node.ID, &title, &status, &dueAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		node.Title = title.String
+		node.Status = status.String
+		node.DueAt = formatDueAt(dueAt)
+		node.Open = node.Status != "done" && node.Status != "archived"
+		index[id] = len(graph.Nodes)
+		graph.Nodes = append(graph.Nodes, node)
+
+		neighbours, err := queryIDs(DB, "SELECT blocker_id FROM task_dependencies WHERE task_id = ? UNION SELECT task_id FROM task_dependencies WHERE blocker_id = ?", id, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range neighbours {
+			if !seen[n] {
+				seen[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	// 只保留两端都在图中的边
+	ids := make([]interface{}, 0, len(graph.Nodes))
+	placeholders := make([]string, 0, len(graph.Nodes))
+	for _, n := range graph.Nodes {
+		ids = append(ids, n.ID)
+		placeholders = append(placeholders, "?")
+	}
+	rows, err := DB.Query("SELECT blocker_id, task_id FROM task_dependencies WHERE task_id IN ("+strings.Join(placeholders, ",")+") ORDER BY blocker_id, task_id", ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e types.GraphEdge
+		if err := rows.Scan(&e.From, &e.To); err != nil {
+			return nil, err
+		}
+		if _, ok := index[e.From]; ok {
+			graph.Edges = append(graph.Edges, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	graph.Order, graph.CriticalPath = analyzeGraph(graph.Nodes, graph.Edges)
+	return graph, nil
+}
+
+// analyzeGraph 计算拓扑顺序（Kahn 算法，同层按 ID 升序）与未完成任务的最长依赖链
+func analyzeGraph(nodes []types.GraphNode, edges []types.GraphEdge) ([]int64, []int64) {
+	open := map[int64]bool{}
+	indegree := map[int64]int{}
+	next := map[int64][]int64{}
+	for _, n := range nodes {
+		open[n.ID] = n.Open
+		indegree[n.ID] = 0
+	}
+	for _, e := range edges {
+		next[e.From] = append(next[e.From], e.To)
+		indegree[e.To]++
+	}
+
+	var ready []int64
+	for _, n := range nodes {
+		if indegree[n.ID] == 0 {
+			ready = append(ready, n.ID)
+		}
+	}
+	order := []int64{}
+	for len(ready) > 0 {
+		// 取 ID 最小的就绪任务，保证结果稳定
+		first := 0
+		for i := range ready {
+			if ready[i] < ready[first] {
+				first = i
+			}
+		}
+		id := ready[first]
+		ready = append(ready[:first], ready[first+1:]...)
+		order = append(order, id)
+		for _, to := range next[id] {
+			if indegree[to]--; indegree[to] == 0 {
+				ready = append(ready, to)
+			}
+		}
+	}
+
+	// 按拓扑顺序求以每个未完成任务结尾的最长链
+	length := map[int64]int{}
+	prev := map[int64]int64{}
+	var end int64
+	for _, id := range order {
+		if !open[id] {
+			continue
+		}
+		if length[id] == 0 {
+			length[id] = 1
+		}
+		if length[id] > length[end] {
+			end = id
+		}
+		for _, to := range next[id] {
+			if open[to] && length[id]+1 > length[to] {
+				length[to] = length[id] + 1
+				prev[to] = id
+			}
+		}
+	}
+	path := []int64{}
+	for id := end; id != 0; id = prev[id] {
+		path = append([]int64{id}, path...)
+	}
+	return order, path
+}
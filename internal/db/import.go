@@ -3,10 +3,12 @@ package db
 import (
 	"fmt"
 	"time"
+
+	"todoapp/internal/types"
 )
 
 // BatchInsertTasks 批量插入任务（用于导入）
-func BatchInsertTasks(userID int, tasks []map[string]interface{}) ([]int64, error) {
+func BatchInsertTasks(userID int, deviceID string, tasks []map[string]interface{}) ([]int64, error) {
 	tx, err := DB.Begin()
 	if err != nil {
 		return nil, err
@@ -45,9 +47,14 @@ func BatchInsertTasks(userID int, tasks []map[string]interface{}) ([]int64, erro
 			priority = "medium"
 		}
 
+		version, err := nextChangeSeq(tx, userID)
+		if err != nil {
+			return nil, err
+		}
+
 		result, err := tx.Exec(
-			"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, created_at, updated_at, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-			userID, localID, 1, title, description, status, priority, now, now, now,
+			"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, created_at, updated_at, is_deleted, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)",
+			userID, localID, version, title, description, status, priority, now, now, now,
 		)
 
 		if err != nil {
@@ -58,6 +65,17 @@ func BatchInsertTasks(userID int, tasks []map[string]interface{}) ([]int64, erro
 		if err != nil {
 			return nil, err
 		}
+		if err := appendTaskChange(tx, userID, version, id, ChangeInsert, deviceID); err != nil {
+			return nil, err
+		}
+		if err := recordActivityTx(tx, userID, id, types.ActivityCreated, map[string]interface{}{"source": "import"}); err != nil {
+			return nil, err
+		}
+		if tags, ok := taskData["tags"].([]string); ok && len(tags) > 0 {
+			if err := setTaskTagsTx(tx, userID, id, tags); err != nil {
+				return nil, err
+			}
+		}
 
 		insertedIDs = append(insertedIDs, id)
 	}
@@ -0,0 +1,422 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+var (
+	// ErrProjectForbidden 用户在项目中的角色不足以执行该操作
+	ErrProjectForbidden = errors.New("没有执行此操作的项目权限")
+	// ErrInvalidRole 成员角色无效
+	ErrInvalidRole = errors.New("角色必须是 viewer、editor 或 owner")
+	// ErrMemberNotFound 用户不是项目成员
+	ErrMemberNotFound = errors.New("该用户不是项目成员")
+	// ErrMemberExists 用户已是项目成员
+	ErrMemberExists = errors.New("该用户已是项目成员")
+	// ErrInviteeNotFound 邀请的用户不存在
+	ErrInviteeNotFound = errors.New("邀请的用户不存在")
+	// ErrProjectCreator 项目创建者始终是 owner，不能修改其角色或将其移除
+	ErrProjectCreator = errors.New("不能修改或移除项目创建者")
+)
+
+// accessibleProjects 用户拥有或被共享的未删除项目（两个参数均为用户 ID）
+const accessibleProjects = `SELECT id FROM projects WHERE is_deleted = 0 AND (user_id = ? OR id IN (SELECT project_id FROM project_members WHERE user_id = ?))`
+
+// taskAccessClause 用户可以读取的任务：本人的任务，或位于用户拥有或被共享的项目中的任务
+// 参数由 taskAccessArgs 生成
+const taskAccessClause = `(user_id = ? OR project_id IN (` + accessibleProjects + `))`
+
+// taskAccessArgs 生成 taskAccessClause 的参数
+func taskAccessArgs(userID int) []interface{} {
+	return []interface{}{userID, userID, userID}
+}
+
+// ValidProjectRole 检查是否为有效的成员角色
+func ValidProjectRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+// roleRank 角色的权限等级，无效或空角色为 0
+func roleRank(role string) int {
+	switch role {
+	case types.ProjectRoleViewer:
+		return 1
+	case types.ProjectRoleEditor:
+		return 2
+	case types.ProjectRoleOwner:
+		return 3
+	}
+	return 0
+}
+
+// projectRole 读取用户在未删除项目中的角色，不是成员时返回空字符串
+func projectRole(q queryRower, userID int, projectID int64) (string, error) {
+	var ownerID int
+	var role sql.NullString
+	err := q.QueryRow(`
+		SELECT p.user_id, m.role FROM projects p
+		LEFT JOIN project_members m ON m.project_id = p.id AND m.user_id = ?
+		WHERE p.id = ? AND p.is_deleted = 0`, userID, projectID).Scan(&ownerID, &role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if ownerID == userID {
+		return types.ProjectRoleOwner, nil
+	}
+	return role.String, nil
+}
+
+// checkProjectAccess 检查用户能否访问项目且角色不低于 minRole，返回附带用户角色的项目
+// 不是成员时返回 ErrProjectNotFound（不暴露项目是否存在），角色不足时返回 ErrProjectForbidden
+func checkProjectAccess(q queryRower, userID int, projectID int64, minRole string) (*types.Project, error) {
+	role, err := projectRole(q, userID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if role == "" {
+		return nil, ErrProjectNotFound
+	}
+	if roleRank(role) < roleRank(minRole) {
+		return nil, ErrProjectForbidden
+	}
+	p, err := scanProject(q.QueryRow("SELECT "+projectColumns+" FROM projects WHERE id = ?", projectID))
+	if err != nil {
+		return nil, err
+	}
+	p.Role = role
+	return p, nil
+}
+
+// taskRole 读取用户对任务的角色：任务属于用户本人时为 owner，否则为用户在任务所在项目中的角色
+func taskRole(q queryRower, userID int, ownerID int, projectID sql.NullInt64) (string, error) {
+	if ownerID == userID {
+		return types.ProjectRoleOwner, nil
+	}
+	if !projectID.Valid {
+		return "", nil
+	}
+	return projectRole(q, userID, projectID.Int64)
+}
+
+// checkTaskAccess 检查任务是否存在、未删除且用户的角色不低于 minRole，返回当前版本号
+// 用户既不是任务所有者也不是其所在项目的成员，或角色不足时返回 ErrTaskForbidden
+func checkTaskAccess(q queryRower, userID int, taskID int64, minRole string) (int, error) {
+	var ownerID int
+	var serverVersion sql.NullInt64
+	var isDeleted sql.NullBool
+	var projectID sql.NullInt64
+	err := q.QueryRow("SELECT user_id, server_version, is_deleted, project_id FROM tasks WHERE id = ?", taskID).
+		Scan(&ownerID, &serverVersion, &isDeleted, &projectID)
+	if err == sql.ErrNoRows {
+		return 0, ErrTaskNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	role, err := taskRole(q, userID, ownerID, projectID)
+	if err != nil {
+		return 0, err
+	}
+	if roleRank(role) < roleRank(minRole) {
+		return 0, ErrTaskForbidden
+	}
+	if isDeleted.Bool {
+		return 0, ErrTaskNotFound
+	}
+	return int(serverVersion.Int64), nil
+}
+
+// CheckTaskAccess 检查用户对任务的角色是否不低于 minRole，供处理器在写入前预先校验
+func CheckTaskAccess(userID int, taskID int64, minRole string) error {
+	_, err := checkTaskAccess(DB, userID, taskID, minRole)
+	return err
+}
+
+// checkTaskAccessAny 与 checkTaskAccess 相同，但已软删除的任务同样视为存在
+func checkTaskAccessAny(q queryRower, userID int, taskID int64, minRole string) error {
+	var ownerID int
+	var projectID sql.NullInt64
+	err := q.QueryRow("SELECT user_id, project_id FROM tasks WHERE id = ?", taskID).Scan(&ownerID, &projectID)
+	if err == sql.ErrNoRows {
+		return ErrTaskNotFound
+	}
+	if err != nil {
+		return err
+	}
+	role, err := taskRole(q, userID, ownerID, projectID)
+	if err != nil {
+		return err
+	}
+	if roleRank(role) < roleRank(minRole) {
+		return ErrTaskForbidden
+	}
+	return nil
+}
+
+// projectAudience 能看到项目的全部用户：创建者与全部成员，创建者在前
+func projectAudience(q Querier, projectID int64) ([]int, error) {
+	rows, err := q.Query(`
+		SELECT user_id FROM (
+			SELECT user_id, 0 AS ord FROM projects WHERE id = ? AND is_deleted = 0
+			UNION
+			SELECT m.user_id, 1 FROM project_members m JOIN projects p ON p.id = m.project_id
+			WHERE m.project_id = ? AND p.is_deleted = 0
+		) ORDER BY ord, user_id`, projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	return users, rows.Err()
+}
+
+// taskAudience 返回任务所有者，以及因共享项目而能看到该任务的其他用户
+func taskAudience(q Querier, taskID int64) (int, []int, error) {
+	var ownerID int
+	var projectID sql.NullInt64
+	if err := q.QueryRow("SELECT user_id, project_id FROM tasks WHERE id = ?", taskID).Scan(&ownerID, &projectID); err != nil {
+		return 0, nil, err
+	}
+	if !projectID.Valid {
+		return ownerID, nil, nil
+	}
+	audience, err := projectAudience(q, projectID.Int64)
+	if err != nil {
+		return 0, nil, err
+	}
+	return ownerID, excludeUsers(audience, ownerID), nil
+}
+
+// excludeUsers 返回 users 中不在 except 里的用户
+func excludeUsers(users []int, except ...int) []int {
+	skip := map[int]bool{}
+	for _, id := range except {
+		skip[id] = true
+	}
+	var result []int
+	for _, id := range users {
+		if !skip[id] {
+			result = append(result, id)
+		}
+	}
+	return result
+}
+
+// shareChangeTx 在每个用户自己的变更日志中追加一条实体变更（序号各自分配），使共享的变更随各自的同步下发
+// 实体的版本号仍以所有者变更日志中的序号为准
+func shareChangeTx(tx *sql.Tx, userIDs []int, actorID int, entityType string, entityID int64, op, deviceID string) error {
+	for _, uid := range userIDs {
+		seq, err := nextChangeSeq(tx, uid)
+		if err != nil {
+			return err
+		}
+		if err := appendChange(tx, uid, actorID, seq, entityType, entityID, op, deviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shareProjectContentsTx 将项目本身及其中全部未删除的任务记入用户的变更日志
+// 用户加入项目后据此拉取项目内容，离开项目后据此收到墓碑
+func shareProjectContentsTx(tx *sql.Tx, userID, actorID int, projectID int64, op string) error {
+	if err := shareChangeTx(tx, []int{userID}, actorID, EntityProject, projectID, op, ""); err != nil {
+		return err
+	}
+	ids, err := queryIDs(tx, "SELECT id FROM tasks WHERE project_id = ? AND is_deleted = 0 AND user_id != ? ORDER BY id", projectID, userID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := shareChangeTx(tx, []int{userID}, actorID, EntityTask, id, op, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListProjectMembers 获取项目的全部成员（创建者在前），项目的任何成员都可查看
+func ListProjectMembers(userID int, projectID int64) ([]types.ProjectMember, error) {
+	if _, err := checkProjectAccess(DB, userID, projectID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	return projectMembers(DB, projectID)
+}
+
+// projectMembers 读取项目的创建者与成员
+func projectMembers(q Querier, projectID int64) ([]types.ProjectMember, error) {
+	rows, err := q.Query(`
+		SELECT u.id, u.email, 'owner', 0, p.created_at, 0 AS ord FROM projects p JOIN users u ON u.id = p.user_id WHERE p.id = ?
+		UNION ALL
+		SELECT u.id, u.email, m.role, COALESCE(m.invited_by, 0), m.created_at, 1 FROM project_members m JOIN users u ON u.id = m.user_id WHERE m.project_id = ?
+		ORDER BY 6, 5, 1`, projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []types.ProjectMember{}
+	for rows.Next() {
+		var m types.ProjectMember
+		var createdAt sql.NullTime
+		var ord int
+		if err := rows.Scan(&m.UserID, &m.Email, &m.Role, &m.InvitedBy, &createdAt, &ord); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = formatDueAt(createdAt)
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// projectMember 读取单个成员
+func projectMember(q Querier, projectID int64, memberID int) (*types.ProjectMember, error) {
+	members, err := projectMembers(q, projectID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range members {
+		if members[i].UserID == memberID {
+			return &members[i], nil
+		}
+	}
+	return nil, ErrMemberNotFound
+}
+
+// AddProjectMember 以指定角色邀请用户加入项目，只有 owner 可以邀请
+// 新成员的变更日志中记入项目及其全部任务，下一次增量同步即可拉取
+func AddProjectMember(userID int, projectID int64, email, role string) (*types.ProjectMember, error) {
+	if !ValidProjectRole(role) {
+		return nil, ErrInvalidRole
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	var memberID int
+	err = tx.QueryRow("SELECT id FROM users WHERE email = ?", email).Scan(&memberID)
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if memberID == p.OwnerID {
+		return nil, ErrMemberExists
+	}
+
+	_, err = tx.Exec("INSERT INTO project_members (project_id, user_id, role, invited_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		projectID, memberID, role, userID, time.Now().UTC())
+	if isUniqueViolation(err) {
+		return nil, ErrMemberExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := shareProjectContentsTx(tx, memberID, userID, projectID, ChangeInsert); err != nil {
+		return nil, err
+	}
+
+	m, err := projectMember(tx, projectID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+// UpdateProjectMember 修改成员的角色，只有 owner 可以修改，项目创建者的角色不能修改；降为 viewer 的成员不再担任项目中任务的负责人
+func UpdateProjectMember(userID int, projectID int64, memberID int, role string) (*types.ProjectMember, error) {
+	if !ValidProjectRole(role) {
+		return nil, ErrInvalidRole
+	}
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	if memberID == p.OwnerID {
+		return nil, ErrProjectCreator
+	}
+	res, err := tx.Exec("UPDATE project_members SET role = ? WHERE project_id = ? AND user_id = ?", role, projectID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrMemberNotFound
+	}
+	// 角色随项目下发，成员的客户端据此调整可编辑状态
+	if err := shareChangeTx(tx, []int{memberID}, userID, EntityProject, projectID, ChangeUpdate, ""); err != nil {
+		return nil, err
+	}
+	if err := unassignMemberTx(tx, userID, "", projectID, memberID); err != nil {
+		return nil, err
+	}
+
+	m, err := projectMember(tx, projectID, memberID)
+	if err != nil {
+		return nil, err
+	}
+	return m, tx.Commit()
+}
+
+// RemoveProjectMember 将成员移出项目：owner 可以移除任何成员，成员也可以自行退出
+// 其在项目中的指派一并取消，被移除的成员随后同步时收到项目及其任务的墓碑
+func RemoveProjectMember(userID int, projectID int64, memberID int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	minRole := types.ProjectRoleOwner
+	if memberID == userID {
+		minRole = types.ProjectRoleViewer
+	}
+	p, err := checkProjectAccess(tx, userID, projectID, minRole)
+	if err != nil {
+		return err
+	}
+	if memberID == p.OwnerID {
+		return ErrProjectCreator
+	}
+	res, err := tx.Exec("DELETE FROM project_members WHERE project_id = ? AND user_id = ?", projectID, memberID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrMemberNotFound
+	}
+	if err := unassignMemberTx(tx, userID, "", projectID, memberID); err != nil {
+		return err
+	}
+	if err := shareProjectContentsTx(tx, memberID, userID, projectID, ChangeDelete); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
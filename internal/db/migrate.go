@@ -0,0 +1,156 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// syncMetaSchema 记录每个用户每台设备的增量同步进度
+const syncMetaSchema = `CREATE TABLE IF NOT EXISTS sync_meta (
+            user_id INTEGER NOT NULL,
+            device_id TEXT NOT NULL DEFAULT '',
+            last_sync_at DATETIME,
+            last_server_version INTEGER DEFAULT 0,
+            PRIMARY KEY(user_id, device_id),
+            FOREIGN KEY(user_id) REFERENCES users(id)
+        );`
+
+// migrateSchema 对已有数据库执行增量结构调整
+func migrateSchema() error {
+	// 早期的 sync_meta 以 user_id 为主键且从未写入，直接按设备维度重建
+	hasDevice, err := columnExists("sync_meta", "device_id")
+	if err != nil {
+		return err
+	}
+	if !hasDevice {
+		if _, err := DB.Exec("DROP TABLE sync_meta"); err != nil {
+			return err
+		}
+		if _, err := DB.Exec(syncMetaSchema); err != nil {
+			return err
+		}
+	}
+
+	// conflicts 增加用户归属与字段级冲突信息
+	conflictColumns := []struct{ name, def string }{
+		{"user_id", "INTEGER"},
+		{"field_conflicts", "TEXT"},
+		{"status", "TEXT DEFAULT 'open'"},
+		{"resolution", "TEXT"},
+		{"resolved_at", "DATETIME"},
+	}
+	for _, c := range conflictColumns {
+		if err := ensureColumn("conflicts", c.name, c.def); err != nil {
+			return err
+		}
+	}
+	if _, err := DB.Exec("UPDATE conflicts SET user_id = (SELECT user_id FROM tasks WHERE tasks.id = conflicts.server_id) WHERE user_id IS NULL"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_conflicts_user_status ON conflicts(user_id, status)"); err != nil {
+		return err
+	}
+
+	// change_log 记录执行变更的用户
+	if err := ensureColumn("change_log", "actor_id", "INTEGER"); err != nil {
+		return err
+	}
+
+	// 早期同步与导入写入的任务未设置 is_deleted
+	if _, err := DB.Exec("UPDATE tasks SET is_deleted = 0 WHERE is_deleted IS NULL"); err != nil {
+		return err
+	}
+
+	// 已完成但未记录完成时间的任务以最后更新时间补齐
+	if _, err := DB.Exec("UPDATE tasks SET completed_at = updated_at WHERE status = 'done' AND completed_at IS NULL"); err != nil {
+		return err
+	}
+
+	// 回收站按删除时间计算保留期限，已删除的任务以最后修改时间作为删除时间，没有任何时间记录时从现在开始计算
+	if err := ensureColumn("tasks", "deleted_at", "DATETIME"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("UPDATE tasks SET deleted_at = COALESCE(last_modified, updated_at, created_at, ?) WHERE is_deleted = 1 AND deleted_at IS NULL", time.Now().UTC()); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_deleted_at ON tasks(is_deleted, deleted_at)"); err != nil {
+		return err
+	}
+
+	// 重复任务所属序列与发生序号
+	for _, column := range []string{"series_id", "occurrence"} {
+		if err := ensureColumn("tasks", column, "INTEGER"); err != nil {
+			return err
+		}
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_series ON tasks(series_id, occurrence)"); err != nil {
+		return err
+	}
+
+	// 子任务的父任务与在兄弟任务中的位置
+	for _, column := range []string{"parent_id", "position"} {
+		if err := ensureColumn("tasks", column, "INTEGER"); err != nil {
+			return err
+		}
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_parent ON tasks(parent_id, position)"); err != nil {
+		return err
+	}
+
+	// 任务所属项目与项目内的排序键
+	if err := ensureColumn("tasks", "project_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumn("tasks", "project_rank", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_project ON tasks(project_id, project_rank)"); err != nil {
+		return err
+	}
+
+	// 任务的负责人
+	if err := ensureColumn("tasks", "assignee_id", "INTEGER"); err != nil {
+		return err
+	}
+	if _, err := DB.Exec("CREATE INDEX IF NOT EXISTS idx_tasks_assignee ON tasks(assignee_id)"); err != nil {
+		return err
+	}
+
+	// change_log 同时记录任务、项目、视图与工时记录的变更，task_id 为对应实体的 ID
+	if err := ensureColumn("change_log", "entity_type", "TEXT NOT NULL DEFAULT 'task'"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ensureColumn 列不存在时为表添加该列
+func ensureColumn(table, column, definition string) error {
+	exists, err := columnExists(table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// columnExists 检查表中是否存在指定列
+func columnExists(table, column string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue interface{}
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
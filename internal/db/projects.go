@@ -0,0 +1,516 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"todoapp/internal/fracindex"
+	"todoapp/internal/types"
+)
+
+// DefaultProjectColor 未指定颜色时项目使用的颜色
+const DefaultProjectColor = "#808080"
+
+var (
+	// ErrProjectNotFound 项目不存在、已删除，或当前用户不是项目成员
+	ErrProjectNotFound = errors.New("项目不存在")
+	// ErrInvalidProject 任务指定的项目无效
+	ErrInvalidProject = errors.New("项目不存在或已删除")
+	// ErrInvalidAnchor 移动任务时参照的任务不在该项目中
+	ErrInvalidAnchor = errors.New("参照任务不在该项目中")
+)
+
+// ProjectFields 项目可写字段，nil 表示不修改
+type ProjectFields struct {
+	Name     *string
+	Color    *string
+	Archived *bool
+	Rank     *string // 项目之间的排序键，创建时缺省排在最后
+}
+
+// projectColumns 项目查询的标准列，与 scanProject 的扫描顺序一致
+const projectColumns = `id, local_id, server_version, user_id, name, color, archived, rank, is_deleted, created_at, updated_at,
+	(SELECT COUNT(*) FROM tasks t WHERE t.project_id = projects.id AND t.is_deleted = 0) AS task_count,
+	(SELECT COUNT(*) FROM project_members m WHERE m.project_id = projects.id) AS member_count`
+
+// scanProject 扫描一行项目记录
+func scanProject(s rowScanner) (*types.Project, error) {
+	p := &types.Project{}
+	var localID sql.NullString
+	var createdAt, updatedAt sql.NullTime
+	if err := s.Scan(&p.ID, &localID, &p.ServerVersion, &p.OwnerID, &p.Name, &p.Color, &p.Archived, &p.Rank, &p.IsDeleted,
+		&createdAt, &updatedAt, &p.TaskCount, &p.MemberCount); err != nil {
+		return nil, err
+	}
+	p.LocalID = localID.String
+	p.CreatedAt = formatDueAt(createdAt)
+	p.UpdatedAt = formatDueAt(updatedAt)
+	return p, nil
+}
+
+// queryProjects 执行返回项目列表的查询
+func queryProjects(q Querier, query string, args ...interface{}) ([]*types.Project, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := []*types.Project{}
+	for rows.Next() {
+		p, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// fillProjectRoles 填充用户在各项目中的角色
+func fillProjectRoles(q queryRower, userID int, projects []*types.Project) error {
+	for _, p := range projects {
+		if p.OwnerID == userID {
+			p.Role = types.ProjectRoleOwner
+			continue
+		}
+		role, err := projectRole(q, userID, p.ID)
+		if err != nil {
+			return err
+		}
+		p.Role = role
+	}
+	return nil
+}
+
+// ListProjects 获取用户拥有或被共享的未删除项目（按排序键），includeArchived 为 false 时不含已归档的项目
+func ListProjects(userID int, includeArchived bool) ([]*types.Project, error) {
+	query := "SELECT " + projectColumns + " FROM projects WHERE id IN (" + accessibleProjects + ")"
+	if !includeArchived {
+		query += " AND archived = 0"
+	}
+	projects, err := queryProjects(DB, query+" ORDER BY rank, id", userID, userID)
+	if err != nil {
+		return nil, err
+	}
+	return projects, fillProjectRoles(DB, userID, projects)
+}
+
+// GetProject 获取用户拥有或被共享的单个项目
+func GetProject(userID int, projectID int64) (*types.Project, error) {
+	return checkProjectAccess(DB, userID, projectID, types.ProjectRoleViewer)
+}
+
+// CreateProject 创建项目并返回完整记录
+func CreateProject(userID int, deviceID, localID string, f ProjectFields) (*types.Project, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, _, err := insertProjectTx(tx, userID, deviceID, localID, f)
+	if err != nil {
+		return nil, err
+	}
+	p, err := checkProjectAccess(tx, userID, id, types.ProjectRoleOwner)
+	if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit()
+}
+
+// insertProjectTx 插入项目并记录变更，返回项目 ID 与分配的版本号
+func insertProjectTx(tx *sql.Tx, userID int, deviceID, localID string, f ProjectFields) (int64, int, error) {
+	name := ""
+	color := DefaultProjectColor
+	archived := false
+	if f.Name != nil {
+		name = *f.Name
+	}
+	if f.Color != nil {
+		color = *f.Color
+	}
+	if f.Archived != nil {
+		archived = *f.Archived
+	}
+	var rank string
+	if f.Rank != nil {
+		rank = *f.Rank
+	} else {
+		var last sql.NullString
+		if err := tx.QueryRow("SELECT MAX(rank) FROM projects WHERE user_id = ? AND is_deleted = 0", userID).Scan(&last); err != nil {
+			return 0, 0, err
+		}
+		var err error
+		if rank, err = fracindex.Between(last.String, ""); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO projects (user_id, local_id, server_version, name, color, archived, rank, is_deleted, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)",
+		userID, localID, version, name, color, archived, rank, now, now,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, version, appendChange(tx, userID, userID, version, EntityProject, id, ChangeInsert, deviceID)
+}
+
+// logProjectChange 分配序号并记录已有项目的变更，userID 为执行者
+// 序号在项目创建者的变更日志中分配（即项目的 server_version），其他成员的变更日志中各追加一条
+func logProjectChange(tx *sql.Tx, userID int, projectID int64, op, deviceID string) (int, error) {
+	audience, err := projectAudience(tx, projectID)
+	if err != nil {
+		return 0, err
+	}
+	if len(audience) == 0 {
+		return 0, ErrProjectNotFound
+	}
+	ownerID := audience[0]
+	seq, err := nextChangeSeq(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, ownerID, userID, seq, EntityProject, projectID, op, deviceID); err != nil {
+		return 0, err
+	}
+	return seq, shareChangeTx(tx, audience[1:], userID, EntityProject, projectID, op, deviceID)
+}
+
+// UpdateProject 部分更新项目并分配新版本号，需要 owner 角色
+func UpdateProject(userID int, deviceID string, projectID int64, f ProjectFields) (*types.Project, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := updateProjectTx(tx, userID, deviceID, projectID, f); err != nil {
+		return nil, err
+	}
+	p, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit()
+}
+
+// updateProjectTx 在事务中部分更新项目、记录变更，返回分配的版本号
+func updateProjectTx(tx *sql.Tx, userID int, deviceID string, projectID int64, f ProjectFields) (int, error) {
+	if _, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleOwner); err != nil {
+		return 0, err
+	}
+
+	sets := ""
+	args := []interface{}{}
+	if f.Name != nil {
+		sets += "name = ?, "
+		args = append(args, *f.Name)
+	}
+	if f.Color != nil {
+		sets += "color = ?, "
+		args = append(args, *f.Color)
+	}
+	if f.Archived != nil {
+		sets += "archived = ?, "
+		args = append(args, *f.Archived)
+	}
+	if f.Rank != nil {
+		sets += "rank = ?, "
+		args = append(args, *f.Rank)
+	}
+
+	version, err := logProjectChange(tx, userID, projectID, ChangeUpdate, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	args = append(args, version, time.Now().UTC(), projectID)
+	if _, err := tx.Exec("UPDATE projects SET "+sets+"server_version = ?, updated_at = ? WHERE id = ?", args...); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DeleteProject 删除项目，其中的任务移出项目（任务本身保留），需要 owner 角色
+func DeleteProject(userID int, deviceID string, projectID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := deleteProjectTx(tx, userID, deviceID, projectID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteProjectTx 软删除项目（保留墓碑供同步下发），移出其中的任务并为未删除的任务分配新版本号
+func deleteProjectTx(tx *sql.Tx, userID int, deviceID string, projectID int64) (int, error) {
+	if _, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleOwner); err != nil {
+		return 0, err
+	}
+	ids, err := queryIDs(tx, "SELECT id FROM tasks WHERE project_id = ? AND is_deleted = 0 ORDER BY id", projectID)
+	if err != nil {
+		return 0, err
+	}
+	// 先在移出前记录变更，使项目的全部成员都收到这些任务（非所有者收到墓碑）
+	if err := touchTasks(tx, userID, deviceID, ids); err != nil {
+		return 0, err
+	}
+	version, err := logProjectChange(tx, userID, projectID, ChangeDelete, deviceID)
+	if err != nil {
+		return 0, err
+	}
+	// 回收站中的任务一并移出，恢复后不会指向已删除的项目
+	all, err := queryIDs(tx, "SELECT id FROM tasks WHERE project_id = ? ORDER BY id", projectID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE tasks SET project_id = NULL, project_rank = NULL WHERE project_id = ?", projectID); err != nil {
+		return 0, err
+	}
+	// 任务已在上面分配新版本号，这里只取消失去编辑权限的负责人
+	if _, err := unassignLostTx(tx, userID, all); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	if _, err := tx.Exec("UPDATE projects SET is_deleted = 1, deleted_at = ?, server_version = ?, updated_at = ? WHERE id = ?",
+		now, version, now, projectID); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// taskProject 读取任务所属的项目与排序键，不属于任何项目时 projectID 为 0
+func taskProject(q queryRower, taskID int64) (int64, string, error) {
+	var projectID sql.NullInt64
+	var rank sql.NullString
+	err := q.QueryRow("SELECT project_id, project_rank FROM tasks WHERE id = ?", taskID).Scan(&projectID, &rank)
+	return projectID.Int64, rank.String, err
+}
+
+// setTaskProjectTx 将任务移入 projectID（0 表示移出项目），rank 为项目内的排序键，用户需是目标项目的 editor
+// rank 为 nil 时保留任务在同一项目中的原有位置，移入新项目则排在最后；任务的版本号由调用方分配
+// 原项目的成员已由调用方的变更记录覆盖，这里为新项目中此前看不到该任务的成员追加变更；负责人失去编辑权限时取消指派
+func setTaskProjectTx(tx *sql.Tx, userID int, deviceID string, taskID, projectID int64, rank *string) error {
+	if projectID == 0 {
+		if _, err := tx.Exec("UPDATE tasks SET project_id = NULL, project_rank = NULL WHERE id = ?", taskID); err != nil {
+			return err
+		}
+		_, err := unassignLostTx(tx, userID, []int64{taskID})
+		return err
+	}
+	if _, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleEditor); err != nil {
+		if err == ErrProjectNotFound || err == ErrProjectForbidden {
+			return ErrInvalidProject
+		}
+		return err
+	}
+
+	currentProject, currentRank, err := taskProject(tx, taskID)
+	if err != nil {
+		return err
+	}
+	key := ""
+	switch {
+	case rank != nil:
+		key = *rank
+	case currentProject == projectID && currentRank != "":
+		return nil
+	default:
+		var last sql.NullString
+		if err := tx.QueryRow("SELECT MAX(project_rank) FROM tasks WHERE project_id = ? AND id != ? AND is_deleted = 0", projectID, taskID).Scan(&last); err != nil {
+			return err
+		}
+		if key, err = fracindex.Between(last.String, ""); err != nil {
+			return err
+		}
+	}
+
+	var before []int
+	if currentProject != 0 && currentProject != projectID {
+		if before, err = projectAudience(tx, currentProject); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("UPDATE tasks SET project_id = ?, project_rank = ? WHERE id = ?", projectID, key, taskID); err != nil {
+		return err
+	}
+	if currentProject == projectID {
+		return nil
+	}
+	if _, err := unassignLostTx(tx, userID, []int64{taskID}); err != nil {
+		return err
+	}
+	ownerID, audience, err := taskAudience(tx, taskID)
+	if err != nil {
+		return err
+	}
+	return shareChangeTx(tx, excludeUsers(audience, append(before, ownerID)...), userID, EntityTask, taskID, ChangeUpdate, deviceID)
+}
+
+// MoveTaskInProject 将任务移到项目中 afterID 之后或 beforeID 之前（都为 0 时移到最后），只改写该任务的排序键
+// 需要项目的 editor 角色
+func MoveTaskInProject(userID int, deviceID string, projectID, taskID, afterID, beforeID int64, expectedVersion int) (map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkProjectAccess(tx, userID, projectID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+
+	// anchorRank 读取参照任务在项目中的排序键
+	anchorRank := func(id int64) (string, error) {
+		if id == taskID {
+			return "", ErrInvalidAnchor
+		}
+		var rank sql.NullString
+		err := tx.QueryRow("SELECT project_rank FROM tasks WHERE id = ? AND project_id = ? AND is_deleted = 0", id, projectID).Scan(&rank)
+		if err == sql.ErrNoRows || (err == nil && !rank.Valid) {
+			return "", ErrInvalidAnchor
+		}
+		return rank.String, err
+	}
+
+	// 相邻任务的排序键相同（如多个离线客户端生成了相同的键）时按严格大于/小于取邻居，保证结果可用
+	var lower, upper sql.NullString
+	switch {
+	case afterID != 0:
+		if lower.String, err = anchorRank(afterID); err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow("SELECT MIN(project_rank) FROM tasks WHERE project_id = ? AND id != ? AND is_deleted = 0 AND project_rank > ?",
+			projectID, taskID, lower.String).Scan(&upper)
+	case beforeID != 0:
+		if upper.String, err = anchorRank(beforeID); err != nil {
+			return nil, err
+		}
+		err = tx.QueryRow("SELECT MAX(project_rank) FROM tasks WHERE project_id = ? AND id != ? AND is_deleted = 0 AND project_rank < ?",
+			projectID, taskID, upper.String).Scan(&lower)
+	default:
+		err = tx.QueryRow("SELECT MAX(project_rank) FROM tasks WHERE project_id = ? AND id != ? AND is_deleted = 0",
+			projectID, taskID).Scan(&lower)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rank, err := fracindex.Between(lower.String, upper.String)
+	if err != nil {
+		return nil, err
+	}
+
+	task, err := updateTaskFieldsTx(tx, userID, deviceID, taskID, TaskFields{ProjectID: &projectID, ProjectRank: &rank}, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	return task, tx.Commit()
+}
+
+// GetProjectChangesSince 获取用户在 sinceSeq 之后变更过的项目（含已删除或已失去访问权限的项目的墓碑），按序号升序
+// 同时返回这些变更中最大的序号；sinceSeq 为 0 表示全量拉取，此时只返回可访问的未删除项目
+// untilSeq 大于 0 时只返回序号不超过 untilSeq 的变更
+func GetProjectChangesSince(q Querier, userID int, sinceSeq, untilSeq int) ([]*types.Project, int, error) {
+	if sinceSeq == 0 {
+		projects, err := queryProjects(q, "SELECT "+projectColumns+" FROM projects WHERE id IN ("+accessibleProjects+") ORDER BY server_version, id", userID, userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		return projects, 0, fillProjectRoles(q, userID, projects)
+	}
+
+	query := "SELECT task_id, MAX(seq) AS last_seq FROM change_log WHERE user_id = ? AND seq > ? AND entity_type = 'project'"
+	args := []interface{}{userID, sinceSeq}
+	if untilSeq > 0 {
+		query += " AND seq <= ?"
+		args = append(args, untilSeq)
+	}
+	rows, err := q.Query(query+" GROUP BY task_id ORDER BY last_seq ASC", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	type entry struct {
+		projectID int64
+		seq       int
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.projectID, &e.seq); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	projects := make([]*types.Project, 0, len(entries))
+	lastSeq := 0
+	for _, e := range entries {
+		lastSeq = e.seq
+		p, err := checkProjectAccess(q, userID, e.projectID, types.ProjectRoleViewer)
+		if err == ErrProjectNotFound {
+			// 项目已删除或用户已不是成员时返回墓碑
+			p = &types.Project{ID: e.projectID, ServerVersion: int64(e.seq), IsDeleted: true}
+		} else if err != nil {
+			return nil, 0, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, lastSeq, nil
+}
+
+// ProjectIDByLocalID 按客户端本地 ID 查找用户未删除的项目
+func ProjectIDByLocalID(tx *sql.Tx, userID int, localID string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM projects WHERE local_id = ? AND user_id = ? AND is_deleted = 0 ORDER BY id DESC LIMIT 1", localID, userID).Scan(&id)
+	return id, err
+}
+
+// InsertSyncProject 插入客户端同步上来的新项目并记录变更，返回项目 ID 与分配的版本号
+func InsertSyncProject(tx *sql.Tx, userID int, deviceID, localID string, f ProjectFields) (int64, int, error) {
+	return insertProjectTx(tx, userID, deviceID, localID, f)
+}
+
+// UpdateSyncProject 在同步事务中更新项目，返回分配的版本号
+func UpdateSyncProject(tx *sql.Tx, userID int, deviceID string, projectID int64, f ProjectFields) (int, error) {
+	return updateProjectTx(tx, userID, deviceID, projectID, f)
+}
+
+// DeleteSyncProject 在同步事务中删除项目，返回分配的版本号
+func DeleteSyncProject(tx *sql.Tx, userID int, deviceID string, projectID int64) (int, error) {
+	return deleteProjectTx(tx, userID, deviceID, projectID)
+}
+
+// SetSyncTaskProject 在同步事务中设置任务所属的项目（projectID 为 nil 时保持原项目），任务的版本号已由同步写入分配
+func SetSyncTaskProject(tx *sql.Tx, userID int, deviceID string, taskID int64, projectID *int64, rank *string) error {
+	if projectID == nil {
+		current, _, err := taskProject(tx, taskID)
+		if err != nil {
+			return err
+		}
+		projectID = &current
+	}
+	return setTaskProjectTx(tx, userID, deviceID, taskID, *projectID, rank)
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// 提醒类型，同时作为通知类型
+const (
+	ReminderDueSoon = "task_due_soon"
+	ReminderOverdue = "task_overdue"
+)
+
+const (
+	// MaxReminderOffsetMinutes 提醒最多提前 7 天
+	MaxReminderOffsetMinutes = 7 * 24 * 60
+	// MaxRemindersPerTask 单个任务最多设置的提醒数
+	MaxRemindersPerTask = 10
+	// reminderCatchUp 服务停机期间错过的逾期提醒最多补发的时间范围
+	reminderCatchUp = 24 * time.Hour
+)
+
+// ErrInvalidReminder 提醒设置无效
+var ErrInvalidReminder = fmt.Errorf("提醒时间必须在 0 到 %d 分钟之间，且最多 %d 个", MaxReminderOffsetMinutes, MaxRemindersPerTask)
+
+// DueReminder 已到触发时间并被认领的提醒
+type DueReminder struct {
+	UserID        int
+	TaskID        int64
+	Title         string
+	Kind          string
+	DueAt         time.Time
+	OffsetMinutes int
+}
+
+// GetTaskReminders 获取任务的提醒提前量（分钟，升序）
+func GetTaskReminders(userID int, taskID int64) ([]int, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	return taskReminderOffsets(DB, taskID)
+}
+
+// taskReminderOffsets 读取任务的提醒提前量
+func taskReminderOffsets(q Querier, taskID int64) ([]int, error) {
+	rows, err := q.Query("SELECT offset_minutes FROM task_reminders WHERE task_id = ? ORDER BY offset_minutes", taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offsets := []int{}
+	for rows.Next() {
+		var offset int
+		if err := rows.Scan(&offset); err != nil {
+			return nil, err
+		}
+		offsets = append(offsets, offset)
+	}
+	return offsets, rows.Err()
+}
+
+// SetTaskReminders 以给定的提前量（分钟）替换任务的全部提醒，返回去重排序后的结果
+func SetTaskReminders(userID int, taskID int64, offsets []int) ([]int, error) {
+	if len(offsets) > MaxRemindersPerTask {
+		return nil, ErrInvalidReminder
+	}
+	for _, offset := range offsets {
+		if offset < 0 || offset > MaxReminderOffsetMinutes {
+			return nil, ErrInvalidReminder
+		}
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM task_reminders WHERE task_id = ?", taskID); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	for _, offset := range offsets {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO task_reminders (task_id, user_id, offset_minutes, created_at) VALUES (?, ?, ?, ?)",
+			taskID, userID, offset, now); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := taskReminderOffsets(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return result, tx.Commit()
+}
+
+// ClaimDueReminders 找出到达触发时间的提醒并记录为已发送，返回本次认领的提醒
+// 每个提醒按（任务、类型、提前量、截止时间）只认领一次，服务重启后不会重复触发；
+// 修改截止时间后会按新的时间重新提醒
+func ClaimDueReminders(now time.Time) ([]DueReminder, error) {
+	now = now.UTC()
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// 截止时间在 [now-补发范围, now+最大提前量] 内的未完成任务，逐个提醒提前量展开
+	rows, err := tx.Query(`
+		SELECT t.id, t.user_id, t.title, t.due_at, r.offset_minutes
+		FROM tasks t
+		LEFT JOIN task_reminders r ON r.task_id = t.id
+		WHERE t.is_deleted = 0 AND t.status NOT IN ('done', 'archived')
+		  AND t.due_at > ? AND t.due_at <= ?`,
+		now.Add(-reminderCatchUp), now.Add(MaxReminderOffsetMinutes*time.Minute),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var candidates []DueReminder
+	for rows.Next() {
+		var r DueReminder
+		var title sql.NullString
+		var offset sql.NullInt64
+		if err := rows.Scan(&r.TaskID, &r.UserID, &title, &r.DueAt, &offset); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Title = title.String
+		r.DueAt = r.DueAt.UTC()
+
+		if !r.DueAt.After(now) {
+			r.Kind = ReminderOverdue
+			candidates = append(candidates, r)
+			continue
+		}
+		if offset.Valid && !r.DueAt.Add(-time.Duration(offset.Int64)*time.Minute).After(now) {
+			r.Kind = ReminderDueSoon
+			r.OffsetMinutes = int(offset.Int64)
+			candidates = append(candidates, r)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var claimed []DueReminder
+	for _, r := range candidates {
+		res, err := tx.Exec(
+			"INSERT OR IGNORE INTO reminder_deliveries (task_id, user_id, kind, offset_minutes, due_at, fired_at) VALUES (?, ?, ?, ?, ?, ?)",
+			r.TaskID, r.UserID, r.Kind, r.OffsetMinutes, r.DueAt, now,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			claimed = append(claimed, r)
+		}
+	}
+	return claimed, tx.Commit()
+}
@@ -0,0 +1,232 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+var (
+	// ErrRevisionNotFound 指定的历史版本不存在
+	ErrRevisionNotFound = errors.New("历史版本不存在")
+	// ErrRevisionDeleted 指定的历史版本处于删除状态
+	ErrRevisionDeleted = errors.New("不能回退到已删除的版本")
+)
+
+// taskRevisionsTable 任务修订历史表
+const taskRevisionsTable = `CREATE TABLE IF NOT EXISTS task_revisions (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            task_id INTEGER NOT NULL,
+            user_id INTEGER,
+            version INTEGER NOT NULL,
+            title TEXT,
+            description TEXT,
+            status TEXT,
+            priority TEXT,
+            due_at DATETIME,
+            is_deleted BOOLEAN,
+            created_at DATETIME,
+            UNIQUE(task_id, version)
+        );`
+
+// taskRevisionTriggers 每次分配新版本号（插入或 server_version 变化）时保存一份任务快照
+// 快照既是历史记录，也是三方合并的基准版本；操作设备与执行者记录在同序号的 change_log 中
+var taskRevisionTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS task_revisions_ai AFTER INSERT ON tasks BEGIN
+            INSERT OR IGNORE INTO task_revisions (task_id, user_id, version, title, description, status, priority, due_at, is_deleted, created_at)
+            VALUES (new.id, new.user_id, new.server_version, new.title, new.description, new.status, new.priority, new.due_at, new.is_deleted, new.last_modified);
+        END;`,
+	`CREATE TRIGGER IF NOT EXISTS task_revisions_au AFTER UPDATE OF server_version ON tasks
+        WHEN new.server_version IS NOT old.server_version BEGIN
+            INSERT OR IGNORE INTO task_revisions (task_id, user_id, version, title, description, status, priority, due_at, is_deleted, created_at)
+            VALUES (new.id, new.user_id, new.server_version, new.title, new.description, new.status, new.priority, new.due_at, new.is_deleted, new.last_modified);
+        END;`,
+}
+
+// initTaskRevisions 创建修订历史表与触发器，首次创建时为已有任务补充当前版本的快照
+func initTaskRevisions() error {
+	var triggers int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'task_revisions_%'").Scan(&triggers); err != nil {
+		return err
+	}
+	if _, err := DB.Exec(taskRevisionsTable); err != nil {
+		return err
+	}
+
+	// 早期的修订表不含截止时间，补列后重建触发器
+	hasDueAt, err := columnExists("task_revisions", "due_at")
+	if err != nil {
+		return err
+	}
+	if !hasDueAt {
+		if err := ensureColumn("task_revisions", "due_at", "DATETIME"); err != nil {
+			return err
+		}
+		for _, name := range []string{"task_revisions_ai", "task_revisions_au"} {
+			if _, err := DB.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, s := range taskRevisionTriggers {
+		if _, err := DB.Exec(s); err != nil {
+			return err
+		}
+	}
+	if triggers == 0 {
+		_, err := DB.Exec(`INSERT OR IGNORE INTO task_revisions (task_id, user_id, version, title, description, status, priority, due_at, is_deleted, created_at)
+            SELECT id, user_id, COALESCE(server_version, 0), title, description, status, priority, due_at, is_deleted, last_modified FROM tasks`)
+		return err
+	}
+	return nil
+}
+
+// GetTaskRevision 获取任务在指定版本时的快照，不存在时返回 nil；访问权限由调用方检查
+func GetTaskRevision(tx *sql.Tx, taskID int64, version int) (*SyncTaskState, error) {
+	var title, description, status, priority sql.NullString
+	var dueAt sql.NullTime
+	var isDeleted sql.NullBool
+	err := tx.QueryRow(
+		"SELECT title, description, status, priority, due_at, is_deleted FROM task_revisions WHERE task_id = ? AND version = ?",
+		taskID, version,
+	).Scan(&title, &description, &status, &priority, &dueAt, &isDeleted)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SyncTaskState{
+		Version:     version,
+		Title:       title.String,
+		Description: description.String,
+		Status:      status.String,
+		Priority:    priority.String,
+		DueAt:       formatDueAt(dueAt),
+		IsDeleted:   isDeleted.Bool,
+	}, nil
+}
+
+// GetTaskHistory 按版本倒序分页获取任务的修订历史，已软删除的任务同样可查
+func GetTaskHistory(userID int, taskID int64, page, pageSize int) ([]*types.TaskRevision, int, error) {
+	if err := checkTaskAccessAny(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM task_revisions WHERE task_id = ?", taskID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(`
+		SELECT r.version, c.op, r.title, r.description, r.status, r.priority, r.due_at, r.is_deleted,
+		       COALESCE(c.actor_id, c.user_id), u.email, c.device_id, r.created_at
+		FROM task_revisions r
+		LEFT JOIN change_log c ON c.user_id = r.user_id AND c.seq = r.version AND c.task_id = r.task_id
+		LEFT JOIN users u ON u.id = COALESCE(c.actor_id, c.user_id)
+		WHERE r.task_id = ?
+		ORDER BY r.version DESC
+		LIMIT ? OFFSET ?`,
+		taskID, pageSize, (page-1)*pageSize,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	revisions := []*types.TaskRevision{}
+	for rows.Next() {
+		var rev types.TaskRevision
+		var op, title, description, status, priority, dueAt, email, deviceID, createdAt sql.NullString
+		var actorID sql.NullInt64
+		var isDeleted sql.NullBool
+		if err := rows.Scan(&rev.Version, &op, &title, &description, &status, &priority, &dueAt, &isDeleted,
+			&actorID, &email, &deviceID, &createdAt); err != nil {
+			return nil, 0, err
+		}
+		rev.Op = op.String
+		rev.Title = title.String
+		rev.Description = description.String
+		rev.Status = status.String
+		rev.Priority = priority.String
+		rev.DueAt = dueAt.String
+		rev.IsDeleted = isDeleted.Bool
+		rev.ActorID = int(actorID.Int64)
+		rev.ActorEmail = email.String
+		rev.DeviceID = deviceID.String
+		rev.CreatedAt = createdAt.String
+		revisions = append(revisions, &rev)
+	}
+	return revisions, total, rows.Err()
+}
+
+// RevertTask 将任务回退到指定历史版本：以该版本的内容生成一个新版本
+// 已软删除的任务会同时恢复，撤销删除快照随之失效
+func RevertTask(userID int, deviceID string, taskID int64, version int) (map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTaskAccessAny(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+
+	var title, description, status, priority, dueAt sql.NullString
+	var isDeleted sql.NullBool
+	err = tx.QueryRow(
+		"SELECT title, description, status, priority, due_at, is_deleted FROM task_revisions WHERE task_id = ? AND version = ?",
+		taskID, version,
+	).Scan(&title, &description, &status, &priority, &dueAt, &isDeleted)
+	if err == sql.ErrNoRows {
+		return nil, ErrRevisionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isDeleted.Bool {
+		return nil, ErrRevisionDeleted
+	}
+
+	var wasDeleted sql.NullBool
+	if err := tx.QueryRow("SELECT is_deleted FROM tasks WHERE id = ?", taskID).Scan(&wasDeleted); err != nil {
+		return nil, err
+	}
+	if wasDeleted.Bool {
+		if err := recordActivityTx(tx, userID, taskID, types.ActivityRestored, map[string]interface{}{"version": version}); err != nil {
+			return nil, err
+		}
+	}
+	if err := recordStatusChangeTx(tx, userID, taskID, status.String); err != nil {
+		return nil, err
+	}
+
+	newVersion, err := logTaskChange(tx, userID, taskID, ChangeRevert, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := tx.Exec(
+		"UPDATE tasks SET title = ?, description = ?, status = ?, priority = ?, due_at = ?, is_deleted = 0, deleted_at = NULL, server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?",
+		title, description, status, priority, dueAt, newVersion, now, now, taskID,
+	); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE deleted_tasks SET is_restorable = 0 WHERE task_id = ?", taskID); err != nil {
+		return nil, err
+	}
+	if err := detachFromDeletedParent(tx, taskID); err != nil {
+		return nil, err
+	}
+
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return task, tx.Commit()
+}
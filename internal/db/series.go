@@ -0,0 +1,310 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"todoapp/internal/recurrence"
+	"todoapp/internal/types"
+)
+
+// ErrSeriesNotFound 重复序列不存在或不属于当前用户
+var ErrSeriesNotFound = errors.New("重复序列不存在")
+
+// taskSeriesID 读取任务所属的重复序列与序号，不属于任何序列时 seriesID 为 0
+func taskSeriesID(q queryRower, taskID int64) (seriesID int64, occurrence int, err error) {
+	var sid, occ sql.NullInt64
+	if err := q.QueryRow("SELECT series_id, occurrence FROM tasks WHERE id = ?", taskID).Scan(&sid, &occ); err != nil {
+		return 0, 0, err
+	}
+	return sid.Int64, int(occ.Int64), nil
+}
+
+// setTaskRecurrenceTx 设置任务的重复规则，rule 为空时结束任务所在的序列
+// 任务尚未属于序列时以其截止时间（没有则为当前时间）为起点新建序列，任务作为第 1 次发生
+func setTaskRecurrenceTx(tx *sql.Tx, userID int, deviceID string, taskID int64, rule string) error {
+	seriesID, _, err := taskSeriesID(tx, taskID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+
+	if rule == "" {
+		if seriesID == 0 {
+			return nil
+		}
+		return stopSeriesTx(tx, userID, deviceID, seriesID, taskID)
+	}
+
+	if seriesID != 0 {
+		if _, err := tx.Exec("UPDATE task_series SET rule = ?, updated_at = ? WHERE id = ?", rule, now, seriesID); err != nil {
+			return err
+		}
+		return touchSeriesTasks(tx, userID, deviceID, seriesID, taskID)
+	}
+
+	var dueAt sql.NullTime
+	if err := tx.QueryRow("SELECT due_at FROM tasks WHERE id = ?", taskID).Scan(&dueAt); err != nil {
+		return err
+	}
+	dtstart := now.Truncate(time.Second)
+	if dueAt.Valid {
+		dtstart = dueAt.Time.UTC()
+	}
+	res, err := tx.Exec("INSERT INTO task_series (user_id, rule, dtstart, created_at, updated_at) VALUES (?, ?, ?, ?, ?)",
+		userID, rule, dtstart, now, now)
+	if err != nil {
+		return err
+	}
+	if seriesID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tasks SET series_id = ?, occurrence = 1 WHERE id = ?", seriesID, taskID)
+	return err
+}
+
+// touchSeriesTasks 为序列中未删除的任务分配新版本号，使序列级修改（如重复规则）同步到客户端
+// exceptTaskID 为本次已记录变更的任务
+func touchSeriesTasks(tx *sql.Tx, userID int, deviceID string, seriesID, exceptTaskID int64) error {
+	ids, err := seriesTaskIDs(tx, seriesID, "is_deleted = 0 AND id != ?", exceptTaskID)
+	if err != nil {
+		return err
+	}
+	return touchTasks(tx, userID, deviceID, ids)
+}
+
+// seriesTaskIDs 按序号获取序列中满足条件的任务 ID
+func seriesTaskIDs(q Querier, seriesID int64, cond string, args ...interface{}) ([]int64, error) {
+	rows, err := q.Query("SELECT id FROM tasks WHERE series_id = ? AND "+cond+" ORDER BY occurrence, id",
+		append([]interface{}{seriesID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// stopSeriesTx 结束重复序列：解除所有任务与序列的关联并删除序列，已生成的任务保留
+// exceptTaskID 为本次已记录变更的任务，其余任务分配新版本号
+func stopSeriesTx(tx *sql.Tx, userID int, deviceID string, seriesID, exceptTaskID int64) error {
+	if err := touchSeriesTasks(tx, userID, deviceID, seriesID, exceptTaskID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE tasks SET series_id = NULL, occurrence = NULL WHERE series_id = ?", seriesID); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM task_series WHERE id = ?", seriesID)
+	return err
+}
+
+// spawnNextOccurrence 任务完成后为其所在序列生成下一次发生的任务，返回新任务 ID（未生成时为 0）
+// 序列中已有更靠后的任务、达到 COUNT 或超过 UNTIL 时不生成，因此重复完成同一任务不会产生重复实例
+// 新任务沿用标题、描述、优先级、标签、项目与提醒设置，作为普通插入记入变更日志，随 /sync 与实时推送下发
+// 新任务属于原任务的所有者，userID 为完成任务的执行者（共享项目中可能是其他成员）
+func spawnNextOccurrence(tx *sql.Tx, userID int, deviceID string, taskID int64) (int64, error) {
+	seriesID, occurrence, err := taskSeriesID(tx, taskID)
+	if err != nil || seriesID == 0 {
+		return 0, err
+	}
+
+	var later int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM tasks WHERE series_id = ? AND occurrence > ?", seriesID, occurrence).Scan(&later); err != nil {
+		return 0, err
+	}
+	if later > 0 {
+		return 0, nil
+	}
+
+	var ruleText string
+	var dtstart time.Time
+	if err := tx.QueryRow("SELECT rule, dtstart FROM task_series WHERE id = ?", seriesID).Scan(&ruleText, &dtstart); err != nil {
+		return 0, err
+	}
+	rule, err := recurrence.Parse(ruleText)
+	if err != nil {
+		return 0, fmt.Errorf("序列 %d 的重复规则无效: %w", seriesID, err)
+	}
+	if rule.Count > 0 && occurrence+1 > rule.Count {
+		return 0, nil
+	}
+
+	var ownerID int
+	var title, description, priority sql.NullString
+	var dueAt sql.NullTime
+	var assigneeID sql.NullInt64
+	if err := tx.QueryRow("SELECT user_id, title, description, priority, due_at, assignee_id FROM tasks WHERE id = ?", taskID).
+		Scan(&ownerID, &title, &description, &priority, &dueAt, &assigneeID); err != nil {
+		return 0, err
+	}
+	after := time.Now().UTC()
+	if dueAt.Valid {
+		after = dueAt.Time
+	}
+	next, ok := rule.Next(dtstart, after)
+	if !ok {
+		return 0, nil
+	}
+
+	version, err := nextChangeSeq(tx, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, due_at, series_id, occurrence, created_at, updated_at, is_deleted, last_modified) VALUES (?, ?, ?, ?, ?, 'todo', ?, ?, ?, ?, ?, ?, 0, ?)",
+		ownerID, fmt.Sprintf("series-%d-%d", seriesID, occurrence+1), version, title.String, description.String, priority.String,
+		next, seriesID, occurrence+1, now, now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	newID, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	// 与 insertTaskTx 一致，插入变更先于移入项目、指派等后续变更记入变更日志
+	if err := appendChange(tx, ownerID, userID, version, EntityTask, newID, ChangeInsert, deviceID); err != nil {
+		return 0, err
+	}
+	if err := recordActivityTx(tx, userID, newID, types.ActivityCreated, map[string]interface{}{"from_task_id": taskID}); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(
+		"INSERT INTO task_reminders (task_id, user_id, offset_minutes, created_at) SELECT ?, user_id, offset_minutes, ? FROM task_reminders WHERE task_id = ?",
+		newID, now, taskID,
+	); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("INSERT INTO task_tags (task_id, tag_id) SELECT ?, tag_id FROM task_tags WHERE task_id = ?", newID, taskID); err != nil {
+		return 0, err
+	}
+	if projectID, _, err := taskProject(tx, taskID); err != nil {
+		return 0, err
+	} else if projectID != 0 {
+		// 执行者已不能编辑该项目（如所有者已被移出）时新任务不放入项目
+		if err := setTaskProjectTx(tx, userID, deviceID, newID, projectID, nil); err != nil && err != ErrInvalidProject {
+			return 0, err
+		}
+	}
+	if assigneeID.Valid {
+		// 负责人已失去编辑权限时下一次出现不再指派
+		if err := setTaskAssigneeTx(tx, userID, newID, int(assigneeID.Int64)); err != nil && err != ErrInvalidAssignee {
+			return 0, err
+		}
+	}
+	return newID, nil
+}
+
+// checkSeriesOwner 检查序列是否存在且属于指定用户
+func checkSeriesOwner(q queryRower, userID int, seriesID int64) (*types.TaskSeries, error) {
+	s := &types.TaskSeries{ID: seriesID}
+	var ownerID int
+	var dtstart, createdAt, updatedAt sql.NullTime
+	err := q.QueryRow("SELECT user_id, rule, dtstart, created_at, updated_at FROM task_series WHERE id = ?", seriesID).
+		Scan(&ownerID, &s.Rule, &dtstart, &createdAt, &updatedAt)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		return nil, ErrSeriesNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.DTStart = formatDueAt(dtstart)
+	s.CreatedAt = formatDueAt(createdAt)
+	s.UpdatedAt = formatDueAt(updatedAt)
+	return s, nil
+}
+
+// GetSeries 获取重复序列及其全部未删除的任务（按序号升序）
+func GetSeries(userID int, seriesID int64) (*types.TaskSeries, error) {
+	return getSeries(DB, userID, seriesID)
+}
+
+func getSeries(q Querier, userID int, seriesID int64) (*types.TaskSeries, error) {
+	s, err := checkSeriesOwner(q, userID, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := q.Query("SELECT "+taskColumns+" FROM tasks WHERE series_id = ? AND is_deleted = 0 ORDER BY occurrence, id", seriesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s.Tasks = []map[string]interface{}{}
+	for rows.Next() {
+		task, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		s.Tasks = append(s.Tasks, task)
+	}
+	return s, rows.Err()
+}
+
+// UpdateSeries 整体修改重复序列：rule 非 nil 时替换重复规则（影响之后生成的任务），
+// f 中的字段应用到序列中所有未完成的任务
+func UpdateSeries(userID int, deviceID string, seriesID int64, rule *string, f TaskFields) (*types.TaskSeries, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkSeriesOwner(tx, userID, seriesID); err != nil {
+		return nil, err
+	}
+
+	open, err := seriesTaskIDs(tx, seriesID, "is_deleted = 0 AND status NOT IN ('done', 'archived')")
+	if err != nil {
+		return nil, err
+	}
+	if !f.IsEmpty() {
+		for _, id := range open {
+			if _, err := updateTaskFieldsTx(tx, userID, deviceID, id, f, 0); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if rule != nil {
+		if _, err := tx.Exec("UPDATE task_series SET rule = ?, updated_at = ? WHERE id = ?", *rule, time.Now().UTC(), seriesID); err != nil {
+			return nil, err
+		}
+		if err := touchSeriesTasks(tx, userID, deviceID, seriesID, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	s, err := getSeries(tx, userID, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	return s, tx.Commit()
+}
+
+// StopSeries 结束重复序列，已生成的任务保留为普通任务
+func StopSeries(userID int, deviceID string, seriesID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkSeriesOwner(tx, userID, seriesID); err != nil {
+		return err
+	}
+	if err := stopSeriesTx(tx, userID, deviceID, seriesID, 0); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
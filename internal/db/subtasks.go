@@ -0,0 +1,242 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// defaultMaxTaskDepth 未配置 max_task_depth 时任务树的最大层数（顶层任务为第 1 层）
+const defaultMaxTaskDepth = 5
+
+// ErrInvalidParent 父任务无效
+var ErrInvalidParent = errors.New("父任务不存在、已删除，或是该任务自身及其子任务")
+
+// TaskDepthError 移动或创建子任务后超过最大层数
+type TaskDepthError struct {
+	Max int
+}
+
+func (e *TaskDepthError) Error() string {
+	return fmt.Sprintf("子任务层级不能超过 %d 层", e.Max)
+}
+
+// MaxTaskDepth 读取任务树的最大层数（system_config 中的 max_task_depth）
+func MaxTaskDepth() int {
+	value, found, err := GetConfigValue("max_task_depth")
+	if err != nil {
+		log.Printf("读取任务最大层数失败: %v", err)
+		return defaultMaxTaskDepth
+	}
+	depth, err := strconv.Atoi(value)
+	if !found || err != nil || depth < 1 {
+		return defaultMaxTaskDepth
+	}
+	return depth
+}
+
+// taskParentID 读取任务的父任务 ID，顶层任务返回 0
+func taskParentID(q queryRower, taskID int64) (int64, error) {
+	var parentID sql.NullInt64
+	err := q.QueryRow("SELECT parent_id FROM tasks WHERE id = ?", taskID).Scan(&parentID)
+	return parentID.Int64, err
+}
+
+// ancestorDepth 返回 parentID 所在的层数，以及 taskID 是否位于其祖先链上（即移动会形成环）
+func ancestorDepth(q queryRower, parentID, taskID int64) (int, bool, error) {
+	var depth int
+	var cycle bool
+	err := q.QueryRow(`
+		WITH RECURSIVE anc(id, parent_id, depth) AS (
+			SELECT id, parent_id, 1 FROM tasks WHERE id = ?
+			UNION ALL
+			SELECT t.id, t.parent_id, a.depth + 1 FROM tasks t JOIN anc a ON t.id = a.parent_id WHERE a.depth < 1000
+		)
+		SELECT COALESCE(MAX(depth), 0), COALESCE(MAX(id = ?), 0) FROM anc`,
+		parentID, taskID,
+	).Scan(&depth, &cycle)
+	return depth, cycle, err
+}
+
+// subtreeHeight 返回以 taskID 为根的未删除子树的层数（没有子任务时为 1）
+func subtreeHeight(q queryRower, taskID int64) (int, error) {
+	var height int
+	err := q.QueryRow(`
+		WITH RECURSIVE sub(id, depth) AS (
+			SELECT id, 1 FROM tasks WHERE id = ?
+			UNION ALL
+			SELECT t.id, s.depth + 1 FROM tasks t JOIN sub s ON t.parent_id = s.id WHERE t.is_deleted = 0 AND s.depth < 1000
+		)
+		SELECT COALESCE(MAX(depth), 1) FROM sub`,
+		taskID,
+	).Scan(&height)
+	return height, err
+}
+
+// descendantIDs 返回 rootIDs 下满足 cond 的全部后代任务 ID（不含根，父任务在前）
+func descendantIDs(q Querier, rootIDs []int64, cond string, args ...interface{}) ([]int64, error) {
+	if len(rootIDs) == 0 {
+		return nil, nil
+	}
+	placeholders := make([]string, len(rootIDs))
+	queryArgs := make([]interface{}, 0, len(rootIDs)+len(args))
+	for i, id := range rootIDs {
+		placeholders[i] = "?"
+		queryArgs = append(queryArgs, id)
+	}
+	queryArgs = append(queryArgs, args...)
+
+	rows, err := q.Query(`
+		WITH RECURSIVE sub(id, depth) AS (
+			SELECT id, 0 FROM tasks WHERE id IN (`+strings.Join(placeholders, ",")+`)
+			UNION
+			SELECT t.id, s.depth + 1 FROM tasks t JOIN sub s ON t.parent_id = s.id WHERE `+cond+` AND s.depth < 1000
+		)
+		SELECT id FROM sub WHERE depth > 0 GROUP BY id ORDER BY MIN(depth), id`,
+		queryArgs...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// setTaskParentTx 将任务移动到 parentID 之下（0 表示移到顶层），index 为在兄弟任务中的位置（nil 表示末尾）
+// 校验父任务归属、环与最大层数；任务本身的版本号由调用方分配，位置变化的兄弟任务分配新版本号
+func setTaskParentTx(tx *sql.Tx, userID int, deviceID string, taskID, parentID int64, index *int) error {
+	if parentID == 0 {
+		_, err := tx.Exec("UPDATE tasks SET parent_id = NULL, position = NULL WHERE id = ?", taskID)
+		return err
+	}
+
+	if parentID == taskID {
+		return ErrInvalidParent
+	}
+	if _, err := checkTaskAccess(tx, userID, parentID, types.ProjectRoleEditor); err != nil {
+		if err == ErrTaskNotFound || err == ErrTaskForbidden {
+			return ErrInvalidParent
+		}
+		return err
+	}
+	depth, cycle, err := ancestorDepth(tx, parentID, taskID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return ErrInvalidParent
+	}
+	height, err := subtreeHeight(tx, taskID)
+	if err != nil {
+		return err
+	}
+	if limit := MaxTaskDepth(); depth+height > limit {
+		return &TaskDepthError{Max: limit}
+	}
+
+	return placeTaskTx(tx, userID, deviceID, taskID, parentID, index)
+}
+
+// placeTaskTx 将任务放到父任务的子任务列表中的指定位置，并按顺序重新编号
+func placeTaskTx(tx *sql.Tx, userID int, deviceID string, taskID, parentID int64, index *int) error {
+	rows, err := tx.Query("SELECT id, position FROM tasks WHERE parent_id = ? AND is_deleted = 0 AND id != ? ORDER BY position, id", parentID, taskID)
+	if err != nil {
+		return err
+	}
+	var siblings []int64
+	positions := map[int64]sql.NullInt64{}
+	for rows.Next() {
+		var id int64
+		var position sql.NullInt64
+		if err := rows.Scan(&id, &position); err != nil {
+			rows.Close()
+			return err
+		}
+		siblings = append(siblings, id)
+		positions[id] = position
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	at := len(siblings)
+	if index != nil && *index >= 0 && *index < at {
+		at = *index
+	}
+	ordered := append(append(append([]int64{}, siblings[:at]...), taskID), siblings[at:]...)
+
+	var moved []int64
+	for i, id := range ordered {
+		if id != taskID {
+			if p := positions[id]; p.Valid && int(p.Int64) == i {
+				continue
+			}
+			moved = append(moved, id)
+		}
+		if _, err := tx.Exec("UPDATE tasks SET parent_id = ?, position = ? WHERE id = ?", parentID, i, id); err != nil {
+			return err
+		}
+	}
+	return touchTasks(tx, userID, deviceID, moved)
+}
+
+// SetSyncTaskParent 在同步事务中设置新插入任务的父任务（追加到子任务末尾）
+func SetSyncTaskParent(tx *sql.Tx, userID int, deviceID string, taskID, parentID int64) error {
+	return setTaskParentTx(tx, userID, deviceID, taskID, parentID, nil)
+}
+
+// deleteDescendantsTx 随父任务一起软删除全部未删除的后代任务，删除时间与父任务一致以便一起恢复
+func deleteDescendantsTx(tx *sql.Tx, userID int, deviceID string, taskIDs []int64, now time.Time) (int, error) {
+	ids, err := descendantIDs(tx, taskIDs, "t.is_deleted = 0")
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := softDeleteTaskTx(tx, userID, deviceID, id, now); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// restoreDescendantsTx 恢复与任务在同一次删除中被删除的后代任务（删除时间相同）
+func restoreDescendantsTx(tx *sql.Tx, userID int, deviceID string, taskID int64, deletedAt time.Time) error {
+	ids, err := descendantIDs(tx, []int64{taskID}, "t.is_deleted = 1 AND t.deleted_at = ?", deletedAt)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := restoreTaskTx(tx, userID, deviceID, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE deleted_tasks SET is_restorable = 0 WHERE task_id = ?", id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detachFromDeletedParent 恢复的任务的父任务仍处于删除状态时，将其移到顶层
+func detachFromDeletedParent(tx *sql.Tx, taskID int64) error {
+	_, err := tx.Exec(
+		"UPDATE tasks SET parent_id = NULL, position = NULL WHERE id = ? AND parent_id IN (SELECT id FROM tasks WHERE is_deleted = 1)",
+		taskID,
+	)
+	return err
+}
@@ -1,29 +1,72 @@
 package db
 
 import (
+	"database/sql"
 	"time"
+
+	"todoapp/internal/types"
 )
 
-// GetTaskForSync 获取任务用于同步检查
-func GetTaskForSync(taskID int64) (int, string, string, string, bool, error) {
-	var serverVersion int
-	var title, description, status string
-	var isDeleted bool
+// SyncTaskState 同步时用于版本比较与合并的服务器端任务状态
+type SyncTaskState struct {
+	Version     int
+	Title       string
+	Description string
+	Status      string
+	Priority    string
+	DueAt       string // UTC RFC3339，没有截止时间时为空
+	IsDeleted   bool
+	UpdatedAt   time.Time
+}
+
+// formatDueAt 将截止时间格式化为同步使用的 RFC3339 字符串
+func formatDueAt(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.UTC().Format(time.RFC3339)
+}
+
+// GetTaskForSync 在同步事务中获取用户可编辑的任务状态（本人的任务或共享项目中 editor 以上角色的任务）
+func GetTaskForSync(tx *sql.Tx, userID int, taskID int64) (*SyncTaskState, error) {
+	if err := checkTaskAccessAny(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return nil, err
+	}
+
+	var version sql.NullInt64
+	var title, description, status, priority sql.NullString
+	var isDeleted sql.NullBool
+	var dueAt, updatedAt sql.NullTime
 
-	err := DB.QueryRow(
-		"SELECT server_version, title, description, status, is_deleted FROM tasks WHERE id = ?",
+	err := tx.QueryRow(
+		"SELECT server_version, title, description, status, priority, due_at, is_deleted, updated_at FROM tasks WHERE id = ?",
 		taskID,
-	).Scan(&serverVersion, &title, &description, &status, &isDeleted)
+	).Scan(&version, &title, &description, &status, &priority, &dueAt, &isDeleted, &updatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTaskNotFound
+		}
+		return nil, err
+	}
 
-	return serverVersion, title, description, status, isDeleted, err
+	return &SyncTaskState{
+		Version:     int(version.Int64),
+		Title:       title.String,
+		Description: description.String,
+		Status:      status.String,
+		Priority:    priority.String,
+		DueAt:       formatDueAt(dueAt),
+		IsDeleted:   isDeleted.Bool,
+		UpdatedAt:   updatedAt.Time,
+	}, nil
 }
 
 // TaskExistsByLocalID 检查local_id是否存在
-func TaskExistsByLocalID(userID int, localID string) (int64, string, error) {
+func TaskExistsByLocalID(tx *sql.Tx, userID int, localID string) (int64, string, error) {
 	var taskID int64
 	var title string
 
-	err := DB.QueryRow(
+	err := tx.QueryRow(
 		"SELECT id, title FROM tasks WHERE local_id = ? AND user_id = ?",
 		localID, userID,
 	).Scan(&taskID, &title)
@@ -31,31 +74,108 @@ func TaskExistsByLocalID(userID int, localID string) (int64, string, error) {
 	return taskID, title, err
 }
 
-// RecordConflict 记录冲突到数据库
-func RecordConflict(localID string, serverID int64, reason string, optionsJSON string) error {
-	_, err := DB.Exec(
-		"INSERT INTO conflicts (local_id, server_id, reason, options, created_at) VALUES (?, ?, ?, ?, ?)",
-		localID, serverID, reason, optionsJSON, time.Now().UTC(),
+// InsertSyncTask 插入客户端同步上来的新任务并记录变更，返回任务 ID 与分配的版本号
+func InsertSyncTask(tx *sql.Tx, userID int, deviceID, localID, title, description, status, priority string, dueAt *time.Time) (int64, int, error) {
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, due_at, created_at, updated_at, is_deleted, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)",
+		userID, localID, version, title, description, status, priority, dueAtValue(dueAt), now, now, now,
 	)
-	return err
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := recordActivityTx(tx, userID, id, types.ActivityCreated, nil); err != nil {
+		return 0, 0, err
+	}
+	return id, version, appendTaskChange(tx, userID, version, id, ChangeInsert, deviceID)
 }
 
-// UpdateTaskWithVersion 更新任务并增加版本号
-func UpdateTaskWithVersion(taskID int64, title, description, status, priority string, newVer int) error {
+// UpdateTaskWithVersion 更新任务、记录变更并分配新版本号，dueAt 为 nil 时清除截止时间
+// 状态变为 in_progress 或 done 时与 REST 更新一样检查前置任务：reject 模式下返回 BlockedError 且不做任何修改，
+// warn 模式下照常更新并返回未完成的前置任务；重复任务变为 done 时生成下一次发生
+func UpdateTaskWithVersion(tx *sql.Tx, userID int, deviceID string, taskID int64, title, description, status, priority string, dueAt *time.Time) (int, []int64, error) {
+	var current sql.NullString
+	if err := tx.QueryRow("SELECT status FROM tasks WHERE id = ?", taskID).Scan(&current); err != nil {
+		return 0, nil, err
+	}
+	// 同步上传的是完整载荷，只在状态实际变化时检查，避免已完成的任务每次同步都被提示
+	var blockers []int64
+	if status != current.String {
+		var err error
+		if blockers, err = checkBlockersTx(tx, taskID, status); err != nil {
+			return 0, nil, err
+		}
+	}
+	if err := recordStatusChangeTx(tx, userID, taskID, status); err != nil {
+		return 0, nil, err
+	}
+	version, err := logTaskChange(tx, userID, taskID, ChangeUpdate, deviceID)
+	if err != nil {
+		return 0, nil, err
+	}
+
 	now := time.Now().UTC()
-	_, err := DB.Exec(
-		"UPDATE tasks SET title=?, description=?, status=?, priority=?, server_version=?, updated_at=?, last_modified=? WHERE id=?",
-		title, description, status, priority, newVer, now, now, taskID,
+	_, err = tx.Exec(
+		"UPDATE tasks SET title=?, description=?, status=?, priority=?, due_at=?, server_version=?, updated_at=?, last_modified=? WHERE id=?",
+		title, description, status, priority, dueAtValue(dueAt), version, now, now, taskID,
 	)
-	return err
+	if err != nil {
+		return 0, nil, err
+	}
+	if status == "done" {
+		// 重复任务完成后生成下一次发生，新任务在本次同步的拉取结果中下发
+		if _, err := spawnNextOccurrence(tx, userID, deviceID, taskID); err != nil {
+			return 0, nil, err
+		}
+	}
+	return version, blockers, nil
 }
 
-// SoftDeleteTaskWithVersion 软删除任务并增加版本号
-func SoftDeleteTaskWithVersion(taskID int64, newVer int) error {
+// SoftDeleteTaskWithVersion 软删除任务、记录变更并分配新版本号
+func SoftDeleteTaskWithVersion(tx *sql.Tx, userID int, deviceID string, taskID int64) (int, error) {
+	version, err := logTaskChange(tx, userID, taskID, ChangeDelete, deviceID)
+	if err != nil {
+		return 0, err
+	}
+
 	now := time.Now().UTC()
-	_, err := DB.Exec(
-		"UPDATE tasks SET is_deleted=1, server_version=?, updated_at=?, last_modified=? WHERE id=?",
-		newVer, now, now, taskID,
+	_, err = tx.Exec(
+		"UPDATE tasks SET is_deleted=1, deleted_at=?, server_version=?, updated_at=?, last_modified=? WHERE id=?",
+		now, version, now, now, taskID,
 	)
+	return version, err
+}
+
+// GetSyncProgress 获取设备上次同步确认的变更序号，found 为 false 表示该设备尚未同步过
+func GetSyncProgress(tx *sql.Tx, userID int, deviceID string) (int, bool, error) {
+	var version sql.NullInt64
+	err := tx.QueryRow(
+		"SELECT last_server_version FROM sync_meta WHERE user_id = ? AND device_id = ?",
+		userID, deviceID,
+	).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return int(version.Int64), true, nil
+}
+
+// SaveSyncProgress 记录设备的同步进度
+func SaveSyncProgress(tx *sql.Tx, userID int, deviceID string, version int, at time.Time) error {
+	_, err := tx.Exec(`
+		INSERT INTO sync_meta (user_id, device_id, last_sync_at, last_server_version) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user_id, device_id) DO UPDATE SET last_sync_at = excluded.last_sync_at, last_server_version = excluded.last_server_version
+	`, userID, deviceID, at, version)
 	return err
 }
@@ -0,0 +1,275 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"todoapp/internal/types"
+)
+
+const (
+	// DefaultTagColor 自动创建的标签使用的颜色
+	DefaultTagColor = "#808080"
+	// MaxTagsPerTask 单个任务最多的标签数
+	MaxTagsPerTask = 20
+	// MaxTagNameLength 标签名的最大长度（字符）
+	MaxTagNameLength = 50
+	// tagSeparator 任务标签在 GROUP_CONCAT 中的分隔符（ASCII 单元分隔符，不会出现在标签名中）
+	tagSeparator = "\x1f"
+)
+
+// taskTagsColumn 任务的标签名列表（按名称排序，以 tagSeparator 连接）
+const taskTagsColumn = `(SELECT GROUP_CONCAT(name, char(31)) FROM (SELECT g.name FROM task_tags tt JOIN tags g ON g.id = tt.tag_id WHERE tt.task_id = tasks.id ORDER BY g.name)) AS tags`
+
+var (
+	// ErrTagNotFound 标签不存在或不属于当前用户
+	ErrTagNotFound = errors.New("标签不存在")
+	// ErrTagExists 同名标签已存在
+	ErrTagExists = errors.New("同名标签已存在")
+	// ErrInvalidTagColor 标签颜色格式无效
+	ErrInvalidTagColor = errors.New("颜色格式无效，应为 #RRGGBB")
+	// ErrTooManyTags 任务的标签数超过上限
+	ErrTooManyTags = fmt.Errorf("每个任务最多 %d 个标签", MaxTagsPerTask)
+)
+
+var tagColorPattern = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// ValidTagColor 检查颜色是否为 #RRGGBB 格式
+func ValidTagColor(color string) bool {
+	return tagColorPattern.MatchString(color)
+}
+
+// NormalizeTagName 去除标签名首尾空白并检查长度与字符
+func NormalizeTagName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("标签名不能为空")
+	}
+	if utf8.RuneCountInString(name) > MaxTagNameLength {
+		return "", fmt.Errorf("标签名不能超过 %d 个字符", MaxTagNameLength)
+	}
+	if strings.ContainsAny(name, ",;"+tagSeparator) {
+		return "", errors.New("标签名不能包含逗号或分号")
+	}
+	return name, nil
+}
+
+// NormalizeTagNames 规范化任务的标签名列表：去除空白、按名称忽略大小写去重，并检查数量上限
+func NormalizeTagNames(names []string) ([]string, error) {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, name := range names {
+		name, err := NormalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(name)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		result = append(result, name)
+	}
+	if len(result) > MaxTagsPerTask {
+		return nil, ErrTooManyTags
+	}
+	return result, nil
+}
+
+// parseTagList 解析 taskTagsColumn 的结果
+func parseTagList(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, tagSeparator)
+}
+
+// setTaskTagsTx 将任务的标签替换为 names（需已规范化），不存在的标签以默认颜色自动创建
+// 任务的版本号由调用方分配
+func setTaskTagsTx(tx *sql.Tx, userID int, taskID int64, names []string) error {
+	if _, err := tx.Exec("DELETE FROM task_tags WHERE task_id = ?", taskID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, name := range names {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tags (user_id, name, color, created_at) VALUES (?, ?, ?, ?)",
+			userID, name, DefaultTagColor, now); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			"INSERT OR IGNORE INTO task_tags (task_id, tag_id) SELECT ?, id FROM tags WHERE user_id = ? AND name = ?",
+			taskID, userID, name,
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetSyncTaskTags 在同步事务中替换任务的标签，任务的版本号已由同步写入分配
+func SetSyncTaskTags(tx *sql.Tx, userID int, taskID int64, names []string) error {
+	return setTaskTagsTx(tx, userID, taskID, names)
+}
+
+// tagTaskIDs 获取使用该标签的未删除任务
+func tagTaskIDs(q Querier, tagID int64) ([]int64, error) {
+	return queryIDs(q, `
+		SELECT t.id FROM task_tags tt JOIN tasks t ON t.id = tt.task_id
+		WHERE tt.tag_id = ? AND t.is_deleted = 0 ORDER BY t.id`, tagID)
+}
+
+// checkTagOwner 检查标签是否存在且属于指定用户
+func checkTagOwner(q queryRower, userID int, tagID int64) (*types.Tag, error) {
+	tag := &types.Tag{ID: tagID}
+	var ownerID int
+	var createdAt sql.NullTime
+	err := q.QueryRow(`
+		SELECT g.user_id, g.name, g.color, g.created_at,
+		       (SELECT COUNT(*) FROM task_tags tt JOIN tasks t ON t.id = tt.task_id WHERE tt.tag_id = g.id AND t.is_deleted = 0)
+		FROM tags g WHERE g.id = ?`, tagID).
+		Scan(&ownerID, &tag.Name, &tag.Color, &createdAt, &tag.TaskCount)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		return nil, ErrTagNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	tag.CreatedAt = formatDueAt(createdAt)
+	return tag, nil
+}
+
+// isUniqueViolation 判断错误是否为唯一约束冲突
+func isUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// ListTags 获取用户的全部标签（按名称排序），附带使用该标签的任务数
+func ListTags(userID int) ([]types.Tag, error) {
+	rows, err := DB.Query(`
+		SELECT g.id, g.name, g.color, g.created_at,
+		       (SELECT COUNT(*) FROM task_tags tt JOIN tasks t ON t.id = tt.task_id WHERE tt.tag_id = g.id AND t.is_deleted = 0)
+		FROM tags g WHERE g.user_id = ? ORDER BY g.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []types.Tag{}
+	for rows.Next() {
+		var tag types.Tag
+		var createdAt sql.NullTime
+		if err := rows.Scan(&tag.ID, &tag.Name, &tag.Color, &createdAt, &tag.TaskCount); err != nil {
+			return nil, err
+		}
+		tag.CreatedAt = formatDueAt(createdAt)
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+// CreateTag 创建标签，同名（忽略大小写）标签已存在时返回 ErrTagExists
+func CreateTag(userID int, name, color string) (*types.Tag, error) {
+	now := time.Now().UTC()
+	res, err := DB.Exec("INSERT INTO tags (user_id, name, color, created_at) VALUES (?, ?, ?, ?)", userID, name, color, now)
+	if isUniqueViolation(err) {
+		return nil, ErrTagExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return checkTagOwner(DB, userID, id)
+}
+
+// UpdateTag 修改标签名称或颜色（nil 表示不修改）
+// 改名后使用该标签的任务分配新版本号，使新名称同步到客户端
+func UpdateTag(userID int, deviceID string, tagID int64, name, color *string) (*types.Tag, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	tag, err := checkTagOwner(tx, userID, tagID)
+	if err != nil {
+		return nil, err
+	}
+	if color != nil {
+		if _, err := tx.Exec("UPDATE tags SET color = ? WHERE id = ?", *color, tagID); err != nil {
+			return nil, err
+		}
+	}
+	if name != nil && *name != tag.Name {
+		_, err := tx.Exec("UPDATE tags SET name = ? WHERE id = ?", *name, tagID)
+		if isUniqueViolation(err) {
+			return nil, ErrTagExists
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids, err := tagTaskIDs(tx, tagID)
+		if err != nil {
+			return nil, err
+		}
+		if err := touchTasks(tx, userID, deviceID, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	if tag, err = checkTagOwner(tx, userID, tagID); err != nil {
+		return nil, err
+	}
+	return tag, tx.Commit()
+}
+
+// DeleteTag 删除标签并从所有任务上移除，受影响的任务分配新版本号
+func DeleteTag(userID int, deviceID string, tagID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTagOwner(tx, userID, tagID); err != nil {
+		return err
+	}
+	ids, err := tagTaskIDs(tx, tagID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM task_tags WHERE tag_id = ?", tagID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM tags WHERE id = ?", tagID); err != nil {
+		return err
+	}
+	if err := touchTasks(tx, userID, deviceID, ids); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// tagFilterClause 构建按标签过滤的条件：match 为 all 时任务需带有全部标签，否则带有任一标签即可
+// 按名称（忽略大小写）匹配，共享任务上由其他成员添加的同名标签同样计入
+func tagFilterClause(names []string, match string) (string, []interface{}) {
+	clause, args := inClause("g.name", names)
+	query := "id IN (SELECT tt.task_id FROM task_tags tt JOIN tags g ON g.id = tt.tag_id WHERE " + clause
+	if match == types.TagMatchAll {
+		// 名称忽略大小写去重后计数，避免 tags=a,A 永远无法匹配
+		distinct := map[string]bool{}
+		for _, name := range names {
+			distinct[strings.ToLower(name)] = true
+		}
+		query += " GROUP BY tt.task_id HAVING COUNT(DISTINCT LOWER(g.name)) = ?"
+		args = append(args, len(distinct))
+	}
+	return query + ")", args
+}
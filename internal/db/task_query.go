@@ -0,0 +1,399 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"todoapp/internal/types"
+)
+
+// ftsEnabled 标记 FTS5 全文索引是否可用（需要以 sqlite_fts5 构建标签编译）
+var ftsEnabled bool
+
+// FilterError 任务查询参数无效
+type FilterError struct {
+	Field   string
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// taskSortColumns 允许的排序键及其 SQL 表达式
+var taskSortColumns = map[string]string{
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"due_at":     "COALESCE(due_at, '')",
+	"title":      "title COLLATE NOCASE",
+	"status":     "CASE status WHEN 'todo' THEN 1 WHEN 'in_progress' THEN 2 WHEN 'done' THEN 3 WHEN 'archived' THEN 4 ELSE 0 END",
+	"priority":   "CASE priority WHEN 'low' THEN 1 WHEN 'medium' THEN 2 WHEN 'high' THEN 3 ELSE 0 END",
+	"position":   "COALESCE(position, 0)",
+	// 不属于项目的任务排在最后（'~' 大于任何排序键字符）
+	"project_rank": "COALESCE(project_rank, '~')",
+}
+
+// initTaskSearch 创建 tasks 的 FTS5 外部内容索引及同步触发器
+// trigram 分词器支持中文子串匹配；FTS5 不可用时退化为 LIKE 查询
+func initTaskSearch() {
+	// 触发器缺失说明索引是新建的或曾被停用，需要回填
+	var triggers int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name LIKE 'tasks_fts_%'").Scan(&triggers); err != nil {
+		log.Println("warning: failed to check tasks_fts triggers:", err)
+		return
+	}
+
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS tasks_fts USING fts5(
+            title, description,
+            content='tasks', content_rowid='id', tokenize='trigram'
+        );`,
+		`SELECT rowid FROM tasks_fts LIMIT 1;`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_fts_ai AFTER INSERT ON tasks BEGIN
+            INSERT INTO tasks_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+        END;`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_fts_ad AFTER DELETE ON tasks BEGIN
+            INSERT INTO tasks_fts(tasks_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+        END;`,
+		`CREATE TRIGGER IF NOT EXISTS tasks_fts_au AFTER UPDATE OF title, description ON tasks BEGIN
+            INSERT INTO tasks_fts(tasks_fts, rowid, title, description) VALUES ('delete', old.id, old.title, old.description);
+            INSERT INTO tasks_fts(rowid, title, description) VALUES (new.id, new.title, new.description);
+        END;`,
+	}
+	for _, s := range stmts {
+		if _, err := DB.Exec(s); err != nil {
+			log.Println("warning: full-text search disabled:", err)
+			// 当前二进制不支持 FTS5 时移除触发器，避免写入 tasks 失败
+			for _, name := range []string{"tasks_fts_ai", "tasks_fts_ad", "tasks_fts_au"} {
+				DB.Exec("DROP TRIGGER IF EXISTS " + name)
+			}
+			return
+		}
+	}
+
+	if triggers < 3 {
+		if _, err := DB.Exec("INSERT INTO tasks_fts(tasks_fts) VALUES ('rebuild')"); err != nil {
+			log.Println("warning: failed to rebuild tasks_fts:", err)
+			return
+		}
+	}
+	ftsEnabled = true
+}
+
+// TaskFilterKeys 任务列表与保存的视图支持的过滤条件
+var TaskFilterKeys = []string{"status", "priority", "due_from", "due_to", "updated_since", "include_deleted", "parent_id", "project_id", "assignee", "tags", "tag_match", "q"}
+
+// relativeTimeUnits 相对时间支持的单位
+var relativeTimeUnits = map[byte]time.Duration{
+	'h': time.Hour,
+	'd': 24 * time.Hour,
+	'w': 7 * 24 * time.Hour,
+}
+
+// parseFilterTime 解析 RFC3339 或 YYYY-MM-DD 格式的时间参数
+// 也支持相对于查询时刻的时间：now、today（当天 0 点，UTC），或 +7d、-12h、+2w 这样的偏移，供保存的视图使用
+func parseFilterTime(field, value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, ok := parseRelativeTime(value, time.Now().UTC()); ok {
+		return t, nil
+	}
+	return time.Time{}, &FilterError{Field: field, Message: "时间格式无效，应为 RFC3339、YYYY-MM-DD、now、today 或 +7d 这样的相对时间"}
+}
+
+// parseRelativeTime 解析相对于 now 的时间
+func parseRelativeTime(value string, now time.Time) (time.Time, bool) {
+	switch value {
+	case "now":
+		return now, true
+	case "today":
+		return now.Truncate(24 * time.Hour), true
+	}
+	if len(value) < 3 || (value[0] != '+' && value[0] != '-') {
+		return time.Time{}, false
+	}
+	unit, ok := relativeTimeUnits[value[len(value)-1]]
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.Atoi(value[1 : len(value)-1])
+	if err != nil || n < 0 || n > 3650 {
+		return time.Time{}, false
+	}
+	if value[0] == '-' {
+		n = -n
+	}
+	return now.Add(time.Duration(n) * unit), true
+}
+
+// IsRelativeFilter 判断过滤条件中是否含有相对时间，这样的视图成员会随时间变化
+func IsRelativeFilter(filters map[string]string) bool {
+	for _, key := range []string{"due_from", "due_to", "updated_since"} {
+		if _, ok := parseRelativeTime(filters[key], time.Time{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitFilterList 拆分逗号分隔的过滤值
+func splitFilterList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// inClause 生成 "column IN (?, ?, ...)" 子句
+func inClause(column string, values []string) (string, []interface{}) {
+	placeholders := make([]string, len(values))
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		placeholders[i] = "?"
+		args[i] = v
+	}
+	return column + " IN (" + strings.Join(placeholders, ",") + ")", args
+}
+
+// buildTaskFilter 根据过滤条件构建 WHERE 子句
+// 支持: status, priority, tags（逗号分隔多值）, tag_match（any/all）, due_from, due_to, updated_since, include_deleted, parent_id（root 表示顶层）, project_id（none 表示不属于项目）,
+// assignee（me 表示当前用户，none 表示未指派，或用户 ID）, q
+func buildTaskFilter(userID int, filters map[string]string) (string, []interface{}, error) {
+	where := []string{taskAccessClause}
+	args := taskAccessArgs(userID)
+
+	if filters["include_deleted"] != "true" {
+		where = append(where, "is_deleted = 0")
+	}
+
+	if statuses := splitFilterList(filters["status"]); len(statuses) > 0 {
+		for _, s := range statuses {
+			if !types.StatusState(s).IsValid() {
+				return "", nil, &FilterError{Field: "status", Message: "无效的任务状态: " + s}
+			}
+		}
+		clause, clauseArgs := inClause("status", statuses)
+		where = append(where, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	if priorities := splitFilterList(filters["priority"]); len(priorities) > 0 {
+		for _, p := range priorities {
+			if !types.PriorityState(p).IsValid() {
+				return "", nil, &FilterError{Field: "priority", Message: "无效的优先级: " + p}
+			}
+		}
+		clause, clauseArgs := inClause("priority", priorities)
+		where = append(where, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	timeFilters := []struct {
+		key  string
+		cond string
+	}{
+		{"due_from", "due_at >= ?"},
+		{"due_to", "due_at <= ?"},
+		{"updated_since", "updated_at > ?"},
+	}
+	for _, tf := range timeFilters {
+		if v := filters[tf.key]; v != "" {
+			t, err := parseFilterTime(tf.key, v)
+			if err != nil {
+				return "", nil, err
+			}
+			where = append(where, tf.cond)
+			args = append(args, t)
+		}
+	}
+
+	switch parent := filters["parent_id"]; parent {
+	case "":
+	case "root":
+		where = append(where, "parent_id IS NULL")
+	default:
+		id, err := strconv.ParseInt(parent, 10, 64)
+		if err != nil || id < 1 {
+			return "", nil, &FilterError{Field: "parent_id", Message: "必须是任务 ID 或 root"}
+		}
+		where = append(where, "parent_id = ?")
+		args = append(args, id)
+	}
+
+	switch project := filters["project_id"]; project {
+	case "":
+	case "none":
+		where = append(where, "project_id IS NULL")
+	default:
+		id, err := strconv.ParseInt(project, 10, 64)
+		if err != nil || id < 1 {
+			return "", nil, &FilterError{Field: "project_id", Message: "必须是项目 ID 或 none"}
+		}
+		where = append(where, "project_id = ?")
+		args = append(args, id)
+	}
+
+	switch assignee := filters["assignee"]; assignee {
+	case "":
+	case types.AssigneeMe:
+		where = append(where, "assignee_id = ?")
+		args = append(args, userID)
+	case "none":
+		where = append(where, "assignee_id IS NULL")
+	default:
+		id, err := strconv.Atoi(assignee)
+		if err != nil || id < 1 {
+			return "", nil, &FilterError{Field: "assignee", Message: "必须是用户 ID、me 或 none"}
+		}
+		where = append(where, "assignee_id = ?")
+		args = append(args, id)
+	}
+
+	if names := splitFilterList(filters["tags"]); len(names) > 0 {
+		match := filters["tag_match"]
+		if match == "" {
+			match = types.TagMatchAny
+		}
+		if match != types.TagMatchAny && match != types.TagMatchAll {
+			return "", nil, &FilterError{Field: "tag_match", Message: "必须是 any 或 all"}
+		}
+		clause, clauseArgs := tagFilterClause(names, match)
+		where = append(where, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	if q := strings.TrimSpace(filters["q"]); q != "" {
+		clause, clauseArgs := taskSearchClause(q)
+		where = append(where, clause)
+		args = append(args, clauseArgs...)
+	}
+
+	return strings.Join(where, " AND "), args, nil
+}
+
+// taskSearchClause 构建标题/描述全文检索条件，多个词之间为 AND 关系
+// trigram 索引只能匹配至少 3 个字符的词，更短的词使用 LIKE
+func taskSearchClause(q string) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	var phrases []string
+
+	for _, term := range strings.Fields(q) {
+		if ftsEnabled && utf8.RuneCountInString(term) >= 3 {
+			phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+			continue
+		}
+		pattern := "%" + escapeLike(term) + "%"
+		conds = append(conds, `(title LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+
+	if len(phrases) > 0 {
+		conds = append(conds, "id IN (SELECT rowid FROM tasks_fts WHERE tasks_fts MATCH ?)")
+		args = append(args, strings.Join(phrases, " AND "))
+	}
+
+	return "(" + strings.Join(conds, " AND ") + ")", args
+}
+
+// escapeLike 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "%", `\%`)
+	return strings.ReplaceAll(s, "_", `\_`)
+}
+
+// taskOrderClause 解析排序参数，返回排序键、SQL 表达式与方向
+func taskOrderClause(orderBy, order string) (string, string, bool, error) {
+	if orderBy == "" {
+		orderBy = "created_at"
+	}
+	expr, ok := taskSortColumns[orderBy]
+	if !ok {
+		return "", "", false, &FilterError{Field: "sort", Message: "不支持的排序字段: " + orderBy}
+	}
+
+	dir := strings.ToUpper(order)
+	if dir == "" {
+		dir = "DESC"
+	}
+	if dir != "ASC" && dir != "DESC" {
+		return "", "", false, &FilterError{Field: "order", Message: "排序方向必须是 asc 或 desc"}
+	}
+
+	return orderBy, expr, dir == "DESC", nil
+}
+
+// GetTasksPaginated 按过滤、排序和全文检索条件分页获取任务
+// q.Cursor 非空时使用游标定位（忽略页码），并返回下一页游标（没有更多数据时为 nil）
+func GetTasksPaginated(userID int, q *types.PaginatedQuery) ([]map[string]interface{}, int, *types.Cursor, error) {
+	where, args, err := buildTaskFilter(userID, q.Filters)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	sortKey, sortExpr, desc, err := taskOrderClause(q.OrderBy, q.Order)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	// 获取总数
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, nil, err
+	}
+
+	offset := q.GetOffset()
+	if q.Cursor != nil {
+		clause, clauseArgs, err := keysetClause(sortKey, sortExpr, desc, q.Cursor)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		where += " AND " + clause
+		args = append(args, clauseArgs...)
+		offset = 0
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+
+	// 多取一行用于判断是否还有下一页
+	query := "SELECT " + taskColumns + ", " + sortExpr + " FROM tasks WHERE " + where +
+		fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT ? OFFSET ?", sortExpr, dir, dir)
+	rows, err := DB.Query(query, append(args, q.PageSize+1, offset)...)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rows.Close()
+
+	results := []map[string]interface{}{}
+	var next *types.Cursor
+	var lastSortValue interface{}
+	for rows.Next() {
+		var sortValue interface{}
+		task, err := scanTask(extraScanner{rows, []interface{}{&sortValue}})
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if len(results) == q.PageSize {
+			last := results[len(results)-1]
+			next = newCursor(sortKey, lastSortValue, last["id"].(int64))
+			break
+		}
+		lastSortValue = sortValue
+		results = append(results, task)
+	}
+	return results, total, next, rows.Err()
+}
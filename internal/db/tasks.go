@@ -0,0 +1,471 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+var (
+	// ErrTaskNotFound 任务不存在（或已删除）
+	ErrTaskNotFound = errors.New("任务不存在")
+	// ErrTaskForbidden 任务不属于当前用户
+	ErrTaskForbidden = errors.New("无权访问此任务")
+)
+
+// VersionConflictError 客户端提交的版本号与服务器不一致
+type VersionConflictError struct {
+	Current int
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("版本冲突，服务器当前版本为 %d", e.Current)
+}
+
+// TaskFields 任务可写字段，nil 表示不修改
+type TaskFields struct {
+	Title          *string
+	Description    *string
+	Status         *string
+	Priority       *string
+	DueAt          *time.Time // 零值表示清除截止时间
+	RecurrenceRule *string    // 规范化的重复规则，空字符串表示结束重复
+	ParentID       *int64     // 0 表示移到顶层
+	Position       *int       // 在兄弟任务中的位置（从 0 开始），仅对子任务有效
+	Tags           *[]string  // 规范化的标签名列表，替换任务现有的全部标签
+	ProjectID      *int64     // 0 表示移出项目
+	ProjectRank    *string    // 项目内的排序键，nil 时移入新项目排在最后
+	AssigneeID     *int       // 0 表示取消指派
+}
+
+// IsEmpty 判断是否没有任何需要修改的字段
+func (f TaskFields) IsEmpty() bool {
+	return f.Title == nil && f.Description == nil && f.Status == nil && f.Priority == nil && f.DueAt == nil &&
+		f.RecurrenceRule == nil && f.ParentID == nil && f.Position == nil && f.Tags == nil &&
+		f.ProjectID == nil && f.ProjectRank == nil && f.AssigneeID == nil
+}
+
+// dueAtValue 将截止时间转换为数据库取值，零值对应 NULL
+func dueAtValue(t *time.Time) interface{} {
+	if t == nil || t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+// taskColumns 任务查询的标准列，与 scanTask 的扫描顺序一致
+const taskColumns = `id, local_id, server_version, title, description, status, priority,
+	due_at, created_at, updated_at, completed_at, is_deleted, last_modified, series_id, occurrence,
+	(SELECT rule FROM task_series WHERE task_series.id = tasks.series_id) AS recurrence_rule, parent_id, position,
+	(SELECT COUNT(*) FROM tasks c WHERE c.parent_id = tasks.id AND c.is_deleted = 0) AS child_count,
+	(SELECT COUNT(*) FROM tasks c WHERE c.parent_id = tasks.id AND c.is_deleted = 0 AND c.status = 'done') AS child_done,
+	(SELECT GROUP_CONCAT(blocker_id) FROM (SELECT blocker_id FROM task_dependencies WHERE task_id = tasks.id ORDER BY blocker_id)) AS blocked_by,
+	` + taskTagsColumn + `, project_id, project_rank, assignee_id, ` + taskAttachmentsColumn
+
+// rowScanner 统一 *sql.Row 与 *sql.Rows 的扫描接口
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower 统一 *sql.DB 与 *sql.Tx 的单行查询接口
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// scanTask 将一行任务记录转换为 map
+func scanTask(s rowScanner) (map[string]interface{}, error) {
+	var id int64
+	var localID sql.NullString
+	var serverVersion sql.NullInt64
+	var title sql.NullString
+	var description sql.NullString
+	var status sql.NullString
+	var priority sql.NullString
+	var dueAt sql.NullString
+	var createdAt sql.NullString
+	var updatedAt sql.NullString
+	var completedAt sql.NullString
+	var isDeleted sql.NullBool
+	var lastModified sql.NullString
+	var seriesID sql.NullInt64
+	var occurrence sql.NullInt64
+	var recurrenceRule sql.NullString
+	var parentID sql.NullInt64
+	var position sql.NullInt64
+	var childCount, childDone int
+	var blockedBy sql.NullString
+	var tags sql.NullString
+	var projectID sql.NullInt64
+	var projectRank sql.NullString
+	var assigneeID sql.NullInt64
+	var attachments sql.NullString
+	if err := s.Scan(&id, &localID, &serverVersion, &title, &description, &status, &priority, &dueAt, &createdAt, &updatedAt, &completedAt, &isDeleted, &lastModified,
+		&seriesID, &occurrence, &recurrenceRule, &parentID, &position, &childCount, &childDone, &blockedBy, &tags, &projectID, &projectRank, &assigneeID, &attachments); err != nil {
+		return nil, err
+	}
+	task := map[string]interface{}{
+		"id":              id,
+		"local_id":        localID.String,
+		"server_version":  serverVersion.Int64,
+		"title":           title.String,
+		"description":     description.String,
+		"status":          status.String,
+		"priority":        priority.String,
+		"due_at":          dueAt.String,
+		"created_at":      createdAt.String,
+		"updated_at":      updatedAt.String,
+		"completed_at":    completedAt.String,
+		"is_deleted":      isDeleted.Bool,
+		"last_modified":   lastModified.String,
+		"series_id":       nil,
+		"occurrence":      nil,
+		"recurrence_rule": recurrenceRule.String,
+		"parent_id":       nil,
+		"position":        nil,
+		"child_count":     childCount,
+		"children_done":   childDone,
+		"progress":        nil,
+		"blocked_by":      parseIDList(blockedBy.String),
+		"tags":            parseTagList(tags.String),
+		"project_id":      nil,
+		"project_rank":    nil,
+		"assignee_id":     nil,
+		"attachments":     parseAttachmentList(attachments.String),
+	}
+	if seriesID.Valid {
+		task["series_id"] = seriesID.Int64
+		task["occurrence"] = occurrence.Int64
+	}
+	if parentID.Valid {
+		task["parent_id"] = parentID.Int64
+		task["position"] = position.Int64
+	}
+	if projectID.Valid {
+		task["project_id"] = projectID.Int64
+		task["project_rank"] = projectRank.String
+	}
+	if assigneeID.Valid {
+		task["assignee_id"] = assigneeID.Int64
+	}
+	if childCount > 0 {
+		// 进度为已完成的直接子任务所占比例
+		task["progress"] = float64(childDone) / float64(childCount)
+	}
+	return task, nil
+}
+
+// parseIDList 解析逗号分隔的 ID 列表
+func parseIDList(s string) []int64 {
+	ids := []int64{}
+	for _, part := range strings.Split(s, ",") {
+		if id, err := strconv.ParseInt(part, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// getTask 在指定查询上下文中读取任务
+func getTask(q queryRower, taskID int64) (map[string]interface{}, error) {
+	task, err := scanTask(q.QueryRow("SELECT "+taskColumns+" FROM tasks WHERE id = ?", taskID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTaskNotFound
+	}
+	return task, err
+}
+
+// GetTask 获取用户的单个任务
+func GetTask(userID int, taskID int64) (map[string]interface{}, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	return getTask(DB, taskID)
+}
+
+// CreateTaskWithFields 创建任务并返回完整记录
+func CreateTaskWithFields(userID int, deviceID, localID string, f TaskFields) (map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	taskID, err := insertTaskTx(tx, userID, deviceID, localID, f)
+	if err != nil {
+		return nil, err
+	}
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return task, tx.Commit()
+}
+
+// insertTaskTx 在事务中插入任务并设置父任务、标签、项目、负责人与重复规则，记录变更，返回任务 ID
+func insertTaskTx(tx *sql.Tx, userID int, deviceID, localID string, f TaskFields) (int64, error) {
+	title := ""
+	description := ""
+	status := "todo"
+	priority := "medium"
+	if f.Title != nil {
+		title = *f.Title
+	}
+	if f.Description != nil {
+		description = *f.Description
+	}
+	if f.Status != nil {
+		status = *f.Status
+	}
+	if f.Priority != nil {
+		priority = *f.Priority
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	result, err := tx.Exec(
+		"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, due_at, created_at, updated_at, is_deleted, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?)",
+		userID, localID, version, title, description, status, priority, dueAtValue(f.DueAt), now, now, now,
+	)
+	if err != nil {
+		return 0, err
+	}
+	taskID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := appendTaskChange(tx, userID, version, taskID, ChangeInsert, deviceID); err != nil {
+		return 0, err
+	}
+	if err := recordActivityTx(tx, userID, taskID, types.ActivityCreated, nil); err != nil {
+		return 0, err
+	}
+	if f.ParentID != nil && *f.ParentID != 0 {
+		if err := setTaskParentTx(tx, userID, deviceID, taskID, *f.ParentID, f.Position); err != nil {
+			return 0, err
+		}
+	}
+	if f.Tags != nil {
+		if err := setTaskTagsTx(tx, userID, taskID, *f.Tags); err != nil {
+			return 0, err
+		}
+	}
+	if f.ProjectID != nil && *f.ProjectID != 0 {
+		if err := setTaskProjectTx(tx, userID, deviceID, taskID, *f.ProjectID, f.ProjectRank); err != nil {
+			return 0, err
+		}
+	}
+	if f.AssigneeID != nil {
+		if err := setTaskAssigneeTx(tx, userID, taskID, *f.AssigneeID); err != nil {
+			return 0, err
+		}
+	}
+	if f.RecurrenceRule != nil {
+		if err := setTaskRecurrenceTx(tx, userID, deviceID, taskID, *f.RecurrenceRule); err != nil {
+			return 0, err
+		}
+		if status == "done" {
+			if _, err := spawnNextOccurrence(tx, userID, deviceID, taskID); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return taskID, nil
+}
+
+// UpdateTaskFields 部分更新任务并分配新版本号
+// expectedVersion 大于 0 时启用乐观锁检查
+func UpdateTaskFields(userID int, deviceID string, taskID int64, f TaskFields, expectedVersion int) (map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	task, err := updateTaskFieldsTx(tx, userID, deviceID, taskID, f, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	return task, tx.Commit()
+}
+
+// updateTaskFieldsTx 在事务中部分更新任务、记录变更并返回更新后的记录
+func updateTaskFieldsTx(tx *sql.Tx, userID int, deviceID string, taskID int64, f TaskFields, expectedVersion int) (map[string]interface{}, error) {
+	currentVersion, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion > 0 && expectedVersion != currentVersion {
+		return nil, &VersionConflictError{Current: currentVersion}
+	}
+	var blockers []int64
+	if f.Status != nil {
+		if blockers, err = checkBlockersTx(tx, taskID, *f.Status); err != nil {
+			return nil, err
+		}
+	}
+
+	sets := ""
+	args := []interface{}{}
+	if f.Title != nil {
+		sets += "title = ?, "
+		args = append(args, *f.Title)
+	}
+	if f.Description != nil {
+		sets += "description = ?, "
+		args = append(args, *f.Description)
+	}
+	if f.Status != nil {
+		if err := recordStatusChangeTx(tx, userID, taskID, *f.Status); err != nil {
+			return nil, err
+		}
+		sets += "status = ?, "
+		args = append(args, *f.Status)
+	}
+	if f.Priority != nil {
+		sets += "priority = ?, "
+		args = append(args, *f.Priority)
+	}
+	if f.DueAt != nil {
+		sets += "due_at = ?, "
+		args = append(args, dueAtValue(f.DueAt))
+	}
+
+	version, err := logTaskChange(tx, userID, taskID, ChangeUpdate, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	args = append(args, version, now, now, taskID)
+	if _, err := tx.Exec("UPDATE tasks SET "+sets+"server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?", args...); err != nil {
+		return nil, err
+	}
+	if f.ParentID != nil || f.Position != nil {
+		parentID := int64(0)
+		if f.ParentID != nil {
+			parentID = *f.ParentID
+		} else if parentID, err = taskParentID(tx, taskID); err != nil {
+			return nil, err
+		}
+		if err := setTaskParentTx(tx, userID, deviceID, taskID, parentID, f.Position); err != nil {
+			return nil, err
+		}
+	}
+	if f.RecurrenceRule != nil {
+		if err := setTaskRecurrenceTx(tx, userID, deviceID, taskID, *f.RecurrenceRule); err != nil {
+			return nil, err
+		}
+	}
+	if f.Tags != nil {
+		if err := setTaskTagsTx(tx, userID, taskID, *f.Tags); err != nil {
+			return nil, err
+		}
+	}
+	if f.ProjectID != nil || f.ProjectRank != nil {
+		projectID := int64(0)
+		if f.ProjectID != nil {
+			projectID = *f.ProjectID
+		} else if projectID, _, err = taskProject(tx, taskID); err != nil {
+			return nil, err
+		}
+		if err := setTaskProjectTx(tx, userID, deviceID, taskID, projectID, f.ProjectRank); err != nil {
+			return nil, err
+		}
+	}
+	if f.AssigneeID != nil {
+		if err := setTaskAssigneeTx(tx, userID, taskID, *f.AssigneeID); err != nil {
+			return nil, err
+		}
+	}
+	if f.Status != nil && *f.Status == "done" {
+		if _, err := spawnNextOccurrence(tx, userID, deviceID, taskID); err != nil {
+			return nil, err
+		}
+	}
+
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if len(blockers) > 0 {
+		// 仅警告模式下允许变更，提示仍未完成的前置任务
+		task["open_blockers"] = blockers
+		task["warning"] = (&BlockedError{Blockers: blockers}).Error()
+	}
+	return task, nil
+}
+
+// touchTasks 为内容未变但对外表示发生变化的任务（如排序位置、所属序列）分配新版本号
+func touchTasks(tx *sql.Tx, userID int, deviceID string, taskIDs []int64) error {
+	now := time.Now().UTC()
+	for _, id := range taskIDs {
+		version, err := logTaskChange(tx, userID, id, ChangeUpdate, deviceID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?",
+			version, now, now, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SoftDeleteTask 软删除单个任务及其全部子任务，分配新版本号并保存撤销快照，返回连带删除的子任务数
+func SoftDeleteTask(userID int, deviceID string, taskID int64) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	if err := softDeleteTaskTx(tx, userID, deviceID, taskID, now); err != nil {
+		return 0, err
+	}
+	count, err := deleteDescendantsTx(tx, userID, deviceID, []int64{taskID}, now)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// softDeleteTaskTx 在事务中软删除任务并保存撤销快照
+func softDeleteTaskTx(tx *sql.Tx, userID int, deviceID string, taskID int64, now time.Time) error {
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return err
+	}
+	taskJSON, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+
+	version, err := logTaskChange(tx, userID, taskID, ChangeDelete, deviceID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("UPDATE tasks SET is_deleted = 1, deleted_at = ?, server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?",
+		now, version, now, now, taskID); err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO deleted_tasks (task_id, user_id, task_data, deleted_at) VALUES (?, ?, ?, ?)",
+		taskID, userID, string(taskJSON), now)
+	return err
+}
@@ -0,0 +1,400 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+const (
+	// MaxTemplatesPerOwner 每个用户（或全局范围）最多保存的模板数
+	MaxTemplatesPerOwner = 100
+	// MaxTemplateSubtasks 一个模板中子任务骨架的最多节点数（含各层嵌套）
+	MaxTemplateSubtasks = 100
+)
+
+var (
+	// ErrTemplateNotFound 模板不存在、已删除或对当前用户不可见
+	ErrTemplateNotFound = errors.New("模板不存在")
+	// ErrTemplateForbidden 全局模板只能由管理员修改
+	ErrTemplateForbidden = errors.New("全局模板只能由管理员修改")
+	// ErrTemplateLimit 模板数已达上限
+	ErrTemplateLimit = errors.New("模板数量已达上限")
+)
+
+// TemplateFields 模板可写字段，nil 表示不修改；调用方已校验并规范化
+type TemplateFields struct {
+	Name        *string
+	Title       *string // 创建时缺省为模板名称
+	Description *string
+	Priority    *string
+	Tags        *[]string
+	DueOffset   *string
+	Subtasks    *[]*types.TemplateSubtask // 整体替换
+}
+
+// InstantiateOptions 实例化模板的参数
+type InstantiateOptions struct {
+	LocalID   string    // 根任务的本地 ID，子任务依次追加 -1、-2…
+	Title     *string   // 覆盖模板中的任务标题
+	Anchor    time.Time // 截止时间偏移的基准时刻
+	ProjectID int64     // 大于 0 时全部任务放入该项目
+	ParentID  int64     // 大于 0 时根任务作为该任务的子任务
+}
+
+// templateColumns 模板查询的标准列，与 scanTemplate 的扫描顺序一致
+const templateColumns = "id, user_id, name, title, description, priority, tags, due_offset, subtasks, created_by, created_at, updated_at"
+
+// ValidDueOffset 判断截止时间偏移是否有效：空字符串表示不设截止时间，否则为 +2d、-4h、+1w 这样的相对偏移
+func ValidDueOffset(offset string) bool {
+	if offset == "" {
+		return true
+	}
+	_, ok := applyDueOffset(offset, time.Time{})
+	return ok
+}
+
+// applyDueOffset 计算 anchor 加上偏移后的截止时间，不接受 now 与 today
+func applyDueOffset(offset string, anchor time.Time) (time.Time, bool) {
+	if offset == "now" || offset == "today" {
+		return time.Time{}, false
+	}
+	return parseRelativeTime(offset, anchor)
+}
+
+// templateOwnerClause 按模板所属用户过滤的条件，ownerID 为 0 表示全局模板
+func templateOwnerClause(ownerID int) (string, []interface{}) {
+	if ownerID == 0 {
+		return "user_id IS NULL", nil
+	}
+	return "user_id = ?", []interface{}{ownerID}
+}
+
+// scanTemplate 扫描一行模板记录
+func scanTemplate(s rowScanner) (*types.TaskTemplate, error) {
+	t := &types.TaskTemplate{}
+	var ownerID sql.NullInt64
+	var tags, subtasks string
+	var createdAt, updatedAt sql.NullTime
+	if err := s.Scan(&t.ID, &ownerID, &t.Name, &t.Title, &t.Description, &t.Priority, &tags, &t.DueOffset, &subtasks,
+		&t.CreatedBy, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	t.Scope = types.TemplateScopeUser
+	if !ownerID.Valid {
+		t.Scope = types.TemplateScopeGlobal
+	}
+	t.Tags = []string{}
+	if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+		return nil, err
+	}
+	t.Subtasks = []*types.TemplateSubtask{}
+	if err := json.Unmarshal([]byte(subtasks), &t.Subtasks); err != nil {
+		return nil, err
+	}
+	t.CreatedAt = formatDueAt(createdAt)
+	t.UpdatedAt = formatDueAt(updatedAt)
+	return t, nil
+}
+
+// queryTemplates 执行返回模板列表的查询
+func queryTemplates(q Querier, query string, args ...interface{}) ([]*types.TaskTemplate, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*types.TaskTemplate{}
+	for rows.Next() {
+		t, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+	return templates, rows.Err()
+}
+
+// getVisibleTemplateTx 获取用户可见的未删除模板（自己的模板或全局模板）
+func getVisibleTemplateTx(q Querier, userID int, templateID int64) (*types.TaskTemplate, error) {
+	t, err := scanTemplate(q.QueryRow("SELECT "+templateColumns+" FROM task_templates WHERE id = ? AND (user_id = ? OR user_id IS NULL) AND is_deleted = 0",
+		templateID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	return t, err
+}
+
+// getOwnedTemplateTx 获取属于 ownerID 的未删除模板，ownerID 为 0 表示全局模板
+// 用户访问全局模板时返回 ErrTemplateForbidden
+func getOwnedTemplateTx(q Querier, ownerID int, templateID int64) (*types.TaskTemplate, error) {
+	clause, args := templateOwnerClause(ownerID)
+	t, err := scanTemplate(q.QueryRow("SELECT "+templateColumns+" FROM task_templates WHERE id = ? AND "+clause+" AND is_deleted = 0",
+		append([]interface{}{templateID}, args...)...))
+	if err != sql.ErrNoRows {
+		return t, err
+	}
+	if ownerID != 0 {
+		if _, err := getVisibleTemplateTx(q, ownerID, templateID); err == nil {
+			return nil, ErrTemplateForbidden
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// ListTemplates 获取用户可见的模板：先是自己的模板，再是全局模板，各自按名称排序
+func ListTemplates(userID int) ([]*types.TaskTemplate, error) {
+	return queryTemplates(DB, "SELECT "+templateColumns+" FROM task_templates WHERE (user_id = ? OR user_id IS NULL) AND is_deleted = 0 ORDER BY user_id IS NULL, name, id", userID)
+}
+
+// ListGlobalTemplates 获取全部全局模板
+func ListGlobalTemplates() ([]*types.TaskTemplate, error) {
+	return queryTemplates(DB, "SELECT "+templateColumns+" FROM task_templates WHERE user_id IS NULL AND is_deleted = 0 ORDER BY name, id")
+}
+
+// GetTemplate 获取用户可见的单个模板
+func GetTemplate(userID int, templateID int64) (*types.TaskTemplate, error) {
+	return getVisibleTemplateTx(DB, userID, templateID)
+}
+
+// GetGlobalTemplate 获取单个全局模板
+func GetGlobalTemplate(templateID int64) (*types.TaskTemplate, error) {
+	return getOwnedTemplateTx(DB, 0, templateID)
+}
+
+// CreateTemplate 创建模板并返回完整记录，ownerID 为 0 表示全局模板，createdBy 为创建者
+func CreateTemplate(ownerID, createdBy int, f TemplateFields) (*types.TaskTemplate, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	clause, args := templateOwnerClause(ownerID)
+	var existing int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM task_templates WHERE "+clause+" AND is_deleted = 0", args...).Scan(&existing); err != nil {
+		return nil, err
+	}
+	if existing >= MaxTemplatesPerOwner {
+		return nil, ErrTemplateLimit
+	}
+
+	name, description, priority, dueOffset := "", "", string(types.PriorityMedium), ""
+	if f.Name != nil {
+		name = *f.Name
+	}
+	title := name
+	if f.Title != nil && *f.Title != "" {
+		title = *f.Title
+	}
+	if f.Description != nil {
+		description = *f.Description
+	}
+	if f.Priority != nil && *f.Priority != "" {
+		priority = *f.Priority
+	}
+	if f.DueOffset != nil {
+		dueOffset = *f.DueOffset
+	}
+	tags := []string{}
+	if f.Tags != nil {
+		tags = *f.Tags
+	}
+	subtasks := []*types.TemplateSubtask{}
+	if f.Subtasks != nil {
+		subtasks = *f.Subtasks
+	}
+	encodedTags, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	encodedSubtasks, err := json.Marshal(subtasks)
+	if err != nil {
+		return nil, err
+	}
+
+	var owner interface{}
+	if ownerID != 0 {
+		owner = ownerID
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO task_templates (user_id, name, title, description, priority, tags, due_offset, subtasks, created_by, is_deleted, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, ?)",
+		owner, name, title, description, priority, string(encodedTags), dueOffset, string(encodedSubtasks), createdBy, now, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	t, err := getOwnedTemplateTx(tx, ownerID, id)
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+// UpdateTemplate 部分更新属于 ownerID 的模板，ownerID 为 0 表示全局模板
+func UpdateTemplate(ownerID int, templateID int64, f TemplateFields) (*types.TaskTemplate, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := getOwnedTemplateTx(tx, ownerID, templateID); err != nil {
+		return nil, err
+	}
+
+	sets := ""
+	args := []interface{}{}
+	if f.Name != nil {
+		sets += "name = ?, "
+		args = append(args, *f.Name)
+	}
+	if f.Title != nil && *f.Title != "" {
+		sets += "title = ?, "
+		args = append(args, *f.Title)
+	}
+	if f.Description != nil {
+		sets += "description = ?, "
+		args = append(args, *f.Description)
+	}
+	if f.Priority != nil && *f.Priority != "" {
+		sets += "priority = ?, "
+		args = append(args, *f.Priority)
+	}
+	if f.Tags != nil {
+		encoded, err := json.Marshal(*f.Tags)
+		if err != nil {
+			return nil, err
+		}
+		sets += "tags = ?, "
+		args = append(args, string(encoded))
+	}
+	if f.DueOffset != nil {
+		sets += "due_offset = ?, "
+		args = append(args, *f.DueOffset)
+	}
+	if f.Subtasks != nil {
+		subtasks := *f.Subtasks
+		if subtasks == nil {
+			subtasks = []*types.TemplateSubtask{}
+		}
+		encoded, err := json.Marshal(subtasks)
+		if err != nil {
+			return nil, err
+		}
+		sets += "subtasks = ?, "
+		args = append(args, string(encoded))
+	}
+	args = append(args, time.Now().UTC(), templateID)
+	if _, err := tx.Exec("UPDATE task_templates SET "+sets+"updated_at = ? WHERE id = ?", args...); err != nil {
+		return nil, err
+	}
+
+	t, err := getOwnedTemplateTx(tx, ownerID, templateID)
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+// DeleteTemplate 软删除属于 ownerID 的模板，ownerID 为 0 表示全局模板；已实例化的任务不受影响
+func DeleteTemplate(ownerID int, templateID int64) error {
+	if _, err := getOwnedTemplateTx(DB, ownerID, templateID); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err := DB.Exec("UPDATE task_templates SET is_deleted = 1, deleted_at = ?, updated_at = ? WHERE id = ?", now, now, templateID)
+	return err
+}
+
+// InstantiateTemplate 在一个事务中按模板创建任务及其子任务骨架，返回创建的任务（根任务在前，按先序排列）
+// 截止时间为基准时刻加上各自的偏移；任何一个任务创建失败（如超过最大层数、无项目权限）时全部回滚
+func InstantiateTemplate(userID int, deviceID string, templateID int64, opts InstantiateOptions) ([]map[string]interface{}, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	t, err := getVisibleTemplateTx(tx, userID, templateID)
+	if err != nil {
+		return nil, err
+	}
+
+	title := t.Title
+	if opts.Title != nil {
+		title = *opts.Title
+	}
+	root := &types.TemplateSubtask{
+		Title:       title,
+		Description: t.Description,
+		Priority:    t.Priority,
+		Tags:        t.Tags,
+		DueOffset:   t.DueOffset,
+		Subtasks:    t.Subtasks,
+	}
+
+	var ids []int64
+	var create func(item *types.TemplateSubtask, parentID int64) error
+	create = func(item *types.TemplateSubtask, parentID int64) error {
+		localID := opts.LocalID
+		if len(ids) > 0 {
+			localID = fmt.Sprintf("%s-%d", opts.LocalID, len(ids))
+		}
+		f := TaskFields{Title: &item.Title, Description: &item.Description}
+		if len(item.Tags) > 0 {
+			f.Tags = &item.Tags
+		}
+		if item.Priority != "" {
+			f.Priority = &item.Priority
+		}
+		if item.DueOffset != "" {
+			dueAt, ok := applyDueOffset(item.DueOffset, opts.Anchor)
+			if !ok {
+				return fmt.Errorf("模板 %d 的截止时间偏移无效: %s", templateID, item.DueOffset)
+			}
+			dueAt = dueAt.UTC()
+			f.DueAt = &dueAt
+		}
+		if parentID != 0 {
+			f.ParentID = &parentID
+		}
+		if opts.ProjectID != 0 {
+			f.ProjectID = &opts.ProjectID
+		}
+		taskID, err := insertTaskTx(tx, userID, deviceID, localID, f)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, taskID)
+		for _, child := range item.Subtasks {
+			if err := create(child, taskID); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := create(root, opts.ParentID); err != nil {
+		return nil, err
+	}
+
+	tasks := make([]map[string]interface{}, 0, len(ids))
+	for _, id := range ids {
+		task, err := getTask(tx, id)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, tx.Commit()
+}
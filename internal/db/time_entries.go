@@ -0,0 +1,643 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+var (
+	// ErrTimeEntryNotFound 工时记录不存在、已删除或不属于当前用户
+	ErrTimeEntryNotFound = errors.New("工时记录不存在")
+	// ErrTimerNotRunning 用户没有正在运行的计时器
+	ErrTimerNotRunning = errors.New("没有正在运行的计时器")
+	// ErrInvalidTimeRange 结束时间早于开始时间
+	ErrInvalidTimeRange = errors.New("结束时间不能早于开始时间")
+)
+
+// RunningTimerError 用户已有正在运行的计时器（每个用户最多一个）
+type RunningTimerError struct {
+	Entry *types.TimeEntry // 并发启动时可能为 nil
+}
+
+func (e *RunningTimerError) Error() string {
+	if e.Entry == nil {
+		return "已有正在运行的计时器"
+	}
+	return fmt.Sprintf("已有正在运行的计时器（任务 %d）", e.Entry.TaskID)
+}
+
+// 工时汇总的分组方式
+const (
+	TimeGroupTask    = "task"
+	TimeGroupProject = "project"
+	TimeGroupDay     = "day"
+)
+
+// TimeEntryFields 工时记录可写字段，nil 表示未提供
+type TimeEntryFields struct {
+	StartedAt *time.Time
+	EndedAt   *time.Time // 零值表示计时器仍在运行；插入时 nil 同样表示仍在运行
+	Note      *string
+}
+
+// TimeEntryFilter 工时记录的查询条件，零值表示不限
+// 时间范围按记录与 [From, To) 是否重叠判断，运行中的计时器视为持续到当前时刻
+type TimeEntryFilter struct {
+	From      time.Time
+	To        time.Time
+	TaskID    int64
+	ProjectID int64
+	// AllUsers 为 true 时包括其他用户在当前用户可查看的任务上的记录，否则只包括自己的记录
+	AllUsers bool
+}
+
+// timeEntryColumns 工时记录查询的标准列，与 scanTimeEntry 的扫描顺序一致
+const timeEntryColumns = "time_entries.id, time_entries.local_id, time_entries.server_version, time_entries.task_id, time_entries.user_id, " +
+	"time_entries.started_at, time_entries.ended_at, time_entries.note, time_entries.is_deleted, time_entries.created_at, time_entries.updated_at"
+
+// scanTimeEntry 扫描一行工时记录，运行中的计时器的时长计到当前时刻
+func scanTimeEntry(s rowScanner) (*types.TimeEntry, error) {
+	e := &types.TimeEntry{}
+	var localID sql.NullString
+	var startedAt, endedAt, createdAt, updatedAt sql.NullTime
+	if err := s.Scan(&e.ID, &localID, &e.ServerVersion, &e.TaskID, &e.UserID, &startedAt, &endedAt, &e.Note, &e.IsDeleted,
+		&createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	e.LocalID = localID.String
+	e.StartedAt = formatDueAt(startedAt)
+	e.EndedAt = formatDueAt(endedAt)
+	e.Running = !endedAt.Valid
+	end := time.Now()
+	if endedAt.Valid {
+		end = endedAt.Time
+	}
+	if d := end.Sub(startedAt.Time); d > 0 {
+		e.DurationSeconds = int64(d / time.Second)
+	}
+	e.CreatedAt = formatDueAt(createdAt)
+	e.UpdatedAt = formatDueAt(updatedAt)
+	return e, nil
+}
+
+// queryTimeEntries 执行返回工时记录列表的查询
+func queryTimeEntries(q Querier, query string, args ...interface{}) ([]*types.TimeEntry, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*types.TimeEntry{}
+	for rows.Next() {
+		e, err := scanTimeEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// getTimeEntryTx 获取用户自己的未删除工时记录
+func getTimeEntryTx(q Querier, userID int, entryID int64) (*types.TimeEntry, error) {
+	e, err := scanTimeEntry(q.QueryRow("SELECT "+timeEntryColumns+" FROM time_entries WHERE id = ? AND user_id = ? AND is_deleted = 0", entryID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrTimeEntryNotFound
+	}
+	return e, err
+}
+
+// runningTimerTx 获取用户正在运行的计时器，没有时返回 nil
+func runningTimerTx(q Querier, userID int) (*types.TimeEntry, error) {
+	e, err := scanTimeEntry(q.QueryRow("SELECT "+timeEntryColumns+" FROM time_entries WHERE user_id = ? AND ended_at IS NULL AND is_deleted = 0", userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return e, err
+}
+
+// GetTimeEntry 获取用户自己的工时记录
+func GetTimeEntry(userID int, entryID int64) (*types.TimeEntry, error) {
+	return getTimeEntryTx(DB, userID, entryID)
+}
+
+// GetRunningTimer 获取用户正在运行的计时器，没有时返回 nil
+func GetRunningTimer(userID int) (*types.TimeEntry, error) {
+	return runningTimerTx(DB, userID)
+}
+
+// entryTime 把工时记录中的时间字符串还原为时间，空字符串为零值
+func entryTime(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+// StartTimer 在任务上启动计时器，需要对任务有编辑权限
+// 已有正在运行的计时器时，stopRunning 为 true 则先停止它（在同一事务中），否则返回 RunningTimerError
+// 返回新的计时器与被停止的计时器（没有时为 nil）
+func StartTimer(userID int, deviceID, localID string, taskID int64, note string, stopRunning bool) (*types.TimeEntry, *types.TimeEntry, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	running, err := runningTimerTx(tx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var stopped *types.TimeEntry
+	if running != nil {
+		if !stopRunning {
+			return nil, nil, &RunningTimerError{Entry: running}
+		}
+		// 新计时器无法启动时事务回滚，原计时器保持运行
+		if _, err := updateTimeEntryTx(tx, userID, deviceID, running.ID, TimeEntryFields{EndedAt: &now}); err != nil {
+			return nil, nil, err
+		}
+		if stopped, err = getTimeEntryTx(tx, userID, running.ID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	id, _, err := insertTimeEntryTx(tx, userID, deviceID, localID, taskID, TimeEntryFields{StartedAt: &now, Note: &note})
+	if err != nil {
+		return nil, nil, err
+	}
+	started, err := getTimeEntryTx(tx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return started, stopped, tx.Commit()
+}
+
+// StopTimer 停止用户正在运行的计时器
+func StopTimer(userID int, deviceID string) (*types.TimeEntry, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	running, err := runningTimerTx(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if running == nil {
+		return nil, ErrTimerNotRunning
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	if _, err := updateTimeEntryTx(tx, userID, deviceID, running.ID, TimeEntryFields{EndedAt: &now}); err != nil {
+		return nil, err
+	}
+	stopped, err := getTimeEntryTx(tx, userID, running.ID)
+	if err != nil {
+		return nil, err
+	}
+	return stopped, tx.Commit()
+}
+
+// CreateTimeEntry 手动补录一条工时记录，需要对任务有编辑权限
+func CreateTimeEntry(userID int, deviceID, localID string, taskID int64, f TimeEntryFields) (*types.TimeEntry, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, _, err := insertTimeEntryTx(tx, userID, deviceID, localID, taskID, f)
+	if err != nil {
+		return nil, err
+	}
+	e, err := getTimeEntryTx(tx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return e, tx.Commit()
+}
+
+// insertTimeEntryTx 检查任务权限、时间范围与运行中的计时器后插入工时记录并记录变更，返回记录 ID 与分配的版本号
+// f.StartedAt 为 nil 时从当前时刻开始，f.EndedAt 为 nil 或零值时记录为正在运行的计时器
+func insertTimeEntryTx(tx *sql.Tx, userID int, deviceID, localID string, taskID int64, f TimeEntryFields) (int64, int, error) {
+	if _, err := checkTaskAccess(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		return 0, 0, err
+	}
+	now := time.Now().UTC()
+	startedAt := now.Truncate(time.Second)
+	if f.StartedAt != nil {
+		startedAt = f.StartedAt.UTC().Truncate(time.Second)
+	}
+	var endedAt interface{}
+	if f.EndedAt != nil && !f.EndedAt.IsZero() {
+		end := f.EndedAt.UTC().Truncate(time.Second)
+		if end.Before(startedAt) {
+			return 0, 0, ErrInvalidTimeRange
+		}
+		endedAt = end
+	} else {
+		running, err := runningTimerTx(tx, userID)
+		if err != nil {
+			return 0, 0, err
+		}
+		if running != nil {
+			return 0, 0, &RunningTimerError{Entry: running}
+		}
+	}
+	note := ""
+	if f.Note != nil {
+		note = *f.Note
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := tx.Exec(
+		"INSERT INTO time_entries (user_id, task_id, local_id, server_version, started_at, ended_at, note, is_deleted, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?)",
+		userID, taskID, localID, version, startedAt, endedAt, note, now, now,
+	)
+	if err != nil {
+		// 并发启动的计时器违反每个用户只有一个运行中计时器的唯一索引
+		if endedAt == nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, 0, &RunningTimerError{}
+		}
+		return 0, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, version, appendChange(tx, userID, userID, version, EntityTimeEntry, id, ChangeInsert, deviceID)
+}
+
+// UpdateTimeEntry 修改用户自己的工时记录并分配新版本号
+func UpdateTimeEntry(userID int, deviceID string, entryID int64, f TimeEntryFields) (*types.TimeEntry, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := updateTimeEntryTx(tx, userID, deviceID, entryID, f); err != nil {
+		return nil, err
+	}
+	e, err := getTimeEntryTx(tx, userID, entryID)
+	if err != nil {
+		return nil, err
+	}
+	return e, tx.Commit()
+}
+
+// updateTimeEntryTx 在事务中修改工时记录、记录变更，返回分配的版本号
+// 为运行中的计时器设置结束时间即停止它；清除已结束记录的结束时间会使其重新运行，此时同样检查运行中的计时器
+func updateTimeEntryTx(tx *sql.Tx, userID int, deviceID string, entryID int64, f TimeEntryFields) (int, error) {
+	cur, err := getTimeEntryTx(tx, userID, entryID)
+	if err != nil {
+		return 0, err
+	}
+
+	startedAt := entryTime(cur.StartedAt)
+	if f.StartedAt != nil {
+		startedAt = f.StartedAt.UTC().Truncate(time.Second)
+	}
+	endedAt := entryTime(cur.EndedAt)
+	if f.EndedAt != nil {
+		endedAt = time.Time{}
+		if !f.EndedAt.IsZero() {
+			endedAt = f.EndedAt.UTC().Truncate(time.Second)
+		}
+	}
+	var ended interface{}
+	if !endedAt.IsZero() {
+		if endedAt.Before(startedAt) {
+			return 0, ErrInvalidTimeRange
+		}
+		ended = endedAt
+	} else if !cur.Running {
+		running, err := runningTimerTx(tx, userID)
+		if err != nil {
+			return 0, err
+		}
+		if running != nil {
+			return 0, &RunningTimerError{Entry: running}
+		}
+	}
+	note := cur.Note
+	if f.Note != nil {
+		note = *f.Note
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, userID, userID, version, EntityTimeEntry, entryID, ChangeUpdate, deviceID); err != nil {
+		return 0, err
+	}
+	_, err = tx.Exec("UPDATE time_entries SET started_at = ?, ended_at = ?, note = ?, server_version = ?, updated_at = ? WHERE id = ?",
+		startedAt, ended, note, version, time.Now().UTC(), entryID)
+	if err != nil && ended == nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return 0, &RunningTimerError{}
+	}
+	return version, err
+}
+
+// DeleteTimeEntry 删除用户自己的工时记录
+func DeleteTimeEntry(userID int, deviceID string, entryID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := deleteTimeEntryTx(tx, userID, deviceID, entryID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteTimeEntryTx 软删除工时记录（保留墓碑供同步下发），返回分配的版本号
+func deleteTimeEntryTx(tx *sql.Tx, userID int, deviceID string, entryID int64) (int, error) {
+	if _, err := getTimeEntryTx(tx, userID, entryID); err != nil {
+		return 0, err
+	}
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, userID, userID, version, EntityTimeEntry, entryID, ChangeDelete, deviceID); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE time_entries SET is_deleted = 1, deleted_at = ?, server_version = ?, updated_at = ? WHERE id = ?",
+		now, version, now, entryID)
+	return version, err
+}
+
+// GetTaskTimeSummary 获取任务上全部用户的工时记录（按开始时间）与合计，需要对任务有查看权限
+func GetTaskTimeSummary(userID int, taskID int64) (*types.TaskTimeSummary, error) {
+	if _, err := checkTaskAccess(DB, userID, taskID, types.ProjectRoleViewer); err != nil {
+		return nil, err
+	}
+	entries, err := queryTimeEntries(DB, "SELECT "+timeEntryColumns+" FROM time_entries WHERE task_id = ? AND is_deleted = 0 ORDER BY started_at, id", taskID)
+	if err != nil {
+		return nil, err
+	}
+	summary := &types.TaskTimeSummary{TaskID: taskID, ByUser: map[int]int64{}, Entries: entries}
+	for _, e := range entries {
+		summary.TotalSeconds += e.DurationSeconds
+		summary.ByUser[e.UserID] += e.DurationSeconds
+	}
+	return summary, nil
+}
+
+// timeEntryWhere 按查询条件生成工时记录的 WHERE 子句，只包括未删除任务上的记录
+func timeEntryWhere(userID int, f TimeEntryFilter) (string, []interface{}) {
+	where := "time_entries.is_deleted = 0"
+	args := []interface{}{}
+	if f.AllUsers {
+		where += " AND time_entries.task_id IN (SELECT id FROM tasks WHERE is_deleted = 0 AND " + taskAccessClause + ")"
+		args = append(args, taskAccessArgs(userID)...)
+	} else {
+		where += " AND time_entries.user_id = ? AND time_entries.task_id IN (SELECT id FROM tasks WHERE is_deleted = 0)"
+		args = append(args, userID)
+	}
+	if f.TaskID > 0 {
+		where += " AND time_entries.task_id = ?"
+		args = append(args, f.TaskID)
+	}
+	if f.ProjectID > 0 {
+		where += " AND time_entries.task_id IN (SELECT id FROM tasks WHERE project_id = ?)"
+		args = append(args, f.ProjectID)
+	}
+	if !f.From.IsZero() {
+		where += " AND (time_entries.ended_at IS NULL OR time_entries.ended_at > ?)"
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		where += " AND time_entries.started_at < ?"
+		args = append(args, f.To.UTC())
+	}
+	return where, args
+}
+
+// ListTimeEntries 分页获取符合条件的工时记录（开始时间倒序）及总数
+func ListTimeEntries(userID int, f TimeEntryFilter, page, pageSize int) ([]*types.TimeEntry, int, error) {
+	where, args := timeEntryWhere(userID, f)
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM time_entries WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	entries, err := queryTimeEntries(DB, "SELECT "+timeEntryColumns+" FROM time_entries WHERE "+where+" ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, pageSize, (page-1)*pageSize)...)
+	return entries, total, err
+}
+
+// TimeTotals 按任务、项目或日期汇总符合条件的工时，返回各组合计与总秒数
+// 记录只计算落在 [From, To) 内的部分，运行中的计时器计到当前时刻；按日期汇总时以 loc 的自然日切分跨天的记录
+// 按任务或项目汇总时按秒数降序，按日期汇总时按日期升序
+func TimeTotals(userID int, f TimeEntryFilter, groupBy string, loc *time.Location) ([]types.TimeTotal, int64, error) {
+	where, args := timeEntryWhere(userID, f)
+	rows, err := DB.Query(`SELECT time_entries.task_id, time_entries.started_at, time_entries.ended_at, tasks.title, tasks.project_id, COALESCE(projects.name, '')
+		FROM time_entries JOIN tasks ON tasks.id = time_entries.task_id LEFT JOIN projects ON projects.id = tasks.project_id
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	now := time.Now().UTC()
+	groups := map[string]*types.TimeTotal{}
+	var total int64
+	add := func(key, name string, seconds int64) {
+		g := groups[key]
+		if g == nil {
+			g = &types.TimeTotal{Key: key, Name: name}
+			groups[key] = g
+		}
+		g.Seconds += seconds
+		g.Entries++
+		total += seconds
+	}
+	for rows.Next() {
+		var taskID int64
+		var startedAt, endedAt sql.NullTime
+		var title, projectName string
+		var projectID sql.NullInt64
+		if err := rows.Scan(&taskID, &startedAt, &endedAt, &title, &projectID, &projectName); err != nil {
+			return nil, 0, err
+		}
+		start, end := startedAt.Time.UTC(), now
+		if endedAt.Valid {
+			end = endedAt.Time.UTC()
+		}
+		if !f.From.IsZero() && start.Before(f.From) {
+			start = f.From.UTC()
+		}
+		if !f.To.IsZero() && end.After(f.To) {
+			end = f.To.UTC()
+		}
+		if end.Before(start) {
+			end = start
+		}
+
+		switch groupBy {
+		case TimeGroupTask:
+			add(strconv.FormatInt(taskID, 10), title, int64(end.Sub(start)/time.Second))
+		case TimeGroupProject:
+			key := "none"
+			if projectID.Valid {
+				key = strconv.FormatInt(projectID.Int64, 10)
+			}
+			add(key, projectName, int64(end.Sub(start)/time.Second))
+		default:
+			for day := start.In(loc); ; {
+				next := time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+				stop := end
+				if next.Before(end) {
+					stop = next
+				}
+				add(day.Format("2006-01-02"), "", int64(stop.Sub(day)/time.Second))
+				if !next.Before(end) {
+					break
+				}
+				day = next
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	totals := make([]types.TimeTotal, 0, len(groups))
+	for _, g := range groups {
+		totals = append(totals, *g)
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if groupBy == TimeGroupDay || totals[i].Seconds == totals[j].Seconds {
+			return totals[i].Key < totals[j].Key
+		}
+		return totals[i].Seconds > totals[j].Seconds
+	})
+	return totals, total, nil
+}
+
+// timeEntryChangeColumns 增量拉取的列，顺序与 timeEntryColumns 一致；记录行已不存在时非空列取默认值，由调用方替换为墓碑
+const timeEntryChangeColumns = "c.task_id, time_entries.local_id, COALESCE(time_entries.server_version, c.last_seq), COALESCE(time_entries.task_id, 0), COALESCE(time_entries.user_id, 0), " +
+	"time_entries.started_at, time_entries.ended_at, COALESCE(time_entries.note, ''), COALESCE(time_entries.is_deleted, 1), time_entries.created_at, time_entries.updated_at"
+
+// GetTimeEntryChangesSince 获取用户在 sinceSeq 之后变更过的工时记录（含已删除或已不存在记录的墓碑），按序号升序
+// 同时返回这些变更中最大的序号；sinceSeq 为 0 表示全量拉取，此时只返回未删除的记录
+// untilSeq 大于 0 时只返回序号不超过 untilSeq 的变更
+func GetTimeEntryChangesSince(q Querier, userID int, sinceSeq, untilSeq int) ([]*types.TimeEntry, int, error) {
+	if sinceSeq == 0 {
+		entries, err := queryTimeEntries(q, "SELECT "+timeEntryColumns+" FROM time_entries WHERE user_id = ? AND is_deleted = 0 ORDER BY server_version, id", userID)
+		return entries, 0, err
+	}
+
+	query := "SELECT " + timeEntryChangeColumns + ", c.last_seq, time_entries.id IS NULL FROM (SELECT task_id, MAX(seq) AS last_seq FROM change_log WHERE user_id = ? AND seq > ? AND entity_type = 'time_entry'"
+	args := []interface{}{userID, sinceSeq}
+	if untilSeq > 0 {
+		query += " AND seq <= ?"
+		args = append(args, untilSeq)
+	}
+	rows, err := q.Query(query+" GROUP BY task_id) c LEFT JOIN time_entries ON time_entries.id = c.task_id ORDER BY c.last_seq", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*types.TimeEntry{}
+	lastSeq := 0
+	for rows.Next() {
+		var missing bool
+		e, err := scanTimeEntry(extraScanner{rows, []interface{}{&lastSeq, &missing}})
+		if err != nil {
+			return nil, 0, err
+		}
+		if missing {
+			e = &types.TimeEntry{ID: e.ID, ServerVersion: int64(lastSeq), UserID: userID, IsDeleted: true}
+		}
+		entries = append(entries, e)
+	}
+	return entries, lastSeq, rows.Err()
+}
+
+// TimeEntryIDByLocalID 按客户端本地 ID 查找用户未删除的工时记录
+func TimeEntryIDByLocalID(tx *sql.Tx, userID int, localID string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM time_entries WHERE local_id = ? AND user_id = ? AND is_deleted = 0 ORDER BY id DESC LIMIT 1", localID, userID).Scan(&id)
+	return id, err
+}
+
+// InsertSyncTimeEntry 插入客户端同步上来的工时记录并记录变更，返回记录 ID 与分配的版本号
+func InsertSyncTimeEntry(tx *sql.Tx, userID int, deviceID, localID string, taskID int64, f TimeEntryFields) (int64, int, error) {
+	return insertTimeEntryTx(tx, userID, deviceID, localID, taskID, f)
+}
+
+// UpdateSyncTimeEntry 在同步事务中修改工时记录，返回分配的版本号
+func UpdateSyncTimeEntry(tx *sql.Tx, userID int, deviceID string, entryID int64, f TimeEntryFields) (int, error) {
+	return updateTimeEntryTx(tx, userID, deviceID, entryID, f)
+}
+
+// DeleteSyncTimeEntry 在同步事务中删除工时记录，返回分配的版本号
+func DeleteSyncTimeEntry(tx *sql.Tx, userID int, deviceID string, entryID int64) (int, error) {
+	return deleteTimeEntryTx(tx, userID, deviceID, entryID)
+}
+
+// GetTimeEntriesStreaming 分批读取用户在未删除任务上的全部工时记录（按开始时间）用于导出，附带任务标题
+// projectID 大于 0 时只包括该项目中的任务
+func GetTimeEntriesStreaming(userID int, projectID int64, batchSize int, processFunc func([]map[string]interface{}) error) error {
+	where, args := timeEntryWhere(userID, TimeEntryFilter{ProjectID: projectID})
+	for offset := 0; ; offset += batchSize {
+		rows, err := DB.Query("SELECT "+timeEntryColumns+", tasks.title FROM time_entries JOIN tasks ON tasks.id = time_entries.task_id WHERE "+where+
+			" ORDER BY time_entries.started_at, time_entries.id LIMIT ? OFFSET ?", append(args, batchSize, offset)...)
+		if err != nil {
+			return err
+		}
+		batch := []map[string]interface{}{}
+		for rows.Next() {
+			var title string
+			e, err := scanTimeEntry(extraScanner{rows, []interface{}{&title}})
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, map[string]interface{}{
+				"id":               e.ID,
+				"local_id":         e.LocalID,
+				"server_version":   e.ServerVersion,
+				"task_id":          e.TaskID,
+				"task_title":       title,
+				"started_at":       e.StartedAt,
+				"ended_at":         e.EndedAt,
+				"duration_seconds": e.DurationSeconds,
+				"note":             e.Note,
+				"created_at":       e.CreatedAt,
+				"updated_at":       e.UpdatedAt,
+			})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := processFunc(batch); err != nil {
+			return err
+		}
+		if len(batch) < batchSize {
+			return nil
+		}
+	}
+}
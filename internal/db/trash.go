@@ -0,0 +1,285 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// defaultTrashRetentionDays 未配置 trash_retention_days 时回收站的保留天数
+const defaultTrashRetentionDays = 30
+
+var (
+	// ErrTaskNotInTrash 任务不存在或未被删除
+	ErrTaskNotInTrash = errors.New("任务不在回收站中")
+	// ErrTrashExpired 任务已超过回收站保留期限
+	ErrTrashExpired = errors.New("任务已超过回收站保留期限")
+)
+
+// TrashRetentionDays 读取回收站保留天数（system_config 中的 trash_retention_days）
+func TrashRetentionDays() int {
+	value, found, err := GetConfigValue("trash_retention_days")
+	if err != nil {
+		log.Printf("读取回收站保留期限失败: %v", err)
+		return defaultTrashRetentionDays
+	}
+	days, err := strconv.Atoi(value)
+	if !found || err != nil || days < 1 {
+		return defaultTrashRetentionDays
+	}
+	return days
+}
+
+// trashCutoff 删除时间早于该时刻的任务已超过保留期限
+func trashCutoff() time.Time {
+	return time.Now().UTC().AddDate(0, 0, -TrashRetentionDays())
+}
+
+// GetTrash 按删除时间倒序分页获取回收站中的任务（含共享项目中被删除的任务），附带删除时间与计划清除时间
+func GetTrash(userID int, page, pageSize int) ([]map[string]interface{}, int, error) {
+	var total int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+taskAccessClause+" AND is_deleted = 1", taskAccessArgs(userID)...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(
+		"SELECT "+taskColumns+", deleted_at FROM tasks WHERE "+taskAccessClause+" AND is_deleted = 1 ORDER BY deleted_at DESC, id DESC LIMIT ? OFFSET ?",
+		append(taskAccessArgs(userID), pageSize, (page-1)*pageSize)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	retention := TrashRetentionDays()
+	results := []map[string]interface{}{}
+	for rows.Next() {
+		var deletedAt sql.NullTime
+		task, err := scanTask(extraScanner{rows, []interface{}{&deletedAt}})
+		if err != nil {
+			return nil, 0, err
+		}
+		if deletedAt.Valid {
+			task["deleted_at"] = deletedAt.Time.UTC().Format(time.RFC3339)
+			task["purge_at"] = deletedAt.Time.UTC().AddDate(0, 0, retention).Format(time.RFC3339)
+		}
+		results = append(results, task)
+	}
+	return results, total, rows.Err()
+}
+
+// RestoreFromTrash 在保留期限内恢复回收站中的任务，分配新版本号，一起删除的子任务一并恢复
+// 共享项目中的任务需要 editor 角色
+func RestoreFromTrash(userID int, deviceID string, taskID int64) (map[string]interface{}, error) {
+	cutoff := trashCutoff()
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkTaskAccessAny(tx, userID, taskID, types.ProjectRoleEditor); err != nil {
+		if err == ErrTaskNotFound || err == ErrTaskForbidden {
+			return nil, ErrTaskNotInTrash
+		}
+		return nil, err
+	}
+	var isDeleted sql.NullBool
+	var deletedAt sql.NullTime
+	if err := tx.QueryRow("SELECT is_deleted, deleted_at FROM tasks WHERE id = ?", taskID).Scan(&isDeleted, &deletedAt); err != nil {
+		return nil, err
+	}
+	if !isDeleted.Bool {
+		return nil, ErrTaskNotInTrash
+	}
+	// 没有删除时间的任务与 PurgeExpiredTrash 一致视为已过期
+	if !deletedAt.Valid || deletedAt.Time.Before(cutoff) {
+		return nil, ErrTrashExpired
+	}
+
+	if err := restoreTaskTx(tx, userID, deviceID, taskID); err != nil {
+		return nil, err
+	}
+	if err := restoreDescendantsTx(tx, userID, deviceID, taskID, deletedAt.Time); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("UPDATE deleted_tasks SET is_restorable = 0 WHERE task_id = ?", taskID); err != nil {
+		return nil, err
+	}
+
+	task, err := getTask(tx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	return task, tx.Commit()
+}
+
+// PurgeTrash 永久删除用户回收站中的指定任务，taskIDs 为空时清空回收站，返回删除数量
+// 只作用于用户本人的任务，共享项目中其他用户的任务由其所有者清除或到期自动清除
+// 变更日志保留，已同步过这些任务的设备增量拉取时仍会收到墓碑
+func PurgeTrash(userID int, taskIDs []int64) (int, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cond := "user_id = ? AND is_deleted = 1"
+	args := []interface{}{userID}
+	if len(taskIDs) > 0 {
+		placeholders := make([]string, len(taskIDs))
+		for i, id := range taskIDs {
+			placeholders[i] = "?"
+			args = append(args, id)
+		}
+		cond += " AND id IN (" + strings.Join(placeholders, ",") + ")"
+	}
+
+	count, err := purgeTasks(tx, cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// PurgeExpiredTrash 永久删除所有超过回收站保留期限的任务，没有删除时间的已删除任务视为已过期
+func PurgeExpiredTrash() (int, error) {
+	cutoff := trashCutoff()
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := purgeTasks(tx, "is_deleted = 1 AND (deleted_at IS NULL OR deleted_at < ?)", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	return count, tx.Commit()
+}
+
+// purgeTasks 永久删除满足条件的任务及其修订历史、撤销快照、提醒、依赖、评论与动态，标记附件待清理，软删除工时记录，并清理空的重复序列
+func purgeTasks(tx *sql.Tx, cond string, args ...interface{}) (int, error) {
+	if err := detachPurgedChildrenTx(tx, cond, args...); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("DELETE FROM task_comment_mentions WHERE comment_id IN (SELECT id FROM task_comments WHERE task_id IN (SELECT id FROM tasks WHERE "+cond+"))", args...); err != nil {
+		return 0, err
+	}
+	// 附件的存储内容由清理任务删除，这里只标记
+	if _, err := tx.Exec("UPDATE task_attachments SET is_deleted = 1, deleted_at = ? WHERE is_deleted = 0 AND task_id IN (SELECT id FROM tasks WHERE "+cond+")",
+		append([]interface{}{time.Now().UTC()}, args...)...); err != nil {
+		return 0, err
+	}
+	if err := purgeTimeEntriesTx(tx, cond, args...); err != nil {
+		return 0, err
+	}
+	for _, table := range []string{"task_revisions", "deleted_tasks", "task_reminders", "reminder_deliveries", "task_dependencies", "task_tags", "task_comments", "task_activity"} {
+		if _, err := tx.Exec("DELETE FROM "+table+" WHERE task_id IN (SELECT id FROM tasks WHERE "+cond+")", args...); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM task_dependencies WHERE blocker_id IN (SELECT id FROM tasks WHERE "+cond+")", args...); err != nil {
+		return 0, err
+	}
+	result, err := tx.Exec("DELETE FROM tasks WHERE "+cond, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// 清除已没有任何任务的重复序列
+	if _, err := tx.Exec("DELETE FROM task_series WHERE id NOT IN (SELECT series_id FROM tasks WHERE series_id IS NOT NULL)"); err != nil {
+		return 0, err
+	}
+	return int(count), nil
+}
+
+// detachPurgedChildrenTx 将仍引用被清除任务、自身不被清除的子任务移到顶层，
+// 并以任务所有者的名义分配新版本号，已同步过这些任务的设备增量拉取时会收到更新
+func detachPurgedChildrenTx(tx *sql.Tx, cond string, args ...interface{}) error {
+	rows, err := tx.Query("SELECT id, user_id FROM tasks WHERE parent_id IN (SELECT id FROM tasks WHERE "+cond+") AND id NOT IN (SELECT id FROM tasks WHERE "+cond+")",
+		append(append([]interface{}{}, args...), args...)...)
+	if err != nil {
+		return err
+	}
+	type child struct {
+		id     int64
+		userID int
+	}
+	var children []child
+	for rows.Next() {
+		var c child
+		if err := rows.Scan(&c.id, &c.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		children = append(children, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, c := range children {
+		version, err := logTaskChange(tx, c.userID, c.id, ChangeUpdate, "")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE tasks SET parent_id = NULL, position = NULL, server_version = ?, updated_at = ?, last_modified = ? WHERE id = ?",
+			version, now, now, c.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// purgeTimeEntriesTx 软删除被清除任务上的工时记录，并在各记录所属用户的变更日志中追加删除变更，
+// 已同步过这些记录的设备增量拉取时会收到墓碑
+func purgeTimeEntriesTx(tx *sql.Tx, cond string, args ...interface{}) error {
+	rows, err := tx.Query("SELECT id, user_id FROM time_entries WHERE is_deleted = 0 AND task_id IN (SELECT id FROM tasks WHERE "+cond+")", args...)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		id     int64
+		userID int
+	}
+	var entries []entry
+	for rows.Next() {
+		var e entry
+		if err := rows.Scan(&e.id, &e.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, e := range entries {
+		version, err := nextChangeSeq(tx, e.userID)
+		if err != nil {
+			return err
+		}
+		if err := appendChange(tx, e.userID, e.userID, version, EntityTimeEntry, e.id, ChangeDelete, ""); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE time_entries SET is_deleted = 1, deleted_at = ?, server_version = ?, updated_at = ? WHERE id = ?",
+			now, version, now, e.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,456 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// MaxViewsPerUser 每个用户最多保存的视图数
+const MaxViewsPerUser = 100
+
+var (
+	// ErrViewNotFound 视图不存在、已删除或不属于当前用户
+	ErrViewNotFound = errors.New("视图不存在")
+	// ErrViewLimit 用户的视图数已达上限
+	ErrViewLimit = errors.New("视图数量已达上限")
+)
+
+// ViewFields 视图可写字段，nil 表示不修改
+type ViewFields struct {
+	Name    *string
+	Filters map[string]string // 整体替换；传入空 map 表示清空过滤条件
+	Sort    *string
+	Order   *string
+}
+
+// viewColumns 视图查询的标准列，与 scanView 的扫描顺序一致
+const viewColumns = "id, local_id, server_version, name, filters, sort, sort_order, is_deleted, last_count, created_at, updated_at"
+
+// scanView 扫描一行视图记录
+func scanView(s rowScanner) (*types.SavedView, error) {
+	v := &types.SavedView{}
+	var localID sql.NullString
+	var filters string
+	var createdAt, updatedAt sql.NullTime
+	if err := s.Scan(&v.ID, &localID, &v.ServerVersion, &v.Name, &filters, &v.Sort, &v.Order, &v.IsDeleted,
+		&v.Count, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	v.LocalID = localID.String
+	v.Filters = map[string]string{}
+	if filters != "" {
+		if err := json.Unmarshal([]byte(filters), &v.Filters); err != nil {
+			return nil, err
+		}
+	}
+	v.CreatedAt = formatDueAt(createdAt)
+	v.UpdatedAt = formatDueAt(updatedAt)
+	return v, nil
+}
+
+// queryViews 执行返回视图列表的查询
+func queryViews(q Querier, query string, args ...interface{}) ([]*types.SavedView, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	views := []*types.SavedView{}
+	for rows.Next() {
+		v, err := scanView(rows)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+// getViewTx 获取用户的未删除视图
+func getViewTx(q Querier, userID int, viewID int64) (*types.SavedView, error) {
+	v, err := scanView(q.QueryRow("SELECT "+viewColumns+" FROM saved_views WHERE id = ? AND user_id = ? AND is_deleted = 0", viewID, userID))
+	if err == sql.ErrNoRows {
+		return nil, ErrViewNotFound
+	}
+	return v, err
+}
+
+// ValidateViewQuery 校验视图的过滤条件与排序方式，过滤条件无效时 Field 为 filters.<key>
+func ValidateViewQuery(filters map[string]string, sort, order string) error {
+	allowed := map[string]bool{}
+	for _, key := range TaskFilterKeys {
+		allowed[key] = true
+	}
+	for key := range filters {
+		if !allowed[key] {
+			return &FilterError{Field: "filters." + key, Message: "不支持的过滤条件"}
+		}
+	}
+	if _, _, err := buildTaskFilter(0, filters); err != nil {
+		if filterErr, ok := err.(*FilterError); ok {
+			return &FilterError{Field: "filters." + filterErr.Field, Message: filterErr.Message}
+		}
+		return err
+	}
+	_, _, _, err := taskOrderClause(sort, order)
+	return err
+}
+
+// countViewMembers 统计符合视图过滤条件的任务数，相对时间按当前时刻求值
+func countViewMembers(q Querier, userID int, filters map[string]string) (int, error) {
+	where, args, err := buildTaskFilter(userID, filters)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	err = q.QueryRow("SELECT COUNT(*) FROM tasks WHERE "+where, args...).Scan(&count)
+	return count, err
+}
+
+// fillViewCounts 以当前的任务重新计算视图的成员数
+func fillViewCounts(q Querier, userID int, views []*types.SavedView) error {
+	for _, v := range views {
+		if v.IsDeleted {
+			continue
+		}
+		count, err := countViewMembers(q, userID, v.Filters)
+		if err != nil {
+			return err
+		}
+		v.Count = count
+	}
+	return nil
+}
+
+// ListViews 获取用户的未删除视图（按创建顺序）及其当前成员数
+func ListViews(userID int) ([]*types.SavedView, error) {
+	views, err := queryViews(DB, "SELECT "+viewColumns+" FROM saved_views WHERE user_id = ? AND is_deleted = 0 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	return views, fillViewCounts(DB, userID, views)
+}
+
+// GetView 获取用户的单个视图及其当前成员数
+func GetView(userID int, viewID int64) (*types.SavedView, error) {
+	v, err := getViewTx(DB, userID, viewID)
+	if err != nil {
+		return nil, err
+	}
+	return v, fillViewCounts(DB, userID, []*types.SavedView{v})
+}
+
+// ViewQuery 按视图的过滤条件与排序方式生成分页查询
+func ViewQuery(v *types.SavedView, page, pageSize int) *types.PaginatedQuery {
+	q := types.NewPaginatedQuery(page, pageSize)
+	for key, value := range v.Filters {
+		q.SetFilter(key, value)
+	}
+	q.OrderBy = v.Sort
+	q.Order = v.Order
+	return q
+}
+
+// CreateView 创建视图并返回完整记录
+func CreateView(userID int, deviceID, localID string, f ViewFields) (*types.SavedView, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	id, _, err := insertViewTx(tx, userID, deviceID, localID, f)
+	if err != nil {
+		return nil, err
+	}
+	v, err := getViewTx(tx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillViewCounts(tx, userID, []*types.SavedView{v}); err != nil {
+		return nil, err
+	}
+	return v, tx.Commit()
+}
+
+// insertViewTx 插入视图并记录变更，返回视图 ID 与分配的版本号；调用方已校验过滤条件
+func insertViewTx(tx *sql.Tx, userID int, deviceID, localID string, f ViewFields) (int64, int, error) {
+	var existing int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM saved_views WHERE user_id = ? AND is_deleted = 0", userID).Scan(&existing); err != nil {
+		return 0, 0, err
+	}
+	if existing >= MaxViewsPerUser {
+		return 0, 0, ErrViewLimit
+	}
+
+	name := ""
+	if f.Name != nil {
+		name = *f.Name
+	}
+	filters := f.Filters
+	if filters == nil {
+		filters = map[string]string{}
+	}
+	sort, order := "created_at", "desc"
+	if f.Sort != nil && *f.Sort != "" {
+		sort = *f.Sort
+	}
+	if f.Order != nil && *f.Order != "" {
+		order = strings.ToLower(*f.Order)
+	}
+	encoded, err := json.Marshal(filters)
+	if err != nil {
+		return 0, 0, err
+	}
+	count, err := countViewMembers(tx, userID, filters)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, 0, err
+	}
+	now := time.Now().UTC()
+	res, err := tx.Exec(
+		"INSERT INTO saved_views (user_id, local_id, server_version, name, filters, sort, sort_order, is_deleted, last_count, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?, ?, ?)",
+		userID, localID, version, name, string(encoded), sort, order, count, now, now,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, 0, err
+	}
+	return id, version, appendChange(tx, userID, userID, version, EntityView, id, ChangeInsert, deviceID)
+}
+
+// UpdateView 部分更新视图并分配新版本号
+func UpdateView(userID int, deviceID string, viewID int64, f ViewFields) (*types.SavedView, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := updateViewTx(tx, userID, deviceID, viewID, f); err != nil {
+		return nil, err
+	}
+	v, err := getViewTx(tx, userID, viewID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fillViewCounts(tx, userID, []*types.SavedView{v}); err != nil {
+		return nil, err
+	}
+	return v, tx.Commit()
+}
+
+// updateViewTx 在事务中部分更新视图、记录变更，返回分配的版本号
+// 过滤条件变化时重新计算成员数，避免随后推送一次并非由任务变化引起的成员数变化
+func updateViewTx(tx *sql.Tx, userID int, deviceID string, viewID int64, f ViewFields) (int, error) {
+	if _, err := getViewTx(tx, userID, viewID); err != nil {
+		return 0, err
+	}
+
+	sets := ""
+	args := []interface{}{}
+	if f.Name != nil {
+		sets += "name = ?, "
+		args = append(args, *f.Name)
+	}
+	if f.Filters != nil {
+		encoded, err := json.Marshal(f.Filters)
+		if err != nil {
+			return 0, err
+		}
+		count, err := countViewMembers(tx, userID, f.Filters)
+		if err != nil {
+			return 0, err
+		}
+		sets += "filters = ?, last_count = ?, "
+		args = append(args, string(encoded), count)
+	}
+	if f.Sort != nil && *f.Sort != "" {
+		sets += "sort = ?, "
+		args = append(args, *f.Sort)
+	}
+	if f.Order != nil && *f.Order != "" {
+		sets += "sort_order = ?, "
+		args = append(args, strings.ToLower(*f.Order))
+	}
+
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, userID, userID, version, EntityView, viewID, ChangeUpdate, deviceID); err != nil {
+		return 0, err
+	}
+	args = append(args, version, time.Now().UTC(), viewID)
+	if _, err := tx.Exec("UPDATE saved_views SET "+sets+"server_version = ?, updated_at = ? WHERE id = ?", args...); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DeleteView 删除视图
+func DeleteView(userID int, deviceID string, viewID int64) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := deleteViewTx(tx, userID, deviceID, viewID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteViewTx 软删除视图（保留墓碑供同步下发），返回分配的版本号
+func deleteViewTx(tx *sql.Tx, userID int, deviceID string, viewID int64) (int, error) {
+	if _, err := getViewTx(tx, userID, viewID); err != nil {
+		return 0, err
+	}
+	version, err := nextChangeSeq(tx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if err := appendChange(tx, userID, userID, version, EntityView, viewID, ChangeDelete, deviceID); err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	_, err = tx.Exec("UPDATE saved_views SET is_deleted = 1, deleted_at = ?, server_version = ?, updated_at = ? WHERE id = ?",
+		now, version, now, viewID)
+	return version, err
+}
+
+// GetViewChangesSince 获取用户在 sinceSeq 之后变更过的视图（含已删除视图的墓碑），按序号升序
+// 同时返回这些变更中最大的序号；sinceSeq 为 0 表示全量拉取，此时只返回未删除的视图
+// untilSeq 大于 0 时只返回序号不超过 untilSeq 的变更
+func GetViewChangesSince(q Querier, userID int, sinceSeq, untilSeq int) ([]*types.SavedView, int, error) {
+	if sinceSeq == 0 {
+		views, err := queryViews(q, "SELECT "+viewColumns+" FROM saved_views WHERE user_id = ? AND is_deleted = 0 ORDER BY server_version, id", userID)
+		if err != nil {
+			return nil, 0, err
+		}
+		return views, 0, fillViewCounts(q, userID, views)
+	}
+
+	query := "SELECT " + viewColumns + ", c.last_seq FROM saved_views JOIN (SELECT task_id, MAX(seq) AS last_seq FROM change_log WHERE user_id = ? AND seq > ? AND entity_type = 'view'"
+	args := []interface{}{userID, sinceSeq}
+	if untilSeq > 0 {
+		query += " AND seq <= ?"
+		args = append(args, untilSeq)
+	}
+	rows, err := q.Query(query+" GROUP BY task_id) c ON c.task_id = saved_views.id ORDER BY c.last_seq", args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	views := []*types.SavedView{}
+	lastSeq := 0
+	for rows.Next() {
+		v, err := scanView(extraScanner{rows, []interface{}{&lastSeq}})
+		if err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		views = append(views, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return views, lastSeq, fillViewCounts(q, userID, views)
+}
+
+// ViewIDByLocalID 按客户端本地 ID 查找用户未删除的视图
+func ViewIDByLocalID(tx *sql.Tx, userID int, localID string) (int64, error) {
+	var id int64
+	err := tx.QueryRow("SELECT id FROM saved_views WHERE local_id = ? AND user_id = ? AND is_deleted = 0 ORDER BY id DESC LIMIT 1", localID, userID).Scan(&id)
+	return id, err
+}
+
+// InsertSyncView 插入客户端同步上来的新视图并记录变更，返回视图 ID 与分配的版本号
+func InsertSyncView(tx *sql.Tx, userID int, deviceID, localID string, f ViewFields) (int64, int, error) {
+	return insertViewTx(tx, userID, deviceID, localID, f)
+}
+
+// UpdateSyncView 在同步事务中更新视图，返回分配的版本号
+func UpdateSyncView(tx *sql.Tx, userID int, deviceID string, viewID int64, f ViewFields) (int, error) {
+	return updateViewTx(tx, userID, deviceID, viewID, f)
+}
+
+// DeleteSyncView 在同步事务中删除视图，返回分配的版本号
+func DeleteSyncView(tx *sql.Tx, userID int, deviceID string, viewID int64) (int, error) {
+	return deleteViewTx(tx, userID, deviceID, viewID)
+}
+
+// RefreshViewCounts 重新计算用户各视图的成员数，记录并返回与上次记录不同的视图
+// relativeOnly 为 true 时只计算含相对时间条件的视图（成员会随时间变化）
+func RefreshViewCounts(userID int, relativeOnly bool) ([]types.ViewCount, error) {
+	views, err := queryViews(DB, "SELECT "+viewColumns+" FROM saved_views WHERE user_id = ? AND is_deleted = 0 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	changed := []types.ViewCount{}
+	for _, v := range views {
+		if relativeOnly && !IsRelativeFilter(v.Filters) {
+			continue
+		}
+		count, err := countViewMembers(DB, userID, v.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if count == v.Count {
+			continue
+		}
+		// 以旧值为条件更新，并发的刷新只有一个会记录并推送这次变化
+		res, err := DB.Exec("UPDATE saved_views SET last_count = ? WHERE id = ? AND last_count = ?", count, v.ID, v.Count)
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			continue
+		}
+		changed = append(changed, types.ViewCount{ViewID: v.ID, Count: count, Previous: v.Count})
+	}
+	return changed, nil
+}
+
+// UsersWithRelativeViews 获取拥有含相对时间条件的视图的用户
+func UsersWithRelativeViews() ([]int, error) {
+	rows, err := DB.Query("SELECT user_id, filters FROM saved_views WHERE is_deleted = 0 ORDER BY user_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []int{}
+	for rows.Next() {
+		var userID int
+		var encoded string
+		if err := rows.Scan(&userID, &encoded); err != nil {
+			return nil, err
+		}
+		if len(users) > 0 && users[len(users)-1] == userID {
+			continue
+		}
+		filters := map[string]string{}
+		if err := json.Unmarshal([]byte(encoded), &filters); err != nil {
+			continue
+		}
+		if IsRelativeFilter(filters) {
+			users = append(users, userID)
+		}
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,119 @@
+// Package fracindex 生成分数索引排序键：任意两个键之间总能生成新的键，
+// 移动一个元素只需改写它自己的键，不必重新编号其余元素
+// 键由 base62 字符（0-9A-Za-z）组成，按字节序比较，且不以 '0' 结尾（保证下方总有空间）
+package fracindex
+
+import (
+	"errors"
+	"strings"
+)
+
+const digits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+var (
+	// ErrInvalidKey 键为空、含有非 base62 字符或以 '0' 结尾
+	ErrInvalidKey = errors.New("无效的排序键")
+	// ErrKeyOrder 下界不小于上界
+	ErrKeyOrder = errors.New("排序键下界必须小于上界")
+)
+
+// Valid 检查是否为合法的排序键
+func Valid(key string) bool {
+	if key == "" || key[len(key)-1] == '0' {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if strings.IndexByte(digits, key[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Between 生成严格位于 a 与 b 之间的键，a 为空表示最前，b 为空表示最后
+// 只有一侧边界时（追加到末尾或插入到最前）逐位步进而不是取中点，连续追加时键长增长缓慢
+func Between(a, b string) (string, error) {
+	if (a != "" && !Valid(a)) || (b != "" && !Valid(b)) {
+		return "", ErrInvalidKey
+	}
+	switch {
+	case a != "" && b != "":
+		if a >= b {
+			return "", ErrKeyOrder
+		}
+	case a != "":
+		return after(a), nil
+	case b != "":
+		if key, ok := before(b); ok {
+			return key, nil
+		}
+	}
+	return midpoint(a, b), nil
+}
+
+// after 生成大于 a 的键：将第一个不是 'z' 的位加一并截断其后各位，全部为 'z' 时在末尾追加 '1'
+func after(a string) string {
+	for i := 0; i < len(a); i++ {
+		if d := strings.IndexByte(digits, a[i]); d < len(digits)-1 {
+			return a[:i] + string(digits[d+1])
+		}
+	}
+	return a + string(digits[1])
+}
+
+// before 生成小于 b 的键：将第一个大于 '1' 的位减一并截断其后各位；没有这样的位时返回 false，由调用方取中点
+func before(b string) (string, bool) {
+	for i := 0; i < len(b); i++ {
+		if d := strings.IndexByte(digits, b[i]); d > 1 {
+			return b[:i] + string(digits[d-1]), true
+		}
+	}
+	return "", false
+}
+
+// midpoint 计算 a 与 b 的中间键，视为 [0, 1) 上的小数；b 为空表示 1
+func midpoint(a, b string) string {
+	if b != "" {
+		// 跳过公共前缀，a 较短时按补 '0' 比较
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(a) {
+				rest = a[n:]
+			}
+			return b[:n] + midpoint(rest, b[n:])
+		}
+	}
+
+	lo := 0
+	if a != "" {
+		lo = strings.IndexByte(digits, a[0])
+	}
+	hi := len(digits)
+	if b != "" {
+		hi = strings.IndexByte(digits, b[0])
+	}
+	if hi-lo > 1 {
+		return string(digits[(lo+hi+1)/2])
+	}
+	// 首位相邻：b 更长时截取其首位即可，否则在 a 的首位之后继续细分
+	if len(b) > 1 {
+		return b[:1]
+	}
+	rest := ""
+	if len(a) > 1 {
+		rest = a[1:]
+	}
+	return string(digits[lo]) + midpoint(rest, "")
+}
+
+// digitAt 返回 s 第 i 位的字符，超出长度时为 '0'
+func digitAt(s string, i int) byte {
+	if i < len(s) {
+		return s[i]
+	}
+	return '0'
+}
@@ -0,0 +1,176 @@
+package fracindex
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestBetween(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"", "", "V"},
+		{"V", "", "W"},
+		{"z", "", "z1"},
+		{"zz", "", "zz1"},
+		{"z1", "", "z2"},
+		{"", "V", "U"},
+		{"", "1", "0V"},
+		{"", "01", "00V"},
+		{"A", "C", "B"},
+		{"A", "B", "AV"},
+		{"A", "A1", "A0V"},
+		{"AV", "B", "Al"},
+		{"0V", "1", "0l"},
+	}
+	for _, tt := range tests {
+		got, err := Between(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("Between(%q, %q) = %q, %v; want %q", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+}
+
+func TestBetweenErrors(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want error
+	}{
+		{"A0", "", ErrInvalidKey},
+		{"", "a-b", ErrInvalidKey},
+		{"A", "中", ErrInvalidKey},
+		{"B", "A", ErrKeyOrder},
+		{"A", "A", ErrKeyOrder},
+	}
+	for _, tt := range tests {
+		if _, err := Between(tt.a, tt.b); err != tt.want {
+			t.Errorf("Between(%q, %q) error = %v, want %v", tt.a, tt.b, err, tt.want)
+		}
+	}
+}
+
+// checkBetween 生成 a 与 b 之间的键并检查其合法且严格位于两者之间
+func checkBetween(t *testing.T, a, b string) string {
+	t.Helper()
+	key, err := Between(a, b)
+	if err != nil {
+		t.Fatalf("Between(%q, %q): %v", a, b, err)
+	}
+	if !Valid(key) {
+		t.Fatalf("Between(%q, %q) = %q, not a valid key", a, b, key)
+	}
+	if (a != "" && key <= a) || (b != "" && key >= b) {
+		t.Fatalf("Between(%q, %q) = %q, not strictly between", a, b, key)
+	}
+	return key
+}
+
+func TestRandomInsertsKeepOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var keys []string
+	for i := 0; i < 5000; i++ {
+		pos := rng.Intn(len(keys) + 1)
+		var a, b string
+		if pos > 0 {
+			a = keys[pos-1]
+		}
+		if pos < len(keys) {
+			b = keys[pos]
+		}
+		key := checkBetween(t, a, b)
+		keys = append(keys[:pos], append([]string{key}, keys[pos:]...)...)
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Fatal("keys are not sorted after random inserts")
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] == keys[i] {
+			t.Fatalf("duplicate key %q", keys[i])
+		}
+	}
+	// 随机位置插入时键长按对数增长
+	for _, k := range keys {
+		if len(k) > 12 {
+			t.Errorf("key %q is longer than expected for %d random inserts", k, len(keys))
+			break
+		}
+	}
+}
+
+// TestKeyLengthGrowth 检查各种插入模式下键长的增长速度；没有重新编号，键长只增不减
+func TestKeyLengthGrowth(t *testing.T) {
+	const n = 1000
+	tests := []struct {
+		name   string
+		next   func(first, last, prev string) (string, string)
+		maxLen int
+	}{
+		// 追加到末尾：最常见的操作，逐位步进，每位约 60 次
+		{"append", func(first, last, prev string) (string, string) { return last, "" }, 20},
+		// 插入到最前，每位约 30 次
+		{"prepend", func(first, last, prev string) (string, string) { return "", first }, 40},
+		// 反复插入到同一个键之后（始终紧贴下界），每次取中点，约每 5 次增加一位
+		{"after the same key", func(first, last, prev string) (string, string) { return first, prev }, n / 4},
+		// 反复插入到同一个键之前（始终紧贴上界）
+		{"before the same key", func(first, last, prev string) (string, string) { return prev, last }, n / 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first := checkBetween(t, "", "")
+			last := checkBetween(t, first, "")
+			prev := checkBetween(t, first, last)
+			keys := []string{first, prev, last}
+			for i := 0; i < n; i++ {
+				a, b := tt.next(first, last, prev)
+				key := checkBetween(t, a, b)
+				switch {
+				case a == "":
+					first = key
+				case b == "":
+					last = key
+				}
+				prev = key
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for i := 1; i < len(keys); i++ {
+				if keys[i-1] == keys[i] {
+					t.Fatalf("duplicate key %q", keys[i])
+				}
+			}
+			maxLen := 0
+			for _, k := range keys {
+				if len(k) > maxLen {
+					maxLen = len(k)
+				}
+			}
+			if maxLen > tt.maxLen {
+				t.Errorf("after %d inserts the longest key has %d characters, want at most %d", n, maxLen, tt.maxLen)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"V", true},
+		{"0V", true},
+		{"azAZ09z", true},
+		{"", false},
+		{"0", false},
+		{"V0", false},
+		{"a b", false},
+		{"a-b", false},
+		{"排序", false},
+	}
+	for _, tt := range tests {
+		if got := Valid(tt.key); got != tt.want {
+			t.Errorf("Valid(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
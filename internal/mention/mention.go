@@ -0,0 +1,36 @@
+// Package mention 从评论正文中提取 @提及
+// 提及写作 @邮箱（如 @bob@example.com）或 @邮箱用户名（如 @bob），@ 前须为行首或非单词字符，
+// 因此正文中的普通邮箱地址不会被当作提及
+package mention
+
+import (
+	"regexp"
+	"strings"
+)
+
+var pattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w.%+-]+(?:@[\w-]+(?:\.[\w-]+)*\.[A-Za-z]{2,})?)`)
+
+// Parse 按出现顺序返回正文中提及的用户标识（小写、去重），末尾的句点等标点不计入
+func Parse(body string) []string {
+	seen := map[string]bool{}
+	handles := []string{}
+	for _, m := range pattern.FindAllStringSubmatch(body, -1) {
+		handle := strings.ToLower(strings.TrimRight(m[1], ".-"))
+		if handle == "" || seen[handle] {
+			continue
+		}
+		seen[handle] = true
+		handles = append(handles, handle)
+	}
+	return handles
+}
+
+// Matches 判断提及标识是否指向该邮箱：完整邮箱需完全一致，否则与邮箱的用户名部分比较（均忽略大小写）
+func Matches(handle, email string) bool {
+	email = strings.ToLower(email)
+	if strings.Contains(handle, "@") {
+		return handle == email
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return handle == local
+}
@@ -0,0 +1,168 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"todoapp/internal/types"
+)
+
+// 内置合并策略名称
+const (
+	LastWriterWins = "last_writer_wins"
+	ServerWins     = "server_wins"
+	ClientWins     = "client_wins"
+	ThreeWay       = "three_way"
+	Concat         = "concat"
+	StatusPriority = "status_priority"
+	TextMerge      = "text_merge"
+)
+
+// DefaultStrategies 未在 system_config 中配置时各字段使用的策略
+var DefaultStrategies = map[string]string{
+	"title":       ServerWins,
+	"description": TextMerge,
+	"status":      StatusPriority,
+	"priority":    ClientWins,
+	"due_at":      ThreeWay,
+}
+
+// Input 单个字段的合并输入
+type Input struct {
+	Field      string
+	Base       string // 客户端修改所基于的版本中的值
+	HasBase    bool   // 是否找到了基准版本
+	Server     string
+	Client     string
+	ServerTime time.Time
+	ClientTime time.Time // 客户端修改时间，未提供时为零值
+}
+
+// Result 字段合并结果
+type Result struct {
+	Value    string
+	Conflict bool // 双方修改重叠、无法自动调和，需要记录冲突
+}
+
+// Func 字段合并策略
+type Func func(in Input) Result
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Func{}
+)
+
+// Register 注册合并策略，同名策略会被覆盖
+func Register(name string, fn Func) {
+	mu.Lock()
+	defer mu.Unlock()
+	registry[name] = fn
+}
+
+// Lookup 按名称查找合并策略
+func Lookup(name string) (Func, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	fn, ok := registry[name]
+	return fn, ok
+}
+
+// Names 返回已注册的策略名称（按字母排序）
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Apply 使用指定策略合并字段，返回合并结果与实际使用的策略名
+// 策略未注册时退化为 server_wins
+func Apply(name string, in Input) (Result, string) {
+	fn, ok := Lookup(name)
+	if !ok {
+		name = ServerWins
+		fn = serverWins
+	}
+	return fn(in), name
+}
+
+func init() {
+	Register(LastWriterWins, lastWriterWins)
+	Register(ServerWins, serverWins)
+	Register(ClientWins, clientWins)
+	Register(ThreeWay, threeWay)
+	Register(Concat, concat)
+	Register(StatusPriority, statusPriority)
+	Register(TextMerge, textMerge)
+}
+
+// bothChanged 判断双方是否都修改了字段，缺少基准版本时无法区分，视为双方都修改
+func bothChanged(in Input) bool {
+	if in.Server == in.Client {
+		return false
+	}
+	return !in.HasBase || (in.Server != in.Base && in.Client != in.Base)
+}
+
+// pick 双方都修改时采用策略选定的值并标记为冲突；有基准版本且只有一方修改时采用修改方的值，与策略无关
+func pick(in Input, value string) Result {
+	if !bothChanged(in) {
+		if in.HasBase && in.Server == in.Base {
+			return Result{Value: in.Client}
+		}
+		if in.HasBase {
+			return Result{Value: in.Server}
+		}
+		return Result{Value: value}
+	}
+	return Result{Value: value, Conflict: true}
+}
+
+// lastWriterWins 按修改时间选择较新的一方，客户端未提供时间时保留服务器值
+func lastWriterWins(in Input) Result {
+	if !in.ClientTime.IsZero() && in.ClientTime.After(in.ServerTime) {
+		return pick(in, in.Client)
+	}
+	return pick(in, in.Server)
+}
+
+func serverWins(in Input) Result { return pick(in, in.Server) }
+
+func clientWins(in Input) Result { return pick(in, in.Client) }
+
+// threeWay 与基准版本比较：只有一方修改时采用修改方的值，双方都修改（或缺少基准）时保留服务器值
+func threeWay(in Input) Result {
+	return pick(in, in.Server)
+}
+
+// concat 双方值不同时拼接两者并标注来源
+func concat(in Input) Result {
+	switch {
+	case in.Client == "" || in.Client == in.Server:
+		return pick(in, in.Server)
+	case in.Server == "":
+		return pick(in, in.Client)
+	}
+	return pick(in, fmt.Sprintf("[服务器更新] %s\n\n[客户端更新] %s", in.Server, in.Client))
+}
+
+// statusPriority 按状态优先级（已完成 > 进行中 > 待办）选择
+func statusPriority(in Input) Result {
+	return pick(in, types.PrioritizeStatus(in.Client, in.Server))
+}
+
+// textMerge 以基准版本为准做行级/词级三方文本合并，只有双方修改同一处时才标记冲突
+// 缺少基准版本时无法合并，保留服务器值
+func textMerge(in Input) Result {
+	if !in.HasBase {
+		return pick(in, in.Server)
+	}
+	value, conflict := MergeText(in.Base, in.Server, in.Client)
+	return Result{Value: value, Conflict: conflict}
+}
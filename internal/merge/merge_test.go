@@ -0,0 +1,56 @@
+package merge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	serverTime := time.Date(2026, 10, 16, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		strategy     string
+		in           Input
+		want         string
+		wantConflict bool
+	}{
+		// 只有客户端修改：任何策略都采用客户端的值
+		{"server_wins, only client changed", ServerWins, Input{Base: "a", HasBase: true, Server: "a", Client: "b"}, "b", false},
+		{"status_priority, only client changed", StatusPriority, Input{Base: "done", HasBase: true, Server: "done", Client: "todo"}, "todo", false},
+		{"last_writer_wins, only client changed", LastWriterWins, Input{Base: "a", HasBase: true, Server: "a", Client: "b"}, "b", false},
+		{"concat, only client changed", Concat, Input{Base: "a", HasBase: true, Server: "a", Client: "b"}, "b", false},
+		// 只有服务器修改：过期客户端提交的旧值不能覆盖
+		{"client_wins, only server changed", ClientWins, Input{Base: "low", HasBase: true, Server: "high", Client: "low"}, "high", false},
+		{"last_writer_wins, only server changed", LastWriterWins,
+			Input{Base: "a", HasBase: true, Server: "b", Client: "a", ServerTime: serverTime, ClientTime: serverTime.Add(time.Hour)}, "b", false},
+		{"status_priority, only server changed", StatusPriority, Input{Base: "in_progress", HasBase: true, Server: "todo", Client: "in_progress"}, "todo", false},
+		{"three_way, only server changed", ThreeWay, Input{Base: "a", HasBase: true, Server: "b", Client: "a"}, "b", false},
+		// 双方都修改：按策略选择并记录冲突
+		{"server_wins, both changed", ServerWins, Input{Base: "a", HasBase: true, Server: "s", Client: "c"}, "s", true},
+		{"client_wins, both changed", ClientWins, Input{Base: "a", HasBase: true, Server: "s", Client: "c"}, "c", true},
+		{"status_priority, both changed", StatusPriority, Input{Base: "todo", HasBase: true, Server: "in_progress", Client: "done"}, "done", true},
+		{"last_writer_wins, client newer", LastWriterWins,
+			Input{Base: "a", HasBase: true, Server: "s", Client: "c", ServerTime: serverTime, ClientTime: serverTime.Add(time.Minute)}, "c", true},
+		{"last_writer_wins, no client time", LastWriterWins, Input{Base: "a", HasBase: true, Server: "s", Client: "c", ServerTime: serverTime}, "s", true},
+		{"three_way, both changed", ThreeWay, Input{Base: "a", HasBase: true, Server: "s", Client: "c"}, "s", true},
+		{"concat, both changed", Concat, Input{Base: "a", HasBase: true, Server: "s", Client: "c"}, "[服务器更新] s\n\n[客户端更新] c", true},
+		// 双方改为相同的值
+		{"server_wins, same change", ServerWins, Input{Base: "a", HasBase: true, Server: "b", Client: "b"}, "b", false},
+		// 缺少基准版本：无法判断哪一方修改，值不同即视为冲突
+		{"server_wins, no base", ServerWins, Input{Server: "s", Client: "c"}, "s", true},
+		{"client_wins, no base", ClientWins, Input{Server: "s", Client: "c"}, "c", true},
+		{"client_wins, no base, equal", ClientWins, Input{Server: "s", Client: "s"}, "s", false},
+		// 未注册的策略退化为 server_wins
+		{"unknown strategy, only client changed", "nope", Input{Base: "a", HasBase: true, Server: "a", Client: "b"}, "b", false},
+		{"unknown strategy, both changed", "nope", Input{Base: "a", HasBase: true, Server: "s", Client: "c"}, "s", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := Apply(tt.strategy, tt.in)
+			if got.Value != tt.want || got.Conflict != tt.wantConflict {
+				t.Errorf("Apply(%q, %+v) = %q, %v; want %q, %v", tt.strategy, tt.in, got.Value, got.Conflict, tt.want, tt.wantConflict)
+			}
+		})
+	}
+}
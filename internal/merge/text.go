@@ -0,0 +1,252 @@
+package merge
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxDiffCells 单次 LCS 计算允许的最大矩阵规模，超过时视为无法自动合并
+const maxDiffCells = 4000000
+
+// MergeText 以 base 为基准对 server 与 client 做三方文本合并
+// 先按行合并，行级存在重叠修改时再按词合并；conflict 为 true 表示双方修改了同一处，
+// 此时重叠部分保留服务器的内容，其余修改仍会合入
+func MergeText(base, server, client string) (merged string, conflict bool) {
+	merged, conflict = diff3(splitLines(base), splitLines(server), splitLines(client))
+	if !conflict {
+		return merged, false
+	}
+	if wordMerged, wordConflict := diff3(splitWords(base), splitWords(server), splitWords(client)); !wordConflict {
+		return wordMerged, false
+	}
+	return merged, true
+}
+
+// splitLines 按行切分并保留换行符
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.SplitAfter(s, "\n")
+}
+
+// splitWords 切分为词、空白与标点；中日韩字符逐字切分
+func splitWords(s string) []string {
+	var tokens []string
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		j := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		case isWordRune(r):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		}
+		tokens = append(tokens, string(runes[i:j]))
+		i = j
+	}
+	return tokens
+}
+
+// isWordRune 判断字符是否属于可连续组成单词的字母或数字（不含中日韩字符）
+func isWordRune(r rune) bool {
+	if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+		return false
+	}
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// diff3 对三个 token 序列做三方合并
+// 以 base 中同时与 a、b 匹配的 token 为同步点，逐段比较两侧修改
+func diff3(base, a, b []string) (string, bool) {
+	matchA, okA := lcsMatch(base, a)
+	matchB, okB := lcsMatch(base, b)
+	if !okA || !okB {
+		return strings.Join(a, ""), true
+	}
+
+	var out strings.Builder
+	conflict := false
+	i, j, k := 0, 0, 0
+	emitChunk := func(baseEnd, aEnd, bEnd int) {
+		chunk, ok := mergeChunk(base[i:baseEnd], a[j:aEnd], b[k:bEnd])
+		if !ok {
+			conflict = true
+		}
+		for _, t := range chunk {
+			out.WriteString(t)
+		}
+	}
+
+	for bi := range base {
+		if matchA[bi] < 0 || matchB[bi] < 0 {
+			continue
+		}
+		emitChunk(bi, matchA[bi], matchB[bi])
+		out.WriteString(base[bi])
+		i, j, k = bi+1, matchA[bi]+1, matchB[bi]+1
+	}
+	emitChunk(len(base), len(a), len(b))
+
+	return out.String(), conflict
+}
+
+// mergeChunk 合并两个同步点之间的一段修改
+// 双方都修改时按各自对 base 的改动区间再细分，互不重叠（包括紧邻）的改动一并合入；
+// 改动重叠且内容不同时保留 a 并返回 false
+func mergeChunk(base, a, b []string) ([]string, bool) {
+	switch {
+	case equalTokens(a, base):
+		return b, true
+	case equalTokens(b, base), equalTokens(a, b):
+		return a, true
+	}
+	if merged, ok := mergeHunks(base, a, b); ok {
+		return merged, true
+	}
+	return a, false
+}
+
+// hunk 一侧对 base 的一处改动：以 repl 替换 base[start:end]，start == end 表示插入
+type hunk struct {
+	start, end int
+	repl       []string
+}
+
+// diffHunks 计算 other 相对 base 的改动区间，按 base 中的位置排列
+func diffHunks(base, other []string) ([]hunk, bool) {
+	match, ok := lcsMatch(base, other)
+	if !ok {
+		return nil, false
+	}
+	var hunks []hunk
+	i, j := 0, 0
+	for bi, oj := range match {
+		if oj < 0 {
+			continue
+		}
+		if i < bi || j < oj {
+			hunks = append(hunks, hunk{start: i, end: bi, repl: other[j:oj]})
+		}
+		i, j = bi+1, oj+1
+	}
+	if i < len(base) || j < len(other) {
+		hunks = append(hunks, hunk{start: i, end: len(base), repl: other[j:]})
+	}
+	return hunks, true
+}
+
+// overlaps 判断两处改动是否冲突：替换区间相交，或在同一位置插入
+// 插入点位于另一处改动的边界上（紧邻）时不算冲突
+func (h hunk) overlaps(o hunk) bool {
+	if h.start == h.end && o.start == o.end {
+		return h.start == o.start
+	}
+	return h.start < o.end && o.start < h.end
+}
+
+func (h hunk) equal(o hunk) bool {
+	return h.start == o.start && h.end == o.end && equalTokens(h.repl, o.repl)
+}
+
+// mergeHunks 将 a 与 b 对 base 的改动依次应用，相同的改动只应用一次；存在重叠的不同改动时返回 false
+func mergeHunks(base, a, b []string) ([]string, bool) {
+	hunksA, okA := diffHunks(base, a)
+	hunksB, okB := diffHunks(base, b)
+	if !okA || !okB {
+		return nil, false
+	}
+
+	var out []string
+	pos, x, y := 0, 0, 0
+	apply := func(h hunk) {
+		out = append(out, base[pos:h.start]...)
+		out = append(out, h.repl...)
+		pos = h.end
+	}
+	for x < len(hunksA) || y < len(hunksB) {
+		switch {
+		case y == len(hunksB):
+			apply(hunksA[x])
+			x++
+		case x == len(hunksA):
+			apply(hunksB[y])
+			y++
+		case hunksA[x].equal(hunksB[y]):
+			apply(hunksA[x])
+			x++
+			y++
+		case hunksA[x].overlaps(hunksB[y]):
+			return nil, false
+		case hunksA[x].start < hunksB[y].start || (hunksA[x].start == hunksB[y].start && hunksA[x].start == hunksA[x].end):
+			// 同一位置上的插入先于替换
+			apply(hunksA[x])
+			x++
+		default:
+			apply(hunksB[y])
+			y++
+		}
+	}
+	out = append(out, base[pos:]...)
+	return out, true
+}
+
+func equalTokens(x, y []string) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lcsMatch 计算 base 与 other 的最长公共子序列，返回 base 每个位置在 other 中的匹配下标（未匹配为 -1）
+func lcsMatch(base, other []string) ([]int, bool) {
+	n, m := len(base), len(other)
+	if n*m > maxDiffCells {
+		return nil, false
+	}
+
+	// lengths[i][j] 为 base[i:] 与 other[j:] 的 LCS 长度
+	lengths := make([][]int, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if base[i] == other[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	match := make([]int, n)
+	for i := range match {
+		match[i] = -1
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case base[i] == other[j]:
+			match[i] = j
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return match, true
+}
@@ -0,0 +1,170 @@
+package merge
+
+import "testing"
+
+func TestMergeText(t *testing.T) {
+	tests := []struct {
+		name         string
+		base         string
+		server       string
+		client       string
+		want         string
+		wantConflict bool
+	}{
+		{
+			name:   "unchanged",
+			base:   "a\nb\nc\n",
+			server: "a\nb\nc\n",
+			client: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:   "only server changed",
+			base:   "a\nb\nc\n",
+			server: "a\nB\nc\n",
+			client: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "only client changed",
+			base:   "a\nb\nc\n",
+			server: "a\nb\nc\n",
+			client: "a\nb\nC\n",
+			want:   "a\nb\nC\n",
+		},
+		{
+			name:   "separate lines",
+			base:   "a\nb\nc\nd\ne\n",
+			server: "A\nb\nc\nd\ne\n",
+			client: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "adjacent deletions",
+			base:   "a\nb\nc\nd\n",
+			server: "a\nc\nd\n",
+			client: "a\nb\nd\n",
+			want:   "a\nd\n",
+		},
+		{
+			name:   "adjacent replacements",
+			base:   "a\nb\nc\nd\n",
+			server: "a\nB\nc\nd\n",
+			client: "a\nb\nC\nd\n",
+			want:   "a\nB\nC\nd\n",
+		},
+		{
+			name:   "insertion next to a deletion",
+			base:   "a\nb\nc\n",
+			server: "a\nnew\nb\nc\n",
+			client: "a\nc\n",
+			want:   "a\nnew\nc\n",
+		},
+		{
+			name:   "identical edits",
+			base:   "a\nb\nc\n",
+			server: "a\nX\nc\n",
+			client: "a\nX\nc\n",
+			want:   "a\nX\nc\n",
+		},
+		{
+			name:   "identical edit plus a separate one",
+			base:   "a\nb\nc\nd\n",
+			server: "a\nX\nc\nd\n",
+			client: "a\nX\nc\nD\n",
+			want:   "a\nX\nc\nD\n",
+		},
+		{
+			name:   "same line, different words",
+			base:   "hello world\n",
+			server: "hello there world\n",
+			client: "hello world!\n",
+			want:   "hello there world!\n",
+		},
+		{
+			name:   "chinese characters",
+			base:   "今天开会",
+			server: "明天开会",
+			client: "今天开短会",
+			want:   "明天开短会",
+		},
+		{
+			name:         "same word changed differently",
+			base:         "hello world\n",
+			server:       "hello there\n",
+			client:       "hello friend\n",
+			want:         "hello there\n",
+			wantConflict: true,
+		},
+		{
+			name:         "conflict keeps the other changes",
+			base:         "a\nb\nc\nd\ne\n",
+			server:       "a\nserver\nc\nd\ne\n",
+			client:       "a\nclient\nc\nd\nE\n",
+			want:         "a\nserver\nc\nd\nE\n",
+			wantConflict: true,
+		},
+		{
+			name:         "insertions at the same place",
+			base:         "a\nb\n",
+			server:       "a\nx\nb\n",
+			client:       "a\ny\nb\n",
+			want:         "a\nx\nb\n",
+			wantConflict: true,
+		},
+		{
+			name:   "empty base, identical sides",
+			base:   "",
+			server: "new text",
+			client: "new text",
+			want:   "new text",
+		},
+		{
+			name:   "empty base, only client wrote",
+			base:   "",
+			server: "",
+			client: "new text",
+			want:   "new text",
+		},
+		{
+			name:         "empty base, different sides",
+			base:         "",
+			server:       "server",
+			client:       "client",
+			want:         "server",
+			wantConflict: true,
+		},
+		{
+			name:   "server cleared, client unchanged",
+			base:   "a\nb\n",
+			server: "",
+			client: "a\nb\n",
+			want:   "",
+		},
+		{
+			name:   "both cleared",
+			base:   "a\nb\n",
+			server: "",
+			client: "",
+			want:   "",
+		},
+		{
+			name:         "server cleared, client edited",
+			base:         "a\nb\n",
+			server:       "",
+			client:       "a\nB\n",
+			want:         "",
+			wantConflict: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflict := MergeText(tt.base, tt.server, tt.client)
+			if got != tt.want || conflict != tt.wantConflict {
+				t.Errorf("MergeText(%q, %q, %q) = %q, %v; want %q, %v",
+					tt.base, tt.server, tt.client, got, conflict, tt.want, tt.wantConflict)
+			}
+		})
+	}
+}
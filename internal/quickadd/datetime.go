@@ -0,0 +1,609 @@
+package quickadd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cnNum 阿拉伯数字或中文数字（至九十九）
+const cnNum = `(\d+|[零一二两三四五六七八九十]+)`
+
+var enNumbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+var cnDigits = map[rune]int{'零': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// parseNumber 解析阿拉伯数字、英文数词或中文数字（如 十二、二十、三十五）
+func parseNumber(s string) (int, bool) {
+	s = strings.ToLower(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	if n, ok := enNumbers[s]; ok {
+		return n, true
+	}
+	runes := []rune(s)
+	if len(runes) == 0 || len(runes) > 3 {
+		return 0, false
+	}
+	n, digit, seenTen := 0, -1, false
+	for _, r := range runes {
+		if r == '十' {
+			if seenTen {
+				return 0, false
+			}
+			if digit < 0 {
+				digit = 1
+			}
+			n, digit, seenTen = digit*10, -1, true
+			continue
+		}
+		d, ok := cnDigits[r]
+		if !ok || digit >= 0 {
+			return 0, false
+		}
+		digit = d
+	}
+	if digit >= 0 {
+		n += digit
+	}
+	return n, true
+}
+
+// weekday 识别英文星期名（至少前三个字母）或中文的 一…六、日、天
+func weekday(s string) (time.Weekday, bool) {
+	switch s {
+	case "一":
+		return time.Monday, true
+	case "二":
+		return time.Tuesday, true
+	case "三":
+		return time.Wednesday, true
+	case "四":
+		return time.Thursday, true
+	case "五":
+		return time.Friday, true
+	case "六":
+		return time.Saturday, true
+	case "日", "天":
+		return time.Sunday, true
+	}
+	s = strings.ToLower(s)
+	if len(s) < 3 {
+		return 0, false
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.HasPrefix(strings.ToLower(d.String()), s) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+var byDayCodes = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+const (
+	enWeekday     = `mon(?:day)?|tue(?:s|sday)?|wed(?:nesday)?|thu(?:r|rs|rsday)?|fri(?:day)?|sat(?:urday)?|sun(?:day)?`
+	enWeekdayFull = `monday|tuesday|wednesday|thursday|friday|saturday|sunday`
+	enMonth       = `jan(?:uary)?|feb(?:ruary)?|mar(?:ch)?|apr(?:il)?|may|june?|july?|aug(?:ust)?|sep(?:t|tember)?|oct(?:ober)?|nov(?:ember)?|dec(?:ember)?`
+)
+
+// fullWeekday 匹配完整的英文星期名
+var fullWeekday = regexp.MustCompile(`(?i)^(?:` + enWeekdayFull + `)$`)
+
+// month 识别英文月份名（至少前三个字母）
+func month(s string) (time.Month, bool) {
+	s = strings.ToLower(s)
+	if len(s) < 3 {
+		return 0, false
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), s[:3]) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// ============ 重复规则 ============
+
+// recurrenceRule 一条重复规则的写法，build 根据子匹配生成 RRULE，无法识别时返回空字符串
+type recurrenceRule struct {
+	re    *regexp.Regexp
+	build func(m []string) string
+}
+
+var freqUnits = map[string]string{
+	"day": "DAILY", "week": "WEEKLY", "month": "MONTHLY", "year": "YEARLY",
+	"天": "DAILY", "日": "DAILY", "周": "WEEKLY", "星期": "WEEKLY", "礼拜": "WEEKLY", "月": "MONTHLY", "年": "YEARLY",
+}
+
+var wordPattern = regexp.MustCompile(`(?i)[a-z]+`)
+
+var recurrenceRules = []recurrenceRule{
+	{regexp.MustCompile(`(?i)\bevery\s+(?:weekday|workday)s?\b|每个?工作日`), func([]string) string {
+		return "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR"
+	}},
+	{regexp.MustCompile(`(?i)\bevery\s+weekends?\b|每个?周末`), func([]string) string {
+		return "FREQ=WEEKLY;BYDAY=SA,SU"
+	}},
+	{regexp.MustCompile(`(?i)\bevery\s+((?:` + enWeekday + `)(?:\s*(?:,|and|&)\s*(?:` + enWeekday + `))*)\b`), func(m []string) string {
+		return byDayRule(wordPattern.FindAllString(m[1], -1), "and")
+	}},
+	{regexp.MustCompile(`每个?(?:周|星期|礼拜)([一二三四五六日天](?:[、,，和及]?[一二三四五六日天])*)`), func(m []string) string {
+		return byDayRule(strings.Split(strings.NewReplacer("、", "", ",", "", "，", "", "和", "", "及", "").Replace(m[1]), ""), "")
+	}},
+	{regexp.MustCompile(`每个?月` + cnNum + `[号日]`), func(m []string) string {
+		if day, ok := parseNumber(m[1]); ok && day >= 1 && day <= 31 {
+			return fmt.Sprintf("FREQ=MONTHLY;BYMONTHDAY=%d", day)
+		}
+		return ""
+	}},
+	{regexp.MustCompile(`(?i)\bevery\s+(other\s+|\d+\s+|[a-z]+\s+)?(day|week|month|year)s?\b`), func(m []string) string {
+		interval := 1
+		if n := strings.ToLower(strings.TrimSpace(m[1])); n == "other" {
+			interval = 2
+		} else if n != "" {
+			v, ok := parseNumber(n)
+			if !ok {
+				return ""
+			}
+			interval = v
+		}
+		return freqRule(freqUnits[strings.ToLower(m[2])], interval)
+	}},
+	{regexp.MustCompile(`(?i)\b(daily|weekly|monthly|yearly|annually)\b`), func(m []string) string {
+		switch strings.ToLower(m[1]) {
+		case "daily":
+			return "FREQ=DAILY"
+		case "weekly":
+			return "FREQ=WEEKLY"
+		case "monthly":
+			return "FREQ=MONTHLY"
+		}
+		return "FREQ=YEARLY"
+	}},
+	{regexp.MustCompile(`每` + cnNum + `?个?(天|日|周|星期|礼拜|月|年)`), func(m []string) string {
+		interval := 1
+		if m[1] != "" {
+			v, ok := parseNumber(m[1])
+			if !ok {
+				return ""
+			}
+			interval = v
+		}
+		return freqRule(freqUnits[m[2]], interval)
+	}},
+}
+
+// freqRule 生成按固定间隔重复的 RRULE
+func freqRule(freq string, interval int) string {
+	if interval < 1 || interval > 365 {
+		return ""
+	}
+	if interval == 1 {
+		return "FREQ=" + freq
+	}
+	return fmt.Sprintf("FREQ=%s;INTERVAL=%d", freq, interval)
+}
+
+// byDayRule 生成按星期重复的 RRULE，skip 为列表中的连接词
+func byDayRule(names []string, skip string) string {
+	seen := map[time.Weekday]bool{}
+	codes := []string{}
+	for _, name := range names {
+		if name == "" || strings.EqualFold(name, skip) {
+			continue
+		}
+		d, ok := weekday(name)
+		if !ok {
+			return ""
+		}
+		if !seen[d] {
+			seen[d] = true
+			codes = append(codes, byDayCodes[d])
+		}
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "FREQ=WEEKLY;BYDAY=" + strings.Join(codes, ",")
+}
+
+// parseRecurrence 识别 every day / every 2 weeks / every mon and wed / weekly / 每天 / 每两周 / 每周一三五 / 每月15号 等写法
+// “每隔 N 天”有歧义（N 天还是 N+1 天），不做识别
+func (p *parser) parseRecurrence() {
+	for _, rule := range recurrenceRules {
+		if p.find(rule.re, TokenRecurrence, func(m []string, _ int) bool {
+			p.result.RecurrenceRule = rule.build(m)
+			return p.result.RecurrenceRule != ""
+		}) {
+			return
+		}
+	}
+}
+
+// ============ 日期与时刻 ============
+
+// when 识别出的日期
+type when struct {
+	day     time.Time // 当天 0 点（用户时区）
+	exact   time.Time // 非零时为精确的时刻（如 in 2 hours），忽略 day 与时刻
+	defHour int       // 未指定时刻时的默认小时（如 tonight 为 20 点），-1 表示全天
+}
+
+// dateRule 一种日期写法，resolve 根据子匹配与当前时刻计算日期
+type dateRule struct {
+	re      *regexp.Regexp
+	resolve func(m []string, now time.Time) (when, bool)
+}
+
+// clock 识别出的时刻
+type clock struct {
+	hour, min int
+	// ambiguous 为 true 表示没有注明上午或下午的 12 小时制钟点（如“三点”），没有日期时取最近的一次
+	ambiguous bool
+}
+
+// timeRule 一种时刻写法，resolve 根据子匹配计算时刻
+type timeRule struct {
+	re      *regexp.Regexp
+	resolve func(m []string) (clock, bool)
+}
+
+// midnight 返回 t 当天 0 点，days 为向后偏移的天数
+func midnight(t time.Time, days int) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, 0, 0, 0, 0, t.Location())
+}
+
+// at 返回 day 当天的 h 点 min 分
+func at(day time.Time, h, min int) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), h, min, 0, 0, day.Location())
+}
+
+// onDay 返回全天的日期
+func onDay(day time.Time) (when, bool) {
+	return when{day: day, defHour: -1}, true
+}
+
+// calendarDate 按年月日构造日期，日期无效时返回 false；year 为 0 时取今天或之后最近的一次
+func calendarDate(now time.Time, year int, m time.Month, d int) (when, bool) {
+	y := year
+	if y == 0 {
+		y = now.Year()
+	}
+	day := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	if day.Month() != m || day.Day() != d {
+		return when{}, false
+	}
+	if year == 0 && day.Before(midnight(now, 0)) {
+		day = day.AddDate(1, 0, 0)
+	}
+	return onDay(day)
+}
+
+// weekdayFrom 返回 now 当天或之后（strict 为 true 时为之后）最近的星期 wd
+func weekdayFrom(now time.Time, wd time.Weekday, strict bool) time.Time {
+	days := (int(wd) - int(now.Weekday()) + 7) % 7
+	if days == 0 && strict {
+		days = 7
+	}
+	return midnight(now, days)
+}
+
+// weekOf 返回 now 所在周（周一开始）偏移 weeks 周后的星期 wd
+func weekOf(now time.Time, wd time.Weekday, weeks int) time.Time {
+	offset := (int(now.Weekday()) + 6) % 7
+	target := (int(wd) + 6) % 7
+	return midnight(now, target-offset+7*weeks)
+}
+
+// relativeDate 按数量与单位计算相对日期，小时与分钟返回精确时刻
+func relativeDate(now time.Time, n int, unit string) (when, bool) {
+	if n < 0 || n > 3650 {
+		return when{}, false
+	}
+	switch unit {
+	case "minute", "min", "分钟":
+		return when{exact: now.Add(time.Duration(n) * time.Minute)}, true
+	case "hour", "hr", "小时", "个小时", "钟头", "个钟头":
+		return when{exact: now.Add(time.Duration(n) * time.Hour)}, true
+	case "day", "天", "日":
+		return onDay(midnight(now, n))
+	case "week", "周", "星期", "个星期", "礼拜", "个礼拜":
+		return onDay(midnight(now, 7*n))
+	case "month", "月", "个月":
+		return onDay(midnight(now, 0).AddDate(0, n, 0))
+	case "year", "年":
+		return onDay(midnight(now, 0).AddDate(n, 0, 0))
+	}
+	return when{}, false
+}
+
+var dateRules = []dateRule{
+	// 2026-10-20、2026/10/20
+	{regexp.MustCompile(`(?i)\b(?:(?:on|by|due)\s+)?(\d{4})[-/](\d{1,2})[-/](\d{1,2})\b`), func(m []string, now time.Time) (when, bool) {
+		y, _ := strconv.Atoi(m[1])
+		mo, _ := strconv.Atoi(m[2])
+		d, _ := strconv.Atoi(m[3])
+		return calendarDate(now, y, time.Month(mo), d)
+	}},
+	// 2026年10月20日、10月20号
+	{regexp.MustCompile(`(?:(\d{4})年)?` + cnNum + `月` + cnNum + `[日号]`), func(m []string, now time.Time) (when, bool) {
+		y, _ := strconv.Atoi(m[1])
+		mo, ok1 := parseNumber(m[2])
+		d, ok2 := parseNumber(m[3])
+		if !ok1 || !ok2 || mo < 1 || mo > 12 {
+			return when{}, false
+		}
+		return calendarDate(now, y, time.Month(mo), d)
+	}},
+	// Oct 20、October 20th, 2026
+	{regexp.MustCompile(`(?i)\b(?:(?:on|by|due)\s+)?(` + enMonth + `)\.?\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s*(\d{4}))?\b`), func(m []string, now time.Time) (when, bool) {
+		mo, _ := month(m[1])
+		d, _ := strconv.Atoi(m[2])
+		y, _ := strconv.Atoi(m[3])
+		return calendarDate(now, y, mo, d)
+	}},
+	// 20 Oct、20th October 2026
+	{regexp.MustCompile(`(?i)\b(?:(?:on|by|due)\s+)?(\d{1,2})(?:st|nd|rd|th)?\s+(` + enMonth + `)\b\.?(?:,?\s*(\d{4})\b)?`), func(m []string, now time.Time) (when, bool) {
+		mo, _ := month(m[2])
+		d, _ := strconv.Atoi(m[1])
+		y, _ := strconv.Atoi(m[3])
+		return calendarDate(now, y, mo, d)
+	}},
+	// in 3 days、in an hour
+	{regexp.MustCompile(`(?i)\bin\s+(\d+|[a-z]+)\s+(minute|min|hour|hr|day|week|month|year)s?\b`), func(m []string, now time.Time) (when, bool) {
+		n, ok := parseNumber(m[1])
+		if !ok {
+			return when{}, false
+		}
+		return relativeDate(now, n, strings.ToLower(m[2]))
+	}},
+	// 3天后、两周以后、半小时后
+	{regexp.MustCompile(cnNum + `(分钟|个?小时|个?钟头|天|日|周|个?星期|个?礼拜|个?月|年)[之以]?后`), func(m []string, now time.Time) (when, bool) {
+		n, ok := parseNumber(m[1])
+		if !ok {
+			return when{}, false
+		}
+		return relativeDate(now, n, m[2])
+	}},
+	{regexp.MustCompile(`半(个?小时|个?钟头)[之以]?后`), func(m []string, now time.Time) (when, bool) {
+		return when{exact: now.Add(30 * time.Minute)}, true
+	}},
+	// today、tonight、tomorrow、day after tomorrow
+	{regexp.MustCompile(`(?i)\b(?:(?:by|due)\s+)?(today|tonight|tomorrow|tmrw?|day after tomorrow)\b`), func(m []string, now time.Time) (when, bool) {
+		switch strings.ToLower(m[1]) {
+		case "today":
+			return onDay(midnight(now, 0))
+		case "tonight":
+			return when{day: midnight(now, 0), defHour: 20}, true
+		case "day after tomorrow":
+			return onDay(midnight(now, 2))
+		}
+		return onDay(midnight(now, 1))
+	}},
+	// 今天、今晚、明早、后天、大后天
+	{regexp.MustCompile(`大后天|今天|今日|今晚|明天|明日|明早|明晚|后天`), func(m []string, now time.Time) (when, bool) {
+		switch m[0] {
+		case "今天", "今日":
+			return onDay(midnight(now, 0))
+		case "今晚":
+			return when{day: midnight(now, 0), defHour: 20}, true
+		case "明早":
+			return when{day: midnight(now, 1), defHour: 9}, true
+		case "明晚":
+			return when{day: midnight(now, 1), defHour: 20}, true
+		case "后天":
+			return onDay(midnight(now, 2))
+		case "大后天":
+			return onDay(midnight(now, 3))
+		}
+		return onDay(midnight(now, 1))
+	}},
+	// next monday、this fri、on tue、by next friday、friday；单独的缩写（如 mon、sat）不视为日期
+	{regexp.MustCompile(`(?i)\b(?:(?:on|by|due)\s+)?(?:(next|this)\s+)?(` + enWeekday + `)\b`), func(m []string, now time.Time) (when, bool) {
+		wd, ok := weekday(m[2])
+		if !ok || (m[0] == m[2] && !fullWeekday.MatchString(m[2])) {
+			return when{}, false
+		}
+		return onDay(weekdayFrom(now, wd, strings.EqualFold(m[1], "next")))
+	}},
+	// next week、by next month、next year
+	{regexp.MustCompile(`(?i)\b(?:(?:by|due)\s+)?next\s+(week|month|year)\b`), func(m []string, now time.Time) (when, bool) {
+		switch strings.ToLower(m[1]) {
+		case "week":
+			return onDay(weekOf(now, time.Monday, 1))
+		case "month":
+			return onDay(time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()))
+		}
+		return onDay(time.Date(now.Year()+1, time.January, 1, 0, 0, 0, 0, now.Location()))
+	}},
+	// 下周五、下下周一、本周三、周五
+	{regexp.MustCompile(`(下下|下|这|本)?个?(?:周|星期|礼拜)([一二三四五六日天])`), func(m []string, now time.Time) (when, bool) {
+		wd, _ := weekday(m[2])
+		switch m[1] {
+		case "下":
+			return onDay(weekOf(now, wd, 1))
+		case "下下":
+			return onDay(weekOf(now, wd, 2))
+		case "这", "本":
+			return onDay(weekOf(now, wd, 0))
+		}
+		return onDay(weekdayFrom(now, wd, false))
+	}},
+	// 下周、下个月、明年
+	{regexp.MustCompile(`下个?(?:周|星期|礼拜)|下个?月|明年`), func(m []string, now time.Time) (when, bool) {
+		switch {
+		case strings.Contains(m[0], "月"):
+			return onDay(time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()))
+		case m[0] == "明年":
+			return onDay(time.Date(now.Year()+1, time.January, 1, 0, 0, 0, 0, now.Location()))
+		}
+		return onDay(weekOf(now, time.Monday, 1))
+	}},
+}
+
+// cnPeriods 中文时段，决定 12 小时制的钟点是上午还是下午
+const cnPeriods = `(凌晨|早上|早晨|清晨|上午|中午|午后|下午|傍晚|晚上|夜里|晚)?`
+
+var timeRules = []timeRule{
+	// 下午5点、晚上八点半、上午9点15分、5点一刻
+	{regexp.MustCompile(cnPeriods + cnNum + `[点點时]钟?(?:(半)|(一刻)|(三刻)|` + cnNum + `分?)?`), func(m []string) (clock, bool) {
+		h, ok := parseNumber(m[2])
+		// 没有时段与分钟的“一点”多为“一点儿”，不视为钟点
+		if !ok || (m[1] == "" && m[2] == "一" && m[3]+m[4]+m[5]+m[6] == "") {
+			return clock{}, false
+		}
+		min := 0
+		switch {
+		case m[3] != "":
+			min = 30
+		case m[4] != "":
+			min = 15
+		case m[5] != "":
+			min = 45
+		case m[6] != "":
+			if min, ok = parseNumber(m[6]); !ok {
+				return clock{}, false
+			}
+		}
+		return cnClock(m[1], h, min)
+	}},
+	// 下午5:30（没有时段时按下面的 24 小时制处理）
+	{regexp.MustCompile(strings.TrimSuffix(cnPeriods, "?") + `\s*(\d{1,2})[:：](\d{2})`), func(m []string) (clock, bool) {
+		h, _ := strconv.Atoi(m[2])
+		min, _ := strconv.Atoi(m[3])
+		return cnClock(m[1], h, min)
+	}},
+	// 5pm、5:30 p.m.、at 11am
+	{regexp.MustCompile(`(?i)\b(?:at\s+)?(\d{1,2})(?::(\d{2}))?\s*([ap])\.?m\b\.?`), func(m []string) (clock, bool) {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		if h < 1 || h > 12 || min > 59 {
+			return clock{}, false
+		}
+		if h == 12 {
+			h = 0
+		}
+		if strings.EqualFold(m[3], "p") {
+			h += 12
+		}
+		return clock{hour: h, min: min}, true
+	}},
+	// 17:00、at 9:30
+	{regexp.MustCompile(`(?i)\b(?:at\s+)?(\d{1,2}):(\d{2})\b`), func(m []string) (clock, bool) {
+		h, _ := strconv.Atoi(m[1])
+		min, _ := strconv.Atoi(m[2])
+		return clock{hour: h, min: min}, h <= 23 && min <= 59
+	}},
+	// noon
+	{regexp.MustCompile(`(?i)\b(?:at\s+)?(?:noon|midday)\b`), func([]string) (clock, bool) {
+		return clock{hour: 12}, true
+	}},
+}
+
+// cnClock 按中文时段换算为 24 小时制，没有时段的 1～11 点记为不确定
+func cnClock(period string, h, min int) (clock, bool) {
+	c := clock{hour: h, min: min, ambiguous: period == "" && h >= 1 && h < 12}
+	switch period {
+	case "下午", "午后", "傍晚", "晚上", "夜里", "晚":
+		if h < 12 {
+			c.hour += 12
+		}
+	case "中午":
+		if h < 3 {
+			c.hour += 12
+		}
+	}
+	return c, c.hour <= 23 && min <= 59
+}
+
+// parseDue 识别日期与时刻并组合为截止时间
+// 只有时刻时取今天（已过则为明天）；按星期或每月某天重复而没有日期时取第一次发生的那天
+func (p *parser) parseDue() {
+	var date *when
+	for _, rule := range dateRules {
+		if p.find(rule.re, TokenDue, func(m []string, _ int) bool {
+			w, ok := rule.resolve(m, p.now)
+			if ok {
+				date = &w
+			}
+			return ok
+		}) {
+			break
+		}
+	}
+	if date != nil && !date.exact.IsZero() {
+		due := date.exact.UTC()
+		p.result.DueAt = &due
+		return
+	}
+
+	var c clock
+	hasTime := false
+	for _, rule := range timeRules {
+		if p.find(rule.re, TokenTime, func(m []string, _ int) bool {
+			c, hasTime = rule.resolve(m)
+			return hasTime
+		}) {
+			break
+		}
+	}
+	hour, min := c.hour, c.min
+
+	var due time.Time
+	switch {
+	case date != nil:
+		due = date.day
+		if hasTime {
+			due = at(due, hour, min)
+		} else if date.defHour >= 0 {
+			due = at(due, date.defHour, 0)
+		} else {
+			p.result.AllDay = true
+		}
+	case strings.Contains(p.result.RecurrenceRule, "BYDAY") || strings.Contains(p.result.RecurrenceRule, "BYMONTHDAY"):
+		for i := 0; i <= 366; i++ {
+			day := midnight(p.now, i)
+			if p.matchesRecurrence(day) && (!hasTime || at(day, hour, min).After(p.now)) {
+				due = at(day, hour, min)
+				break
+			}
+		}
+		if due.IsZero() {
+			return
+		}
+		p.result.AllDay = !hasTime
+	case hasTime:
+		due = at(p.now, hour, min)
+		if !due.After(p.now) && c.ambiguous {
+			due = at(p.now, hour+12, min)
+		}
+		if !due.After(p.now) {
+			due = at(midnight(p.now, 1), hour, min)
+		}
+	default:
+		return
+	}
+	due = due.UTC()
+	p.result.DueAt = &due
+}
+
+// matchesRecurrence 判断某天是否符合已识别的 BYDAY 或 BYMONTHDAY 重复规则
+func (p *parser) matchesRecurrence(day time.Time) bool {
+	for _, part := range strings.Split(p.result.RecurrenceRule, ";") {
+		switch {
+		case strings.HasPrefix(part, "BYDAY="):
+			return strings.Contains(part, byDayCodes[day.Weekday()])
+		case strings.HasPrefix(part, "BYMONTHDAY="):
+			return part == fmt.Sprintf("BYMONTHDAY=%d", day.Day())
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+// Package quickadd 从一行快速添加文本中解析任务的截止时间、优先级、标签与重复规则，支持英文与中文，
+// 例如 "Pay invoice tomorrow 5pm !high #finance every month" 或 "明天下午5点交房租 !高 #财务 每月"
+// 识别出的片段从文本中移除，剩余部分作为任务标题；日期与时刻按调用方给出的时区理解
+package quickadd
+
+import (
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 识别出的片段类型
+const (
+	TokenDue        = "due"
+	TokenTime       = "time"
+	TokenPriority   = "priority"
+	TokenTag        = "tag"
+	TokenRecurrence = "recurrence"
+)
+
+// Token 文本中被识别的一个片段
+type Token struct {
+	Type string `json:"type"`
+	Text string `json:"text"` // 原文中的片段
+}
+
+// Result 解析结果，未识别出的字段为零值
+type Result struct {
+	Title          string     `json:"title"`
+	DueAt          *time.Time `json:"due_at"`  // UTC
+	AllDay         bool       `json:"all_day"` // 只识别出日期，截止时间取当天 0 点（与 YYYY-MM-DD 一致）
+	Priority       string     `json:"priority"`
+	Tags           []string   `json:"tags"`
+	RecurrenceRule string     `json:"recurrence_rule"`
+	Tokens         []Token    `json:"tokens"`
+}
+
+// mask 已识别片段在工作文本中的占位字节，不会被任何规则匹配
+const mask = 0
+
+// parser 一次解析的状态
+type parser struct {
+	text   string // 原文
+	work   []byte // 工作文本，与原文等长，已识别的片段替换为 mask
+	now    time.Time
+	result *Result
+}
+
+// Parse 解析快速添加文本，now 的时区即用户所在的时区
+func Parse(text string, now time.Time) *Result {
+	text = strings.TrimSpace(text)
+	p := &parser{
+		text:   text,
+		work:   []byte(text),
+		now:    now,
+		result: &Result{Tags: []string{}, Tokens: []Token{}},
+	}
+	p.parseTags()
+	p.parsePriority()
+	p.parseRecurrence()
+	p.parseDue()
+	p.result.Title = p.title()
+	return p.result
+}
+
+// find 在未识别的部分中按出现顺序查找 re 的匹配，第一个被 accept 接受的匹配记为 kind 类型的片段并从工作文本中移除
+// accept 接收子匹配与匹配的起始位置
+func (p *parser) find(re *regexp.Regexp, kind string, accept func(m []string, start int) bool) bool {
+	work := string(p.work)
+	for _, loc := range re.FindAllStringSubmatchIndex(work, -1) {
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = work[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		if !accept(m, loc[0]) {
+			continue
+		}
+		for i := loc[0]; i < loc[1]; i++ {
+			p.work[i] = mask
+		}
+		p.result.Tokens = append(p.result.Tokens, Token{Type: kind, Text: strings.TrimSpace(p.text[loc[0]:loc[1]])})
+		return true
+	}
+	return false
+}
+
+var tagPattern = regexp.MustCompile(`[#＃]([^\s#＃!！,，;；。]+)`)
+
+// parseTags 识别 #标签，标签须以字母或汉字开头（#123 多为编号，不视为标签），# 前为字母或数字时（如 C#、issue#12）也不视为标签
+func (p *parser) parseTags() {
+	seen := map[string]bool{}
+	for p.find(tagPattern, TokenTag, func(m []string, start int) bool {
+		if first, _ := utf8.DecodeRuneInString(m[1]); !unicode.IsLetter(first) {
+			return false
+		}
+		if start > 0 {
+			r, _ := utf8.DecodeLastRune(p.work[:start])
+			if r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return false
+			}
+		}
+		return true
+	}) {
+		tag := p.result.Tokens[len(p.result.Tokens)-1].Text
+		_, size := utf8.DecodeRuneInString(tag)
+		name := tag[size:]
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			p.result.Tags = append(p.result.Tags, name)
+		}
+	}
+}
+
+var priorityPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)[!！](?:(high|medium|med|low|urgent|[123])\b|(高|中|低|紧急))`),
+	regexp.MustCompile(`(?i)\b(high|medium|low)[ -]priority\b`),
+	regexp.MustCompile(`(?i)\bpriority\s*:?\s*(high|medium|low)\b`),
+	regexp.MustCompile(`(高|中|低)优先级`),
+	regexp.MustCompile(`优先级\s*[:：为是]?\s*(高|中|低)`),
+}
+
+var priorityWords = map[string]string{
+	"high": "high", "urgent": "high", "1": "high", "高": "high", "紧急": "high",
+	"medium": "medium", "med": "medium", "2": "medium", "中": "medium",
+	"low": "low", "3": "low", "低": "low",
+}
+
+// parsePriority 识别 !high、!1、!高、high priority、高优先级 等写法
+func (p *parser) parsePriority() {
+	for _, re := range priorityPatterns {
+		if p.find(re, TokenPriority, func(m []string, _ int) bool {
+			for _, g := range m[1:] {
+				if v, ok := priorityWords[strings.ToLower(g)]; ok {
+					p.result.Priority = v
+					return true
+				}
+			}
+			return false
+		}) {
+			return
+		}
+	}
+}
+
+// title 移除已识别的片段后整理出标题：片段两侧都是中文等非 ASCII 字符时直接拼接，否则以一个空格分隔
+func (p *parser) title() string {
+	var b strings.Builder
+	gap, masked := false, false
+	var last rune
+	for _, r := range string(p.work) {
+		if r == mask || unicode.IsSpace(r) {
+			gap = true
+			masked = masked || r == mask
+			continue
+		}
+		if gap && b.Len() > 0 && (!masked || last < utf8.RuneSelf || r < utf8.RuneSelf) {
+			b.WriteByte(' ')
+		}
+		gap, masked = false, false
+		b.WriteRune(r)
+		last = r
+	}
+	return strings.Trim(b.String(), " ,，;；、")
+}
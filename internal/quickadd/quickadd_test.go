@@ -0,0 +1,79 @@
+package quickadd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	shanghai := time.FixedZone("CST", 8*3600)
+	// 2026-10-16 是周五
+	now := time.Date(2026, 10, 16, 10, 0, 0, 0, shanghai)
+
+	tests := []struct {
+		text     string
+		title    string
+		due      string // UTC，空字符串表示没有截止时间
+		allDay   bool
+		priority string
+		tags     []string
+		rule     string
+	}{
+		// 英文
+		{
+			text: "Pay invoice tomorrow 5pm !high #finance every month", title: "Pay invoice",
+			due: "2026-10-17T09:00:00Z", priority: "high", tags: []string{"finance"}, rule: "FREQ=MONTHLY",
+		},
+		{text: "Call mom next friday", title: "Call mom", due: "2026-10-22T16:00:00Z", allDay: true},
+		{text: "Submit report in 2 hours", title: "Submit report", due: "2026-10-16T04:00:00Z"},
+		{
+			text: "standup every weekday 9:30", title: "standup",
+			due: "2026-10-19T01:30:00Z", rule: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR",
+		},
+		{text: "Water plants every other day high priority", title: "Water plants", priority: "high", rule: "FREQ=DAILY;INTERVAL=2"},
+		{text: "buy milk", title: "buy milk"},
+		// 中文
+		{
+			text: "明天下午5点交房租 !高 #财务 每月", title: "交房租",
+			due: "2026-10-17T09:00:00Z", priority: "high", tags: []string{"财务"}, rule: "FREQ=MONTHLY",
+		},
+		{text: "下周三开会 !中", title: "开会", due: "2026-10-20T16:00:00Z", allDay: true, priority: "medium"},
+		{text: "3天后提交报告 #工作", title: "提交报告", due: "2026-10-18T16:00:00Z", allDay: true, tags: []string{"工作"}},
+		{text: "三点给客户打电话", title: "给客户打电话", due: "2026-10-16T07:00:00Z"},
+		{text: "每周一三五健身", title: "健身", due: "2026-10-15T16:00:00Z", allDay: true, rule: "FREQ=WEEKLY;BYDAY=MO,WE,FR"},
+		// 标签
+		{text: "Fix #123 before release #backend", title: "Fix #123 before release", tags: []string{"backend"}},
+		{text: "#42", title: "#42"},
+		{text: "Review #1a and #v2", title: "Review #1a and", tags: []string{"v2"}},
+		{text: "Learn C# and close issue#12 #dev", title: "Learn C# and close issue#12", tags: []string{"dev"}},
+		{text: "#Work report #work", title: "report", tags: []string{"Work"}},
+		{text: "修复 #123 问题 ＃后端", title: "修复 #123 问题", tags: []string{"后端"}},
+		{text: "整理#文档，#2号会议室", title: "整理，#2号会议室", tags: []string{"文档"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			r := Parse(tt.text, now)
+			if r.Title != tt.title {
+				t.Errorf("title = %q, want %q", r.Title, tt.title)
+			}
+			due := ""
+			if r.DueAt != nil {
+				due = r.DueAt.Format(time.RFC3339)
+			}
+			if due != tt.due || r.AllDay != tt.allDay {
+				t.Errorf("due = %q (all day %v), want %q (all day %v)", due, r.AllDay, tt.due, tt.allDay)
+			}
+			if r.Priority != tt.priority {
+				t.Errorf("priority = %q, want %q", r.Priority, tt.priority)
+			}
+			if strings.Join(r.Tags, ",") != strings.Join(tt.tags, ",") {
+				t.Errorf("tags = %q, want %q", r.Tags, tt.tags)
+			}
+			if r.RecurrenceRule != tt.rule {
+				t.Errorf("recurrence = %q, want %q", r.RecurrenceRule, tt.rule)
+			}
+		})
+	}
+}
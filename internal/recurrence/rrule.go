@@ -0,0 +1,357 @@
+// Package recurrence 解析 iCalendar RRULE 子集并计算重复任务的下一次发生时间
+// 支持 FREQ（DAILY、WEEKLY、MONTHLY、YEARLY）、INTERVAL、BYDAY、BYMONTHDAY、COUNT、UNTIL，
+// 所有计算均以 UTC 进行
+package recurrence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 支持的重复频率
+const (
+	Daily   = "DAILY"
+	Weekly  = "WEEKLY"
+	Monthly = "MONTHLY"
+	Yearly  = "YEARLY"
+)
+
+// maxPeriods 查找下一次发生时间时最多检查的周期数
+const maxPeriods = 1000
+
+var weekdayCodes = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// WeekdayNum BYDAY 中的一项，N 为月内序号（1 表示第一个，-1 表示最后一个，0 表示每个）
+type WeekdayNum struct {
+	Day time.Weekday
+	N   int
+}
+
+// Rule 解析后的重复规则
+type Rule struct {
+	Freq       string
+	Interval   int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	Count      int       // 0 表示不限次数
+	Until      time.Time // 零值表示不限结束时间
+}
+
+// Parse 解析 RRULE 字符串，如 "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE;COUNT=10"，可带 "RRULE:" 前缀
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("重复规则不能为空")
+	}
+
+	r := &Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("无效的规则项: %s", part)
+		}
+		key, value := kv[0], kv[1]
+		if seen[key] {
+			return nil, fmt.Errorf("规则项重复: %s", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch value {
+			case Daily, Weekly, Monthly, Yearly:
+				r.Freq = value
+			default:
+				return nil, fmt.Errorf("不支持的 FREQ: %s", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 1000 {
+				return nil, fmt.Errorf("INTERVAL 必须是 1 到 1000 之间的整数")
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("COUNT 必须是正整数")
+			}
+			r.Count = n
+		case "UNTIL":
+			t, err := parseUntil(value)
+			if err != nil {
+				return nil, err
+			}
+			r.Until = t
+		case "BYDAY":
+			for _, item := range strings.Split(value, ",") {
+				wd, err := parseWeekdayNum(item)
+				if err != nil {
+					return nil, err
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, item := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(item)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("BYMONTHDAY 必须在 1..31 或 -31..-1 之间: %s", item)
+				}
+				r.ByMonthDay = append(r.ByMonthDay, n)
+			}
+		default:
+			return nil, fmt.Errorf("不支持的规则项: %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("缺少 FREQ")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT 与 UNTIL 不能同时指定")
+	}
+	for _, wd := range r.ByDay {
+		if wd.N != 0 && r.Freq != Monthly && r.Freq != Yearly {
+			return nil, fmt.Errorf("BYDAY 序号只能用于 MONTHLY 或 YEARLY")
+		}
+	}
+	return r, nil
+}
+
+// parseUntil 解析 UNTIL，支持 20060102T150405Z 与 20060102 两种格式
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", value); err == nil {
+		// 只给出日期时包含当天全天
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("UNTIL 格式无效，应为 YYYYMMDD 或 YYYYMMDDTHHMMSSZ")
+}
+
+// parseWeekdayNum 解析 BYDAY 项，如 MO、1MO、-1FR
+func parseWeekdayNum(item string) (WeekdayNum, error) {
+	if len(item) < 2 {
+		return WeekdayNum{}, fmt.Errorf("无效的 BYDAY: %s", item)
+	}
+	day, ok := weekdayCodes[item[len(item)-2:]]
+	if !ok {
+		return WeekdayNum{}, fmt.Errorf("无效的 BYDAY: %s", item)
+	}
+	wd := WeekdayNum{Day: day}
+	if prefix := item[:len(item)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return WeekdayNum{}, fmt.Errorf("无效的 BYDAY 序号: %s", item)
+		}
+		wd.N = n
+	}
+	return wd, nil
+}
+
+// String 返回规范化的 RRULE 字符串
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + r.Freq}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, wd := range r.ByDay {
+			days[i] = wd.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	return strings.Join(parts, ";")
+}
+
+func (wd WeekdayNum) String() string {
+	for code, day := range weekdayCodes {
+		if day == wd.Day {
+			if wd.N != 0 {
+				return strconv.Itoa(wd.N) + code
+			}
+			return code
+		}
+	}
+	return ""
+}
+
+// Next 返回以 dtstart 为起点的序列中晚于 after 的第一次发生时间
+// 超过 UNTIL 或在检查范围内找不到时返回 false；COUNT 由调用方按已生成的次数判断
+func (r *Rule) Next(dtstart, after time.Time) (time.Time, bool) {
+	dtstart = dtstart.UTC()
+	after = after.UTC()
+
+	start := r.periodNear(dtstart, after)
+	for i := start; i < start+maxPeriods; i++ {
+		for _, t := range r.periodOccurrences(dtstart, i) {
+			if t.Before(dtstart) || !t.After(after) {
+				continue
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return time.Time{}, false
+			}
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// periodNear 估算 after 所在周期的序号（向前留出一个周期的余量）
+func (r *Rule) periodNear(dtstart, after time.Time) int {
+	if !after.After(dtstart) {
+		return 0
+	}
+	var periods int
+	switch r.Freq {
+	case Daily:
+		periods = int(after.Sub(dtstart).Hours() / 24)
+	case Weekly:
+		periods = int(after.Sub(dtstart).Hours() / (24 * 7))
+	case Monthly:
+		periods = (after.Year()-dtstart.Year())*12 + int(after.Month()) - int(dtstart.Month())
+	case Yearly:
+		periods = after.Year() - dtstart.Year()
+	}
+	if n := periods/r.Interval - 1; n > 0 {
+		return n
+	}
+	return 0
+}
+
+// periodOccurrences 返回第 i 个周期内的全部发生时间（升序）
+func (r *Rule) periodOccurrences(dtstart time.Time, i int) []time.Time {
+	clock := dtstart.Sub(dateOf(dtstart))
+	var days []time.Time
+
+	switch r.Freq {
+	case Daily:
+		day := dateOf(dtstart).AddDate(0, 0, i*r.Interval)
+		if r.matchesWeekday(day) && r.matchesMonthDay(day) {
+			days = append(days, day)
+		}
+	case Weekly:
+		offset := (int(dtstart.Weekday()) + 6) % 7 // 以周一为一周的开始
+		weekStart := dateOf(dtstart).AddDate(0, 0, -offset+i*r.Interval*7)
+		for d := 0; d < 7; d++ {
+			day := weekStart.AddDate(0, 0, d)
+			if len(r.ByDay) == 0 && day.Weekday() != dtstart.Weekday() {
+				continue
+			}
+			if r.matchesWeekday(day) && r.matchesMonthDay(day) {
+				days = append(days, day)
+			}
+		}
+	case Monthly:
+		first := time.Date(dtstart.Year(), dtstart.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, i*r.Interval, 0)
+		days = r.monthDays(first, dtstart.Day())
+	case Yearly:
+		first := time.Date(dtstart.Year()+i*r.Interval, dtstart.Month(), 1, 0, 0, 0, 0, time.UTC)
+		days = r.monthDays(first, dtstart.Day())
+	}
+
+	result := make([]time.Time, len(days))
+	for j, day := range days {
+		result[j] = day.Add(clock)
+	}
+	return result
+}
+
+// monthDays 返回某月内符合 BYMONTHDAY/BYDAY 的日期，都未指定时为起始日期的同一天（该月没有这一天则跳过）
+func (r *Rule) monthDays(first time.Time, defaultDay int) []time.Time {
+	daysInMonth := first.AddDate(0, 1, -1).Day()
+	var days []time.Time
+	for d := 1; d <= daysInMonth; d++ {
+		day := first.AddDate(0, 0, d-1)
+		switch {
+		case len(r.ByMonthDay) == 0 && len(r.ByDay) == 0:
+			if d != defaultDay {
+				continue
+			}
+		case len(r.ByMonthDay) > 0 && !r.matchesMonthDay(day):
+			continue
+		case len(r.ByDay) > 0 && !r.matchesWeekdayInMonth(day, daysInMonth):
+			continue
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// matchesWeekday 判断日期是否满足 BYDAY（不含序号），未指定时总是满足
+func (r *Rule) matchesWeekday(day time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Day == day.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesWeekdayInMonth 判断日期是否满足带序号的 BYDAY，如每月第一个周一或最后一个周五
+func (r *Rule) matchesWeekdayInMonth(day time.Time, daysInMonth int) bool {
+	for _, wd := range r.ByDay {
+		if wd.Day != day.Weekday() {
+			continue
+		}
+		switch {
+		case wd.N == 0:
+			return true
+		case wd.N > 0 && (day.Day()-1)/7+1 == wd.N:
+			return true
+		case wd.N < 0 && (daysInMonth-day.Day())/7+1 == -wd.N:
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMonthDay 判断日期是否满足 BYMONTHDAY（负数从月末倒数），未指定时总是满足
+func (r *Rule) matchesMonthDay(day time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, md := range r.ByMonthDay {
+		if md == day.Day() || (md < 0 && daysInMonth+md+1 == day.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+// dateOf 截取 UTC 日期部分
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,297 @@
+package recurrence
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func utc(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t.UTC()
+}
+
+// occurrences 从 dtstart 开始连续调用 Next，最多返回 n 次发生时间（不含 dtstart 本身）
+func occurrences(r *Rule, dtstart time.Time, n int) []string {
+	var out []string
+	after := dtstart
+	for len(out) < n {
+		next, ok := r.Next(dtstart, after)
+		if !ok {
+			break
+		}
+		out = append(out, next.UTC().Format(time.RFC3339))
+		after = next
+	}
+	return out
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart string
+		n       int
+		want    []string
+	}{
+		{
+			name:    "daily",
+			rule:    "FREQ=DAILY",
+			dtstart: "2026-03-01T09:00:00Z",
+			n:       3,
+			want:    []string{"2026-03-02T09:00:00Z", "2026-03-03T09:00:00Z", "2026-03-04T09:00:00Z"},
+		},
+		{
+			name:    "daily interval across month end",
+			rule:    "FREQ=DAILY;INTERVAL=3",
+			dtstart: "2026-01-29T08:30:00Z",
+			n:       3,
+			want:    []string{"2026-02-01T08:30:00Z", "2026-02-04T08:30:00Z", "2026-02-07T08:30:00Z"},
+		},
+		{
+			name:    "weekly defaults to the start weekday",
+			rule:    "FREQ=WEEKLY",
+			dtstart: "2026-10-14T18:00:00Z", // 周三
+			n:       2,
+			want:    []string{"2026-10-21T18:00:00Z", "2026-10-28T18:00:00Z"},
+		},
+		{
+			name:    "weekly byday",
+			rule:    "FREQ=WEEKLY;BYDAY=MO,WE,FR",
+			dtstart: "2026-10-12T07:00:00Z", // 周一
+			n:       4,
+			want:    []string{"2026-10-14T07:00:00Z", "2026-10-16T07:00:00Z", "2026-10-19T07:00:00Z", "2026-10-21T07:00:00Z"},
+		},
+		{
+			name:    "biweekly byday",
+			rule:    "FREQ=WEEKLY;INTERVAL=2;BYDAY=TU,TH",
+			dtstart: "2026-10-13T10:00:00Z", // 周二
+			n:       3,
+			want:    []string{"2026-10-15T10:00:00Z", "2026-10-27T10:00:00Z", "2026-10-29T10:00:00Z"},
+		},
+		{
+			name:    "monthly on the 31st skips short months",
+			rule:    "FREQ=MONTHLY",
+			dtstart: "2026-01-31T12:00:00Z",
+			n:       3,
+			want:    []string{"2026-03-31T12:00:00Z", "2026-05-31T12:00:00Z", "2026-07-31T12:00:00Z"},
+		},
+		{
+			name:    "monthly on the 30th skips february",
+			rule:    "FREQ=MONTHLY",
+			dtstart: "2027-01-30T12:00:00Z",
+			n:       2,
+			want:    []string{"2027-03-30T12:00:00Z", "2027-04-30T12:00:00Z"},
+		},
+		{
+			name:    "last day of month",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=-1",
+			dtstart: "2027-12-31T09:00:00Z",
+			n:       4,
+			want:    []string{"2028-01-31T09:00:00Z", "2028-02-29T09:00:00Z", "2028-03-31T09:00:00Z", "2028-04-30T09:00:00Z"},
+		},
+		{
+			name:    "bymonthday 31 only in long months",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=31",
+			dtstart: "2026-03-31T09:00:00Z",
+			n:       2,
+			want:    []string{"2026-05-31T09:00:00Z", "2026-07-31T09:00:00Z"},
+		},
+		{
+			name:    "several month days",
+			rule:    "FREQ=MONTHLY;BYMONTHDAY=1,15",
+			dtstart: "2026-10-01T09:00:00Z",
+			n:       3,
+			want:    []string{"2026-10-15T09:00:00Z", "2026-11-01T09:00:00Z", "2026-11-15T09:00:00Z"},
+		},
+		{
+			name:    "first monday",
+			rule:    "FREQ=MONTHLY;BYDAY=1MO",
+			dtstart: "2026-10-05T09:00:00Z",
+			n:       2,
+			want:    []string{"2026-11-02T09:00:00Z", "2026-12-07T09:00:00Z"},
+		},
+		{
+			name:    "last friday",
+			rule:    "FREQ=MONTHLY;BYDAY=-1FR",
+			dtstart: "2026-10-30T16:00:00Z",
+			n:       2,
+			want:    []string{"2026-11-27T16:00:00Z", "2026-12-25T16:00:00Z"},
+		},
+		{
+			name:    "yearly on leap day",
+			rule:    "FREQ=YEARLY",
+			dtstart: "2024-02-29T00:00:00Z",
+			n:       2,
+			want:    []string{"2028-02-29T00:00:00Z", "2032-02-29T00:00:00Z"},
+		},
+		{
+			name:    "yearly interval",
+			rule:    "FREQ=YEARLY;INTERVAL=2",
+			dtstart: "2026-06-15T10:00:00Z",
+			n:       2,
+			want:    []string{"2028-06-15T10:00:00Z", "2030-06-15T10:00:00Z"},
+		},
+		{
+			name:    "until with time is inclusive",
+			rule:    "FREQ=DAILY;UNTIL=20261003T090000Z",
+			dtstart: "2026-10-01T09:00:00Z",
+			n:       5,
+			want:    []string{"2026-10-02T09:00:00Z", "2026-10-03T09:00:00Z"},
+		},
+		{
+			name:    "until date covers the whole day",
+			rule:    "FREQ=DAILY;UNTIL=20261003",
+			dtstart: "2026-10-01T22:00:00Z",
+			n:       5,
+			want:    []string{"2026-10-02T22:00:00Z", "2026-10-03T22:00:00Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Parse(tt.rule)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.rule, err)
+			}
+			got := occurrences(r, utc(tt.dtstart), tt.n)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("%s from %s:\n got %v\nwant %v", tt.rule, tt.dtstart, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextAfterFarFuture(t *testing.T) {
+	r, err := Parse("FREQ=WEEKLY;BYDAY=SA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// after 远在起点之后时直接定位到附近的周期
+	got, ok := r.Next(utc("2020-01-04T09:00:00Z"), utc("2026-10-16T12:00:00Z"))
+	if !ok || !got.Equal(utc("2026-10-17T09:00:00Z")) {
+		t.Errorf("Next = %v, %v; want 2026-10-17T09:00:00Z", got, ok)
+	}
+	// after 早于起点时返回起点之后的第一次（起点本身也算）
+	got, ok = r.Next(utc("2026-10-17T09:00:00Z"), utc("2026-01-01T00:00:00Z"))
+	if !ok || !got.Equal(utc("2026-10-17T09:00:00Z")) {
+		t.Errorf("Next before dtstart = %v, %v; want 2026-10-17T09:00:00Z", got, ok)
+	}
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	r, err := Parse("FREQ=DAILY")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dtstart time.Time
+		want    []string
+	}{
+		{
+			// 2026-03-08 美国进入夏令时：计算以 UTC 进行，UTC 时刻不变，本地时间从 9 点变为 10 点
+			name:    "spring forward",
+			dtstart: time.Date(2026, 3, 7, 9, 0, 0, 0, newYork),
+			want:    []string{"2026-03-08T14:00:00Z", "2026-03-09T14:00:00Z"},
+		},
+		{
+			// 2026-11-01 夏令时结束，同样保持 UTC 时刻
+			name:    "fall back",
+			dtstart: time.Date(2026, 10, 31, 9, 0, 0, 0, newYork),
+			want:    []string{"2026-11-01T13:00:00Z", "2026-11-02T13:00:00Z"},
+		},
+		{
+			// 本地时间落在凌晨跳过的一小时附近，跨日的 UTC 起点不会丢失或重复一天
+			name:    "anchor near the skipped hour",
+			dtstart: time.Date(2026, 3, 7, 21, 30, 0, 0, newYork),
+			want:    []string{"2026-03-09T02:30:00Z", "2026-03-10T02:30:00Z"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := occurrences(r, tt.dtstart, len(tt.want))
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// 起点的时区只影响表示，不影响结果
+			if inUTC := occurrences(r, tt.dtstart.UTC(), len(tt.want)); strings.Join(inUTC, " ") != strings.Join(got, " ") {
+				t.Errorf("UTC dtstart gives %v, local gives %v", inUTC, got)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,we", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{" FREQ=MONTHLY;INTERVAL=1;BYMONTHDAY=-1 ", "FREQ=MONTHLY;BYMONTHDAY=-1"},
+		{"FREQ=MONTHLY;BYDAY=-1FR;COUNT=6", "FREQ=MONTHLY;BYDAY=-1FR;COUNT=6"},
+		{"FREQ=YEARLY;INTERVAL=2;UNTIL=20301231T000000Z", "FREQ=YEARLY;INTERVAL=2;UNTIL=20301231T000000Z"},
+		{"FREQ=DAILY;UNTIL=20261231", "FREQ=DAILY;UNTIL=20261231T235959Z"},
+		{"FREQ=DAILY;;", "FREQ=DAILY"},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.in)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.in, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		wantErr string
+	}{
+		{"", "不能为空"},
+		{"RRULE:", "不能为空"},
+		{"INTERVAL=2", "缺少 FREQ"},
+		{"FREQ=HOURLY", "不支持的 FREQ"},
+		{"FREQ=DAILY;FREQ=WEEKLY", "规则项重复"},
+		{"FREQ=DAILY;INTERVAL", "无效的规则项"},
+		{"FREQ=DAILY;INTERVAL=", "无效的规则项"},
+		{"FREQ=DAILY;INTERVAL=0", "INTERVAL"},
+		{"FREQ=DAILY;INTERVAL=1001", "INTERVAL"},
+		{"FREQ=DAILY;INTERVAL=x", "INTERVAL"},
+		{"FREQ=DAILY;COUNT=0", "COUNT"},
+		{"FREQ=DAILY;COUNT=-3", "COUNT"},
+		{"FREQ=DAILY;UNTIL=2026-12-31", "UNTIL"},
+		{"FREQ=DAILY;COUNT=3;UNTIL=20261231", "不能同时指定"},
+		{"FREQ=WEEKLY;BYDAY=XX", "BYDAY"},
+		{"FREQ=WEEKLY;BYDAY=M", "BYDAY"},
+		{"FREQ=MONTHLY;BYDAY=6MO", "BYDAY 序号"},
+		{"FREQ=MONTHLY;BYDAY=0MO", "BYDAY 序号"},
+		{"FREQ=WEEKLY;BYDAY=1MO", "只能用于 MONTHLY 或 YEARLY"},
+		{"FREQ=MONTHLY;BYMONTHDAY=0", "BYMONTHDAY"},
+		{"FREQ=MONTHLY;BYMONTHDAY=32", "BYMONTHDAY"},
+		{"FREQ=MONTHLY;BYMONTHDAY=-32", "BYMONTHDAY"},
+		{"FREQ=DAILY;BYHOUR=9", "不支持的规则项"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.in)
+		if err == nil {
+			t.Errorf("Parse(%q) succeeded, want error containing %q", tt.in, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Parse(%q) = %v, want error containing %q", tt.in, err, tt.wantErr)
+		}
+	}
+}
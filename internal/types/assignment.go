@@ -0,0 +1,13 @@
+package types
+
+// AssigneeMe 按负责人过滤任务时表示当前用户（GET /tasks 的 assignee 参数）
+const AssigneeMe = "me"
+
+// UserWorkload 用户被指派任务的工作量统计（管理员 API）
+type UserWorkload struct {
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Open      int    `json:"open"`      // 未完成（todo、in_progress）的任务数
+	Overdue   int    `json:"overdue"`   // 未完成且已超过截止时间的任务数
+	Completed int    `json:"completed"` // 统计窗口内完成的任务数
+}
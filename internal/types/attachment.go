@@ -0,0 +1,20 @@
+package types
+
+// TaskAttachment 任务附件的元数据，内容通过 GET /tasks/{id}/attachments/{attachment_id} 下载
+type TaskAttachment struct {
+	ID          int64  `json:"id"`
+	TaskID      int64  `json:"task_id"`
+	UploadedBy  int    `json:"uploaded_by"`
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+	SHA256      string `json:"sha256"` // 内容的 SHA-256（十六进制），客户端可据此判断本地缓存是否有效
+	CreatedAt   string `json:"created_at"`
+	StorageKey  string `json:"-"`
+}
+
+// AttachmentUsage 用户附件占用的存储空间与配额（字节）
+type AttachmentUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
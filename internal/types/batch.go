@@ -0,0 +1,21 @@
+package types
+
+// 批量操作单项失败原因
+const (
+	BatchErrorNotFound        = "not_found"
+	BatchErrorForbidden       = "forbidden"
+	BatchErrorVersionConflict = "version_conflict"
+	BatchErrorInvalidParent   = "invalid_parent"
+	BatchErrorBlocked         = "blocked"
+	BatchErrorInvalidProject  = "invalid_project"
+	BatchErrorInvalidAssignee = "invalid_assignee"
+)
+
+// BatchItemResult 批量操作中单个任务的处理结果
+type BatchItemResult struct {
+	ID             int64  `json:"id"`
+	Success        bool   `json:"success"`
+	ServerVersion  int64  `json:"server_version,omitempty"`
+	Error          string `json:"error,omitempty"`
+	CurrentVersion int    `json:"current_version,omitempty"` // 版本冲突时服务器的当前版本
+}
@@ -0,0 +1,45 @@
+package types
+
+// 任务动态的条目类型
+const (
+	ActivityComment       = "comment"        // 评论
+	ActivityCreated       = "created"        // 任务创建
+	ActivityStatusChanged = "status_changed" // 状态变化，data 为 {from, to}
+	ActivityReassigned    = "reassigned"     // 负责人变化，data 为 {from, to}（用户 ID，未指派为 null）
+	ActivityRestored      = "restored"       // 从删除状态恢复
+)
+
+// CommentMention 评论中提及的用户
+type CommentMention struct {
+	UserID int    `json:"user_id"`
+	Email  string `json:"email"`
+}
+
+// TaskComment 任务评论，回复的 parent_id 指向被回复的评论，root_id 指向所在话题的首条评论
+type TaskComment struct {
+	ID        int64            `json:"id"`
+	TaskID    int64            `json:"task_id"`
+	ParentID  *int64           `json:"parent_id"`
+	RootID    *int64           `json:"root_id"`
+	UserID    int              `json:"user_id"`
+	Email     string           `json:"email"`
+	Body      string           `json:"body"` // 已删除的评论为空字符串
+	Mentions  []CommentMention `json:"mentions"`
+	Edited    bool             `json:"edited"`
+	IsDeleted bool             `json:"is_deleted"`
+	CreatedAt string           `json:"created_at"`
+	UpdatedAt string           `json:"updated_at"`
+	Replies   []TaskComment    `json:"replies,omitempty"` // 话题中的全部回复（按时间顺序），仅首条评论带有
+}
+
+// ActivityItem 任务动态中的一条记录：评论或系统事件，ID 需与 Type 一起区分（评论与事件分别编号）
+type ActivityItem struct {
+	ID         int64                  `json:"id"`
+	Type       string                 `json:"type"`
+	TaskID     int64                  `json:"task_id"`
+	ActorID    int                    `json:"actor_id"`
+	ActorEmail string                 `json:"actor_email"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+	Comment    *TaskComment           `json:"comment,omitempty"`
+	CreatedAt  string                 `json:"created_at"`
+}
@@ -7,23 +7,40 @@ const (
 	ConflictKeepServer ConflictResolution = "keep_server"
 	ConflictKeepClient ConflictResolution = "keep_client"
 	ConflictMerge      ConflictResolution = "merge"
+	// ConflictManual 由用户显式指定字段值
+	ConflictManual ConflictResolution = "manual"
 )
 
+// IsValid 检查冲突解决策略是否为已知取值
+func (r ConflictResolution) IsValid() bool {
+	switch r {
+	case ConflictKeepServer, ConflictKeepClient, ConflictMerge, ConflictManual:
+		return true
+	}
+	return false
+}
+
 // FieldLevelConflict 字段级冲突信息
 type FieldLevelConflict struct {
 	FieldName   string      `json:"field_name"`
 	ServerValue interface{} `json:"server_value"`
 	ClientValue interface{} `json:"client_value"`
+	MergedValue interface{} `json:"merged_value"`
+	Strategy    string      `json:"strategy,omitempty"` // 自动合并该字段所用的策略
 }
 
 // ConflictRecord 冲突记录
 type ConflictRecord struct {
-	LocalID   string               `json:"local_id"`
-	ServerID  int64                `json:"server_id"`
-	Reason    string               `json:"reason"`
-	Options   []ConflictResolution `json:"options"`
-	Conflicts []FieldLevelConflict `json:"conflicts,omitempty"`
-	CreatedAt string               `json:"created_at"`
+	ID         int64                `json:"id"`
+	LocalID    string               `json:"local_id"`
+	ServerID   int64                `json:"server_id"`
+	Reason     string               `json:"reason"`
+	Options    []ConflictResolution `json:"options"`
+	Conflicts  []FieldLevelConflict `json:"conflicts,omitempty"`
+	Status     string               `json:"status"`
+	Resolution string               `json:"resolution,omitempty"`
+	ResolvedAt string               `json:"resolved_at,omitempty"`
+	CreatedAt  string               `json:"created_at"`
 }
 
 // MergeResult 合并结果
@@ -43,6 +60,15 @@ const (
 	PriorityHigh   PriorityState = "high"
 )
 
+// IsValid 检查优先级是否为已知取值
+func (p PriorityState) IsValid() bool {
+	switch p {
+	case PriorityLow, PriorityMedium, PriorityHigh:
+		return true
+	}
+	return false
+}
+
 // StatusState 任务状态
 type StatusState string
 
@@ -53,6 +79,15 @@ const (
 	StatusArchived   StatusState = "archived"
 )
 
+// IsValid 检查任务状态是否为已知取值
+func (s StatusState) IsValid() bool {
+	switch s {
+	case StatusTodo, StatusInProgress, StatusDone, StatusArchived:
+		return true
+	}
+	return false
+}
+
 // PrioritizeStatus 状态优先级排序（已完成 > 进行中 > 待办）
 func PrioritizeStatus(s1, s2 string) string {
 	statusMap := map[string]int{
@@ -0,0 +1,40 @@
+package types
+
+// 前置任务未完成时开始或完成任务的处理方式（system_config 中的 dependency_enforcement）
+const (
+	DependencyReject = "reject" // 拒绝状态变更
+	DependencyWarn   = "warn"   // 允许变更并在响应中给出警告
+)
+
+// TaskDependencies 任务的直接依赖关系
+type TaskDependencies struct {
+	TaskID    int64   `json:"task_id"`
+	BlockedBy []int64 `json:"blocked_by"` // 当前任务的前置任务
+	Blocking  []int64 `json:"blocking"`   // 以当前任务为前置任务的任务
+}
+
+// GraphNode 依赖图中的任务
+type GraphNode struct {
+	ID     int64  `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	DueAt  string `json:"due_at"`
+	Open   bool   `json:"open"` // 未完成且未删除
+}
+
+// GraphEdge 依赖图中的边，From 为前置任务，To 为被阻塞的任务
+type GraphEdge struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// TaskGraph 任务所在的依赖图（连通的全部上下游任务）
+type TaskGraph struct {
+	TaskID int64       `json:"task_id"`
+	Nodes  []GraphNode `json:"nodes"`
+	Edges  []GraphEdge `json:"edges"`
+	// Order 拓扑顺序，前置任务在前
+	Order []int64 `json:"order"`
+	// CriticalPath 未完成任务组成的最长依赖链
+	CriticalPath []int64 `json:"critical_path"`
+}
@@ -15,6 +15,7 @@ type PaginatedQuery struct {
 	OrderBy  string            `json:"order_by,omitempty"`
 	Order    string            `json:"order,omitempty"`
 	Filters  map[string]string `json:"filters,omitempty"`
+	Cursor   *Cursor           `json:"-"`
 }
 
 // NewPaginatedQuery 创建新的分页查询参数
@@ -55,3 +56,11 @@ func (pq *PaginatedQuery) GetFilter(key string) string {
 	}
 	return pq.Filters[key]
 }
+
+// Cursor 游标分页位置，按 (排序键, id) 定位下一页的起点
+type Cursor struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+	Kind  string `json:"t,omitempty"` // 排序值类型: time, int 或空（字符串）
+	ID    int64  `json:"id"`
+}
@@ -0,0 +1,35 @@
+package types
+
+// 项目成员角色，权限依次递增
+const (
+	ProjectRoleViewer = "viewer" // 只读
+	ProjectRoleEditor = "editor" // 可创建、修改、删除项目中的任务
+	ProjectRoleOwner  = "owner"  // 另可修改项目、管理成员；项目创建者始终为 owner
+)
+
+// Project 用户的项目（任务清单）
+type Project struct {
+	ID            int64  `json:"id"`
+	LocalID       string `json:"local_id"`
+	ServerVersion int64  `json:"server_version"`
+	OwnerID       int    `json:"owner_id"` // 项目创建者
+	Role          string `json:"role"`     // 当前用户在项目中的角色
+	Name          string `json:"name"`
+	Color         string `json:"color"`
+	Archived      bool   `json:"archived"`
+	Rank          string `json:"rank"` // 项目之间的排序键（分数索引）
+	IsDeleted     bool   `json:"is_deleted"`
+	TaskCount     int    `json:"task_count"`   // 项目中未删除的任务数
+	MemberCount   int    `json:"member_count"` // 共享成员数（不含创建者）
+	CreatedAt     string `json:"created_at"`
+	UpdatedAt     string `json:"updated_at"`
+}
+
+// ProjectMember 项目的成员
+type ProjectMember struct {
+	UserID    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	InvitedBy int    `json:"invited_by,omitempty"` // 创建者本人没有邀请人
+	CreatedAt string `json:"created_at"`
+}
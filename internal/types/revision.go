@@ -0,0 +1,17 @@
+package types
+
+// TaskRevision 任务在某个版本时的快照
+type TaskRevision struct {
+	Version     int    `json:"version"`
+	Op          string `json:"op,omitempty"` // 产生该版本的变更类型
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Priority    string `json:"priority"`
+	DueAt       string `json:"due_at"`
+	IsDeleted   bool   `json:"is_deleted"`
+	ActorID     int    `json:"actor_id,omitempty"`
+	ActorEmail  string `json:"actor_email,omitempty"`
+	DeviceID    string `json:"device_id"`
+	CreatedAt   string `json:"created_at"`
+}
@@ -0,0 +1,11 @@
+package types
+
+// TaskSeries 重复任务序列
+type TaskSeries struct {
+	ID        int64                    `json:"id"`
+	Rule      string                   `json:"recurrence_rule"`
+	DTStart   string                   `json:"dtstart"` // 规则计算的起点（UTC）
+	CreatedAt string                   `json:"created_at"`
+	UpdatedAt string                   `json:"updated_at"`
+	Tasks     []map[string]interface{} `json:"tasks"`
+}
@@ -0,0 +1,16 @@
+package types
+
+// 按多个标签过滤任务时的匹配方式（GET /tasks 的 tag_match 参数）
+const (
+	TagMatchAny = "any" // 带有任一标签
+	TagMatchAll = "all" // 带有全部标签
+)
+
+// Tag 用户的标签
+type Tag struct {
+	ID        int64  `json:"id"`
+	Name      string `json:"name"`
+	Color     string `json:"color"`
+	CreatedAt string `json:"created_at"`
+	TaskCount int    `json:"task_count"` // 使用该标签的未删除任务数
+}
@@ -0,0 +1,34 @@
+package types
+
+// 任务模板的范围
+const (
+	TemplateScopeUser   = "user"   // 用户自己的模板
+	TemplateScopeGlobal = "global" // 管理员维护、所有用户可见的模板
+)
+
+// TaskTemplate 任务模板：实例化时在一个事务中创建任务及其子任务骨架
+type TaskTemplate struct {
+	ID          int64    `json:"id"`
+	Scope       string   `json:"scope"`
+	Name        string   `json:"name"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Priority    string   `json:"priority"`
+	Tags        []string `json:"tags"`
+	// DueOffset 截止时间相对实例化基准时刻的偏移，如 +2d、-4h、+1w，空字符串表示不设截止时间
+	DueOffset string             `json:"due_offset"`
+	Subtasks  []*TemplateSubtask `json:"subtasks"`
+	CreatedBy int                `json:"created_by"`
+	CreatedAt string             `json:"created_at"`
+	UpdatedAt string             `json:"updated_at"`
+}
+
+// TemplateSubtask 模板中的一个子任务，可以继续嵌套子任务
+type TemplateSubtask struct {
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Priority    string             `json:"priority"`
+	Tags        []string           `json:"tags"`
+	DueOffset   string             `json:"due_offset"` // 同样相对实例化基准时刻，而不是相对父任务
+	Subtasks    []*TemplateSubtask `json:"subtasks"`
+}
@@ -0,0 +1,34 @@
+package types
+
+// TimeEntry 一条工时记录，ended_at 为空表示计时器仍在运行
+type TimeEntry struct {
+	ID              int64  `json:"id"`
+	LocalID         string `json:"local_id"`
+	ServerVersion   int64  `json:"server_version"`
+	TaskID          int64  `json:"task_id"`
+	UserID          int    `json:"user_id"`
+	StartedAt       string `json:"started_at"`
+	EndedAt         string `json:"ended_at"`
+	Running         bool   `json:"running"`
+	DurationSeconds int64  `json:"duration_seconds"` // 运行中的计时器计到当前时刻
+	Note            string `json:"note"`
+	IsDeleted       bool   `json:"is_deleted"`
+	CreatedAt       string `json:"created_at"`
+	UpdatedAt       string `json:"updated_at"`
+}
+
+// TimeTotal 按任务、项目或日期汇总的工时
+type TimeTotal struct {
+	Key     string `json:"key"`  // 任务 ID、项目 ID（不属于项目时为 none）或日期 YYYY-MM-DD
+	Name    string `json:"name"` // 任务标题或项目名称，按日期汇总时为空
+	Seconds int64  `json:"seconds"`
+	Entries int    `json:"entries"`
+}
+
+// TaskTimeSummary 单个任务的工时记录与合计，by_user 为各用户的合计秒数
+type TaskTimeSummary struct {
+	TaskID       int64         `json:"task_id"`
+	TotalSeconds int64         `json:"total_seconds"`
+	ByUser       map[int]int64 `json:"by_user"`
+	Entries      []*TimeEntry  `json:"entries"`
+}
@@ -0,0 +1,23 @@
+package types
+
+// SavedView 用户保存的视图：一组命名的任务过滤条件与排序方式，由服务器求值
+type SavedView struct {
+	ID            int64             `json:"id"`
+	LocalID       string            `json:"local_id"`
+	ServerVersion int64             `json:"server_version"`
+	Name          string            `json:"name"`
+	Filters       map[string]string `json:"filters"` // 与 GET /tasks 的过滤参数相同
+	Sort          string            `json:"sort"`
+	Order         string            `json:"order"`
+	IsDeleted     bool              `json:"is_deleted"`
+	Count         int               `json:"count"` // 当前符合条件的任务数
+	CreatedAt     string            `json:"created_at"`
+	UpdatedAt     string            `json:"updated_at"`
+}
+
+// ViewCount 视图成员数的变化
+type ViewCount struct {
+	ViewID   int64 `json:"view_id"`
+	Count    int   `json:"count"`
+	Previous int   `json:"previous"`
+}
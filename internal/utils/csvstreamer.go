@@ -40,6 +40,7 @@ func (cs *CSVStreamer) WriteRow(task map[string]interface{}) error {
 		EscapeCSV(task["completed_at"]),
 		EscapeCSV(task["is_deleted"]),
 		EscapeCSV(task["last_modified"]),
+		EscapeCSV(task["tags"]),
 	}
 
 	if err := cs.writer.Write(row); err != nil {
@@ -55,6 +56,25 @@ func (cs *CSVStreamer) WriteRow(task map[string]interface{}) error {
 	return nil
 }
 
+// WriteColumns 按 columns 的顺序写入一行数据，用于任务以外的导出
+func (cs *CSVStreamer) WriteColumns(row map[string]interface{}, columns []string) error {
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		values[i] = EscapeCSV(row[column])
+	}
+	if err := cs.writer.Write(values); err != nil {
+		return err
+	}
+
+	cs.rowsWritten++
+
+	if cs.rowsWritten%100 == 0 {
+		cs.writer.Flush()
+	}
+
+	return nil
+}
+
 // WriteRows 批量写入行数据
 func (cs *CSVStreamer) WriteRows(tasks []map[string]interface{}) error {
 	for _, task := range tasks {
@@ -46,6 +46,9 @@ func convertToString(value interface{}) string {
 			return "true"
 		}
 		return "false"
+	case []string:
+		// 列表（如任务标签）以分号连接
+		return strings.Join(v, ";")
 	default:
 		return ""
 	}
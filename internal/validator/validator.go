@@ -63,6 +63,30 @@ func IsValidTaskTitle(title string) bool {
 	return true
 }
 
+// IsValidProjectName 验证项目名称
+func IsValidProjectName(name string) bool {
+	if len(name) < 1 || len(name) > 100 {
+		return false
+	}
+	return true
+}
+
+// IsValidViewName 验证视图名称
+func IsValidViewName(name string) bool {
+	if len(name) < 1 || len(name) > 100 {
+		return false
+	}
+	return true
+}
+
+// IsValidTemplateName 验证任务模板名称
+func IsValidTemplateName(name string) bool {
+	if len(name) < 1 || len(name) > 100 {
+		return false
+	}
+	return true
+}
+
 // IsValidTaskDescription 验证任务描述
 func IsValidTaskDescription(description string) bool {
 	if len(description) > 5000 {
@@ -70,3 +94,11 @@ func IsValidTaskDescription(description string) bool {
 	}
 	return true
 }
+
+// IsValidTimeEntryNote 验证工时记录备注
+func IsValidTimeEntryNote(note string) bool {
+	if len(note) > 500 {
+		return false
+	}
+	return true
+}
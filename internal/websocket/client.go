@@ -146,9 +146,27 @@ func (c *Client) handleMessage(messageType int, data []byte) error {
 	case "ping":
 		c.sendPong()
 	case "subscribe":
-		// 处理订阅请求
+		taskID, ok := messageTaskID(msg)
+		if !ok {
+			c.sendError("无效的任务ID")
+			return nil
+		}
+		if err := c.hub.Subscribe(c, taskID); err == errClientGone {
+			// 连接已注销，发送通道已关闭，不再回复
+			return nil
+		} else if err != nil {
+			c.sendError("任务不存在")
+			return nil
+		}
+		c.sendSubscription("subscribed", taskID)
 	case "unsubscribe":
-		// 处理取消订阅
+		taskID, ok := messageTaskID(msg)
+		if !ok {
+			c.sendError("无效的任务ID")
+			return nil
+		}
+		c.hub.Unsubscribe(c, taskID)
+		c.sendSubscription("unsubscribed", taskID)
 	default:
 		// Log unknown message type
 	}
@@ -196,6 +214,25 @@ func (c *Client) sendPong() {
 	c.SendMessage(msg)
 }
 
+// messageTaskID 读取订阅消息中的 data.task_id
+func messageTaskID(msg Message) (int64, bool) {
+	v, ok := msg.Data["task_id"].(float64)
+	if !ok || v <= 0 || v != float64(int64(v)) {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// sendSubscription 确认订阅或取消订阅任务动态
+func (c *Client) sendSubscription(msgType string, taskID int64) {
+	msg := Message{
+		Type:      msgType,
+		Data:      map[string]interface{}{"task_id": taskID},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}
+	c.SendMessage(msg)
+}
+
 // sendError 发送错误消息
 func (c *Client) sendError(errMsg string) {
 	msg := Message{
@@ -6,24 +6,28 @@ import (
 	"sync"
 )
 
+// errClientGone 连接已从 Hub 注销，其发送通道已关闭
+var errClientGone = errors.New("client disconnected")
+
 // Hub WebSocket连接管理器
 type Hub struct {
-	clients      map[int64]*Client // userID -> Client
-	register     chan *Client
-	unregister   chan *Client
-	broadcast    chan []byte
-	userChannels map[int64]chan []byte // 每个用户的私有通道
-	mu           sync.RWMutex
+	clients    map[int64]map[*Client]bool // userID -> 该用户的全部连接（每个设备一个）
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan []byte
+	watchers   map[int64]map[*Client]bool // taskID -> 订阅该任务动态的客户端
+	authorize  func(userID, taskID int64) bool
+	mu         sync.RWMutex
 }
 
 // NewHub 创建新的Hub
 func NewHub() *Hub {
 	return &Hub{
-		clients:      make(map[int64]*Client),
-		register:     make(chan *Client),
-		unregister:   make(chan *Client),
-		broadcast:    make(chan []byte),
-		userChannels: make(map[int64]chan []byte),
+		clients:    make(map[int64]map[*Client]bool),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan []byte),
+		watchers:   make(map[int64]map[*Client]bool),
 	}
 }
 
@@ -33,43 +37,55 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.register:
 			h.mu.Lock()
-			h.clients[client.userID] = client
-			h.userChannels[client.userID] = client.send
+			if h.clients[client.userID] == nil {
+				h.clients[client.userID] = make(map[*Client]bool)
+			}
+			h.clients[client.userID][client] = true
 			h.mu.Unlock()
 
 		case client := <-h.unregister:
 			h.mu.Lock()
-			if _, exists := h.clients[client.userID]; exists {
-				delete(h.clients, client.userID)
-				delete(h.userChannels, client.userID)
-				close(client.send)
-			}
+			h.removeClient(client)
 			h.mu.Unlock()
 
 		case message := <-h.broadcast:
-			h.mu.RLock()
-			for _, client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					// 发送失败，关闭连接
-					close(client.send)
-					delete(h.clients, client.userID)
-					delete(h.userChannels, client.userID)
+			h.mu.Lock()
+			for _, conns := range h.clients {
+				for client := range conns {
+					select {
+					case client.send <- message:
+					default:
+						// 发送失败，关闭连接
+						h.removeClient(client)
+					}
 				}
 			}
-			h.mu.RUnlock()
+			h.mu.Unlock()
 		}
 	}
 }
 
-// BroadcastToUser 向指定用户发送消息
+// removeClient 移除一个连接并关闭其发送通道，同一用户的其他连接不受影响；调用方需持有写锁
+func (h *Hub) removeClient(client *Client) {
+	conns := h.clients[client.userID]
+	if !conns[client] {
+		return
+	}
+	delete(conns, client)
+	if len(conns) == 0 {
+		delete(h.clients, client.userID)
+	}
+	close(client.send)
+	h.removeWatcher(client)
+}
+
+// BroadcastToUser 向指定用户的全部连接发送消息，至少一个连接收到即成功
 func (h *Hub) BroadcastToUser(userID int64, msg Message) error {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	channel, exists := h.userChannels[userID]
-	if !exists {
+	conns := h.clients[userID]
+	if len(conns) == 0 {
 		return errors.New("user not connected")
 	}
 
@@ -78,12 +94,18 @@ func (h *Hub) BroadcastToUser(userID int64, msg Message) error {
 		return err
 	}
 
-	select {
-	case channel <- data:
-		return nil
-	default:
+	sent := 0
+	for client := range conns {
+		select {
+		case client.send <- data:
+			sent++
+		default:
+		}
+	}
+	if sent == 0 {
 		return errors.New("send buffer full")
 	}
+	return nil
 }
 
 // IsUserConnected 检查用户是否在线
@@ -91,16 +113,19 @@ func (h *Hub) IsUserConnected(userID int64) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	_, exists := h.clients[userID]
-	return exists
+	return len(h.clients[userID]) > 0
 }
 
-// GetConnectedClientCount 获取在线客户端数量
+// GetConnectedClientCount 获取在线连接数量
 func (h *Hub) GetConnectedClientCount() int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	return len(h.clients)
+	count := 0
+	for _, conns := range h.clients {
+		count += len(conns)
+	}
+	return count
 }
 
 // Register 注册客户端（外部调用）
@@ -123,3 +148,98 @@ func (h *Hub) Broadcast(msg Message) error {
 	h.broadcast <- data
 	return nil
 }
+
+// SetSubscribeAuthorizer 设置任务订阅的权限检查，未设置时拒绝所有订阅
+func (h *Hub) SetSubscribeAuthorizer(authorize func(userID, taskID int64) bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.authorize = authorize
+}
+
+// Subscribe 客户端订阅任务的动态，用户无权查看该任务时返回错误
+func (h *Hub) Subscribe(client *Client, taskID int64) error {
+	h.mu.RLock()
+	authorize := h.authorize
+	h.mu.RUnlock()
+	if authorize == nil || !authorize(client.userID, taskID) {
+		return errors.New("task not found")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// 权限检查期间连接可能已注销，不能再加入订阅，否则广播会向已关闭的通道发送
+	if !h.clients[client.userID][client] {
+		return errClientGone
+	}
+	if h.watchers[taskID] == nil {
+		h.watchers[taskID] = make(map[*Client]bool)
+	}
+	h.watchers[taskID][client] = true
+	return nil
+}
+
+// Unsubscribe 客户端取消订阅任务的动态
+func (h *Hub) Unsubscribe(client *Client, taskID int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if watchers := h.watchers[taskID]; watchers != nil {
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(h.watchers, taskID)
+		}
+	}
+}
+
+// removeWatcher 从全部任务的订阅集合中移除客户端，调用方需持有写锁
+func (h *Hub) removeWatcher(client *Client) {
+	for taskID, watchers := range h.watchers {
+		if !watchers[client] {
+			continue
+		}
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(h.watchers, taskID)
+		}
+	}
+}
+
+// BroadcastToTaskWatchers 向订阅了任务动态的客户端发送消息，返回送达的客户端数量
+// allow 不为 nil 时逐个用户检查，订阅后失去查看权限的用户不再收到消息
+func (h *Hub) BroadcastToTaskWatchers(taskID int64, msg Message, allow func(userID int64) bool) int {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0
+	}
+
+	h.mu.RLock()
+	users := make(map[int64]bool)
+	for client := range h.watchers[taskID] {
+		users[client.userID] = true
+	}
+	h.mu.RUnlock()
+
+	// 权限检查可能访问数据库，不持有锁
+	for userID := range users {
+		users[userID] = allow == nil || allow(userID)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	sent := 0
+	for client := range h.watchers[taskID] {
+		if !users[client.userID] {
+			continue
+		}
+		select {
+		case client.send <- data:
+			sent++
+		default:
+			// 发送缓冲区已满，丢弃该条动态
+		}
+	}
+	return sent
+}
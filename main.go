@@ -2,19 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"mime"
 	"net/http"
 	"os"
+	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"todoapp/internal/auth"
+	"todoapp/internal/blobstore"
 	"todoapp/internal/crypto"
 	"todoapp/internal/db"
+	"todoapp/internal/fracindex"
+	"todoapp/internal/merge"
+	"todoapp/internal/quickadd"
+	"todoapp/internal/recurrence"
 	"todoapp/internal/response"
 	"todoapp/internal/types"
 	"todoapp/internal/utils"
@@ -128,13 +143,30 @@ func main() {
 
 	// Initialize WebSocket Hub
 	wsHub := websocket.NewHub()
+	wsHub.SetSubscribeAuthorizer(func(userID, taskID int64) bool {
+		return db.CheckTaskAccess(int(userID), taskID, types.ProjectRoleViewer) == nil
+	})
 	go wsHub.Run()
 	log.Println("WebSocket Hub initialized.")
 
+	// Initialize blob store for task attachments
+	blobs, err := blobstore.NewFromEnv()
+	if err != nil {
+		log.Fatalf("failed to init blob store: %v", err)
+	}
+	log.Println("Blob store initialized.")
+
 	// Start cleanup tasks
-	go startCleanupTasks()
+	go startCleanupTasks(blobs)
 	log.Println("Cleanup tasks started.")
 
+	// Start reminder dispatcher
+	go startReminderScheduler(wsHub)
+	log.Println("Reminder scheduler started.")
+
+	// Start refreshing counts of views with relative time filters
+	go startViewCountRefresher(wsHub)
+
 	// Create router with Gorilla Mux for better routing
 	router := mux.NewRouter()
 
@@ -160,15 +192,118 @@ func main() {
 	protected.Use(em.EncryptResponse)
 
 	protected.HandleFunc("/users/me", handleMe).Methods("GET")
-	protected.HandleFunc("/tasks", handleTasks).Methods("GET", "POST")
-	protected.HandleFunc("/tasks/{id}", handleTaskByID).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/tasks", func(w http.ResponseWriter, r *http.Request) {
+		handleTasks(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/tasks/quick-add/preview", handleQuickAddPreview).Methods("POST")
 	protected.HandleFunc("/tasks/batch", func(w http.ResponseWriter, r *http.Request) {
 		handleBatchDeleteTasks(w, r, wsHub)
 	}).Methods("DELETE")
-	protected.HandleFunc("/tasks/{id}/restore", handleRestoreTask).Methods("POST")
+	protected.HandleFunc("/tasks/batch", func(w http.ResponseWriter, r *http.Request) {
+		handleBatchUpdateTasks(w, r, wsHub)
+	}).Methods("PATCH")
+	protected.HandleFunc("/tasks/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskByID(w, r, wsHub)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/restore", func(w http.ResponseWriter, r *http.Request) {
+		handleRestoreTask(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/trash", handleListTrash).Methods("GET")
+	protected.HandleFunc("/trash", handlePurgeTrash).Methods("DELETE")
+	protected.HandleFunc("/trash/{id:[0-9]+}", handlePurgeTrash).Methods("DELETE")
+	protected.HandleFunc("/trash/{id:[0-9]+}/restore", func(w http.ResponseWriter, r *http.Request) {
+		handleRestoreFromTrash(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/reminders", handleTaskReminders).Methods("GET", "PUT")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/history", handleTaskHistory).Methods("GET")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/dependencies", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskDependencies(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/dependencies/{blocker_id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskDependencies(w, r, wsHub)
+	}).Methods("DELETE")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/graph", handleTaskGraph).Methods("GET")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/comments", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskComments(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/comments/{comment_id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskComment(w, r, wsHub)
+	}).Methods("PATCH", "DELETE")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/activity", handleTaskActivity).Methods("GET")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/attachments", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskAttachments(w, r, wsHub, blobs)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/attachments/{attachment_id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskAttachment(w, r, wsHub, blobs)
+	}).Methods("GET", "DELETE")
+	protected.HandleFunc("/attachments/usage", handleAttachmentUsage).Methods("GET")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/revert", func(w http.ResponseWriter, r *http.Request) {
+		handleRevertTask(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/series/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleSeries(w, r, wsHub)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/projects", func(w http.ResponseWriter, r *http.Request) {
+		handleProjects(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/projects/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleProjectByID(w, r, wsHub)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/projects/{id:[0-9]+}/tasks/{task_id:[0-9]+}/move", func(w http.ResponseWriter, r *http.Request) {
+		handleMoveProjectTask(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/projects/{id:[0-9]+}/members", func(w http.ResponseWriter, r *http.Request) {
+		handleProjectMembers(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/projects/{id:[0-9]+}/members/{user_id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleProjectMember(w, r, wsHub)
+	}).Methods("PATCH", "DELETE")
+	protected.HandleFunc("/views", func(w http.ResponseWriter, r *http.Request) {
+		handleViews(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/views/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleViewByID(w, r, wsHub)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/views/{id:[0-9]+}/tasks", handleViewTasks).Methods("GET")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/timer/start", func(w http.ResponseWriter, r *http.Request) {
+		handleStartTimer(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/tasks/{id:[0-9]+}/time-entries", func(w http.ResponseWriter, r *http.Request) {
+		handleTaskTimeEntries(w, r, wsHub)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/timer", func(w http.ResponseWriter, r *http.Request) {
+		handleTimer(w, r, wsHub)
+	}).Methods("GET")
+	protected.HandleFunc("/timer/stop", func(w http.ResponseWriter, r *http.Request) {
+		handleTimer(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/time-entries", handleTimeEntries).Methods("GET")
+	protected.HandleFunc("/time-entries/totals", handleTimeTotals).Methods("GET")
+	protected.HandleFunc("/time-entries/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTimeEntryByID(w, r, wsHub)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/templates", func(w http.ResponseWriter, r *http.Request) {
+		handleTemplates(w, r, false)
+	}).Methods("GET", "POST")
+	protected.HandleFunc("/templates/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTemplateByID(w, r, false)
+	}).Methods("GET", "PATCH", "DELETE")
+	protected.HandleFunc("/templates/{id:[0-9]+}/instantiate", func(w http.ResponseWriter, r *http.Request) {
+		handleInstantiateTemplate(w, r, wsHub)
+	}).Methods("POST")
+	protected.HandleFunc("/tags", handleTags).Methods("GET", "POST")
+	protected.HandleFunc("/tags/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTagByID(w, r, wsHub)
+	}).Methods("PATCH", "DELETE")
 	protected.HandleFunc("/sync", func(w http.ResponseWriter, r *http.Request) { handleSync(w, r, wsHub) }).Methods("POST")
+	protected.HandleFunc("/conflicts", handleListConflicts).Methods("GET")
+	protected.HandleFunc("/conflicts/{id:[0-9]+}/resolve", func(w http.ResponseWriter, r *http.Request) {
+		handleResolveConflict(w, r, wsHub)
+	}).Methods("POST")
 	protected.HandleFunc("/export", handleExport).Methods("GET")
-	protected.HandleFunc("/import", handleImport).Methods("POST")
+	protected.HandleFunc("/import", func(w http.ResponseWriter, r *http.Request) {
+		handleImport(w, r, wsHub)
+	}).Methods("POST")
 	protected.HandleFunc("/notifications", handleGetNotifications).Methods("GET")
 	protected.HandleFunc("/notifications", handleCreateNotification).Methods("POST")
 	protected.HandleFunc("/notifications/{id}/read", handleMarkAsRead).Methods("PATCH")
@@ -199,9 +334,18 @@ func main() {
 	admin.HandleFunc("/users/{id}/unlock", handleAdminUnlockUser).Methods("POST")
 	admin.HandleFunc("/users/{id}", handleAdminDeleteUser).Methods("DELETE")
 
+	admin.HandleFunc("/workload", handleAdminWorkload).Methods("GET")
+
 	admin.HandleFunc("/logs/login", handleAdminGetLoginLogs).Methods("GET")
 	admin.HandleFunc("/logs/actions", handleAdminGetActionLogs).Methods("GET")
 
+	admin.HandleFunc("/templates", func(w http.ResponseWriter, r *http.Request) {
+		handleTemplates(w, r, true)
+	}).Methods("GET", "POST")
+	admin.HandleFunc("/templates/{id:[0-9]+}", func(w http.ResponseWriter, r *http.Request) {
+		handleTemplateByID(w, r, true)
+	}).Methods("GET", "PATCH", "DELETE")
+
 	admin.HandleFunc("/config", handleAdminGetConfig).Methods("GET")
 	admin.HandleFunc("/config", handleAdminSetConfig).Methods("PUT")
 
@@ -216,7 +360,8 @@ func main() {
 	corsHandler := handlers.CORS(
 		handlers.AllowedOrigins([]string{"http://localhost:3000", "http://localhost:8080"}),
 		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-User-ID"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-User-ID", "X-Device-ID"}),
+		handlers.ExposedHeaders([]string{"X-Next-Cursor"}),
 		handlers.AllowCredentials(),
 	)(router)
 
@@ -238,6 +383,17 @@ func main() {
 // securityMiddleware adds security headers and request validation
 func securityMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 附件上传只接受 multipart/form-data，大小上限取决于附件配置
+		if r.Method == http.MethodPost && attachmentUploadPath.MatchString(r.URL.Path) {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "multipart/form-data" {
+				http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, db.AttachmentMaxBytes()+multipartOverhead)
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		// Validate content type for POST/PUT/PATCH
 		if r.Method != "GET" && r.Method != "DELETE" {
 			contentType := r.Header.Get("Content-Type")
@@ -353,6 +509,15 @@ func getUserIDFromContext(ctx context.Context) string {
 	return ""
 }
 
+// deviceIDFromRequest 从 X-Device-ID 请求头获取设备标识，用于在变更日志中记录变更来源
+func deviceIDFromRequest(r *http.Request) string {
+	deviceID := strings.TrimSpace(r.Header.Get("X-Device-ID"))
+	if len(deviceID) > 128 {
+		return ""
+	}
+	return deviceID
+}
+
 func getRoleFromContext(ctx context.Context) string {
 	if role, ok := ctx.Value("role").(string); ok {
 		return role
@@ -360,6 +525,28 @@ func getRoleFromContext(ctx context.Context) string {
 	return ""
 }
 
+// parseCursorParam 解析并校验请求中的 cursor 参数，未提供时返回 nil
+func parseCursorParam(r *http.Request) (*types.Cursor, error) {
+	token := r.URL.Query().Get("cursor")
+	if token == "" {
+		return nil, nil
+	}
+	return auth.ParseCursor(token)
+}
+
+// signCursor 生成响应中的 next_cursor，没有下一页时返回空字符串
+func signCursor(c *types.Cursor) string {
+	if c == nil {
+		return ""
+	}
+	token, err := auth.SignCursor(c)
+	if err != nil {
+		log.Printf("生成分页游标失败: %v", err)
+		return ""
+	}
+	return token
+}
+
 // ---------------- handlers ----------------
 
 type loginReq struct {
@@ -568,13 +755,208 @@ func handleMe(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"id": "demo_user_id", "email": "test@example.com"})
 }
 
-type task struct {
-	ID          int    `json:"id,omitempty"`
-	Title       string `json:"title"`
-	Description string `json:"description,omitempty"`
+// taskWriteReq 创建/更新任务的请求体，指针字段为 nil 表示未提供
+type taskWriteReq struct {
+	LocalID     string  `json:"local_id"`
+	Title       *string `json:"title"`
+	Description *string `json:"description"`
+	Status      *string `json:"status"`
+	Priority    *string `json:"priority"`
+	DueAt       *string `json:"due_at"` // RFC3339 或 YYYY-MM-DD，空字符串表示清除
+	// RecurrenceRule iCalendar RRULE 子集，如 FREQ=WEEKLY;BYDAY=MO,WE，空字符串表示结束重复
+	RecurrenceRule *string `json:"recurrence_rule"`
+	ParentID       *int64  `json:"parent_id"` // 0 表示移到顶层
+	Position       *int    `json:"position"`  // 在兄弟任务中的位置，从 0 开始
+	// Tags 标签名列表，替换任务现有的全部标签，不存在的标签自动创建
+	Tags          *[]string `json:"tags"`
+	ProjectID     *int64    `json:"project_id"`   // 0 表示移出项目
+	ProjectRank   *string   `json:"project_rank"` // 项目内的排序键（分数索引），缺省时排在最后
+	AssigneeID    *int      `json:"assignee_id"`  // 负责人的用户 ID，0 表示取消指派
+	ServerVersion int       `json:"server_version"`
+}
+
+// validate 校验任务字段，creating 为 true 时要求标题必填
+func (req *taskWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	if req.Title != nil || creating {
+		if req.Title == nil || !validator.IsValidTaskTitle(strings.TrimSpace(*req.Title)) {
+			errs["title"] = "标题不能为空且不能超过200个字符"
+		}
+	}
+	if req.Description != nil && !validator.IsValidTaskDescription(*req.Description) {
+		errs["description"] = "描述不能超过5000个字符"
+	}
+	if req.Status != nil && !types.StatusState(*req.Status).IsValid() {
+		errs["status"] = "无效的任务状态"
+	}
+	if req.Priority != nil && !types.PriorityState(*req.Priority).IsValid() {
+		errs["priority"] = "无效的优先级"
+	}
+	if req.DueAt != nil && *req.DueAt != "" {
+		if _, err := parseDueAt(*req.DueAt); err != nil {
+			errs["due_at"] = "截止时间格式无效，应为 RFC3339 或 YYYY-MM-DD"
+		}
+	}
+	if req.RecurrenceRule != nil && *req.RecurrenceRule != "" {
+		if _, err := recurrence.Parse(*req.RecurrenceRule); err != nil {
+			errs["recurrence_rule"] = "重复规则无效: " + err.Error()
+		}
+	}
+	if req.ParentID != nil && *req.ParentID < 0 {
+		errs["parent_id"] = "无效的父任务ID"
+	}
+	if req.Position != nil && *req.Position < 0 {
+		errs["position"] = "位置不能为负数"
+	}
+	if req.Tags != nil {
+		if _, err := db.NormalizeTagNames(*req.Tags); err != nil {
+			errs["tags"] = err.Error()
+		}
+	}
+	if req.ProjectID != nil && *req.ProjectID < 0 {
+		errs["project_id"] = "无效的项目ID"
+	}
+	if req.ProjectRank != nil && !fracindex.Valid(*req.ProjectRank) {
+		errs["project_rank"] = fracindex.ErrInvalidKey.Error()
+	}
+	if req.AssigneeID != nil && *req.AssigneeID < 0 {
+		errs["assignee_id"] = "无效的负责人ID"
+	}
+	return errs
+}
+
+// parseDueAt 解析 RFC3339 或 YYYY-MM-DD 格式的截止时间
+func parseDueAt(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// fields 转换为数据库层的可写字段
+func (req *taskWriteReq) fields() db.TaskFields {
+	title := req.Title
+	if title != nil {
+		trimmed := strings.TrimSpace(*title)
+		title = &trimmed
+	}
+	f := db.TaskFields{
+		Title:       title,
+		Description: req.Description,
+		Status:      req.Status,
+		Priority:    req.Priority,
+		ParentID:    req.ParentID,
+		Position:    req.Position,
+		ProjectID:   req.ProjectID,
+		ProjectRank: req.ProjectRank,
+		AssigneeID:  req.AssigneeID,
+	}
+	if req.DueAt != nil {
+		// 空字符串解析失败得到零值，即清除截止时间
+		dueAt, _ := parseDueAt(*req.DueAt)
+		f.DueAt = &dueAt
+	}
+	if req.RecurrenceRule != nil {
+		// 以规范化形式保存，空字符串保持不变
+		rule := ""
+		if parsed, err := recurrence.Parse(*req.RecurrenceRule); err == nil {
+			rule = parsed.String()
+		}
+		f.RecurrenceRule = &rule
+	}
+	if req.Tags != nil {
+		tags, _ := db.NormalizeTagNames(*req.Tags)
+		f.Tags = &tags
+	}
+	return f
+}
+
+// maxQuickAddLength 快速添加文本的最大长度（字符数）
+const maxQuickAddLength = 500
+
+// createTaskReq 创建任务的请求体，可以附带一行快速添加文本
+type createTaskReq struct {
+	taskWriteReq
+	// QuickAdd 如 "Pay invoice tomorrow 5pm !high #finance every month"，解析出的字段只填入请求中未提供的字段
+	QuickAdd *string `json:"quick_add"`
+	Timezone string  `json:"timezone"` // IANA 时区名，用于理解文本中的日期与时刻，缺省为服务器时区
+}
+
+// loadTimezone 加载请求中的 IANA 时区名，空字符串为服务器时区
+func loadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// parseQuickAdd 按请求的时区解析快速添加文本
+func parseQuickAdd(text, timezone string) (*quickadd.Result, map[string]string) {
+	errs := map[string]string{}
+	loc, err := loadTimezone(timezone)
+	if err != nil {
+		errs["timezone"] = "无效的时区"
+	}
+	if utf8.RuneCountInString(text) > maxQuickAddLength {
+		errs["quick_add"] = fmt.Sprintf("快速添加文本不能超过%d个字符", maxQuickAddLength)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return quickadd.Parse(text, time.Now().In(loc)), nil
+}
+
+// applyQuickAdd 解析快速添加文本并填入未提供的字段，未附带文本时返回 nil
+func (req *createTaskReq) applyQuickAdd() (*quickadd.Result, map[string]string) {
+	if req.QuickAdd == nil {
+		return nil, nil
+	}
+	parsed, errs := parseQuickAdd(*req.QuickAdd, req.Timezone)
+	if parsed == nil {
+		return nil, errs
+	}
+	if req.Title == nil {
+		req.Title = &parsed.Title
+	}
+	if req.DueAt == nil && parsed.DueAt != nil {
+		dueAt := parsed.DueAt.Format(time.RFC3339)
+		req.DueAt = &dueAt
+	}
+	if req.Priority == nil && parsed.Priority != "" {
+		req.Priority = &parsed.Priority
+	}
+	if req.Tags == nil && len(parsed.Tags) > 0 {
+		req.Tags = &parsed.Tags
+	}
+	if req.RecurrenceRule == nil && parsed.RecurrenceRule != "" {
+		req.RecurrenceRule = &parsed.RecurrenceRule
+	}
+	return parsed, nil
+}
+
+// handleQuickAddPreview 试解析快速添加文本，不创建任务；errors 为按解析结果创建任务时会出现的校验错误
+func handleQuickAddPreview(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Text     string `json:"text"`
+		Timezone string `json:"timezone"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	create := createTaskReq{QuickAdd: &req.Text, Timezone: req.Timezone}
+	parsed, errs := create.applyQuickAdd()
+	if parsed == nil {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+	response.SuccessResponse(w, map[string]interface{}{
+		"quick_add": parsed,
+		"errors":    create.validate(true),
+	}, http.StatusOK)
 }
 
-func handleTasks(w http.ResponseWriter, r *http.Request) {
+func handleTasks(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
 	if r.Method == http.MethodGet {
 		// 从上下文获取用户 ID
 		userIDStr := getUserIDFromContext(r.Context())
@@ -601,8 +983,30 @@ func handleTasks(w http.ResponseWriter, r *http.Request) {
 			pageSize = 20
 		}
 
-		tasks, total, err := db.GetTasksPaginated(userID, page, pageSize)
+		q := types.NewPaginatedQuery(page, pageSize)
+		if sortKey := r.URL.Query().Get("sort"); sortKey != "" {
+			q.OrderBy = sortKey
+		}
+		if order := r.URL.Query().Get("order"); order != "" {
+			q.Order = order
+		}
+		for _, key := range db.TaskFilterKeys {
+			if v := r.URL.Query().Get(key); v != "" {
+				q.SetFilter(key, v)
+			}
+		}
+		if q.Cursor, err = parseCursorParam(r); err != nil {
+			response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		tasks, total, next, err := db.GetTasksPaginated(userID, q)
 		if err != nil {
+			if filterErr, ok := err.(*db.FilterError); ok {
+				response.ValidationErrorResponse(w, map[string]string{filterErr.Field: filterErr.Message})
+				return
+			}
+			log.Printf("获取任务失败: %v", err)
 			response.ErrorResponse(w, "获取任务失败", http.StatusInternalServerError)
 			return
 		}
@@ -615,240 +1019,974 @@ func handleTasks(w http.ResponseWriter, r *http.Request) {
 				"total":     total,
 				"pages":     (total + pageSize - 1) / pageSize,
 			},
+			"next_cursor": signCursor(next),
 		}, http.StatusOK)
 		return
 	}
 
-	// POST: 创建任务（临时实现）
-	var t task
-	json.NewDecoder(r.Body).Decode(&t)
-	t.ID = 1
-	response.SuccessResponse(w, t, http.StatusCreated)
-}
+	// POST: 创建任务
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
 
-func handleTaskByID(w http.ResponseWriter, r *http.Request) {
-	// path: /api/v1/tasks/{id}
-	idStr := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
-	if idStr == "" {
-		http.NotFound(w, r)
+	var req createTaskReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
 		return
 	}
-	if _, err := strconv.Atoi(idStr); err != nil {
-		http.NotFound(w, r)
+	parsed, errs := req.applyQuickAdd()
+	if len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
 		return
 	}
-	w.WriteHeader(http.StatusNotFound)
-}
 
-type syncReq struct {
-	LastSyncAt string `json:"last_sync_at"`
-	Changes    []struct {
-		LocalID string                 `json:"local_id"`
-		Op      string                 `json:"op"`
-		Payload map[string]interface{} `json:"payload"`
-		CV      int                    `json:"client_version"`
-	} `json:"changes"`
-}
+	localID := req.LocalID
+	if localID == "" {
+		localID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
 
-func handleSync(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
-	var s syncReq
-	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
-		response.ErrorResponse(w, "错误的请求", http.StatusBadRequest)
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	task, err := db.CreateTaskWithFields(userID, deviceIDFromRequest(r), localID, req.fields())
+	if err != nil {
+		writeTaskError(w, err, "创建任务失败")
 		return
 	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+	if req.AssigneeID != nil {
+		notifyTaskAssignments(wsHub, userID, nil, []int64{task["id"].(int64)})
+	}
+	if parsed != nil {
+		task["quick_add"] = parsed
+	}
 
-	// 从上下文获取用户 ID（已认证的用户）
-	userIDStr := getUserIDFromContext(r.Context())
-	if userIDStr == "" {
+	response.SuccessResponse(w, task, http.StatusCreated)
+}
+
+// handleTaskByID 获取、部分更新或删除单个任务
+func handleTaskByID(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	userID, err := strconv.Atoi(userIDStr)
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		response.ErrorResponse(w, "无效的用户 ID", http.StatusUnauthorized)
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
 		return
 	}
 
-	now := time.Now().UTC()
+	switch r.Method {
+	case http.MethodGet:
+		task, err := db.GetTask(userID, taskID)
+		if err != nil {
+			writeTaskError(w, err, "获取任务失败")
+			return
+		}
+		response.SuccessResponse(w, task, http.StatusOK)
 
-	// ✅ 使用事务保护同步操作
-	tx, err := db.DB.Begin()
-	if err != nil {
-		log.Printf("事务开始失败: %v", err)
-		response.ErrorResponse(w, "事务开始失败", http.StatusInternalServerError)
-		return
-	}
-	defer func() {
+	case http.MethodPatch:
+		var req taskWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+
+		var before map[int64]db.TaskAssignment
+		if req.AssigneeID != nil || req.Status != nil {
+			if before, err = db.GetTaskAssignments(db.DB, []int64{taskID}); err != nil {
+				log.Printf("读取任务指派失败: %v", err)
+			}
+		}
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		task, err := db.UpdateTaskFields(userID, deviceIDFromRequest(r), taskID, req.fields(), req.ServerVersion)
 		if err != nil {
-			tx.Rollback()
-		} else {
-			tx.Commit()
+			writeTaskError(w, err, "更新任务失败")
+			return
 		}
-	}()
+		pushTaskChanges(wsHub, userID, afterSeq)
+		if before != nil {
+			notifyTaskAssignments(wsHub, userID, before, []int64{taskID})
+		}
+		response.SuccessResponse(w, task, http.StatusOK)
 
-	serverChanges := []map[string]interface{}{}
-	clientChanges := []map[string]interface{}{}
-	conflicts := []map[string]interface{}{}
-	syncFailed := false
+	case http.MethodDelete:
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		subtasks, err := db.SoftDeleteTask(userID, deviceIDFromRequest(r), taskID)
+		if err != nil {
+			writeTaskError(w, err, "删除任务失败")
+			return
+		}
+		pushTaskChanges(wsHub, userID, afterSeq)
+		response.SuccessResponse(w, map[string]interface{}{
+			"status":              "deleted",
+			"id":                  taskID,
+			"subtasks_deleted":    subtasks,
+			"can_undo":            true,
+			"undo_window_seconds": 30,
+		}, http.StatusOK)
+	}
+}
 
-	for _, c := range s.Changes {
-		op := strings.ToLower(c.Op)
+// writeTaskError 将任务相关的数据库错误映射为 HTTP 响应
+func writeTaskError(w http.ResponseWriter, err error, fallback string) {
+	if conflictErr, ok := err.(*db.VersionConflictError); ok {
+		response.ErrorResponse(w, conflictErr.Error(), http.StatusConflict)
+		return
+	}
+	if depthErr, ok := err.(*db.TaskDepthError); ok {
+		response.ValidationErrorResponse(w, map[string]string{"parent_id": depthErr.Error()})
+		return
+	}
+	if blockedErr, ok := err.(*db.BlockedError); ok {
+		response.ValidationErrorResponse(w, map[string]string{"status": blockedErr.Error()})
+		return
+	}
+	switch err {
+	case db.ErrInvalidParent:
+		response.ValidationErrorResponse(w, map[string]string{"parent_id": err.Error()})
+	case db.ErrInvalidProject:
+		response.ValidationErrorResponse(w, map[string]string{"project_id": err.Error()})
+	case db.ErrInvalidAssignee:
+		response.ValidationErrorResponse(w, map[string]string{"assignee_id": err.Error()})
+	case db.ErrTaskNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrTaskForbidden:
+		response.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("%s: %v", fallback, err)
+		response.ErrorResponse(w, fallback, http.StatusInternalServerError)
+	}
+}
 
-		switch op {
-		case "insert":
-			title := ""
-			description := ""
-			status := "todo"
-			priority := "medium"
+type syncReq struct {
+	// LastSeq 客户端已应用的最大变更序号，缺省时使用该设备记录的同步进度
+	LastSeq *int `json:"last_seq"`
+	// LastSyncAt 上次同步的服务器时间（RFC3339），仅在既没有 last_seq 也没有设备进度时用于换算游标，兼容旧客户端
+	LastSyncAt string       `json:"last_sync_at"`
+	DeviceID   string       `json:"device_id"`
+	Changes    []syncChange `json:"changes"`
+}
 
-			if v, ok := c.Payload["title"].(string); ok {
-				title = v
+// syncChange 客户端提交的单条离线变更
+type syncChange struct {
+	// Entity 变更的实体类型：task（缺省）、project、view 或 time_entry
+	Entity  string                 `json:"entity"`
+	LocalID string                 `json:"local_id"`
+	Op      string                 `json:"op"`
+	Payload map[string]interface{} `json:"payload"`
+	CV      int                    `json:"client_version"`
+	// UpdatedAt 客户端修改时间（RFC3339），供 last_writer_wins 策略使用
+	UpdatedAt string `json:"updated_at"`
+}
+
+// orderSyncChanges 调整变更顺序：项目变更排在任务变更之前，使任务可以通过 project_local_id 引用同批次新建的项目；
+// 工时记录排在最后，使其可以通过 task_local_id 引用同批次新建的任务；
+// 通过 parent_local_id 引用同批次其他插入的子任务排在父任务之后
+// 其余变更保持原有顺序；引用成环时按原顺序处理剩余变更
+func orderSyncChanges(changes []syncChange) []syncChange {
+	projects := []syncChange{}
+	entries := []syncChange{}
+	tasks := make([]syncChange, 0, len(changes))
+	for _, c := range changes {
+		switch c.Entity {
+		case db.EntityProject:
+			projects = append(projects, c)
+		case db.EntityTimeEntry:
+			entries = append(entries, c)
+		default:
+			tasks = append(tasks, c)
+		}
+	}
+	changes = tasks
+
+	pending := map[string]int{}
+	for _, c := range changes {
+		if strings.ToLower(c.Op) == "insert" && c.LocalID != "" {
+			pending[c.LocalID]++
+		}
+	}
+
+	ordered := make([]syncChange, 0, len(changes))
+	done := make([]bool, len(changes))
+	for len(ordered) < len(changes) {
+		progressed := false
+		for i, c := range changes {
+			if done[i] {
+				continue
 			}
-			if v, ok := c.Payload["description"].(string); ok {
-				description = v
+			if parent, _ := c.Payload["parent_local_id"].(string); parent != "" && parent != c.LocalID && pending[parent] > 0 {
+				continue
 			}
-			if v, ok := c.Payload["status"].(string); ok {
-				status = v
+			done[i] = true
+			progressed = true
+			ordered = append(ordered, c)
+			if strings.ToLower(c.Op) == "insert" && c.LocalID != "" {
+				pending[c.LocalID]--
 			}
-			if v, ok := c.Payload["priority"].(string); ok {
-				priority = v
+		}
+		if !progressed {
+			for i, c := range changes {
+				if !done[i] {
+					ordered = append(ordered, c)
+				}
 			}
+			break
+		}
+	}
+	return append(append(projects, ordered...), entries...)
+}
 
-			// ✅ 检查 local_id 是否已存在（冲突检测）
-			existingID, _, checkErr := db.TaskExistsByLocalID(userID, c.LocalID)
-			if checkErr == nil && existingID > 0 {
-				// 冲突：local_id重复，使用生成的新标题插入
-				newTitle := fmt.Sprintf("%s (副本: %d)", title, existingID)
-				res, insertErr := tx.Exec(
-					"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, created_at, updated_at, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-					userID, c.LocalID+"_"+randomString(8), 1, newTitle, description, status, priority, now, now, now,
-				)
+// syncBlockedChange reject 模式下因前置任务未完成而拒绝的同步更新，任务保持服务器状态
+func syncBlockedChange(localID string, taskID int64, blockedErr *db.BlockedError) map[string]interface{} {
+	return map[string]interface{}{
+		"local_id": localID, "server_id": taskID, "op": "update",
+		"blocked": true, "open_blockers": blockedErr.Blockers, "error": blockedErr.Error(),
+	}
+}
 
-				if insertErr == nil {
-					if serverID, err2 := res.LastInsertId(); err2 == nil {
-						serverChanges = append(serverChanges, map[string]interface{}{
-							"id": serverID, "server_version": 1, "title": newTitle, "updated_at": now.Format(time.RFC3339), "is_deleted": false,
-						})
-						clientChanges = append(clientChanges, map[string]interface{}{
-							"local_id": c.LocalID, "server_id": serverID, "op": "insert",
-						})
+// addBlockerWarning warn 模式下同步更新仍有未完成的前置任务时，与 PATCH /tasks/{id} 一样返回 warning 与 open_blockers
+func addBlockerWarning(change map[string]interface{}, blockers []int64) {
+	if len(blockers) == 0 {
+		return
+	}
+	change["open_blockers"] = blockers
+	change["warning"] = (&db.BlockedError{Blockers: blockers}).Error()
+}
 
-						// 记录冲突
-						recordConflict(c.LocalID, existingID, "duplicate_insert", userID)
-						conflicts = append(conflicts, map[string]interface{}{
-							"local_id":  c.LocalID,
-							"server_id": existingID,
-							"reason":    "duplicate_insert",
-						})
-					}
-				} else {
-					log.Printf("插入副本失败: %v", insertErr)
-					syncFailed = true
-				}
-			} else {
-				// 正常插入
-				res, insertErr := tx.Exec(
-					"INSERT INTO tasks (user_id, local_id, server_version, title, description, status, priority, created_at, updated_at, last_modified) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-					userID, c.LocalID, 1, title, description, status, priority, now, now, now,
-				)
+// applyTaskRelations 应用同步变更中的父任务（仅插入时）、标签、项目与负责人
+// 无效的引用不影响任务本身，原因写入 change 的 parent_error、tags_error、project_error 或 assignee_error；返回是否发生了内部错误
+func applyTaskRelations(tx *sql.Tx, userID int, deviceID string, taskID int64, payload map[string]interface{}, inserting bool, change map[string]interface{}) bool {
+	failed := false
+	if inserting {
+		if parentErr, err := attachSyncParent(tx, userID, deviceID, taskID, payload); err != nil {
+			log.Printf("设置父任务失败: %v", err)
+			failed = true
+		} else if parentErr != "" {
+			change["parent_error"] = parentErr
+		}
+	}
+	if tagsErr, err := applySyncTags(tx, userID, taskID, payload); err != nil {
+		log.Printf("设置任务标签失败: %v", err)
+		failed = true
+	} else if tagsErr != "" {
+		change["tags_error"] = tagsErr
+	}
+	if projectErr, err := applySyncProject(tx, userID, deviceID, taskID, payload); err != nil {
+		log.Printf("设置任务项目失败: %v", err)
+		failed = true
+	} else if projectErr != "" {
+		change["project_error"] = projectErr
+	}
+	if assigneeErr, err := applySyncAssignee(tx, userID, taskID, payload); err != nil {
+		log.Printf("设置任务负责人失败: %v", err)
+		failed = true
+	} else if assigneeErr != "" {
+		change["assignee_error"] = assigneeErr
+	}
+	return failed
+}
 
-				if insertErr == nil {
-					if serverID, err2 := res.LastInsertId(); err2 == nil {
-						serverChanges = append(serverChanges, map[string]interface{}{
-							"id": serverID, "server_version": 1, "title": title, "updated_at": now.Format(time.RFC3339),
-							"description": description, "status": status, "priority": priority, "is_deleted": false,
-						})
-						clientChanges = append(clientChanges, map[string]interface{}{
-							"local_id": c.LocalID, "server_id": serverID, "op": "insert",
-						})
-					}
-				} else {
-					log.Printf("插入任务失败: %v", insertErr)
-					syncFailed = true
-				}
-			}
+// attachSyncParent 按同步插入中的 parent_id（服务器 ID）或 parent_local_id（客户端本地 ID）设置父任务
+// 父任务无效或超过层数限制时任务保留在顶层，返回提示给客户端的原因
+func attachSyncParent(tx *sql.Tx, userID int, deviceID string, taskID int64, payload map[string]interface{}) (string, error) {
+	var parentID int64
+	if v, ok := payload["parent_id"].(float64); ok && v > 0 {
+		parentID = int64(v)
+	} else if localID, ok := payload["parent_local_id"].(string); ok && localID != "" {
+		id, _, err := db.TaskExistsByLocalID(tx, userID, localID)
+		if err == sql.ErrNoRows {
+			return db.ErrInvalidParent.Error(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		parentID = id
+	}
+	if parentID == 0 {
+		return "", nil
+	}
 
-		case "update":
-			if idVal, ok := c.Payload["id"].(float64); ok {
-				id := int64(idVal)
+	err := db.SetSyncTaskParent(tx, userID, deviceID, taskID, parentID)
+	if _, ok := err.(*db.TaskDepthError); ok || err == db.ErrInvalidParent {
+		return err.Error(), nil
+	}
+	return "", err
+}
 
-				// ✅ 获取服务器当前版本和数据
-				oldVer, serverTitle, serverDesc, serverStatus, _, err := db.GetTaskForSync(id)
+// applySyncTags 按同步载荷中的 tags（标签名数组）替换任务的标签，载荷不含 tags 时保持不变
+// 标签无效时任务的标签保持不变，返回提示给客户端的原因
+func applySyncTags(tx *sql.Tx, userID int, taskID int64, payload map[string]interface{}) (string, error) {
+	raw, ok := payload["tags"]
+	if !ok {
+		return "", nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok && raw != nil {
+		return "tags 必须是字符串数组", nil
+	}
+	names := make([]string, 0, len(values))
+	for _, v := range values {
+		name, ok := v.(string)
+		if !ok {
+			return "tags 必须是字符串数组", nil
+		}
+		names = append(names, name)
+	}
+	names, err := db.NormalizeTagNames(names)
+	if err != nil {
+		return err.Error(), nil
+	}
+	return "", db.SetSyncTaskTags(tx, userID, taskID, names)
+}
 
-				if err != nil {
-					log.Printf("查询任务失败: %v", err)
-					syncFailed = true
-					continue
-				}
+// applySyncProject 按同步载荷中的 project_id（服务器 ID，0 或 null 表示移出项目）或 project_local_id 以及 project_rank 设置任务所属的项目
+// 载荷不含这些字段时保持不变；项目或排序键无效时保持不变，返回提示给客户端的原因
+func applySyncProject(tx *sql.Tx, userID int, deviceID string, taskID int64, payload map[string]interface{}) (string, error) {
+	rawID, hasID := payload["project_id"]
+	localID, _ := payload["project_local_id"].(string)
+	rawRank, hasRank := payload["project_rank"]
+	if !hasID && localID == "" && !hasRank {
+		return "", nil
+	}
+
+	var rank *string
+	if hasRank && rawRank != nil {
+		v, ok := rawRank.(string)
+		if !ok || !fracindex.Valid(v) {
+			return fracindex.ErrInvalidKey.Error(), nil
+		}
+		rank = &v
+	}
+
+	var projectID *int64
+	if v, ok := rawID.(float64); ok && v > 0 {
+		id := int64(v)
+		projectID = &id
+	} else if localID != "" {
+		id, err := db.ProjectIDByLocalID(tx, userID, localID)
+		if err == sql.ErrNoRows {
+			return db.ErrInvalidProject.Error(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		projectID = &id
+	} else if hasID {
+		none := int64(0)
+		projectID = &none
+	}
 
-				newVer := oldVer + 1
+	err := db.SetSyncTaskProject(tx, userID, deviceID, taskID, projectID, rank)
+	if err == db.ErrInvalidProject {
+		return err.Error(), nil
+	}
+	return "", err
+}
 
-				// ✅ 版本检查和冲突检测
-				if c.CV != oldVer {
-					log.Printf("检测到冲突: client_version=%d, server_version=%d", c.CV, oldVer)
+// applySyncAssignee 按同步载荷中的 assignee_id（用户 ID，0 或 null 表示取消指派）设置任务的负责人
+// 载荷不含该字段时保持不变；负责人无效时保持不变，返回提示给客户端的原因
+func applySyncAssignee(tx *sql.Tx, userID int, taskID int64, payload map[string]interface{}) (string, error) {
+	raw, ok := payload["assignee_id"]
+	if !ok {
+		return "", nil
+	}
+	assigneeID := 0
+	if raw != nil {
+		v, ok := raw.(float64)
+		if !ok || v < 0 || v != float64(int(v)) {
+			return "无效的负责人ID", nil
+		}
+		assigneeID = int(v)
+	}
+	err := db.SetSyncTaskAssignee(tx, userID, taskID, assigneeID)
+	if err == db.ErrInvalidAssignee {
+		return err.Error(), nil
+	}
+	return "", err
+}
 
-					// 获取客户端更新
-					clientTitle := ""
-					clientDesc := ""
-					clientStatus := serverStatus
+// syncTouchesAssignment 判断同步载荷是否可能改变任务的负责人或完成状态
+func syncTouchesAssignment(payload map[string]interface{}) bool {
+	_, assignee := payload["assignee_id"]
+	_, status := payload["status"]
+	return assignee || status
+}
 
-					if v, ok := c.Payload["title"].(string); ok {
-						clientTitle = v
+// syncProjectFields 解析同步载荷中的项目字段，字段无效时返回原因
+func syncProjectFields(payload map[string]interface{}) (db.ProjectFields, string) {
+	req := projectWriteReq{}
+	if v, ok := payload["name"].(string); ok {
+		req.Name = &v
+	}
+	if v, ok := payload["color"].(string); ok {
+		req.Color = &v
+	}
+	if v, ok := payload["archived"].(bool); ok {
+		req.Archived = &v
+	}
+	if v, ok := payload["rank"].(string); ok {
+		req.Rank = &v
+	}
+	for _, msg := range req.validate(false) {
+		return db.ProjectFields{}, msg
+	}
+	return req.fields(), ""
+}
+
+// applySyncProjectChange 应用客户端提交的项目变更，项目不做版本冲突检测，以最后提交的内容为准
+// 变更无效时不写入，在返回的 client_changes 项中给出 error
+func applySyncProjectChange(tx *sql.Tx, userID int, deviceID string, c syncChange) (map[string]interface{}, error) {
+	op := strings.ToLower(c.Op)
+	change := map[string]interface{}{"entity": db.EntityProject, "local_id": c.LocalID, "op": op}
+	f, fieldErr := syncProjectFields(c.Payload)
+	if fieldErr != "" {
+		change["error"] = fieldErr
+		return change, nil
+	}
+
+	switch op {
+	case "insert":
+		// 重复提交同一 local_id 的插入时返回已创建的项目
+		if c.LocalID != "" {
+			id, err := db.ProjectIDByLocalID(tx, userID, c.LocalID)
+			if err == nil {
+				change["server_id"] = id
+				return change, nil
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+		if f.Name == nil {
+			change["error"] = "项目名称不能为空"
+			return change, nil
+		}
+		id, _, err := db.InsertSyncProject(tx, userID, deviceID, c.LocalID, f)
+		if err != nil {
+			return nil, err
+		}
+		change["server_id"] = id
+
+	case "update", "delete":
+		idVal, ok := c.Payload["id"].(float64)
+		if !ok {
+			change["error"] = "缺少项目ID"
+			return change, nil
+		}
+		id := int64(idVal)
+		change["server_id"] = id
+		var err error
+		if op == "update" {
+			_, err = db.UpdateSyncProject(tx, userID, deviceID, id, f)
+		} else {
+			_, err = db.DeleteSyncProject(tx, userID, deviceID, id)
+		}
+		if err == db.ErrProjectNotFound || err == db.ErrProjectForbidden {
+			change["error"] = err.Error()
+			return change, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		change["error"] = "不支持的操作: " + c.Op
+	}
+	return change, nil
+}
+
+// syncViewFields 解析同步载荷中的视图字段，字段无效时返回原因
+func syncViewFields(payload map[string]interface{}) (db.ViewFields, string) {
+	req := viewWriteReq{}
+	if v, ok := payload["name"].(string); ok {
+		req.Name = &v
+	}
+	if raw, ok := payload["filters"].(map[string]interface{}); ok {
+		req.Filters = map[string]string{}
+		for key, value := range raw {
+			s, ok := value.(string)
+			if !ok {
+				return db.ViewFields{}, "过滤条件 " + key + " 必须是字符串"
+			}
+			req.Filters[key] = s
+		}
+	}
+	if v, ok := payload["sort"].(string); ok {
+		req.Sort = &v
+	}
+	if v, ok := payload["order"].(string); ok {
+		req.Order = &v
+	}
+	for _, msg := range req.validate(false) {
+		return db.ViewFields{}, msg
+	}
+	return req.fields(), ""
+}
+
+// applySyncViewChange 应用客户端提交的视图变更，视图不做版本冲突检测，以最后提交的内容为准
+// 变更无效时不写入，在返回的 client_changes 项中给出 error
+func applySyncViewChange(tx *sql.Tx, userID int, deviceID string, c syncChange) (map[string]interface{}, error) {
+	op := strings.ToLower(c.Op)
+	change := map[string]interface{}{"entity": db.EntityView, "local_id": c.LocalID, "op": op}
+	f, fieldErr := syncViewFields(c.Payload)
+	if fieldErr != "" {
+		change["error"] = fieldErr
+		return change, nil
+	}
+
+	switch op {
+	case "insert":
+		// 重复提交同一 local_id 的插入时返回已创建的视图
+		if c.LocalID != "" {
+			id, err := db.ViewIDByLocalID(tx, userID, c.LocalID)
+			if err == nil {
+				change["server_id"] = id
+				return change, nil
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+		if f.Name == nil || *f.Name == "" {
+			change["error"] = "视图名称不能为空"
+			return change, nil
+		}
+		id, _, err := db.InsertSyncView(tx, userID, deviceID, c.LocalID, f)
+		if err == db.ErrViewLimit {
+			change["error"] = err.Error()
+			return change, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		change["server_id"] = id
+
+	case "update", "delete":
+		idVal, ok := c.Payload["id"].(float64)
+		if !ok {
+			change["error"] = "缺少视图ID"
+			return change, nil
+		}
+		id := int64(idVal)
+		change["server_id"] = id
+		var err error
+		if op == "update" {
+			_, err = db.UpdateSyncView(tx, userID, deviceID, id, f)
+		} else {
+			_, err = db.DeleteSyncView(tx, userID, deviceID, id)
+		}
+		if err == db.ErrViewNotFound {
+			change["error"] = err.Error()
+			return change, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		change["error"] = "不支持的操作: " + c.Op
+	}
+	return change, nil
+}
+
+// syncTimeEntryFields 解析同步载荷中的工时记录字段，ended_at 为空字符串表示计时器仍在运行；字段无效时返回原因
+func syncTimeEntryFields(payload map[string]interface{}) (db.TimeEntryFields, string) {
+	f := db.TimeEntryFields{}
+	for key, dest := range map[string]**time.Time{"started_at": &f.StartedAt, "ended_at": &f.EndedAt} {
+		v, ok := payload[key].(string)
+		if !ok {
+			continue
+		}
+		t := time.Time{}
+		if v != "" || key == "started_at" {
+			var err error
+			if t, err = time.Parse(time.RFC3339, v); err != nil {
+				return db.TimeEntryFields{}, key + " 格式无效，应为 RFC3339"
+			}
+		}
+		*dest = &t
+	}
+	if v, ok := payload["note"].(string); ok {
+		if !validator.IsValidTimeEntryNote(v) {
+			return db.TimeEntryFields{}, "备注不能超过500个字符"
+		}
+		f.Note = &v
+	}
+	return f, ""
+}
+
+// applySyncTimeEntryChange 应用客户端提交的工时记录变更，工时记录不做版本冲突检测，以最后提交的内容为准
+// 插入时通过 task_id（服务器 ID）或 task_local_id（同一批次或之前同步过的任务）指定任务
+// 变更无效（包括已有运行中的计时器时再插入一个）时不写入，在返回的 client_changes 项中给出 error
+func applySyncTimeEntryChange(tx *sql.Tx, userID int, deviceID string, c syncChange) (map[string]interface{}, error) {
+	op := strings.ToLower(c.Op)
+	change := map[string]interface{}{"entity": db.EntityTimeEntry, "local_id": c.LocalID, "op": op}
+	f, fieldErr := syncTimeEntryFields(c.Payload)
+	if fieldErr != "" {
+		change["error"] = fieldErr
+		return change, nil
+	}
+
+	var err error
+	switch op {
+	case "insert":
+		// 重复提交同一 local_id 的插入时返回已创建的记录
+		if c.LocalID != "" {
+			id, err := db.TimeEntryIDByLocalID(tx, userID, c.LocalID)
+			if err == nil {
+				change["server_id"] = id
+				return change, nil
+			}
+			if err != sql.ErrNoRows {
+				return nil, err
+			}
+		}
+		var taskID int64
+		if v, ok := c.Payload["task_id"].(float64); ok && v > 0 {
+			taskID = int64(v)
+		} else if localID, ok := c.Payload["task_local_id"].(string); ok && localID != "" {
+			id, _, err := db.TaskExistsByLocalID(tx, userID, localID)
+			if err == sql.ErrNoRows {
+				change["error"] = db.ErrTaskNotFound.Error()
+				return change, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			taskID = id
+		}
+		if taskID == 0 {
+			change["error"] = "缺少任务ID"
+			return change, nil
+		}
+		if f.StartedAt == nil {
+			change["error"] = "开始时间不能为空"
+			return change, nil
+		}
+		var id int64
+		if id, _, err = db.InsertSyncTimeEntry(tx, userID, deviceID, c.LocalID, taskID, f); err == nil {
+			change["server_id"] = id
+		}
+
+	case "update", "delete":
+		idVal, ok := c.Payload["id"].(float64)
+		if !ok {
+			change["error"] = "缺少工时记录ID"
+			return change, nil
+		}
+		id := int64(idVal)
+		change["server_id"] = id
+		if op == "update" {
+			_, err = db.UpdateSyncTimeEntry(tx, userID, deviceID, id, f)
+		} else {
+			_, err = db.DeleteSyncTimeEntry(tx, userID, deviceID, id)
+		}
+
+	default:
+		change["error"] = "不支持的操作: " + c.Op
+	}
+
+	if _, ok := err.(*db.RunningTimerError); ok {
+		change["error"] = err.Error()
+		return change, nil
+	}
+	switch err {
+	case nil:
+	case db.ErrTimeEntryNotFound, db.ErrInvalidTimeRange, db.ErrTaskNotFound, db.ErrTaskForbidden:
+		change["error"] = err.Error()
+	default:
+		return nil, err
+	}
+	return change, nil
+}
+
+func handleSync(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	var s syncReq
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		response.ErrorResponse(w, "错误的请求", http.StatusBadRequest)
+		return
+	}
+
+	// 从上下文获取用户 ID（已认证的用户）
+	userIDStr := getUserIDFromContext(r.Context())
+	if userIDStr == "" {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		response.ErrorResponse(w, "无效的用户 ID", http.StatusUnauthorized)
+		return
+	}
+
+	deviceID := s.DeviceID
+	if deviceID == "" {
+		deviceID = deviceIDFromRequest(r)
+	}
+
+	now := time.Now().UTC()
+
+	// ✅ 使用事务保护同步操作（推送、拉取与进度记录在同一事务中完成）
+	tx, err := db.DB.Begin()
+	if err != nil {
+		log.Printf("事务开始失败: %v", err)
+		response.ErrorResponse(w, "事务开始失败", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	startSeq, err := db.LatestChangeSeq(tx, userID)
+	if err != nil {
+		log.Printf("读取变更序号失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+	startLogID, err := db.LatestChangeLogID(tx)
+	if err != nil {
+		log.Printf("读取变更序号失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+	startActivityID, err := db.LatestActivityID(tx)
+	if err != nil {
+		log.Printf("读取任务动态失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	serverChanges := []map[string]interface{}{}
+	clientChanges := []map[string]interface{}{}
+	conflicts := []map[string]interface{}{}
+	syncFailed := false
+	var strategies map[string]string
+	// 涉及负责人或状态的任务及其写入前的指派状态，提交后据此发送通知
+	assignBefore := map[int64]db.TaskAssignment{}
+	assignIDs := []int64{}
+	// 本次同步提交了工时记录时，提交后向用户的全部设备推送计时器状态
+	timeEntriesSynced := false
+
+	for _, c := range orderSyncChanges(s.Changes) {
+		if c.Entity == db.EntityProject {
+			change, err := applySyncProjectChange(tx, userID, deviceID, c)
+			if err != nil {
+				log.Printf("同步项目失败: %v", err)
+				syncFailed = true
+				continue
+			}
+			clientChanges = append(clientChanges, change)
+			continue
+		}
+		if c.Entity == db.EntityView {
+			change, err := applySyncViewChange(tx, userID, deviceID, c)
+			if err != nil {
+				log.Printf("同步视图失败: %v", err)
+				syncFailed = true
+				continue
+			}
+			clientChanges = append(clientChanges, change)
+			continue
+		}
+		if c.Entity == db.EntityTimeEntry {
+			change, err := applySyncTimeEntryChange(tx, userID, deviceID, c)
+			if err != nil {
+				log.Printf("同步工时记录失败: %v", err)
+				syncFailed = true
+				continue
+			}
+			clientChanges = append(clientChanges, change)
+			timeEntriesSynced = true
+			continue
+		}
+
+		op := strings.ToLower(c.Op)
+		normalizeSyncDueAt(c.Payload)
+
+		switch op {
+		case "insert":
+			title := ""
+			description := ""
+			status := "todo"
+			priority := "medium"
+
+			if v, ok := c.Payload["title"].(string); ok {
+				title = v
+			}
+			if v, ok := c.Payload["description"].(string); ok {
+				description = v
+			}
+			if v, ok := c.Payload["status"].(string); ok {
+				status = v
+			}
+			if v, ok := c.Payload["priority"].(string); ok {
+				priority = v
+			}
+			dueAt, _ := c.Payload["due_at"].(string)
+
+			// ✅ 检查 local_id 是否已存在（冲突检测）
+			existingID, _, checkErr := db.TaskExistsByLocalID(tx, userID, c.LocalID)
+			if checkErr == nil && existingID > 0 {
+				// 冲突：local_id重复，使用生成的新标题插入
+				newTitle := fmt.Sprintf("%s (副本: %d)", title, existingID)
+				serverID, newVer, insertErr := db.InsertSyncTask(tx, userID, deviceID, c.LocalID+"_"+randomString(8), newTitle, description, status, priority, syncDueAt(dueAt))
+
+				if insertErr == nil {
+					serverChanges = append(serverChanges, map[string]interface{}{
+						"id": serverID, "server_version": newVer, "title": newTitle, "updated_at": now.Format(time.RFC3339), "is_deleted": false,
+					})
+					change := map[string]interface{}{
+						"local_id": c.LocalID, "server_id": serverID, "op": "insert",
 					}
-					if v, ok := c.Payload["description"].(string); ok {
-						clientDesc = v
+					if applyTaskRelations(tx, userID, deviceID, serverID, c.Payload, true, change) {
+						syncFailed = true
 					}
-					if v, ok := c.Payload["status"].(string); ok {
-						clientStatus = v
+					if _, ok := c.Payload["assignee_id"]; ok {
+						assignIDs = append(assignIDs, serverID)
 					}
+					clientChanges = append(clientChanges, change)
+
+					// 记录冲突
+					conflictID := recordConflict(tx, userID, c.LocalID, existingID, "duplicate_insert", nil)
+					conflicts = append(conflicts, map[string]interface{}{
+						"id":        conflictID,
+						"local_id":  c.LocalID,
+						"server_id": existingID,
+						"reason":    "duplicate_insert",
+					})
+				} else {
+					log.Printf("插入副本失败: %v", insertErr)
+					syncFailed = true
+				}
+			} else {
+				// 正常插入
+				serverID, newVer, insertErr := db.InsertSyncTask(tx, userID, deviceID, c.LocalID, title, description, status, priority, syncDueAt(dueAt))
 
-					// ✅ 智能合并
-					mergedTitle, mergedDesc, mergedStatus := intelligentMerge(serverTitle, serverDesc, serverStatus, map[string]interface{}{
-						"title":       clientTitle,
-						"description": clientDesc,
-						"status":      clientStatus,
+				if insertErr == nil {
+					serverChanges = append(serverChanges, map[string]interface{}{
+						"id": serverID, "server_version": newVer, "title": title, "updated_at": now.Format(time.RFC3339),
+						"description": description, "status": status, "priority": priority, "due_at": dueAt, "is_deleted": false,
 					})
+					change := map[string]interface{}{
+						"local_id": c.LocalID, "server_id": serverID, "op": "insert",
+					}
+					if applyTaskRelations(tx, userID, deviceID, serverID, c.Payload, true, change) {
+						syncFailed = true
+					}
+					if _, ok := c.Payload["assignee_id"]; ok {
+						assignIDs = append(assignIDs, serverID)
+					}
+					clientChanges = append(clientChanges, change)
+				} else {
+					log.Printf("插入任务失败: %v", insertErr)
+					syncFailed = true
+				}
+			}
 
-					// 应用合并结果
-					priority := "medium"
-					if v, ok := c.Payload["priority"].(string); ok {
-						priority = v
+		case "update":
+			if idVal, ok := c.Payload["id"].(float64); ok {
+				id := int64(idVal)
+
+				// ✅ 获取服务器当前版本和数据
+				current, err := db.GetTaskForSync(tx, userID, id)
+				if err == db.ErrTaskForbidden {
+					// 共享项目中没有编辑权限（viewer 或已被移出），拒绝该变更，客户端以拉取到的服务器状态为准
+					clientChanges = append(clientChanges, map[string]interface{}{
+						"local_id": c.LocalID, "server_id": id, "op": "update", "error": err.Error(),
+					})
+					continue
+				}
+				if err != nil {
+					log.Printf("查询任务失败: %v", err)
+					syncFailed = true
+					continue
+				}
+				if syncTouchesAssignment(c.Payload) {
+					if _, seen := assignBefore[id]; !seen {
+						snapshot, err := db.GetTaskAssignments(tx, []int64{id})
+						if err != nil {
+							log.Printf("读取任务指派失败: %v", err)
+						} else if a, ok := snapshot[id]; ok {
+							assignBefore[id] = a
+							assignIDs = append(assignIDs, id)
+						}
 					}
+				}
 
-					updateErr := db.UpdateTaskWithVersion(id, mergedTitle, mergedDesc, mergedStatus, priority, newVer)
-					if updateErr != nil {
+				// ✅ 版本检查和冲突检测
+				if c.CV != current.Version {
+					log.Printf("检测到冲突: client_version=%d, server_version=%d", c.CV, current.Version)
+
+					// ✅ 按字段策略合并，三方合并以客户端修改所基于的版本为基准
+					base, err := db.GetTaskRevision(tx, id, c.CV)
+					if err != nil {
+						log.Printf("查询基准版本失败: %v", err)
+						syncFailed = true
+						continue
+					}
+					if strategies == nil {
+						strategies = loadMergeStrategies()
+					}
+					clientTime, _ := time.Parse(time.RFC3339, c.UpdatedAt)
+					merged, fieldConflicts := mergeSyncFields(current, base, c.Payload, clientTime, strategies)
+					mergedTitle, mergedDesc, mergedStatus, priority, dueAt := merged["title"], merged["description"], merged["status"], merged["priority"], merged["due_at"]
+
+					newVer, blockers, updateErr := db.UpdateTaskWithVersion(tx, userID, deviceID, id, mergedTitle, mergedDesc, mergedStatus, priority, syncDueAt(dueAt))
+					if blockedErr, ok := updateErr.(*db.BlockedError); ok {
+						clientChanges = append(clientChanges, syncBlockedChange(c.LocalID, id, blockedErr))
+					} else if updateErr != nil {
 						log.Printf("应用合并结果失败: %v", updateErr)
 						syncFailed = true
 					} else {
 						serverChanges = append(serverChanges, map[string]interface{}{
 							"id": id, "server_version": newVer, "title": mergedTitle, "description": mergedDesc,
-							"status": mergedStatus, "priority": priority, "updated_at": now.Format(time.RFC3339), "is_deleted": false,
+							"status": mergedStatus, "priority": priority, "due_at": dueAt, "updated_at": now.Format(time.RFC3339), "is_deleted": false,
 						})
-						clientChanges = append(clientChanges, map[string]interface{}{
+						change := map[string]interface{}{
 							"local_id": c.LocalID, "server_id": id, "op": "update",
-						})
+						}
+						addBlockerWarning(change, blockers)
+						if applyTaskRelations(tx, userID, deviceID, id, c.Payload, false, change) {
+							syncFailed = true
+						}
+						clientChanges = append(clientChanges, change)
 
-						// 记录冲突
-						recordConflict(c.LocalID, id, "intelligent_merge", userID)
-						conflicts = append(conflicts, map[string]interface{}{
-							"local_id":   c.LocalID,
-							"server_id":  id,
-							"reason":     "intelligent_merge",
-							"resolution": "智能合并",
-							"merged_data": map[string]interface{}{
-								"title":       mergedTitle,
-								"description": mergedDesc,
-								"status":      mergedStatus,
-							},
-						})
+						// 只有双方修改重叠的字段才记录冲突，其余修改已自动合并
+						if len(fieldConflicts) > 0 {
+							// 记录冲突：保存各字段的服务器值、客户端值、合并结果与所用策略，供用户事后修正
+							conflictID := recordConflict(tx, userID, c.LocalID, id, "intelligent_merge", fieldConflicts)
+							conflicts = append(conflicts, map[string]interface{}{
+								"id":         conflictID,
+								"local_id":   c.LocalID,
+								"server_id":  id,
+								"reason":     "intelligent_merge",
+								"resolution": "按字段策略合并",
+								"conflicts":  fieldConflicts,
+								"merged_data": map[string]interface{}{
+									"title":       mergedTitle,
+									"description": mergedDesc,
+									"status":      mergedStatus,
+								},
+							})
+						}
 					}
 				} else {
 					// 正常更新
-					title := serverTitle
-					description := serverDesc
-					status := serverStatus
-					priority := "medium"
+					title := current.Title
+					description := current.Description
+					status := current.Status
+					priority := current.Priority
+					dueAt := current.DueAt
 
 					if v, ok := c.Payload["title"].(string); ok {
 						title = v
@@ -862,19 +2000,29 @@ func handleSync(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
 					if v, ok := c.Payload["priority"].(string); ok {
 						priority = v
 					}
+					if v, ok := c.Payload["due_at"].(string); ok {
+						dueAt = v
+					}
 
-					updateErr := db.UpdateTaskWithVersion(id, title, description, status, priority, newVer)
-					if updateErr != nil {
+					newVer, blockers, updateErr := db.UpdateTaskWithVersion(tx, userID, deviceID, id, title, description, status, priority, syncDueAt(dueAt))
+					if blockedErr, ok := updateErr.(*db.BlockedError); ok {
+						clientChanges = append(clientChanges, syncBlockedChange(c.LocalID, id, blockedErr))
+					} else if updateErr != nil {
 						log.Printf("更新任务失败: %v", updateErr)
 						syncFailed = true
 					} else {
 						serverChanges = append(serverChanges, map[string]interface{}{
 							"id": id, "server_version": newVer, "title": title, "updated_at": now.Format(time.RFC3339),
-							"description": description, "status": status, "priority": priority, "is_deleted": false,
+							"description": description, "status": status, "priority": priority, "due_at": dueAt, "is_deleted": false,
 						})
-						clientChanges = append(clientChanges, map[string]interface{}{
+						change := map[string]interface{}{
 							"local_id": c.LocalID, "server_id": id, "op": "update",
-						})
+						}
+						addBlockerWarning(change, blockers)
+						if applyTaskRelations(tx, userID, deviceID, id, c.Payload, false, change) {
+							syncFailed = true
+						}
+						clientChanges = append(clientChanges, change)
 					}
 				}
 			}
@@ -883,397 +2031,3050 @@ func handleSync(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
 			if idVal, ok := c.Payload["id"].(float64); ok {
 				id := int64(idVal)
 
-				oldVer, _, _, _, isDeleted, err := db.GetTaskForSync(id)
-
+				current, err := db.GetTaskForSync(tx, userID, id)
+				if err == db.ErrTaskForbidden {
+					// 共享项目中没有编辑权限（viewer 或已被移出），拒绝该变更，客户端以拉取到的服务器状态为准
+					clientChanges = append(clientChanges, map[string]interface{}{
+						"local_id": c.LocalID, "server_id": id, "op": "delete", "error": err.Error(),
+					})
+					continue
+				}
 				if err != nil {
 					log.Printf("查询任务失败: %v", err)
 					syncFailed = true
 					continue
 				}
 
-				newVer := oldVer + 1
+				// ✅ 版本检查和冲突检测
+				if c.CV != current.Version {
+					log.Printf("删除冲突检测: client_version=%d, server_version=%d", c.CV, current.Version)
+
+					if current.IsDeleted {
+						// 服务器已删除，忽略客户端删除
+						continue
+					} else {
+						// 冲突：客户端想删除但服务器有更新
+						// 策略：软删除，记录冲突
+						newVer, deleteErr := db.SoftDeleteTaskWithVersion(tx, userID, deviceID, id)
+						if deleteErr != nil {
+							log.Printf("删除任务失败: %v", deleteErr)
+							syncFailed = true
+						} else {
+							serverChanges = append(serverChanges, map[string]interface{}{
+								"id": id, "server_version": newVer, "is_deleted": true, "updated_at": now.Format(time.RFC3339),
+							})
+							clientChanges = append(clientChanges, map[string]interface{}{
+								"local_id": c.LocalID, "server_id": id, "op": "delete",
+							})
+
+							// 记录冲突：服务器被标记为删除，保留服务器版本即恢复任务
+							fieldConflicts := []types.FieldLevelConflict{{
+								FieldName: "is_deleted", ServerValue: false, ClientValue: true, MergedValue: true,
+							}}
+							conflictID := recordConflict(tx, userID, c.LocalID, id, "delete_while_modified", fieldConflicts)
+							conflicts = append(conflicts, map[string]interface{}{
+								"id":        conflictID,
+								"local_id":  c.LocalID,
+								"server_id": id,
+								"reason":    "delete_while_modified",
+								"conflicts": fieldConflicts,
+							})
+						}
+					}
+				} else {
+					// 正常删除
+					newVer, deleteErr := db.SoftDeleteTaskWithVersion(tx, userID, deviceID, id)
+					if deleteErr != nil {
+						log.Printf("删除任务失败: %v", deleteErr)
+						syncFailed = true
+					} else {
+						serverChanges = append(serverChanges, map[string]interface{}{
+							"id": id, "server_version": newVer, "is_deleted": true, "updated_at": now.Format(time.RFC3339),
+						})
+						clientChanges = append(clientChanges, map[string]interface{}{
+							"local_id": c.LocalID, "server_id": id, "op": "delete",
+						})
+					}
+				}
+			}
+		}
+	}
+
+	// 拉取游标：请求中的 last_seq 优先，其次为该设备记录的同步进度，再次按 last_sync_at 换算，都没有时全量拉取
+	// 没有设备 ID 的请求不读写 sync_meta，避免多个设备共用一份进度而漏掉彼此的变更
+	sinceSeq := 0
+	found := false
+	if s.LastSeq != nil {
+		sinceSeq, found = *s.LastSeq, true
+	} else if deviceID != "" {
+		if sinceSeq, found, err = db.GetSyncProgress(tx, userID, deviceID); err != nil {
+			log.Printf("读取同步进度失败: %v", err)
+			response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !found && s.LastSyncAt != "" {
+		if since, err := time.Parse(time.RFC3339, s.LastSyncAt); err == nil {
+			if sinceSeq, err = db.ChangeSeqBefore(tx, userID, since); err != nil {
+				log.Printf("读取变更序号失败: %v", err)
+				response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// ✅ 按变更日志拉取其他设备产生的变更（包含已删除任务的墓碑）
+	pulled, _, _, err := db.GetTaskChangesSince(tx, userID, sinceSeq, 0)
+	if err != nil {
+		log.Printf("拉取变更失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	// 拉取结果为服务器最新状态，覆盖本次推送产生的同一任务记录
+	pulledIDs := make(map[int64]bool, len(pulled))
+	for _, t := range pulled {
+		pulledIDs[t["id"].(int64)] = true
+	}
+	for _, t := range serverChanges {
+		if !pulledIDs[t["id"].(int64)] {
+			pulled = append(pulled, t)
+		}
+	}
+	serverChanges = pulled
+
+	projectChanges, _, err := db.GetProjectChangesSince(tx, userID, sinceSeq, 0)
+	if err != nil {
+		log.Printf("拉取项目变更失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	viewChanges, _, err := db.GetViewChangesSince(tx, userID, sinceSeq, 0)
+	if err != nil {
+		log.Printf("拉取视图变更失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	timeEntryChanges, _, err := db.GetTimeEntryChangesSince(tx, userID, sinceSeq, 0)
+	if err != nil {
+		log.Printf("拉取工时记录变更失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	lastSeq, err := db.LatestChangeSeq(tx, userID)
+	if err != nil {
+		log.Printf("读取变更序号失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+	if deviceID != "" {
+		if err := db.SaveSyncProgress(tx, userID, deviceID, lastSeq, now); err != nil {
+			log.Printf("保存同步进度失败: %v", err)
+			response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("事务提交失败: %v", err)
+		response.ErrorResponse(w, "同步失败", http.StatusInternalServerError)
+		return
+	}
+
+	// 推送本次同步写入的变更到用户的实时连接
+	pushTaskChanges(wsHub, userID, changeMark{seq: startSeq, logID: startLogID, activityID: startActivityID})
+	if len(assignIDs) > 0 {
+		notifyTaskAssignments(wsHub, userID, assignBefore, assignIDs)
+	}
+	if timeEntriesSynced {
+		pushTimer(wsHub, userID)
+	}
+
+	// 发送同步结果通知
+	if syncFailed {
+		sendNotificationToUser(userID, "sync_failed", "同步失败", "部分任务同步失败，请检查网络连接", "high", wsHub)
+	} else if len(conflicts) > 0 {
+		sendNotificationToUser(userID, "sync_conflict", "同步完成但存在冲突", fmt.Sprintf("成功同步 %d 个任务，但检测到 %d 个冲突", len(clientChanges), len(conflicts)), "high", wsHub)
+	} else if len(clientChanges) > 0 {
+		sendNotificationToUser(userID, "sync_success", "同步完成", fmt.Sprintf("成功同步 %d 个任务", len(clientChanges)), "normal", wsHub)
+	}
+
+	resp := map[string]interface{}{
+		"server_changes":     serverChanges,
+		"project_changes":    projectChanges,
+		"view_changes":       viewChanges,
+		"time_entry_changes": timeEntryChanges,
+		"client_changes":     clientChanges,
+		"last_sync_at":       now.Format(time.RFC3339),
+		"last_seq":           lastSeq,
+		"conflicts":          conflicts, // ✅ 返回实际冲突
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleDevicePairing 处理设备配对请求
+func handleDevicePairing(w http.ResponseWriter, r *http.Request) {
+	userIDStr := getUserIDFromContext(r.Context())
+	if userIDStr == "" {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		response.ErrorResponse(w, "无效的用户ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Key        string `json:"key"`
+		DeviceType string `json:"device_type"`
+		DeviceID   string `json:"device_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求数据", http.StatusBadRequest)
+		return
+	}
+
+	// 验证必填字段
+	if req.Key == "" || req.DeviceType == "" || req.DeviceID == "" {
+		response.ErrorResponse(w, "配对密钥、设备类型和设备ID为必填项", http.StatusBadRequest)
+		return
+	}
+
+	// 验证密钥格式（64字符hex）
+	if len(req.Key) != 64 {
+		response.ErrorResponse(w, "配对密钥格式无效", http.StatusBadRequest)
+		return
+	}
+
+	// 验证设备类型
+	validDeviceTypes := map[string]bool{"web": true, "android": true, "ios": true}
+	if !validDeviceTypes[req.DeviceType] {
+		response.ErrorResponse(w, "无效的设备类型", http.StatusBadRequest)
+		return
+	}
+
+	// 检查设备是否已配对
+	existing, _ := db.ValidatePairingKey(userID, req.Key)
+	if existing {
+		response.ErrorResponse(w, "该设备已配对", http.StatusConflict)
+		return
+	}
+
+	// 获取服务器URL
+	serverURL := os.Getenv("SERVER_URL")
+	if serverURL == "" {
+		serverURL = "http://localhost:8080"
+	}
+
+	// 注册设备
+	err = db.RegisterDevice(userID, req.DeviceType, req.DeviceID, req.Key, serverURL)
+	if err != nil {
+		log.Printf("设备注册失败: %v", err)
+		response.ErrorResponse(w, "设备注册失败", http.StatusInternalServerError)
+		return
+	}
+
+	// 记录审计日志
+	log.Printf("用户 %d 注册设备: type=%s, id=%s", userID, req.DeviceType, req.DeviceID)
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"status":     "paired",
+		"device_id":  req.DeviceID,
+		"server_url": serverURL,
+	}, http.StatusOK)
+}
+
+// handleListDevices 获取用户的设备列表
+func handleListDevices(w http.ResponseWriter, r *http.Request) {
+	userIDStr := getUserIDFromContext(r.Context())
+	if userIDStr == "" {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	devices, err := db.GetUserDevices(userID)
+	if err != nil {
+		log.Printf("获取设备列表失败: %v", err)
+		response.ErrorResponse(w, "获取设备列表失败", http.StatusInternalServerError)
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"devices": devices,
+		"count":   len(devices),
+	}, http.StatusOK)
+}
+
+// handleRegenerateDeviceKey 重新生成设备配对密钥
+func handleRegenerateDeviceKey(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deviceID := vars["id"]
+
+	if deviceID == "" {
+		response.ErrorResponse(w, "设备ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 验证用户是否有权限操作此设备
+	userID, serverURL, _, isActive, err := db.GetDeviceInfoByDeviceID(deviceID)
+	if err != nil {
+		response.ErrorResponse(w, "设备不存在", http.StatusNotFound)
+		return
+	}
+
+	if !isActive {
+		response.ErrorResponse(w, "设备已撤销", http.StatusBadRequest)
+		return
+	}
+
+	// 检查当前用户是否是设备所有者
+	currentUserIDStr := getUserIDFromContext(r.Context())
+	currentUserID, _ := strconv.Atoi(currentUserIDStr)
+	if currentUserID != userID {
+		response.ErrorResponse(w, "无权操作此设备", http.StatusForbidden)
+		return
+	}
+
+	// 生成新密钥
+	newKey := generateRandomKey()
+
+	// 更新数据库
+	err = db.RegeneratePairingKey(deviceID, newKey)
+	if err != nil {
+		log.Printf("密钥更新失败: %v", err)
+		response.ErrorResponse(w, "密钥更新失败", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("用户 %d 重新生成了设备 %s 的配对密钥", currentUserID, deviceID)
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"status":     "regenerated",
+		"new_key":    newKey,
+		"device_id":  deviceID,
+		"server_url": serverURL,
+	}, http.StatusOK)
+}
+
+// handleRevokeDevice 撤销设备
+func handleRevokeDevice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deviceID := vars["id"]
+
+	if deviceID == "" {
+		response.ErrorResponse(w, "设备ID不能为空", http.StatusBadRequest)
+		return
+	}
+
+	// 验证用户是否有权限操作此设备
+	userID, _, _, isActive, err := db.GetDeviceInfoByDeviceID(deviceID)
+	if err != nil {
+		response.ErrorResponse(w, "设备不存在", http.StatusNotFound)
+		return
+	}
+
+	if !isActive {
+		response.ErrorResponse(w, "设备已撤销", http.StatusBadRequest)
+		return
+	}
+
+	// 检查当前用户是否是设备所有者
+	currentUserIDStr := getUserIDFromContext(r.Context())
+	currentUserID, _ := strconv.Atoi(currentUserIDStr)
+	if currentUserID != userID {
+		response.ErrorResponse(w, "无权操作此设备", http.StatusForbidden)
+		return
+	}
+
+	// 撤销设备
+	err = db.RevokeDevice(deviceID)
+	if err != nil {
+		log.Printf("撤销设备失败: %v", err)
+		response.ErrorResponse(w, "撤销设备失败", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("用户 %d 撤销了设备 %s", currentUserID, deviceID)
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"status":    "revoked",
+		"device_id": deviceID,
+	}, http.StatusOK)
+}
+
+// generateRandomKey 生成32字节随机密钥（hex格式，64字符）
+func generateRandomKey() string {
+	const charset = "0123456789abcdef"
+	b := make([]byte, 64)
+	for i := range b {
+		b[i] = charset[time.Now().UnixNano()%16]
+	}
+	return string(b)
+}
+
+// toImportString 安全地转换JSON中的值为字符串（用于导入功能）
+func toImportString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return fmt.Sprintf("%f", val)
+	case int64:
+		return fmt.Sprintf("%d", val)
+	case bool:
+		if val {
+			return "true"
+		}
+		return "false"
+	case int:
+		return fmt.Sprintf("%d", val)
+	default:
+		return ""
+	}
+}
+
+// toImportTags 将导入数据中的标签（JSON 数组或以分号分隔的字符串）转换为标签名列表
+// 无效的标签名被忽略，超出数量上限的部分被截断
+func toImportTags(v interface{}) []string {
+	var raw []string
+	switch val := v.(type) {
+	case string:
+		raw = strings.Split(val, ";")
+	case []interface{}:
+		for _, item := range val {
+			if name, ok := item.(string); ok {
+				raw = append(raw, name)
+			}
+		}
+	}
+
+	names := []string{}
+	seen := map[string]bool{}
+	for _, name := range raw {
+		name, err := db.NormalizeTagName(name)
+		if err != nil || seen[strings.ToLower(name)] {
+			continue
+		}
+		if len(names) == db.MaxTagsPerTask {
+			break
+		}
+		seen[strings.ToLower(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// handleBatchDeleteTasks 批量删除任务
+func handleBatchDeleteTasks(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userIDStr := getUserIDFromContext(r.Context())
+	if userIDStr == "" {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		response.ErrorResponse(w, "无效的用户ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		TaskIDs []int64 `json:"task_ids"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请�数据", http.StatusBadRequest)
+		return
+	}
+
+	if len(req.TaskIDs) == 0 {
+		response.ErrorResponse(w, "未选择要删除的任务", http.StatusBadRequest)
+		return
+	}
+
+	// 批量软删除任务
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	count, err := db.BatchDeleteTasks(userID, deviceIDFromRequest(r), req.TaskIDs)
+	if err != nil {
+		log.Printf("批量删除失败: %v", err)
+		response.ErrorResponse(w, "批量删除失败", http.StatusInternalServerError)
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	// 发送通知
+	sendNotificationToUser(userID, "tasks_deleted", "任务已删除", fmt.Sprintf("已删除 %d 个任务，30秒内可撤销", count), "normal", wsHub)
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"status":               "deleted",
+		"count":                count,
+		"can_undo":             true,
+		"undo_window_seconds":  30,
+		"trash_retention_days": db.TrashRetentionDays(),
+	}, http.StatusOK)
+}
+
+// handleBatchUpdateTasks 批量更新任务：对 task_ids 或 filter 选出的任务应用相同的部分更新
+func handleBatchUpdateTasks(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		TaskIDs      []int64           `json:"task_ids"`
+		Filter       map[string]string `json:"filter"`
+		Changes      taskWriteReq      `json:"changes"`
+		DueShiftDays int               `json:"due_shift_days"`
+		// Versions 可选的逐项乐观锁版本号，键为任务 ID
+		Versions map[string]int `json:"versions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求数据", http.StatusBadRequest)
+		return
+	}
+
+	if (len(req.TaskIDs) == 0) == (len(req.Filter) == 0) {
+		response.ErrorResponse(w, "需要且只能指定 task_ids 或 filter 之一", http.StatusBadRequest)
+		return
+	}
+	if len(req.TaskIDs) > db.MaxBatchTasks {
+		response.ErrorResponse(w, db.ErrBatchTooLarge.Error(), http.StatusBadRequest)
+		return
+	}
+	if errs := req.Changes.validate(false); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+	if req.Changes.ProjectRank != nil {
+		// 多个任务不能共用同一个排序键，批量移入项目时依次排在最后
+		response.ValidationErrorResponse(w, map[string]string{"project_rank": "批量更新不支持指定排序键"})
+		return
+	}
+	fields := req.Changes.fields()
+	if fields.IsEmpty() && req.DueShiftDays == 0 {
+		response.ErrorResponse(w, "未指定要更新的字段", http.StatusBadRequest)
+		return
+	}
+	if fields.DueAt != nil && req.DueShiftDays != 0 {
+		response.ErrorResponse(w, "due_at 与 due_shift_days 不能同时指定", http.StatusBadRequest)
+		return
+	}
+
+	versions := make(map[int64]int, len(req.Versions))
+	for idStr, version := range req.Versions {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			response.ValidationErrorResponse(w, map[string]string{"versions": "任务ID无效: " + idStr})
+			return
+		}
+		versions[id] = version
+	}
+
+	// 指派或状态变化需要通知相关用户，先读取受影响任务的原有状态
+	var before map[int64]db.TaskAssignment
+	if fields.AssigneeID != nil || fields.Status != nil {
+		ids := req.TaskIDs
+		if len(ids) == 0 {
+			// 过滤条件无效时由批量更新返回错误
+			ids, _ = db.FilterTaskIDs(userID, req.Filter)
+		}
+		if before, err = db.GetTaskAssignments(db.DB, ids); err != nil {
+			log.Printf("读取任务指派失败: %v", err)
+		}
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	results, err := db.BatchUpdateTasks(userID, deviceIDFromRequest(r), db.BatchUpdate{
+		TaskIDs:  req.TaskIDs,
+		Filters:  req.Filter,
+		Fields:   fields,
+		DueShift: time.Duration(req.DueShiftDays) * 24 * time.Hour,
+		Versions: versions,
+	})
+	if err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			response.ValidationErrorResponse(w, map[string]string{filterErr.Field: filterErr.Message})
+			return
+		}
+		if err == db.ErrBatchTooLarge {
+			response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("批量更新失败: %v", err)
+		response.ErrorResponse(w, "批量更新失败", http.StatusInternalServerError)
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	updated := 0
+	updatedIDs := []int64{}
+	for _, res := range results {
+		if res.Success {
+			updated++
+			updatedIDs = append(updatedIDs, res.ID)
+		}
+	}
+	if before != nil {
+		notifyTaskAssignments(wsHub, userID, before, updatedIDs)
+	}
+	if updated > 0 {
+		sendNotificationToUser(userID, "tasks_updated", "任务已批量更新", fmt.Sprintf("已更新 %d 个任务", updated), "normal", wsHub)
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"updated": updated,
+		"failed":  len(results) - updated,
+		"results": results,
+	}, http.StatusOK)
+}
+
+// handleRestoreTask 恢复删除的任务（撤销）
+func handleRestoreTask(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userIDStr := getUserIDFromContext(r.Context())
+	if userIDStr == "" {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	userID, _ := strconv.Atoi(userIDStr)
+
+	// 恢复任务
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	err = db.RestoreDeletedTask(taskID, userID, deviceIDFromRequest(r))
+	if err != nil {
+		if _, ok := err.(*db.UndoExpiredError); ok {
+			response.ErrorResponse(w, "已超过30秒撤销期限", http.StatusGone)
+		} else {
+			response.ErrorResponse(w, "恢复失败", http.StatusInternalServerError)
+		}
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, map[string]string{
+		"status": "restored",
+	}, http.StatusOK)
+}
+
+// handleListTrash 获取回收站中的任务（按删除时间倒序）
+func handleListTrash(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	tasks, total, err := db.GetTrash(userID, page, pageSize)
+	if err != nil {
+		log.Printf("获取回收站失败: %v", err)
+		response.ErrorResponse(w, "获取回收站失败", http.StatusInternalServerError)
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"tasks":          tasks,
+		"retention_days": db.TrashRetentionDays(),
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
+	}, http.StatusOK)
+}
+
+// handleRestoreFromTrash 在保留期限内恢复回收站中的任务
+func handleRestoreFromTrash(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	task, err := db.RestoreFromTrash(userID, deviceIDFromRequest(r), taskID)
+	switch err {
+	case nil:
+	case db.ErrTaskNotInTrash:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	case db.ErrTrashExpired:
+		response.ErrorResponse(w, err.Error(), http.StatusGone)
+		return
+	default:
+		log.Printf("从回收站恢复失败: %v", err)
+		response.ErrorResponse(w, "恢复失败", http.StatusInternalServerError)
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, task, http.StatusOK)
+}
+
+// handlePurgeTrash 永久删除回收站中的任务
+// DELETE /trash/{id} 删除单个任务；DELETE /trash 按 task_ids 批量删除，或以 all=true 清空回收站
+func handlePurgeTrash(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	var taskIDs []int64
+	if idStr, ok := mux.Vars(r)["id"]; ok {
+		taskID, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+			return
+		}
+		taskIDs = []int64{taskID}
+	} else if r.URL.Query().Get("all") != "true" {
+		var req struct {
+			TaskIDs []int64 `json:"task_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求数据", http.StatusBadRequest)
+			return
+		}
+		if len(req.TaskIDs) == 0 {
+			response.ErrorResponse(w, "未选择要永久删除的任务", http.StatusBadRequest)
+			return
+		}
+		taskIDs = req.TaskIDs
+	}
+
+	count, err := db.PurgeTrash(userID, taskIDs)
+	if err != nil {
+		log.Printf("永久删除任务失败: %v", err)
+		response.ErrorResponse(w, "永久删除失败", http.StatusInternalServerError)
+		return
+	}
+	if count == 0 && len(taskIDs) == 1 {
+		response.ErrorResponse(w, db.ErrTaskNotInTrash.Error(), http.StatusNotFound)
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"status": "purged",
+		"count":  count,
+	}, http.StatusOK)
+}
+
+// handleTaskReminders 获取或替换任务的提醒设置（截止前若干分钟）
+func handleTaskReminders(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	var offsets []int
+	if r.Method == http.MethodPut {
+		var req struct {
+			OffsetsMinutes []int `json:"offsets_minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求数据", http.StatusBadRequest)
+			return
+		}
+		offsets, err = db.SetTaskReminders(userID, taskID, req.OffsetsMinutes)
+	} else {
+		offsets, err = db.GetTaskReminders(userID, taskID)
+	}
+	if err == db.ErrInvalidReminder {
+		response.ValidationErrorResponse(w, map[string]string{"offsets_minutes": err.Error()})
+		return
+	}
+	if err != nil {
+		writeTaskError(w, err, "处理任务提醒失败")
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"task_id":         taskID,
+		"offsets_minutes": offsets,
+	}, http.StatusOK)
+}
+
+// handleTaskHistory 获取任务的修订历史（按版本倒序）
+func handleTaskHistory(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	revisions, total, err := db.GetTaskHistory(userID, taskID, page, pageSize)
+	if err != nil {
+		writeTaskError(w, err, "获取任务历史失败")
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"revisions": revisions,
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
+	}, http.StatusOK)
+}
+
+// handleRevertTask 将任务回退到指定历史版本，生成内容与该版本一致的新版本
+func handleRevertTask(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	version, err := strconv.Atoi(r.URL.Query().Get("version"))
+	if err != nil || version < 1 {
+		response.ValidationErrorResponse(w, map[string]string{"version": "版本号必须是正整数"})
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	task, err := db.RevertTask(userID, deviceIDFromRequest(r), taskID, version)
+	switch err {
+	case nil:
+	case db.ErrRevisionNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	case db.ErrRevisionDeleted:
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	default:
+		writeTaskError(w, err, "回退任务失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, task, http.StatusOK)
+}
+
+// handleSeries 查看、整体修改或结束重复任务序列
+// PATCH 可修改重复规则（影响之后生成的任务）以及所有未完成任务的标题、描述与优先级；
+// DELETE 结束重复，已生成的任务保留为普通任务
+func handleSeries(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	seriesID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的序列ID", http.StatusBadRequest)
+		return
+	}
+
+	var series *types.TaskSeries
+	switch r.Method {
+	case http.MethodGet:
+		series, err = db.GetSeries(userID, seriesID)
+
+	case http.MethodPatch:
+		var req taskWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		errs := req.validate(false)
+		if req.Status != nil || req.DueAt != nil {
+			errs["series"] = "序列只能整体修改 recurrence_rule、title、description 与 priority"
+		}
+		if req.RecurrenceRule != nil && *req.RecurrenceRule == "" {
+			errs["recurrence_rule"] = "结束重复请使用 DELETE"
+		}
+		if len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+
+		f := req.fields()
+		rule := f.RecurrenceRule
+		f.RecurrenceRule = nil
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		series, err = db.UpdateSeries(userID, deviceIDFromRequest(r), seriesID, rule, f)
+		if err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+		}
+
+	case http.MethodDelete:
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if err = db.StopSeries(userID, deviceIDFromRequest(r), seriesID); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+			response.SuccessResponse(w, map[string]interface{}{"status": "stopped", "id": seriesID}, http.StatusOK)
+			return
+		}
+	}
+
+	if err == db.ErrSeriesNotFound {
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeTaskError(w, err, "处理重复序列失败")
+		return
+	}
+	response.SuccessResponse(w, series, http.StatusOK)
+}
+
+// handleTaskDependencies 查看、添加或移除任务的前置任务（blocked by）
+func handleTaskDependencies(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		deps, err := db.GetTaskDependencies(userID, taskID)
+		if err != nil {
+			writeTaskError(w, err, "获取任务依赖失败")
+			return
+		}
+		response.SuccessResponse(w, deps, http.StatusOK)
+		return
+	}
+
+	var deps *types.TaskDependencies
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	switch r.Method {
+	case http.MethodPost:
+		var req struct {
+			BlockerID int64 `json:"blocker_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if req.BlockerID < 1 {
+			response.ValidationErrorResponse(w, map[string]string{"blocker_id": "无效的前置任务ID"})
+			return
+		}
+		if req.BlockerID == taskID {
+			response.ValidationErrorResponse(w, map[string]string{"blocker_id": db.ErrDependencyCycle.Error()})
+			return
+		}
+		deps, err = db.AddTaskDependency(userID, deviceIDFromRequest(r), taskID, req.BlockerID)
+
+	case http.MethodDelete:
+		blockerID, _ := strconv.ParseInt(vars["blocker_id"], 10, 64)
+		deps, err = db.RemoveTaskDependency(userID, deviceIDFromRequest(r), taskID, blockerID)
+	}
+
+	switch err {
+	case nil:
+	case db.ErrDependencyCycle:
+		response.ValidationErrorResponse(w, map[string]string{"blocker_id": err.Error()})
+		return
+	case db.ErrDependencyNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		writeTaskError(w, err, "修改任务依赖失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, deps, http.StatusOK)
+}
+
+// handleTaskGraph 获取任务所在的依赖图（节点、边、拓扑顺序与关键路径）
+func handleTaskGraph(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	graph, err := db.GetTaskGraph(userID, taskID)
+	if err != nil {
+		writeTaskError(w, err, "获取依赖图失败")
+		return
+	}
+	response.SuccessResponse(w, graph, http.StatusOK)
+}
+
+// commentReq 发表或修改评论的请求体
+type commentReq struct {
+	Body string `json:"body"`
+	// ParentID 回复的评论 ID，缺省或 0 表示开启新话题；修改评论时忽略
+	ParentID int64 `json:"parent_id"`
+}
+
+// writeCommentError 将评论相关的数据库错误映射为 HTTP 响应
+func writeCommentError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case db.ErrInvalidCommentParent:
+		response.ValidationErrorResponse(w, map[string]string{"parent_id": err.Error()})
+	case db.ErrCommentNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrCommentForbidden:
+		response.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	default:
+		writeTaskError(w, err, fallback)
+	}
+}
+
+// handleTaskComments 分页获取任务的评论话题（GET）或发表评论（POST）
+func handleTaskComments(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+		if pageSize < 1 || pageSize > 100 {
+			pageSize = 20
+		}
+
+		comments, total, err := db.ListTaskComments(userID, taskID, page, pageSize)
+		if err != nil {
+			writeCommentError(w, err, "获取评论失败")
+			return
+		}
+		response.SuccessResponse(w, map[string]interface{}{
+			"comments": comments,
+			"pagination": map[string]interface{}{
+				"page":      page,
+				"page_size": pageSize,
+				"total":     total,
+				"pages":     (total + pageSize - 1) / pageSize,
+			},
+		}, http.StatusOK)
+		return
+	}
+
+	var req commentReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	body, err := db.NormalizeCommentBody(req.Body)
+	if err != nil {
+		response.ValidationErrorResponse(w, map[string]string{"body": err.Error()})
+		return
+	}
+	if req.ParentID < 0 {
+		response.ValidationErrorResponse(w, map[string]string{"parent_id": db.ErrInvalidCommentParent.Error()})
+		return
+	}
+
+	comment, mentioned, err := db.CreateTaskComment(userID, taskID, req.ParentID, body)
+	if err != nil {
+		writeCommentError(w, err, "发表评论失败")
+		return
+	}
+	pushTaskActivity(wsHub, "created", db.CommentActivityItem(comment))
+	notifyMentions(wsHub, comment, mentioned)
+
+	response.SuccessResponse(w, comment, http.StatusCreated)
+}
+
+// handleTaskComment 修改（PATCH，仅作者）或删除（DELETE，作者或任务 owner）评论
+func handleTaskComment(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+	commentID, err := strconv.ParseInt(vars["comment_id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的评论ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		comment, err := db.DeleteTaskComment(userID, taskID, commentID)
+		if err != nil {
+			writeCommentError(w, err, "删除评论失败")
+			return
+		}
+		pushTaskActivity(wsHub, "deleted", db.CommentActivityItem(comment))
+		response.SuccessResponse(w, map[string]string{"message": "评论已删除"}, http.StatusOK)
+		return
+	}
+
+	var req commentReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	body, err := db.NormalizeCommentBody(req.Body)
+	if err != nil {
+		response.ValidationErrorResponse(w, map[string]string{"body": err.Error()})
+		return
+	}
+
+	comment, mentioned, err := db.UpdateTaskComment(userID, taskID, commentID, body)
+	if err != nil {
+		writeCommentError(w, err, "修改评论失败")
+		return
+	}
+	pushTaskActivity(wsHub, "updated", db.CommentActivityItem(comment))
+	notifyMentions(wsHub, comment, mentioned)
+
+	response.SuccessResponse(w, comment, http.StatusOK)
+}
+
+// notifyMentions 向评论中新提及的用户发送 mention 通知
+func notifyMentions(wsHub *wsclient.Hub, comment *types.TaskComment, users []int) {
+	if len(users) == 0 {
+		return
+	}
+	title := ""
+	if task, err := db.GetTask(comment.UserID, comment.TaskID); err == nil {
+		title, _ = task["title"].(string)
+	}
+	excerpt := []rune(comment.Body)
+	if len(excerpt) > 100 {
+		excerpt = append(excerpt[:100], []rune("…")...)
+	}
+	for _, userID := range users {
+		if _, err := sendNotificationToUser(userID, "mention", "有人在评论中提到了你",
+			fmt.Sprintf("%s 在任务「%s」的评论中提到了你：%s", comment.Email, title, string(excerpt)), "normal", wsHub); err != nil {
+			log.Printf("发送提及通知失败: %v", err)
+		}
+	}
+}
+
+// handleTaskActivity 分页获取任务动态：评论与系统事件（创建、状态变化、改派、恢复）按时间倒序合并
+func handleTaskActivity(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	items, total, err := db.GetTaskActivity(userID, taskID, page, pageSize)
+	if err != nil {
+		writeTaskError(w, err, "获取任务动态失败")
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"activity": items,
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
+	}, http.StatusOK)
+}
+
+// attachmentUploadPath 上传附件的请求路径，securityMiddleware 对其放行 multipart 请求体
+var attachmentUploadPath = regexp.MustCompile(`^/api/v1/tasks/[0-9]+/attachments$`)
+
+const (
+	// maxAttachmentFilename 附件文件名的最大长度（字符）
+	maxAttachmentFilename = 255
+	// multipartOverhead 附件上传请求中 multipart 边界与表单头允许占用的额外字节数
+	multipartOverhead = 64 * 1024
+)
+
+// writeAttachmentError 将附件相关的数据库错误映射为 HTTP 响应
+func writeAttachmentError(w http.ResponseWriter, err error, fallback string) {
+	if quotaErr, ok := err.(*db.QuotaExceededError); ok {
+		response.ErrorResponse(w, quotaErr.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err == db.ErrAttachmentNotFound {
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	writeTaskError(w, err, fallback)
+}
+
+// handleTaskAttachments 获取任务的附件列表（GET）或上传附件（POST，multipart/form-data 的 file 字段）
+func handleTaskAttachments(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub, blobs blobstore.Store) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		attachments, err := db.ListAttachments(userID, taskID)
+		if err != nil {
+			writeAttachmentError(w, err, "获取附件失败")
+			return
+		}
+		response.SuccessResponse(w, attachments, http.StatusOK)
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		response.ErrorResponse(w, "请求必须是 multipart/form-data", http.StatusBadRequest)
+		return
+	}
+	var part io.Reader
+	var filename, contentType string
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeUploadReadError(w, err)
+			return
+		}
+		if p.FormName() == "file" {
+			part = p
+			filename = attachmentFilename(p.FileName())
+			contentType = p.Header.Get("Content-Type")
+			break
+		}
+	}
+	if part == nil {
+		response.ValidationErrorResponse(w, map[string]string{"file": "缺少上传的文件"})
+		return
+	}
+	if filename == "" {
+		response.ValidationErrorResponse(w, map[string]string{"file": "文件名不能为空"})
+		return
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || mediaType == "application/octet-stream" {
+		// 客户端未声明类型时按扩展名推断
+		if byExt := mime.TypeByExtension(path.Ext(filename)); byExt != "" {
+			contentType = byExt
+		} else {
+			contentType = "application/octet-stream"
+		}
+	}
+
+	// 内容先写入临时文件：计算大小与摘要，并在配额检查通过后再写入存储
+	tmp, err := os.CreateTemp("", "todoapp-upload-*")
+	if err != nil {
+		log.Printf("创建上传临时文件失败: %v", err)
+		response.ErrorResponse(w, "上传附件失败", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	maxBytes := db.AttachmentMaxBytes()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(part, maxBytes+1))
+	if err != nil {
+		writeUploadReadError(w, err)
+		return
+	}
+	if size > maxBytes {
+		response.ErrorResponse(w, fmt.Sprintf("附件不能超过 %d 字节", maxBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if size == 0 {
+		response.ValidationErrorResponse(w, map[string]string{"file": "文件不能为空"})
+		return
+	}
+	if err := db.CheckAttachmentUpload(userID, taskID, size); err != nil {
+		writeAttachmentError(w, err, "上传附件失败")
+		return
+	}
+
+	key, err := attachmentKey(taskID)
+	if err != nil {
+		log.Printf("生成附件存储键失败: %v", err)
+		response.ErrorResponse(w, "上传附件失败", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		log.Printf("读取上传临时文件失败: %v", err)
+		response.ErrorResponse(w, "上传附件失败", http.StatusInternalServerError)
+		return
+	}
+	if err := blobs.Put(r.Context(), key, tmp, size, contentType); err != nil {
+		log.Printf("写入附件存储失败: %v", err)
+		response.ErrorResponse(w, "上传附件失败", http.StatusBadGateway)
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	attachment, err := db.CreateAttachment(userID, deviceIDFromRequest(r), taskID, types.TaskAttachment{
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		SHA256:      hex.EncodeToString(hash.Sum(nil)),
+		StorageKey:  key,
+	})
+	if err != nil {
+		// 元数据未写入，存储中的内容不会被引用
+		if delErr := blobs.Delete(context.Background(), key); delErr != nil {
+			log.Printf("删除未引用的附件内容失败: %v", delErr)
+		}
+		writeAttachmentError(w, err, "上传附件失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, attachment, http.StatusCreated)
+}
+
+// writeUploadReadError 读取上传内容失败时的响应，请求体超过上限时返回 413
+func writeUploadReadError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		response.ErrorResponse(w, fmt.Sprintf("附件不能超过 %d 字节", db.AttachmentMaxBytes()), http.StatusRequestEntityTooLarge)
+		return
+	}
+	response.ErrorResponse(w, "无效的上传内容", http.StatusBadRequest)
+}
+
+// attachmentFilename 只保留客户端文件名的最后一段，并限制长度
+func attachmentFilename(name string) string {
+	name = strings.TrimSpace(path.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == "/" {
+		return ""
+	}
+	if utf8.RuneCountInString(name) > maxAttachmentFilename {
+		runes := []rune(name)
+		ext := []rune(path.Ext(name))
+		if len(ext) > 16 {
+			ext = nil
+		}
+		name = string(runes[:maxAttachmentFilename-len(ext)]) + string(ext)
+	}
+	return name
+}
+
+// attachmentKey 为任务的新附件生成随机的存储键
+func attachmentKey(taskID int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("tasks/%d/%s", taskID, hex.EncodeToString(b)), nil
+}
+
+// handleTaskAttachment 下载（GET）或删除（DELETE，需要编辑权限）任务的附件
+func handleTaskAttachment(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub, blobs blobstore.Store) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+	attachmentID, err := strconv.ParseInt(vars["attachment_id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的附件ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		key, err := db.DeleteAttachment(userID, deviceIDFromRequest(r), taskID, attachmentID)
+		if err != nil {
+			writeAttachmentError(w, err, "删除附件失败")
+			return
+		}
+		pushTaskChanges(wsHub, userID, afterSeq)
+		// 存储中的内容删除失败时留给定时清理
+		if err := blobs.Delete(r.Context(), key); err != nil {
+			log.Printf("删除附件内容失败: %v", err)
+		} else if err := db.FinishBlobDeletion(attachmentID); err != nil {
+			log.Printf("清理附件记录失败: %v", err)
+		}
+		response.SuccessResponse(w, map[string]string{"message": "附件已删除"}, http.StatusOK)
+		return
+	}
+
+	attachment, err := db.GetAttachment(userID, taskID, attachmentID)
+	if err != nil {
+		writeAttachmentError(w, err, "下载附件失败")
+		return
+	}
+	etag := `"` + attachment.SHA256 + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	body, err := blobs.Get(r.Context(), attachment.StorageKey)
+	if err != nil {
+		log.Printf("读取附件内容失败 (attachment %d): %v", attachmentID, err)
+		if err == blobstore.ErrNotFound {
+			response.ErrorResponse(w, "附件内容不存在", http.StatusNotFound)
+			return
+		}
+		response.ErrorResponse(w, "下载附件失败", http.StatusBadGateway)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", attachment.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("发送附件内容失败 (attachment %d): %v", attachmentID, err)
+	}
+}
+
+// handleAttachmentUsage 获取当前用户的附件用量与配额
+func handleAttachmentUsage(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	usage, err := db.GetAttachmentUsage(userID)
+	if err != nil {
+		log.Printf("获取附件用量失败: %v", err)
+		response.ErrorResponse(w, "获取附件用量失败", http.StatusInternalServerError)
+		return
+	}
+	response.SuccessResponse(w, usage, http.StatusOK)
+}
+
+// cleanupAttachmentBlobs 删除已删除附件（包括永久删除的任务的附件）在存储中的内容
+func cleanupAttachmentBlobs(blobs blobstore.Store) {
+	for {
+		pending, err := db.PendingBlobDeletions(100)
+		if err != nil {
+			log.Printf("读取待清理附件失败: %v", err)
+			return
+		}
+		removed := 0
+		for _, blob := range pending {
+			if err := blobs.Delete(context.Background(), blob.StorageKey); err != nil {
+				log.Printf("删除附件内容失败: %v", err)
+				continue
+			}
+			if err := db.FinishBlobDeletion(blob.ID); err != nil {
+				log.Printf("清理附件记录失败: %v", err)
+				continue
+			}
+			removed++
+		}
+		// 本批次全部失败时等待下一次清理，避免反复重试
+		if len(pending) < 100 || removed == 0 {
+			return
+		}
+	}
+}
+
+// projectWriteReq 创建/修改项目的请求体，指针字段为 nil 表示未提供
+type projectWriteReq struct {
+	LocalID  string  `json:"local_id"`
+	Name     *string `json:"name"`
+	Color    *string `json:"color"`
+	Archived *bool   `json:"archived"`
+	Rank     *string `json:"rank"` // 项目之间的排序键（分数索引），创建时缺省排在最后
+}
+
+// validate 校验项目字段，creating 为 true 时要求名称必填
+func (req *projectWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	if req.Name != nil || creating {
+		if req.Name == nil || !validator.IsValidProjectName(strings.TrimSpace(*req.Name)) {
+			errs["name"] = "项目名称不能为空且不能超过100个字符"
+		}
+	}
+	if req.Color != nil && !db.ValidTagColor(*req.Color) {
+		errs["color"] = db.ErrInvalidTagColor.Error()
+	}
+	if req.Rank != nil && !fracindex.Valid(*req.Rank) {
+		errs["rank"] = fracindex.ErrInvalidKey.Error()
+	}
+	return errs
+}
+
+// fields 转换为数据库层的可写字段
+func (req *projectWriteReq) fields() db.ProjectFields {
+	name := req.Name
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		name = &trimmed
+	}
+	return db.ProjectFields{Name: name, Color: req.Color, Archived: req.Archived, Rank: req.Rank}
+}
+
+// handleProjects 获取或创建当前用户的项目
+func handleProjects(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		projects, err := db.ListProjects(userID, r.URL.Query().Get("include_archived") == "true")
+		if err != nil {
+			log.Printf("获取项目失败: %v", err)
+			response.ErrorResponse(w, "获取项目失败", http.StatusInternalServerError)
+			return
+		}
+		response.SuccessResponse(w, projects, http.StatusOK)
+		return
+	}
+
+	var req projectWriteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+	localID := req.LocalID
+	if localID == "" {
+		localID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	project, err := db.CreateProject(userID, deviceIDFromRequest(r), localID, req.fields())
+	if err != nil {
+		log.Printf("创建项目失败: %v", err)
+		response.ErrorResponse(w, "创建项目失败", http.StatusInternalServerError)
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, project, http.StatusCreated)
+}
+
+// handleProjectByID 获取、修改或删除项目，删除时项目中的任务移出项目
+func handleProjectByID(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	projectID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的项目ID", http.StatusBadRequest)
+		return
+	}
+
+	var project *types.Project
+	switch r.Method {
+	case http.MethodGet:
+		project, err = db.GetProject(userID, projectID)
+
+	case http.MethodPatch:
+		var req projectWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if project, err = db.UpdateProject(userID, deviceIDFromRequest(r), projectID, req.fields()); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+		}
+
+	case http.MethodDelete:
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if err = db.DeleteProject(userID, deviceIDFromRequest(r), projectID); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+			response.SuccessResponse(w, map[string]interface{}{"status": "deleted", "id": projectID}, http.StatusOK)
+			return
+		}
+	}
+
+	if err != nil {
+		writeProjectError(w, err, "处理项目失败")
+		return
+	}
+	response.SuccessResponse(w, project, http.StatusOK)
+}
+
+// writeProjectError 将项目与成员操作的错误转换为 HTTP 响应，其余错误按任务错误处理
+func writeProjectError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case db.ErrProjectNotFound, db.ErrMemberNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrProjectForbidden:
+		response.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	case db.ErrMemberExists:
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+	case db.ErrProjectCreator:
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case db.ErrInvalidRole:
+		response.ValidationErrorResponse(w, map[string]string{"role": err.Error()})
+	case db.ErrInviteeNotFound:
+		response.ValidationErrorResponse(w, map[string]string{"email": err.Error()})
+	default:
+		writeTaskError(w, err, fallback)
+	}
+}
+
+// handleProjectMembers 获取项目成员（任何成员可查看）或邀请用户加入项目（需要 owner 角色）
+// 被邀请的用户收到通知，并随下一次同步或实时推送获得项目及其任务
+func handleProjectMembers(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+	projectID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的项目ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		members, err := db.ListProjectMembers(userID, projectID)
+		if err != nil {
+			writeProjectError(w, err, "获取项目成员失败")
+			return
+		}
+		response.SuccessResponse(w, members, http.StatusOK)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Role == "" {
+		req.Role = types.ProjectRoleEditor
+	}
+	if !validator.IsValidEmail(req.Email) {
+		response.ValidationErrorResponse(w, map[string]string{"email": "邮箱格式无效"})
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	member, err := db.AddProjectMember(userID, projectID, req.Email, req.Role)
+	if err != nil {
+		writeProjectError(w, err, "邀请成员失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+	notifyProjectMember(wsHub, userID, projectID, member.UserID, "project_invited", "你被邀请加入项目",
+		"%s 邀请你以 %s 身份加入项目「%s」", member.Role)
+
+	response.SuccessResponse(w, member, http.StatusCreated)
+}
+
+// handleProjectMember 修改成员角色（需要 owner 角色）或将成员移出项目（owner 可移除任何成员，成员可自行退出）
+// 被 owner 移除的成员收到通知，其客户端随后收到项目及其任务的墓碑
+func handleProjectMember(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+	vars := mux.Vars(r)
+	projectID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的项目ID", http.StatusBadRequest)
+		return
+	}
+	memberID, err := strconv.Atoi(vars["user_id"])
+	if err != nil {
+		response.ErrorResponse(w, "无效的用户ID", http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		member, err := db.UpdateProjectMember(userID, projectID, memberID, req.Role)
+		if err != nil {
+			writeProjectError(w, err, "修改成员角色失败")
+			return
+		}
+		pushTaskChanges(wsHub, userID, afterSeq)
+		response.SuccessResponse(w, member, http.StatusOK)
+		return
+	}
+
+	// 移除后已无法读取项目，先取得通知所需的项目名称
+	project, err := db.GetProject(userID, projectID)
+	if err != nil {
+		writeProjectError(w, err, "移除成员失败")
+		return
+	}
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	if err := db.RemoveProjectMember(userID, projectID, memberID); err != nil {
+		writeProjectError(w, err, "移除成员失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+	if memberID != userID {
+		content := fmt.Sprintf("你已被移出项目「%s」", project.Name)
+		if _, err := sendNotificationToUser(memberID, "project_removed", "你已被移出项目", content, "normal", wsHub); err != nil {
+			log.Printf("发送成员通知失败: %v", err)
+		}
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{"status": "removed", "project_id": projectID, "user_id": memberID}, http.StatusOK)
+}
+
+// notifyTaskAssignments 比较任务写入前后的指派状态并发送通知：新负责人收到 task_assigned，被取消指派的原负责人收到 task_unassigned，
+// 任务被他人改派或完成时创建者收到 task_reassigned / task_completed；执行者本人不会收到通知
+// before 中缺少的任务（如新建的任务）视为未指派且未完成
+func notifyTaskAssignments(wsHub *wsclient.Hub, actorID int, before map[int64]db.TaskAssignment, taskIDs []int64) {
+	after, err := db.GetTaskAssignments(db.DB, taskIDs)
+	if err != nil {
+		log.Printf("读取任务指派失败: %v", err)
+		return
+	}
+	emails := map[int]string{}
+	email := func(userID int) string {
+		if _, ok := emails[userID]; !ok {
+			emails[userID], _ = db.GetUserEmail(int64(userID))
+		}
+		return emails[userID]
+	}
+	send := func(userID int, ntype, title, content string) {
+		if userID == 0 || userID == actorID {
+			return
+		}
+		if _, err := sendNotificationToUser(userID, ntype, title, content, "normal", wsHub); err != nil {
+			log.Printf("发送任务通知失败: %v", err)
+		}
+	}
+
+	actor := email(actorID)
+	for _, id := range taskIDs {
+		a, ok := after[id]
+		if !ok {
+			continue
+		}
+		b := before[id]
+		if a.AssigneeID != b.AssigneeID {
+			send(a.AssigneeID, "task_assigned", "有任务指派给你", fmt.Sprintf("%s 将任务「%s」指派给了你", actor, a.Title))
+			send(b.AssigneeID, "task_unassigned", "任务已改派", fmt.Sprintf("%s 取消了你对任务「%s」的指派", actor, a.Title))
+			if a.CreatorID != a.AssigneeID && a.CreatorID != b.AssigneeID {
+				content := fmt.Sprintf("%s 取消了你创建的任务「%s」的指派", actor, a.Title)
+				if a.AssigneeID != 0 {
+					content = fmt.Sprintf("%s 将你创建的任务「%s」指派给了 %s", actor, a.Title, email(a.AssigneeID))
+				}
+				send(a.CreatorID, "task_reassigned", "你创建的任务已改派", content)
+			}
+		}
+		if a.Status == "done" && b.Status != "done" {
+			send(a.CreatorID, "task_completed", "你创建的任务已完成", fmt.Sprintf("%s 完成了你创建的任务「%s」", actor, a.Title))
+		}
+	}
+}
+
+// notifyProjectMember 通知成员项目共享的变化，format 依次接收执行者邮箱、角色与项目名称
+func notifyProjectMember(wsHub *wsclient.Hub, actorID int, projectID int64, memberID int, ntype, title, format, role string) {
+	project, err := db.GetProject(memberID, projectID)
+	if err != nil {
+		log.Printf("发送成员通知失败: %v", err)
+		return
+	}
+	actor, err := db.GetUserEmail(int64(actorID))
+	if err != nil {
+		log.Printf("发送成员通知失败: %v", err)
+		return
+	}
+	if _, err := sendNotificationToUser(memberID, ntype, title, fmt.Sprintf(format, actor, role, project.Name), "normal", wsHub); err != nil {
+		log.Printf("发送成员通知失败: %v", err)
+	}
+}
+
+// handleMoveProjectTask 将任务移到项目中指定任务之后（after_id）或之前（before_id），都未指定时移到最后
+// 任务不在该项目中时一并移入；只改写被移动任务的排序键
+func handleMoveProjectTask(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	vars := mux.Vars(r)
+	projectID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的项目ID", http.StatusBadRequest)
+		return
+	}
+	taskID, err := strconv.ParseInt(vars["task_id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		AfterID       int64 `json:"after_id"`
+		BeforeID      int64 `json:"before_id"`
+		ServerVersion int   `json:"server_version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if req.AfterID != 0 && req.BeforeID != 0 {
+		response.ErrorResponse(w, "after_id 与 before_id 不能同时指定", http.StatusBadRequest)
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	task, err := db.MoveTaskInProject(userID, deviceIDFromRequest(r), projectID, taskID, req.AfterID, req.BeforeID, req.ServerVersion)
+	switch err {
+	case nil:
+	case db.ErrInvalidAnchor:
+		field := "after_id"
+		if req.BeforeID != 0 {
+			field = "before_id"
+		}
+		response.ValidationErrorResponse(w, map[string]string{field: err.Error()})
+		return
+	default:
+		writeProjectError(w, err, "移动任务失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, task, http.StatusOK)
+}
+
+// viewWriteReq 创建/修改视图的请求体，指针字段为 nil 表示未提供
+type viewWriteReq struct {
+	LocalID string            `json:"local_id"`
+	Name    *string           `json:"name"`
+	Filters map[string]string `json:"filters"` // 与 GET /tasks 的过滤参数相同，修改时整体替换
+	Sort    *string           `json:"sort"`
+	Order   *string           `json:"order"`
+}
+
+// validate 校验视图字段，creating 为 true 时要求名称必填
+func (req *viewWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	if req.Name != nil || creating {
+		if req.Name == nil || !validator.IsValidViewName(strings.TrimSpace(*req.Name)) {
+			errs["name"] = "视图名称不能为空且不能超过100个字符"
+		}
+	}
+	sort, order := "", ""
+	if req.Sort != nil {
+		sort = *req.Sort
+	}
+	if req.Order != nil {
+		order = *req.Order
+	}
+	if err := db.ValidateViewQuery(req.Filters, sort, order); err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			errs[filterErr.Field] = filterErr.Message
+		} else {
+			errs["filters"] = err.Error()
+		}
+	}
+	return errs
+}
+
+// fields 转换为数据库层的可写字段，忽略值为空的过滤条件
+func (req *viewWriteReq) fields() db.ViewFields {
+	name := req.Name
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		name = &trimmed
+	}
+	var filters map[string]string
+	if req.Filters != nil {
+		filters = map[string]string{}
+		for key, value := range req.Filters {
+			if value = strings.TrimSpace(value); value != "" {
+				filters[key] = value
+			}
+		}
+	}
+	return db.ViewFields{Name: name, Filters: filters, Sort: req.Sort, Order: req.Order}
+}
+
+// handleViews 获取当前用户保存的视图（含成员数）或创建视图
+func handleViews(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		views, err := db.ListViews(userID)
+		if err != nil {
+			log.Printf("获取视图失败: %v", err)
+			response.ErrorResponse(w, "获取视图失败", http.StatusInternalServerError)
+			return
+		}
+		response.SuccessResponse(w, views, http.StatusOK)
+		return
+	}
+
+	var req viewWriteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+	localID := req.LocalID
+	if localID == "" {
+		localID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	view, err := db.CreateView(userID, deviceIDFromRequest(r), localID, req.fields())
+	if err != nil {
+		writeViewError(w, err, "创建视图失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, view, http.StatusCreated)
+}
+
+// handleViewByID 获取、修改或删除视图
+func handleViewByID(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	viewID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的视图ID", http.StatusBadRequest)
+		return
+	}
+
+	var view *types.SavedView
+	switch r.Method {
+	case http.MethodGet:
+		view, err = db.GetView(userID, viewID)
+
+	case http.MethodPatch:
+		var req viewWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if view, err = db.UpdateView(userID, deviceIDFromRequest(r), viewID, req.fields()); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+		}
+
+	case http.MethodDelete:
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if err = db.DeleteView(userID, deviceIDFromRequest(r), viewID); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+			response.SuccessResponse(w, map[string]interface{}{"status": "deleted", "id": viewID}, http.StatusOK)
+			return
+		}
+	}
+
+	if err != nil {
+		writeViewError(w, err, "处理视图失败")
+		return
+	}
+	response.SuccessResponse(w, view, http.StatusOK)
+}
+
+// handleViewTasks 按视图的过滤条件分页获取任务，sort/order 参数可临时覆盖视图的排序方式
+func handleViewTasks(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	viewID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的视图ID", http.StatusBadRequest)
+		return
+	}
+	view, err := db.GetView(userID, viewID)
+	if err != nil {
+		writeViewError(w, err, "获取视图失败")
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	q := db.ViewQuery(view, page, pageSize)
+	if sortKey := r.URL.Query().Get("sort"); sortKey != "" {
+		q.OrderBy = sortKey
+	}
+	if order := r.URL.Query().Get("order"); order != "" {
+		q.Order = order
+	}
+	if q.Cursor, err = parseCursorParam(r); err != nil {
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tasks, total, next, err := db.GetTasksPaginated(userID, q)
+	if err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			response.ValidationErrorResponse(w, map[string]string{filterErr.Field: filterErr.Message})
+			return
+		}
+		log.Printf("获取视图任务失败: %v", err)
+		response.ErrorResponse(w, "获取视图任务失败", http.StatusInternalServerError)
+		return
+	}
+	view.Count = total
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"view":  view,
+		"tasks": tasks,
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
+		"next_cursor": signCursor(next),
+	}, http.StatusOK)
+}
+
+// writeViewError 将视图操作的错误转换为 HTTP 响应
+func writeViewError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case db.ErrViewNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrViewLimit:
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("%s: %v", fallback, err)
+		response.ErrorResponse(w, fallback, http.StatusInternalServerError)
+	}
+}
+
+// pushViewCounts 重新计算在线用户的视图成员数，通过 WebSocket 推送发生变化的视图
+// relativeOnly 为 true 时只计算含相对时间条件的视图
+func pushViewCounts(wsHub *wsclient.Hub, userID int, relativeOnly bool) {
+	if !wsHub.IsUserConnected(int64(userID)) {
+		return
+	}
+	changed, err := db.RefreshViewCounts(userID, relativeOnly)
+	if err != nil {
+		log.Printf("计算视图成员数失败: %v", err)
+		return
+	}
+	if len(changed) == 0 {
+		return
+	}
+	err = wsHub.BroadcastToUser(int64(userID), wsclient.Message{
+		Type:      "view_counts",
+		Data:      map[string]interface{}{"views": changed},
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to push view counts via WebSocket: %v", err)
+	}
+}
+
+// timeEntryWriteReq 补录/修改工时记录的请求体，指针字段为 nil 表示未提供
+type timeEntryWriteReq struct {
+	LocalID   string  `json:"local_id"`
+	StartedAt *string `json:"started_at"` // RFC3339
+	EndedAt   *string `json:"ended_at"`   // RFC3339
+	Note      *string `json:"note"`
+}
+
+// maxTimeEntrySkew 工时记录的时间允许超出服务器当前时间的幅度（客户端时钟误差）
+const maxTimeEntrySkew = time.Minute
+
+// validate 校验工时记录字段，creating 为 true 时要求开始与结束时间必填
+func (req *timeEntryWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	latest := time.Now().Add(maxTimeEntrySkew)
+	var startedAt, endedAt time.Time
+	if req.StartedAt != nil || creating {
+		var err error
+		if req.StartedAt == nil {
+			errs["started_at"] = "开始时间不能为空"
+		} else if startedAt, err = time.Parse(time.RFC3339, *req.StartedAt); err != nil {
+			errs["started_at"] = "开始时间格式无效，应为 RFC3339"
+		} else if startedAt.After(latest) {
+			errs["started_at"] = "开始时间不能晚于当前时间"
+		}
+	}
+	if req.EndedAt != nil || creating {
+		var err error
+		if req.EndedAt == nil {
+			errs["ended_at"] = "结束时间不能为空，正在进行的工作请使用计时器"
+		} else if endedAt, err = time.Parse(time.RFC3339, *req.EndedAt); err != nil {
+			errs["ended_at"] = "结束时间格式无效，应为 RFC3339"
+		} else if endedAt.After(latest) {
+			errs["ended_at"] = "结束时间不能晚于当前时间"
+		}
+	}
+	if errs["started_at"] == "" && errs["ended_at"] == "" && !startedAt.IsZero() && !endedAt.IsZero() && endedAt.Before(startedAt) {
+		errs["ended_at"] = db.ErrInvalidTimeRange.Error()
+	}
+	if req.Note != nil && !validator.IsValidTimeEntryNote(*req.Note) {
+		errs["note"] = "备注不能超过500个字符"
+	}
+	return errs
+}
+
+// fields 转换为数据库层的可写字段
+func (req *timeEntryWriteReq) fields() db.TimeEntryFields {
+	f := db.TimeEntryFields{Note: req.Note}
+	if req.StartedAt != nil {
+		startedAt, _ := time.Parse(time.RFC3339, *req.StartedAt)
+		f.StartedAt = &startedAt
+	}
+	if req.EndedAt != nil {
+		endedAt, _ := time.Parse(time.RFC3339, *req.EndedAt)
+		f.EndedAt = &endedAt
+	}
+	return f
+}
+
+// handleStartTimer 在任务上启动计时器，stop_running 为 true 时先停止正在运行的计时器
+func handleStartTimer(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		LocalID     string `json:"local_id"`
+		Note        string `json:"note"`
+		StopRunning bool   `json:"stop_running"`
+	}
+	// 请求体可以为空
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if !validator.IsValidTimeEntryNote(req.Note) {
+		response.ValidationErrorResponse(w, map[string]string{"note": "备注不能超过500个字符"})
+		return
+	}
+	localID := req.LocalID
+	if localID == "" {
+		localID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	started, stopped, err := db.StartTimer(userID, deviceIDFromRequest(r), localID, taskID, req.Note, req.StopRunning)
+	if err != nil {
+		writeTimeEntryError(w, err, "启动计时器失败")
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+	pushTimer(wsHub, userID)
 
-				// ✅ 版本检查和冲突检测
-				if c.CV != oldVer {
-					log.Printf("删除冲突检测: client_version=%d, server_version=%d", c.CV, oldVer)
+	response.SuccessResponse(w, map[string]interface{}{"timer": started, "stopped": stopped}, http.StatusCreated)
+}
 
-					if isDeleted {
-						// 服务器已删除，忽略客户端删除
-						continue
-					} else {
-						// 冲突：客户端想删除但服务器有更新
-						// 策略：软删除，记录冲突
-						deleteErr := db.SoftDeleteTaskWithVersion(id, newVer)
-						if deleteErr != nil {
-							log.Printf("删除任务失败: %v", deleteErr)
-							syncFailed = true
-						} else {
-							serverChanges = append(serverChanges, map[string]interface{}{
-								"id": id, "server_version": newVer, "is_deleted": true, "updated_at": now.Format(time.RFC3339),
-							})
-							clientChanges = append(clientChanges, map[string]interface{}{
-								"local_id": c.LocalID, "server_id": id, "op": "delete",
-							})
+// handleTimer 获取当前用户正在运行的计时器（没有时 timer 为 null）或停止它
+func handleTimer(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
 
-							// 记录冲突：服务器被标记为删除
-							recordConflict(c.LocalID, id, "delete_while_modified", userID)
-							conflicts = append(conflicts, map[string]interface{}{
-								"local_id":  c.LocalID,
-								"server_id": id,
-								"reason":    "delete_while_modified",
-							})
-						}
-					}
-				} else {
-					// 正常删除
-					deleteErr := db.SoftDeleteTaskWithVersion(id, newVer)
-					if deleteErr != nil {
-						log.Printf("删除任务失败: %v", deleteErr)
-						syncFailed = true
-					} else {
-						serverChanges = append(serverChanges, map[string]interface{}{
-							"id": id, "server_version": newVer, "is_deleted": true, "updated_at": now.Format(time.RFC3339),
-						})
-						clientChanges = append(clientChanges, map[string]interface{}{
-							"local_id": c.LocalID, "server_id": id, "op": "delete",
-						})
-					}
-				}
-			}
+	if r.Method == http.MethodGet {
+		running, err := db.GetRunningTimer(userID)
+		if err != nil {
+			writeTimeEntryError(w, err, "获取计时器失败")
+			return
 		}
+		response.SuccessResponse(w, map[string]interface{}{"timer": running}, http.StatusOK)
+		return
 	}
 
-	// 发送同步结果通知
-	if syncFailed {
-		sendNotificationToUser(userID, "sync_failed", "同步失败", "部分任务同步失败，请检查网络连接", "high", wsHub)
-	} else if len(conflicts) > 0 {
-		sendNotificationToUser(userID, "sync_conflict", "同步完成但存在冲突", fmt.Sprintf("成功同步 %d 个任务，但检测到 %d 个冲突", len(clientChanges), len(conflicts)), "high", wsHub)
-	} else if len(clientChanges) > 0 {
-		sendNotificationToUser(userID, "sync_success", "同步完成", fmt.Sprintf("成功同步 %d 个任务", len(clientChanges)), "normal", wsHub)
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	stopped, err := db.StopTimer(userID, deviceIDFromRequest(r))
+	if err != nil {
+		writeTimeEntryError(w, err, "停止计时器失败")
+		return
 	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+	pushTimer(wsHub, userID)
 
-	resp := map[string]interface{}{
-		"server_changes": serverChanges,
-		"client_changes": clientChanges,
-		"last_sync_at":   now.Format(time.RFC3339),
-		"conflicts":      conflicts, // ✅ 返回实际冲突
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	response.SuccessResponse(w, stopped, http.StatusOK)
 }
 
-// handleDevicePairing 处理设备配对请求
-func handleDevicePairing(w http.ResponseWriter, r *http.Request) {
-	userIDStr := getUserIDFromContext(r.Context())
-	if userIDStr == "" {
+// handleTaskTimeEntries 获取任务上全部用户的工时记录与合计，或手动补录一条自己的工时记录
+func handleTaskTimeEntries(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	userID, err := strconv.Atoi(userIDStr)
+	taskID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		response.ErrorResponse(w, "无效的用户ID", http.StatusBadRequest)
+		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
 		return
 	}
 
-	var req struct {
-		Key        string `json:"key"`
-		DeviceType string `json:"device_type"`
-		DeviceID   string `json:"device_id"`
+	if r.Method == http.MethodGet {
+		summary, err := db.GetTaskTimeSummary(userID, taskID)
+		if err != nil {
+			writeTimeEntryError(w, err, "获取工时记录失败")
+			return
+		}
+		response.SuccessResponse(w, summary, http.StatusOK)
+		return
 	}
 
+	var req timeEntryWriteReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		response.ErrorResponse(w, "无效的请求数据", http.StatusBadRequest)
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
 		return
 	}
+	localID := req.LocalID
+	if localID == "" {
+		localID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
 
-	// 验证必填字段
-	if req.Key == "" || req.DeviceType == "" || req.DeviceID == "" {
-		response.ErrorResponse(w, "配对密钥、设备类型和设备ID为必填项", http.StatusBadRequest)
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	entry, err := db.CreateTimeEntry(userID, deviceIDFromRequest(r), localID, taskID, req.fields())
+	if err != nil {
+		writeTimeEntryError(w, err, "补录工时失败")
 		return
 	}
+	pushTaskChanges(wsHub, userID, afterSeq)
 
-	// 验证密钥格式（64字符hex）
-	if len(req.Key) != 64 {
-		response.ErrorResponse(w, "配对密钥格式无效", http.StatusBadRequest)
+	response.SuccessResponse(w, entry, http.StatusCreated)
+}
+
+// handleTimeEntryByID 获取、修改或删除自己的工时记录；为运行中的计时器设置 ended_at 即停止它
+func handleTimeEntryByID(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	// 验证设备类型
-	validDeviceTypes := map[string]bool{"web": true, "android": true, "ios": true}
-	if !validDeviceTypes[req.DeviceType] {
-		response.ErrorResponse(w, "无效的设备类型", http.StatusBadRequest)
+	entryID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的工时记录ID", http.StatusBadRequest)
 		return
 	}
 
-	// 检查设备是否已配对
-	existing, _ := db.ValidatePairingKey(userID, req.Key)
-	if existing {
-		response.ErrorResponse(w, "该设备已配对", http.StatusConflict)
+	var entry *types.TimeEntry
+	switch r.Method {
+	case http.MethodGet:
+		entry, err = db.GetTimeEntry(userID, entryID)
+
+	case http.MethodPatch:
+		var req timeEntryWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if entry, err = db.UpdateTimeEntry(userID, deviceIDFromRequest(r), entryID, req.fields()); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+			pushTimer(wsHub, userID)
+		}
+
+	case http.MethodDelete:
+		afterSeq := changeSeqBeforeWrite(wsHub, userID)
+		if err = db.DeleteTimeEntry(userID, deviceIDFromRequest(r), entryID); err == nil {
+			pushTaskChanges(wsHub, userID, afterSeq)
+			pushTimer(wsHub, userID)
+			response.SuccessResponse(w, map[string]interface{}{"status": "deleted", "id": entryID}, http.StatusOK)
+			return
+		}
+	}
+
+	if err != nil {
+		writeTimeEntryError(w, err, "处理工时记录失败")
 		return
 	}
+	response.SuccessResponse(w, entry, http.StatusOK)
+}
 
-	// 获取服务器URL
-	serverURL := os.Getenv("SERVER_URL")
-	if serverURL == "" {
-		serverURL = "http://localhost:8080"
+// parseTimeEntryFilter 解析工时查询参数 from、to（RFC3339，或按 loc 理解的 YYYY-MM-DD）、task_id、project_id 与 scope（mine 或 all）
+func parseTimeEntryFilter(r *http.Request, loc *time.Location) (db.TimeEntryFilter, map[string]string) {
+	query := r.URL.Query()
+	errs := map[string]string{}
+	f := db.TimeEntryFilter{}
+	parseTime := func(key string) time.Time {
+		value := query.Get(key)
+		if value == "" {
+			return time.Time{}
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t.UTC()
+		}
+		if t, err := time.ParseInLocation("2006-01-02", value, loc); err == nil {
+			return t.UTC()
+		}
+		errs[key] = "时间格式无效，应为 RFC3339 或 YYYY-MM-DD"
+		return time.Time{}
+	}
+	f.From = parseTime("from")
+	f.To = parseTime("to")
+	if !f.From.IsZero() && !f.To.IsZero() && !f.To.After(f.From) {
+		errs["to"] = "结束时间必须晚于开始时间"
+	}
+	for key, dest := range map[string]*int64{"task_id": &f.TaskID, "project_id": &f.ProjectID} {
+		if value := query.Get(key); value != "" {
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id < 1 {
+				errs[key] = "无效的ID"
+				continue
+			}
+			*dest = id
+		}
+	}
+	switch query.Get("scope") {
+	case "", "mine":
+	case "all":
+		f.AllUsers = true
+	default:
+		errs["scope"] = "scope 只能是 mine 或 all"
 	}
+	return f, errs
+}
 
-	// 注册设备
-	err = db.RegisterDevice(userID, req.DeviceType, req.DeviceID, req.Key, serverURL)
+// handleTimeEntries 分页获取工时记录，默认只包括自己的记录
+func handleTimeEntries(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
 	if err != nil {
-		log.Printf("设备注册失败: %v", err)
-		response.ErrorResponse(w, "设备注册失败", http.StatusInternalServerError)
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	// 记录审计日志
-	log.Printf("用户 %d 注册设备: type=%s, id=%s", userID, req.DeviceType, req.DeviceID)
+	loc, err := loadTimezone(r.URL.Query().Get("timezone"))
+	if err != nil {
+		response.ValidationErrorResponse(w, map[string]string{"timezone": "无效的时区"})
+		return
+	}
+	f, errs := parseTimeEntryFilter(r, loc)
+	if len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
 
+	entries, total, err := db.ListTimeEntries(userID, f, page, pageSize)
+	if err != nil {
+		writeTimeEntryError(w, err, "获取工时记录失败")
+		return
+	}
 	response.SuccessResponse(w, map[string]interface{}{
-		"status":     "paired",
-		"device_id":  req.DeviceID,
-		"server_url": serverURL,
+		"time_entries": entries,
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
 	}, http.StatusOK)
 }
 
-// handleListDevices 获取用户的设备列表
-func handleListDevices(w http.ResponseWriter, r *http.Request) {
-	userIDStr := getUserIDFromContext(r.Context())
-	if userIDStr == "" {
+// handleTimeTotals 按任务、项目或日期（group_by）汇总工时，日期按 timezone 切分
+func handleTimeTotals(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	userID, _ := strconv.Atoi(userIDStr)
-
-	devices, err := db.GetUserDevices(userID)
+	loc, err := loadTimezone(r.URL.Query().Get("timezone"))
 	if err != nil {
-		log.Printf("获取设备列表失败: %v", err)
-		response.ErrorResponse(w, "获取设备列表失败", http.StatusInternalServerError)
+		response.ValidationErrorResponse(w, map[string]string{"timezone": "无效的时区"})
+		return
+	}
+	f, errs := parseTimeEntryFilter(r, loc)
+	groupBy := r.URL.Query().Get("group_by")
+	if groupBy == "" {
+		groupBy = db.TimeGroupTask
+	}
+	if groupBy != db.TimeGroupTask && groupBy != db.TimeGroupProject && groupBy != db.TimeGroupDay {
+		errs["group_by"] = "group_by 只能是 task、project 或 day"
+	}
+	if len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
 		return
 	}
 
+	totals, total, err := db.TimeTotals(userID, f, groupBy, loc)
+	if err != nil {
+		writeTimeEntryError(w, err, "汇总工时失败")
+		return
+	}
 	response.SuccessResponse(w, map[string]interface{}{
-		"devices": devices,
-		"count":   len(devices),
+		"group_by":      groupBy,
+		"totals":        totals,
+		"total_seconds": total,
 	}, http.StatusOK)
 }
 
-// handleRegenerateDeviceKey 重新生成设备配对密钥
-func handleRegenerateDeviceKey(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	deviceID := vars["id"]
+// writeTimeEntryError 将工时操作的错误转换为 HTTP 响应
+func writeTimeEntryError(w http.ResponseWriter, err error, fallback string) {
+	if _, ok := err.(*db.RunningTimerError); ok {
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
+	switch err {
+	case db.ErrTimeEntryNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrTimerNotRunning:
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+	case db.ErrInvalidTimeRange:
+		response.ValidationErrorResponse(w, map[string]string{"ended_at": err.Error()})
+	default:
+		writeTaskError(w, err, fallback)
+	}
+}
 
-	if deviceID == "" {
-		response.ErrorResponse(w, "设备ID不能为空", http.StatusBadRequest)
+// pushTimer 通过 WebSocket 向用户的全部设备推送当前正在运行的计时器（没有时 running 为 null）
+func pushTimer(wsHub *wsclient.Hub, userID int) {
+	if !wsHub.IsUserConnected(int64(userID)) {
+		return
+	}
+	running, err := db.GetRunningTimer(userID)
+	if err != nil {
+		log.Printf("读取计时器失败: %v", err)
 		return
 	}
+	err = wsHub.BroadcastToUser(int64(userID), wsclient.Message{
+		Type:      "timer",
+		Data:      map[string]interface{}{"running": running},
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to push timer via WebSocket: %v", err)
+	}
+}
 
-	// 验证用户是否有权限操作此设备
-	userID, serverURL, _, isActive, err := db.GetDeviceInfoByDeviceID(deviceID)
+// templateWriteReq 创建/修改任务模板的请求体，指针字段为 nil 表示未提供
+type templateWriteReq struct {
+	Name        *string   `json:"name"`
+	Title       *string   `json:"title"` // 实例化出的任务标题，创建时缺省为模板名称
+	Description *string   `json:"description"`
+	Priority    *string   `json:"priority"`
+	Tags        *[]string `json:"tags"`
+	// DueOffset 截止时间相对实例化基准时刻的偏移，如 +2d、-4h、+1w，空字符串表示不设截止时间
+	DueOffset *string                   `json:"due_offset"`
+	Subtasks  *[]*types.TemplateSubtask `json:"subtasks"` // 子任务骨架，修改时整体替换
+}
+
+// validate 校验模板字段，creating 为 true 时要求名称必填
+func (req *templateWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	if req.Name != nil || creating {
+		if req.Name == nil || !validator.IsValidTemplateName(strings.TrimSpace(*req.Name)) {
+			errs["name"] = "模板名称不能为空且不能超过100个字符"
+		}
+	}
+	if req.Title != nil && !validator.IsValidTaskTitle(strings.TrimSpace(*req.Title)) {
+		errs["title"] = "标题不能为空且不能超过200个字符"
+	}
+	if req.Description != nil && !validator.IsValidTaskDescription(*req.Description) {
+		errs["description"] = "描述不能超过5000个字符"
+	}
+	if req.Priority != nil && !types.PriorityState(*req.Priority).IsValid() {
+		errs["priority"] = "无效的优先级"
+	}
+	if req.Tags != nil {
+		if _, err := db.NormalizeTagNames(*req.Tags); err != nil {
+			errs["tags"] = err.Error()
+		}
+	}
+	if req.DueOffset != nil && !db.ValidDueOffset(*req.DueOffset) {
+		errs["due_offset"] = "截止时间偏移无效，应为 +2d、-4h、+1w 这样的相对偏移"
+	}
+	if req.Subtasks != nil {
+		count := 0
+		validateTemplateSubtasks(errs, "subtasks", *req.Subtasks, 2, db.MaxTaskDepth(), &count)
+		if count > db.MaxTemplateSubtasks {
+			errs["subtasks"] = fmt.Sprintf("子任务骨架不能超过 %d 个", db.MaxTemplateSubtasks)
+		}
+	}
+	return errs
+}
+
+// validateTemplateSubtasks 递归校验子任务骨架并统计节点数，depth 为这一层在任务树中的层数（根任务为第 1 层）
+func validateTemplateSubtasks(errs map[string]string, path string, items []*types.TemplateSubtask, depth, maxDepth int, count *int) {
+	if len(items) > 0 && depth > maxDepth {
+		errs[path] = (&db.TaskDepthError{Max: maxDepth}).Error()
+		return
+	}
+	for i, item := range items {
+		field := fmt.Sprintf("%s[%d]", path, i)
+		if item == nil {
+			errs[field] = "子任务不能为空"
+			continue
+		}
+		*count++
+		if !validator.IsValidTaskTitle(strings.TrimSpace(item.Title)) {
+			errs[field+".title"] = "标题不能为空且不能超过200个字符"
+		}
+		if !validator.IsValidTaskDescription(item.Description) {
+			errs[field+".description"] = "描述不能超过5000个字符"
+		}
+		if item.Priority != "" && !types.PriorityState(item.Priority).IsValid() {
+			errs[field+".priority"] = "无效的优先级"
+		}
+		if _, err := db.NormalizeTagNames(item.Tags); err != nil {
+			errs[field+".tags"] = err.Error()
+		}
+		if !db.ValidDueOffset(item.DueOffset) {
+			errs[field+".due_offset"] = "截止时间偏移无效，应为 +2d、-4h、+1w 这样的相对偏移"
+		}
+		validateTemplateSubtasks(errs, field+".subtasks", item.Subtasks, depth+1, maxDepth, count)
+	}
+}
+
+// normalizeTemplateSubtasks 整理已校验的子任务骨架：去除标题两端空白、规范化标签，缺省优先级为 medium
+func normalizeTemplateSubtasks(items []*types.TemplateSubtask) []*types.TemplateSubtask {
+	result := make([]*types.TemplateSubtask, 0, len(items))
+	for _, item := range items {
+		tags, _ := db.NormalizeTagNames(item.Tags)
+		priority := item.Priority
+		if priority == "" {
+			priority = string(types.PriorityMedium)
+		}
+		result = append(result, &types.TemplateSubtask{
+			Title:       strings.TrimSpace(item.Title),
+			Description: item.Description,
+			Priority:    priority,
+			Tags:        tags,
+			DueOffset:   item.DueOffset,
+			Subtasks:    normalizeTemplateSubtasks(item.Subtasks),
+		})
+	}
+	return result
+}
+
+// fields 转换为数据库层的可写字段
+func (req *templateWriteReq) fields() db.TemplateFields {
+	f := db.TemplateFields{Description: req.Description, Priority: req.Priority, DueOffset: req.DueOffset}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		f.Name = &name
+	}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		f.Title = &title
+	}
+	if req.Tags != nil {
+		tags, _ := db.NormalizeTagNames(*req.Tags)
+		f.Tags = &tags
+	}
+	if req.Subtasks != nil {
+		subtasks := normalizeTemplateSubtasks(*req.Subtasks)
+		f.Subtasks = &subtasks
+	}
+	return f
+}
+
+// handleTemplates 获取可见的任务模板（自己的模板与全局模板）或创建模板
+// global 为 true 时（管理员路由）只处理全局模板
+func handleTemplates(w http.ResponseWriter, r *http.Request, global bool) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
 	if err != nil {
-		response.ErrorResponse(w, "设备不存在", http.StatusNotFound)
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	if !isActive {
-		response.ErrorResponse(w, "设备已撤销", http.StatusBadRequest)
+	if r.Method == http.MethodGet {
+		var templates []*types.TaskTemplate
+		if global {
+			templates, err = db.ListGlobalTemplates()
+		} else {
+			templates, err = db.ListTemplates(userID)
+		}
+		if err != nil {
+			log.Printf("获取模板失败: %v", err)
+			response.ErrorResponse(w, "获取模板失败", http.StatusInternalServerError)
+			return
+		}
+		response.SuccessResponse(w, templates, http.StatusOK)
 		return
 	}
 
-	// 检查当前用户是否是设备所有者
-	currentUserIDStr := getUserIDFromContext(r.Context())
-	currentUserID, _ := strconv.Atoi(currentUserIDStr)
-	if currentUserID != userID {
-		response.ErrorResponse(w, "无权操作此设备", http.StatusForbidden)
+	var req templateWriteReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+
+	ownerID := userID
+	if global {
+		ownerID = 0
+	}
+	template, err := db.CreateTemplate(ownerID, userID, req.fields())
+	if err != nil {
+		writeTemplateError(w, err, "创建模板失败")
 		return
 	}
+	if global {
+		logTemplateAction(r, "create_template", template)
+	}
 
-	// 生成新密钥
-	newKey := generateRandomKey()
+	response.SuccessResponse(w, template, http.StatusCreated)
+}
 
-	// 更新数据库
-	err = db.RegeneratePairingKey(deviceID, newKey)
+// handleTemplateByID 获取、修改或删除任务模板；用户只能修改自己的模板，global 为 true 时（管理员路由）只处理全局模板
+func handleTemplateByID(w http.ResponseWriter, r *http.Request, global bool) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
 	if err != nil {
-		log.Printf("密钥更新失败: %v", err)
-		response.ErrorResponse(w, "密钥更新失败", http.StatusInternalServerError)
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	log.Printf("用户 %d 重新生成了设备 %s 的配对密钥", currentUserID, deviceID)
+	templateID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的模板ID", http.StatusBadRequest)
+		return
+	}
+	ownerID := userID
+	if global {
+		ownerID = 0
+	}
 
-	response.SuccessResponse(w, map[string]interface{}{
-		"status":     "regenerated",
-		"new_key":    newKey,
-		"device_id":  deviceID,
-		"server_url": serverURL,
-	}, http.StatusOK)
-}
+	var template *types.TaskTemplate
+	switch r.Method {
+	case http.MethodGet:
+		if global {
+			template, err = db.GetGlobalTemplate(templateID)
+		} else {
+			template, err = db.GetTemplate(userID, templateID)
+		}
 
-// handleRevokeDevice 撤销设备
-func handleRevokeDevice(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	deviceID := vars["id"]
+	case http.MethodPatch:
+		var req templateWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
+		}
+		if template, err = db.UpdateTemplate(ownerID, templateID, req.fields()); err == nil && global {
+			logTemplateAction(r, "update_template", template)
+		}
 
-	if deviceID == "" {
-		response.ErrorResponse(w, "设备ID不能为空", http.StatusBadRequest)
+	case http.MethodDelete:
+		if err = db.DeleteTemplate(ownerID, templateID); err == nil {
+			if global {
+				logTemplateAction(r, "delete_template", &types.TaskTemplate{ID: templateID})
+			}
+			response.SuccessResponse(w, map[string]interface{}{"status": "deleted", "id": templateID}, http.StatusOK)
+			return
+		}
+	}
+
+	if err != nil {
+		writeTemplateError(w, err, "处理模板失败")
 		return
 	}
+	response.SuccessResponse(w, template, http.StatusOK)
+}
 
-	// 验证用户是否有权限操作此设备
-	userID, _, _, isActive, err := db.GetDeviceInfoByDeviceID(deviceID)
+// instantiateTemplateReq 实例化任务模板的请求体
+type instantiateTemplateReq struct {
+	LocalID string  `json:"local_id"` // 根任务的本地 ID，子任务依次追加 -1、-2…
+	Title   *string `json:"title"`    // 覆盖模板中的任务标题
+	// Anchor 截止时间偏移的基准时刻，RFC3339 或 YYYY-MM-DD，缺省为当前时刻
+	Anchor    string `json:"anchor"`
+	ProjectID int64  `json:"project_id"` // 全部任务放入该项目
+	ParentID  int64  `json:"parent_id"`  // 根任务作为该任务的子任务
+}
+
+// handleInstantiateTemplate 按模板在一个事务中创建任务及其子任务骨架
+func handleInstantiateTemplate(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
 	if err != nil {
-		response.ErrorResponse(w, "设备不存在", http.StatusNotFound)
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	if !isActive {
-		response.ErrorResponse(w, "设备已撤销", http.StatusBadRequest)
+	templateID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的模板ID", http.StatusBadRequest)
 		return
 	}
 
-	// 检查当前用户是否是设备所有者
-	currentUserIDStr := getUserIDFromContext(r.Context())
-	currentUserID, _ := strconv.Atoi(currentUserIDStr)
-	if currentUserID != userID {
-		response.ErrorResponse(w, "无权操作此设备", http.StatusForbidden)
+	var req instantiateTemplateReq
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+	}
+	errs := map[string]string{}
+	opts := db.InstantiateOptions{LocalID: req.LocalID, Anchor: time.Now().UTC().Truncate(time.Second), ProjectID: req.ProjectID, ParentID: req.ParentID}
+	if req.Title != nil {
+		title := strings.TrimSpace(*req.Title)
+		if !validator.IsValidTaskTitle(title) {
+			errs["title"] = "标题不能为空且不能超过200个字符"
+		}
+		opts.Title = &title
+	}
+	if req.Anchor != "" {
+		if opts.Anchor, err = parseDueAt(req.Anchor); err != nil {
+			errs["anchor"] = "基准时刻格式无效，应为 RFC3339 或 YYYY-MM-DD"
+		}
+	}
+	if req.ProjectID < 0 {
+		errs["project_id"] = "无效的项目ID"
+	}
+	if req.ParentID < 0 {
+		errs["parent_id"] = "无效的父任务ID"
+	}
+	if len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
 		return
 	}
+	if opts.LocalID == "" {
+		opts.LocalID = fmt.Sprintf("api-%d", time.Now().UnixNano())
+	}
 
-	// 撤销设备
-	err = db.RevokeDevice(deviceID)
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	tasks, err := db.InstantiateTemplate(userID, deviceIDFromRequest(r), templateID, opts)
 	if err != nil {
-		log.Printf("撤销设备失败: %v", err)
-		response.ErrorResponse(w, "撤销设备失败", http.StatusInternalServerError)
+		writeTemplateError(w, err, "实例化模板失败")
 		return
 	}
-
-	log.Printf("用户 %d 撤销了设备 %s", currentUserID, deviceID)
+	pushTaskChanges(wsHub, userID, afterSeq)
 
 	response.SuccessResponse(w, map[string]interface{}{
-		"status":    "revoked",
-		"device_id": deviceID,
-	}, http.StatusOK)
+		"template_id": templateID,
+		"tasks":       tasks,
+	}, http.StatusCreated)
 }
 
-// generateRandomKey 生成32字节随机密钥（hex格式，64字符）
-func generateRandomKey() string {
-	const charset = "0123456789abcdef"
-	b := make([]byte, 64)
-	for i := range b {
-		b[i] = charset[time.Now().UnixNano()%16]
+// writeTemplateError 将模板操作的错误转换为 HTTP 响应，实例化时任务层面的错误交给 writeTaskError
+func writeTemplateError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case db.ErrTemplateNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+	case db.ErrTemplateForbidden:
+		response.ErrorResponse(w, err.Error(), http.StatusForbidden)
+	case db.ErrTemplateLimit:
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		writeTaskError(w, err, fallback)
 	}
-	return string(b)
 }
 
-// toImportString 安全地转换JSON中的值为字符串（用于导入功能）
-func toImportString(v interface{}) string {
-	if v == nil {
-		return ""
+// logTemplateAction 记录管理员对全局模板的操作
+func logTemplateAction(r *http.Request, action string, template *types.TaskTemplate) {
+	adminID := toInt(getUserIDFromContext(r.Context()))
+	details := fmt.Sprintf("Global template %d", template.ID)
+	if template.Name != "" {
+		details += ": " + template.Name
 	}
+	if err := db.LogAdminAction(adminID, getEmailFromContext(r.Context()), action, "", 0, details, getClientIP(r)); err != nil {
+		log.Printf("记录管理操作日志错误: %v", err)
+	}
+}
 
-	switch val := v.(type) {
-	case string:
-		return val
-	case float64:
-		return fmt.Sprintf("%f", val)
-	case int64:
-		return fmt.Sprintf("%d", val)
-	case bool:
-		if val {
-			return "true"
+// tagWriteReq 创建/修改标签的请求体，指针字段为 nil 表示未提供
+type tagWriteReq struct {
+	Name  *string `json:"name"`
+	Color *string `json:"color"`
+}
+
+// validate 校验标签字段，creating 为 true 时要求名称必填
+func (req *tagWriteReq) validate(creating bool) map[string]string {
+	errs := map[string]string{}
+	if req.Name != nil || creating {
+		if req.Name == nil {
+			errs["name"] = "标签名不能为空"
+		} else if name, err := db.NormalizeTagName(*req.Name); err != nil {
+			errs["name"] = err.Error()
+		} else {
+			req.Name = &name
 		}
-		return "false"
-	case int:
-		return fmt.Sprintf("%d", val)
-	default:
-		return ""
 	}
+	if req.Color != nil && !db.ValidTagColor(*req.Color) {
+		errs["color"] = db.ErrInvalidTagColor.Error()
+	}
+	return errs
 }
 
-// handleBatchDeleteTasks 批量删除任务
-func handleBatchDeleteTasks(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
-	userIDStr := getUserIDFromContext(r.Context())
-	if userIDStr == "" {
+// handleTags 获取或创建当前用户的标签
+func handleTags(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	userID, err := strconv.Atoi(userIDStr)
-	if err != nil {
-		response.ErrorResponse(w, "无效的用户ID", http.StatusBadRequest)
+	if r.Method == http.MethodGet {
+		tags, err := db.ListTags(userID)
+		if err != nil {
+			log.Printf("获取标签失败: %v", err)
+			response.ErrorResponse(w, "获取标签失败", http.StatusInternalServerError)
+			return
+		}
+		response.SuccessResponse(w, tags, http.StatusOK)
 		return
 	}
 
-	var req struct {
-		TaskIDs []int64 `json:"task_ids"`
-	}
-
+	var req tagWriteReq
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		response.ErrorResponse(w, "无效的请�数据", http.StatusBadRequest)
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
 		return
 	}
-
-	if len(req.TaskIDs) == 0 {
-		response.ErrorResponse(w, "未选择要删除的任务", http.StatusBadRequest)
+	if errs := req.validate(true); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
 		return
 	}
+	color := db.DefaultTagColor
+	if req.Color != nil {
+		color = *req.Color
+	}
 
-	// 批量软删除任务
-	count, err := db.BatchDeleteTasks(userID, req.TaskIDs)
+	tag, err := db.CreateTag(userID, *req.Name, color)
+	if err == db.ErrTagExists {
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
-		log.Printf("批量删除失败: %v", err)
-		response.ErrorResponse(w, "批量删除失败", http.StatusInternalServerError)
+		log.Printf("创建标签失败: %v", err)
+		response.ErrorResponse(w, "创建标签失败", http.StatusInternalServerError)
 		return
 	}
-
-	// 发送通知
-	sendNotificationToUser(userID, "tasks_deleted", "任务已删除", fmt.Sprintf("已删除 %d 个任务，30秒内可撤销", count), "normal", wsHub)
-
-	response.SuccessResponse(w, map[string]interface{}{
-		"status":              "deleted",
-		"count":               count,
-		"can_undo":            true,
-		"undo_window_seconds": 30,
-	}, http.StatusOK)
+	response.SuccessResponse(w, tag, http.StatusCreated)
 }
 
-// handleRestoreTask 恢复删除的任务（撤销）
-func handleRestoreTask(w http.ResponseWriter, r *http.Request) {
-	userIDStr := getUserIDFromContext(r.Context())
-	if userIDStr == "" {
+// handleTagByID 修改或删除标签，改名或删除会使相关任务分配新版本号并推送给客户端
+func handleTagByID(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
 		return
 	}
 
-	vars := mux.Vars(r)
-	taskID, err := strconv.ParseInt(vars["id"], 10, 64)
+	tagID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
 	if err != nil {
-		response.ErrorResponse(w, "无效的任务ID", http.StatusBadRequest)
+		response.ErrorResponse(w, "无效的标签ID", http.StatusBadRequest)
 		return
 	}
 
-	userID, _ := strconv.Atoi(userIDStr)
-
-	// 恢复任务
-	err = db.RestoreDeletedTask(taskID, userID)
-	if err != nil {
-		if _, ok := err.(*db.UndoExpiredError); ok {
-			response.ErrorResponse(w, "已超过30秒撤销期限", http.StatusGone)
-		} else {
-			response.ErrorResponse(w, "恢复失败", http.StatusInternalServerError)
+	var tag *types.Tag
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	switch r.Method {
+	case http.MethodPatch:
+		var req tagWriteReq
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+			return
+		}
+		if errs := req.validate(false); len(errs) > 0 {
+			response.ValidationErrorResponse(w, errs)
+			return
 		}
+		tag, err = db.UpdateTag(userID, deviceIDFromRequest(r), tagID, req.Name, req.Color)
+
+	case http.MethodDelete:
+		err = db.DeleteTag(userID, deviceIDFromRequest(r), tagID)
+	}
+
+	switch err {
+	case nil:
+	case db.ErrTagNotFound:
+		response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	case db.ErrTagExists:
+		response.ErrorResponse(w, err.Error(), http.StatusConflict)
+		return
+	default:
+		log.Printf("修改标签失败: %v", err)
+		response.ErrorResponse(w, "修改标签失败", http.StatusInternalServerError)
 		return
 	}
+	pushTaskChanges(wsHub, userID, afterSeq)
 
-	response.SuccessResponse(w, map[string]string{
-		"status": "restored",
-	}, http.StatusOK)
+	if r.Method == http.MethodDelete {
+		response.SuccessResponse(w, map[string]interface{}{"status": "deleted", "id": tagID}, http.StatusOK)
+		return
+	}
+	response.SuccessResponse(w, tag, http.StatusOK)
 }
 
 // handleImport 导入任务数据（JSON 或 CSV 格式）
-func handleImport(w http.ResponseWriter, r *http.Request) {
+func handleImport(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
 	userID := getUserIDFromContext(r.Context())
 	if userID == "" {
 		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
@@ -1337,6 +5138,7 @@ func handleImport(w http.ResponseWriter, r *http.Request) {
 				"description": toImportString(jsonTask["description"]),
 				"status":      toImportString(jsonTask["status"]),
 				"priority":    toImportString(jsonTask["priority"]),
+				"tags":        toImportTags(jsonTask["tags"]),
 			}
 
 			if task["title"] != "" {
@@ -1378,6 +5180,7 @@ func handleImport(w http.ResponseWriter, r *http.Request) {
 				"description": description,
 				"status":      status,
 				"priority":    priority,
+				"tags":        toImportTags(record["tags"]),
 			}
 			tasks = append(tasks, task)
 		}
@@ -1392,12 +5195,14 @@ func handleImport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 批量插入
-	insertedIDs, err := db.BatchInsertTasks(userIDInt, tasks)
+	afterSeq := changeSeqBeforeWrite(wsHub, userIDInt)
+	insertedIDs, err := db.BatchInsertTasks(userIDInt, deviceIDFromRequest(r), tasks)
 	if err != nil {
 		log.Printf("批量插入失败: %v", err)
 		response.ErrorResponse(w, "导入失败", http.StatusInternalServerError)
 		return
 	}
+	pushTaskChanges(wsHub, userIDInt, afterSeq)
 
 	importedCount = len(insertedIDs)
 
@@ -1430,7 +5235,7 @@ func handleExport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	t := r.URL.Query().Get("type")
-	if t != "tasks" {
+	if t != "tasks" && t != "time_entries" {
 		response.ErrorResponse(w, "不支持的导出类型", http.StatusBadRequest)
 		return
 	}
@@ -1440,13 +5245,36 @@ func handleExport(w http.ResponseWriter, r *http.Request) {
 		format = "json"
 	}
 
+	// project_id 指定时只导出该项目中的任务
+	var projectID int64
+	if v := r.URL.Query().Get("project_id"); v != "" {
+		if projectID, err = strconv.ParseInt(v, 10, 64); err != nil || projectID < 1 {
+			response.ValidationErrorResponse(w, map[string]string{"project_id": "无效的项目ID"})
+			return
+		}
+		if _, err := db.GetProject(userIDInt, projectID); err != nil {
+			if err == db.ErrProjectNotFound {
+				response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			log.Printf("查询项目失败: %v", err)
+			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if t == "time_entries" {
+		exportTimeEntries(w, userIDInt, projectID, format)
+		return
+	}
+
 	if format == "json" {
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("Content-Disposition", "attachment;filename=tasks.json")
 
 		w.Write([]byte("[\n"))
 		first := true
-		if err := db.GetTasksStreaming(userIDInt, 100, func(batch []map[string]interface{}) error {
+		if err := db.GetTasksStreaming(userIDInt, projectID, 100, func(batch []map[string]interface{}) error {
 			for _, task := range batch {
 				if !first {
 					w.Write([]byte(",\n"))
@@ -1462,106 +5290,247 @@ func handleExport(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		w.Write([]byte("\n]"))
-
-		log.Printf("用户 %d 流式导出任务到 JSON 格式", userIDInt)
-		return
-	}
-
-	if format == "csv" {
+
+		log.Printf("用户 %d 流式导出任务到 JSON 格式", userIDInt)
+		return
+	}
+
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", "attachment;filename=tasks.csv")
+
+		// 写入UTF-8 BOM（Excel兼容）
+		w.Write([]byte{0xEF, 0xBB, 0xBF})
+
+		// 使用CSVStreamer进行流式写入
+		streamer := utils.NewCSVStreamer(w)
+		defer streamer.Close()
+
+		headers := []string{
+			"id", "local_id", "server_version", "title", "description",
+			"status", "priority", "due_at", "created_at", "updated_at",
+			"completed_at", "is_deleted", "last_modified", "tags",
+		}
+		if err := streamer.WriteHeader(headers); err != nil {
+			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
+			return
+		}
+
+		totalExported := 0
+		if err := db.GetTasksStreaming(userIDInt, projectID, 100, func(batch []map[string]interface{}) error {
+			for _, task := range batch {
+				if task["description"] == nil {
+					task["description"] = ""
+				}
+				if err := streamer.WriteRow(task); err != nil {
+					return err
+				}
+			}
+			totalExported += len(batch)
+			return nil
+		}); err != nil {
+			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
+			return
+		}
+
+		// 记录导出审计日志
+		if err := db.LogExportAction(userIDInt, "tasks", format, totalExported); err != nil {
+			log.Printf("记录导出审计日志错误: %v", err)
+		}
+
+		log.Printf("用户 %d 流式导出了 %d 个任务到 CSV 格式（分批: 100条/批）", userIDInt, totalExported)
+		return
+	}
+
+	response.ErrorResponse(w, "未知的格式", http.StatusBadRequest)
+}
+
+// timeEntryExportColumns 工时记录 CSV 导出的列
+var timeEntryExportColumns = []string{
+	"id", "local_id", "server_version", "task_id", "task_title", "started_at", "ended_at",
+	"duration_seconds", "note", "created_at", "updated_at",
+}
+
+// exportTimeEntries 流式导出用户在未删除任务上的工时记录（JSON 或 CSV），运行中的计时器 ended_at 为空、时长计到当前时刻
+func exportTimeEntries(w http.ResponseWriter, userID int, projectID int64, format string) {
+	if format != "json" && format != "csv" {
+		response.ErrorResponse(w, "未知的格式", http.StatusBadRequest)
+		return
+	}
+
+	total := 0
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment;filename=time_entries.json")
+
+		w.Write([]byte("[\n"))
+		if err := db.GetTimeEntriesStreaming(userID, projectID, 100, func(batch []map[string]interface{}) error {
+			for _, entry := range batch {
+				if total > 0 {
+					w.Write([]byte(",\n"))
+				}
+				total++
+				if err := json.NewEncoder(w).Encode(entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("\n]"))
+	} else {
 		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
-		w.Header().Set("Content-Disposition", "attachment;filename=tasks.csv")
+		w.Header().Set("Content-Disposition", "attachment;filename=time_entries.csv")
 
 		// 写入UTF-8 BOM（Excel兼容）
 		w.Write([]byte{0xEF, 0xBB, 0xBF})
 
-		// 使用CSVStreamer进行流式写入
 		streamer := utils.NewCSVStreamer(w)
 		defer streamer.Close()
-
-		headers := []string{
-			"id", "local_id", "server_version", "title", "description",
-			"status", "priority", "due_at", "created_at", "updated_at",
-			"completed_at", "is_deleted", "last_modified",
-		}
-		if err := streamer.WriteHeader(headers); err != nil {
+		if err := streamer.WriteHeader(timeEntryExportColumns); err != nil {
 			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
 			return
 		}
-
-		totalExported := 0
-		if err := db.GetTasksStreaming(userIDInt, 100, func(batch []map[string]interface{}) error {
-			for _, task := range batch {
-				if task["description"] == nil {
-					task["description"] = ""
-				}
-				if err := streamer.WriteRow(task); err != nil {
+		if err := db.GetTimeEntriesStreaming(userID, projectID, 100, func(batch []map[string]interface{}) error {
+			for _, entry := range batch {
+				if err := streamer.WriteColumns(entry, timeEntryExportColumns); err != nil {
 					return err
 				}
 			}
-			totalExported += len(batch)
+			total += len(batch)
 			return nil
 		}); err != nil {
 			response.ErrorResponse(w, "导出错误", http.StatusInternalServerError)
 			return
 		}
+	}
 
-		// 记录导出审计日志
-		if err := db.LogExportAction(userIDInt, "tasks", format, totalExported); err != nil {
-			log.Printf("记录导出审计日志错误: %v", err)
-		}
-
-		log.Printf("用户 %d 流式导出了 %d 个任务到 CSV 格式（分批: 100条/批）", userIDInt, totalExported)
-		return
+	if err := db.LogExportAction(userID, "time_entries", format, total); err != nil {
+		log.Printf("记录导出审计日志错误: %v", err)
 	}
+	log.Printf("用户 %d 流式导出了 %d 条工时记录到 %s 格式", userID, total, strings.ToUpper(format))
+}
 
-	response.ErrorResponse(w, "未知的格式", http.StatusBadRequest)
+// recordConflict 记录冲突到数据库，返回冲突 ID（记录失败时为 0）
+func recordConflict(tx *sql.Tx, userID int, localID string, serverID int64, reason string, fields []types.FieldLevelConflict) int64 {
+	conflictID, err := db.RecordConflict(tx, userID, types.ConflictRecord{
+		LocalID:   localID,
+		ServerID:  serverID,
+		Reason:    reason,
+		Options:   []types.ConflictResolution{types.ConflictKeepServer, types.ConflictKeepClient, types.ConflictMerge},
+		Conflicts: fields,
+	})
+	if err != nil {
+		log.Printf("记录冲突错误: %v", err)
+	}
+	return conflictID
 }
 
-// intelligentMerge 策略：字段级冲突智能合并
-func intelligentMerge(serverTitle, serverDesc, serverStatus string, clientProps map[string]interface{}) (string, string, string) {
-	mergedTitle := serverTitle
-	mergedDesc := serverDesc
-	mergedStatus := serverStatus
+// syncMergeFields 同步冲突时参与合并的字段
+var syncMergeFields = []string{"title", "description", "status", "priority", "due_at"}
 
-	// 标题：优先服务器
-	if clientTitle, ok := clientProps["title"].(string); ok && clientTitle != "" {
-		if clientTitle != serverTitle {
-			mergedTitle = serverTitle
-		} else {
-			mergedTitle = clientTitle
+// loadMergeStrategies 读取各字段的合并策略（system_config 中的 merge_strategy.<字段>），未配置时使用默认策略
+func loadMergeStrategies() map[string]string {
+	strategies := make(map[string]string, len(merge.DefaultStrategies))
+	for field, strategy := range merge.DefaultStrategies {
+		strategies[field] = strategy
+		value, found, err := db.GetConfigValue("merge_strategy." + field)
+		if err != nil {
+			log.Printf("读取合并策略配置失败: %v", err)
+			continue
+		}
+		if found && value != "" {
+			strategies[field] = value
 		}
 	}
+	return strategies
+}
 
-	// 描述：合并两者的描述
-	if clientDesc, ok := clientProps["description"].(string); ok {
-		if clientDesc != serverDesc && serverDesc != "" && clientDesc != "" {
-			mergedDesc = fmt.Sprintf("[服务器更新] %s\n\n[客户端更新] %s", serverDesc, clientDesc)
-		} else if clientDesc != "" {
-			mergedDesc = clientDesc
+// mergeSyncFields 按字段策略合并客户端提交的值与服务器当前值
+// base 为客户端修改所基于的版本（可能为 nil），返回合并后的字段值与双方修改重叠的字段级冲突列表
+func mergeSyncFields(server, base *db.SyncTaskState, payload map[string]interface{}, clientTime time.Time, strategies map[string]string) (map[string]string, []types.FieldLevelConflict) {
+	stateValues := func(t *db.SyncTaskState) map[string]string {
+		if t == nil {
+			return map[string]string{}
+		}
+		return map[string]string{
+			"title":       t.Title,
+			"description": t.Description,
+			"status":      t.Status,
+			"priority":    t.Priority,
+			"due_at":      t.DueAt,
 		}
 	}
+	serverValues := stateValues(server)
+	baseValues := stateValues(base)
 
-	// 状态：保留较新的状态
-	if clientStatus, ok := clientProps["status"].(string); ok {
-		if clientStatus != serverStatus {
-			mergedStatus = types.PrioritizeStatus(clientStatus, serverStatus)
-		}
+	merged := make(map[string]string, len(serverValues))
+	for name, v := range serverValues {
+		merged[name] = v
 	}
 
-	return mergedTitle, mergedDesc, mergedStatus
+	var fields []types.FieldLevelConflict
+	for _, name := range syncMergeFields {
+		clientValue, ok := payload[name].(string)
+		if !ok || clientValue == serverValues[name] {
+			continue
+		}
+		result, strategy := merge.Apply(strategies[name], merge.Input{
+			Field:      name,
+			Base:       baseValues[name],
+			HasBase:    base != nil,
+			Server:     serverValues[name],
+			Client:     clientValue,
+			ServerTime: server.UpdatedAt,
+			ClientTime: clientTime,
+		})
+		merged[name] = result.Value
+		if !result.Conflict {
+			continue
+		}
+		fields = append(fields, types.FieldLevelConflict{
+			FieldName:   name,
+			ServerValue: serverValues[name],
+			ClientValue: clientValue,
+			MergedValue: result.Value,
+			Strategy:    strategy,
+		})
+	}
+	return merged, fields
 }
 
-// recordConflict 记录冲突到数据库
-func recordConflict(localID string, serverID int64, reason string, userID int) {
-	options := []types.ConflictResolution{types.ConflictKeepServer, types.ConflictKeepClient, types.ConflictMerge}
-	optionsJSON, err := json.Marshal(options)
+// normalizeSyncDueAt 将同步载荷中的 due_at 统一为 UTC RFC3339，null 或空字符串表示清除，无法解析时忽略该字段
+func normalizeSyncDueAt(payload map[string]interface{}) {
+	v, ok := payload["due_at"]
+	if !ok {
+		return
+	}
+	s, _ := v.(string)
+	if s == "" {
+		payload["due_at"] = ""
+		return
+	}
+	dueAt, err := parseDueAt(s)
 	if err != nil {
-		log.Printf("序列化冲突选项错误: %v", err)
-		optionsJSON = []byte("[]")
+		log.Printf("忽略无效的截止时间 %q: %v", s, err)
+		delete(payload, "due_at")
+		return
 	}
-	if err := db.RecordConflict(localID, serverID, reason, string(optionsJSON)); err != nil {
-		log.Printf("记录冲突错误: %v", err)
+	payload["due_at"] = dueAt.UTC().Format(time.RFC3339)
+}
+
+// syncDueAt 将同步使用的 RFC3339 字符串转换为截止时间，空字符串表示没有截止时间
+func syncDueAt(s string) *time.Time {
+	if s == "" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
 	}
+	return &t
 }
 
 // randomString 生成随机字符串（用于local_id冲突处理）
@@ -1586,6 +5555,114 @@ func toString(v interface{}) string {
 	}
 }
 
+// ============ Conflict Handlers ============
+
+// handleListConflicts 获取当前用户的同步冲突记录
+func handleListConflicts(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	// 默认只返回未解决的冲突，status=all 返回全部
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = db.ConflictStatusOpen
+	case "all":
+		status = ""
+	case db.ConflictStatusOpen, db.ConflictStatusResolved:
+	default:
+		response.ValidationErrorResponse(w, map[string]string{"status": "状态必须是 open、resolved 或 all"})
+		return
+	}
+
+	conflicts, total, err := db.GetConflicts(userID, status, page, pageSize)
+	if err != nil {
+		log.Printf("获取冲突记录失败: %v", err)
+		response.ErrorResponse(w, "获取冲突记录失败", http.StatusInternalServerError)
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"conflicts": conflicts,
+		"pagination": map[string]interface{}{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+			"pages":     (total + pageSize - 1) / pageSize,
+		},
+	}, http.StatusOK)
+}
+
+// handleResolveConflict 按 keep_server、keep_client、merge 或显式字段值解决冲突
+func handleResolveConflict(w http.ResponseWriter, r *http.Request, wsHub *wsclient.Hub) {
+	userID, err := strconv.Atoi(getUserIDFromContext(r.Context()))
+	if err != nil {
+		response.ErrorResponse(w, "未授权", http.StatusUnauthorized)
+		return
+	}
+
+	conflictID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		response.ErrorResponse(w, "无效的冲突ID", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Resolution    types.ConflictResolution `json:"resolution"`
+		Values        taskWriteReq             `json:"values"`
+		ServerVersion int                      `json:"server_version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		response.ErrorResponse(w, "无效的请求体", http.StatusBadRequest)
+		return
+	}
+
+	overrides := req.Values.fields()
+	if req.Resolution == "" && !overrides.IsEmpty() {
+		req.Resolution = types.ConflictManual
+	}
+	if !req.Resolution.IsValid() {
+		response.ValidationErrorResponse(w, map[string]string{"resolution": "解决方式必须是 keep_server、keep_client、merge 或提供 values"})
+		return
+	}
+	if errs := req.Values.validate(false); len(errs) > 0 {
+		response.ValidationErrorResponse(w, errs)
+		return
+	}
+
+	afterSeq := changeSeqBeforeWrite(wsHub, userID)
+	conflict, task, err := db.ResolveConflict(userID, deviceIDFromRequest(r), conflictID, req.Resolution, overrides, req.ServerVersion)
+	if err != nil {
+		switch err {
+		case db.ErrConflictNotFound:
+			response.ErrorResponse(w, err.Error(), http.StatusNotFound)
+		case db.ErrConflictResolved:
+			response.ErrorResponse(w, err.Error(), http.StatusConflict)
+		default:
+			writeTaskError(w, err, "解决冲突失败")
+		}
+		return
+	}
+	pushTaskChanges(wsHub, userID, afterSeq)
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"conflict": conflict,
+		"task":     task,
+	}, http.StatusOK)
+}
+
 // ============ Admin Handlers ============
 
 type adminUserResponse struct {
@@ -1624,6 +5701,33 @@ type adminConfigRequest struct {
 	Description string `json:"description"`
 }
 
+// handleAdminWorkload 按用户统计被指派的任务：未完成数、逾期数与最近 days 天（默认 30，最多 365）内的完成数
+func handleAdminWorkload(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 365 {
+			response.ValidationErrorResponse(w, map[string]string{"days": "必须是 1 到 365 之间的整数"})
+			return
+		}
+		days = n
+	}
+
+	since := time.Now().UTC().AddDate(0, 0, -days)
+	workload, unassigned, err := db.GetAssigneeWorkload(since)
+	if err != nil {
+		log.Printf("统计工作量失败: %v", err)
+		response.ErrorResponse(w, "统计工作量失败", http.StatusInternalServerError)
+		return
+	}
+
+	response.SuccessResponse(w, map[string]interface{}{
+		"users":           workload,
+		"unassigned_open": unassigned,
+		"completed_since": since.Format(time.RFC3339),
+	}, http.StatusOK)
+}
+
 func handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
 	// 解析分页参数
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
@@ -1640,8 +5744,18 @@ func handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
 	email := r.URL.Query().Get("email")
 	role := r.URL.Query().Get("role")
 
-	users, total, err := db.GetUsersPaginated(page, pageSize, email, role)
+	cursor, err := parseCursorParam(r)
 	if err != nil {
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	users, total, next, err := db.GetUsersPaginated(page, pageSize, email, role, cursor)
+	if err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			response.ErrorResponse(w, filterErr.Message, http.StatusBadRequest)
+			return
+		}
 		log.Printf("Failed to get users: %v", err)
 		response.ErrorResponse(w, "获取用户列表失败", http.StatusInternalServerError)
 		return
@@ -1661,6 +5775,7 @@ func handleAdminListUsers(w http.ResponseWriter, r *http.Request) {
 			"has_prev":  hasPrev,
 			"has_next":  hasNext,
 		},
+		"next_cursor": signCursor(next),
 	}, http.StatusOK)
 }
 
@@ -1920,12 +6035,27 @@ func handleAdminGetActionLogs(w http.ResponseWriter, r *http.Request) {
 		"page_size":    r.URL.Query().Get("page_size"),
 	}
 
-	logs, err := db.GetAdminLogs(filters)
+	cursor, err := parseCursorParam(r)
+	if err != nil {
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	logs, next, err := db.GetAdminLogs(filters, cursor)
 	if err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			response.ErrorResponse(w, filterErr.Message, http.StatusBadRequest)
+			return
+		}
 		log.Printf("Failed to get admin logs: %v", err)
 		response.ErrorResponse(w, "获取操作日志失败", http.StatusInternalServerError)
 		return
 	}
+
+	// 响应体保持数组格式，下一页游标通过响应头返回
+	if token := signCursor(next); token != "" {
+		w.Header().Set("X-Next-Cursor", token)
+	}
 	response.SuccessResponse(w, logs, http.StatusOK)
 }
 
@@ -1950,6 +6080,35 @@ func handleAdminSetConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// 合并策略配置需指向可合并的字段与已注册的策略
+	if field := strings.TrimPrefix(req.Key, "merge_strategy."); field != req.Key {
+		if _, ok := merge.DefaultStrategies[field]; !ok {
+			response.ErrorResponse(w, "不支持配置合并策略的字段: "+field, http.StatusBadRequest)
+			return
+		}
+		if _, ok := merge.Lookup(req.Value); !ok {
+			response.ErrorResponse(w, "未知的合并策略，可选: "+strings.Join(merge.Names(), ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if req.Key == "trash_retention_days" {
+		if days, err := strconv.Atoi(req.Value); err != nil || days < 1 {
+			response.ErrorResponse(w, "回收站保留天数必须是正整数", http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Key == "dependency_enforcement" && req.Value != types.DependencyReject && req.Value != types.DependencyWarn {
+		response.ErrorResponse(w, "依赖检查方式必须是 reject 或 warn", http.StatusBadRequest)
+		return
+	}
+	if req.Key == "max_task_depth" {
+		if depth, err := strconv.Atoi(req.Value); err != nil || depth < 1 || depth > 100 {
+			response.ErrorResponse(w, "任务最大层数必须是 1 到 100 之间的整数", http.StatusBadRequest)
+			return
+		}
+	}
+
 	adminID := getUserIDFromContext(r.Context())
 	if err := db.SetSystemConfig(req.Key, req.Value, req.Description, adminID); err != nil {
 		response.ErrorResponse(w, "设置配置失败", http.StatusInternalServerError)
@@ -2016,8 +6175,18 @@ func handleGetNotifications(w http.ResponseWriter, r *http.Request) {
 		"priority": r.URL.Query().Get("priority"),
 	}
 
-	notifications, total, err := db.GetNotificationsPaginated(userID, page, pageSize, filters)
+	cursor, err := parseCursorParam(r)
+	if err != nil {
+		response.ErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	notifications, total, next, err := db.GetNotificationsPaginated(userID, page, pageSize, filters, cursor)
 	if err != nil {
+		if filterErr, ok := err.(*db.FilterError); ok {
+			response.ErrorResponse(w, filterErr.Message, http.StatusBadRequest)
+			return
+		}
 		response.ErrorResponse(w, "获取通知失败", http.StatusInternalServerError)
 		return
 	}
@@ -2030,6 +6199,7 @@ func handleGetNotifications(w http.ResponseWriter, r *http.Request) {
 			"total":     total,
 			"pages":     (total + pageSize - 1) / pageSize,
 		},
+		"next_cursor": signCursor(next),
 	}, http.StatusOK)
 }
 
@@ -2225,17 +6395,20 @@ func handleGetUnreadCount(w http.ResponseWriter, r *http.Request) {
 // ============ Helper Functions ============
 
 // startCleanupTasks 启动定期清理任务
-func startCleanupTasks() {
-	// 每小时清理一次过期通知和令牌
+func startCleanupTasks(blobs blobstore.Store) {
+	// 每小时清理一次过期通知和令牌，以及已删除附件在存储中的内容
 	ticker := time.NewTicker(1 * time.Hour)
 	go func() {
+		// 启动时先清理上次运行遗留的附件内容
+		cleanupAttachmentBlobs(blobs)
 		for range ticker.C {
 			cleanupExpiredNotifications()
 			cleanupExpiredTokens()
+			cleanupAttachmentBlobs(blobs)
 		}
 	}()
 
-	// 每天清理一次旧日志和删除记录
+	// 每天清理一次旧日志、删除记录和超过保留期限的回收站任务
 	dailyTicker := time.NewTicker(24 * time.Hour)
 	go func() {
 		for range dailyTicker.C {
@@ -2244,10 +6417,67 @@ func startCleanupTasks() {
 			if count > 0 {
 				log.Printf("Cleaned up %d old deleted_task records", count)
 			}
+			purged, err := db.PurgeExpiredTrash()
+			if err != nil {
+				log.Printf("Failed to purge expired trash: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d tasks past the trash retention period", purged)
+			}
 		}
 	}()
 }
 
+// reminderInterval 提醒调度的检查间隔
+const reminderInterval = time.Minute
+
+// startReminderScheduler 定期检查到期提醒，发送“即将到期”和“已逾期”通知
+// 提醒在发送前已持久化认领，重启后不会重复发送
+func startReminderScheduler(wsHub *wsclient.Hub) {
+	dispatchReminders(wsHub)
+	ticker := time.NewTicker(reminderInterval)
+	for range ticker.C {
+		dispatchReminders(wsHub)
+	}
+}
+
+// viewRefreshInterval 重新计算含相对时间条件的视图成员数的间隔
+const viewRefreshInterval = time.Minute
+
+// startViewCountRefresher 定期重新计算在线用户中含相对时间条件（如 due_to=+7d）的视图成员数
+// 这类视图的成员即使没有任务写入也会随时间变化
+func startViewCountRefresher(wsHub *wsclient.Hub) {
+	ticker := time.NewTicker(viewRefreshInterval)
+	for range ticker.C {
+		users, err := db.UsersWithRelativeViews()
+		if err != nil {
+			log.Printf("Failed to list relative views: %v", err)
+			continue
+		}
+		for _, userID := range users {
+			pushViewCounts(wsHub, userID, true)
+		}
+	}
+}
+
+// dispatchReminders 认领并发送当前到期的提醒
+func dispatchReminders(wsHub *wsclient.Hub) {
+	reminders, err := db.ClaimDueReminders(time.Now())
+	if err != nil {
+		log.Printf("Failed to claim due reminders: %v", err)
+		return
+	}
+	for _, rem := range reminders {
+		due := rem.DueAt.Format("2006-01-02 15:04 UTC")
+		title, content, priority := "任务即将到期", fmt.Sprintf("任务「%s」将于 %s 到期", rem.Title, due), "high"
+		if rem.Kind == db.ReminderOverdue {
+			title, content, priority = "任务已逾期", fmt.Sprintf("任务「%s」已于 %s 到期", rem.Title, due), "urgent"
+		}
+		if _, err := sendNotificationToUser(rem.UserID, rem.Kind, title, content, priority, wsHub); err != nil {
+			log.Printf("Failed to send %s reminder for task %d: %v", rem.Kind, rem.TaskID, err)
+		}
+	}
+}
+
 // cleanupExpiredNotifications 清理过期通知
 func cleanupExpiredNotifications() {
 	count, err := db.CleanupExpiredNotifications()
@@ -2301,6 +6531,159 @@ func sendNotificationToUser(userID int, ntype, title, content, priority string,
 	return notificationID, nil
 }
 
+// maxPushedChanges 单条 WebSocket 消息最多携带的任务变更数
+const maxPushedChanges = 100
+
+// changeMark 写入前的变更日志位置，用于写入后推送新增的变更
+type changeMark struct {
+	seq        int   // 执行者当前的变更序号，不在线时为 -1
+	logID      int64 // 变更日志当前最大的记录 ID，用于找出共享项目中受影响的其他成员
+	activityID int64 // 任务动态当前最大的事件 ID，用于向订阅者推送新产生的系统事件，读取失败时为 -1
+}
+
+// changeSeqBeforeWrite 在写入前记录用户当前的变更序号、变更日志与任务动态的位置
+func changeSeqBeforeWrite(wsHub *wsclient.Hub, userID int) changeMark {
+	mark := changeMark{seq: -1, logID: -1, activityID: -1}
+	activityID, err := db.LatestActivityID(db.DB)
+	if err != nil {
+		log.Printf("读取任务动态失败: %v", err)
+	} else {
+		mark.activityID = activityID
+	}
+	logID, err := db.LatestChangeLogID(db.DB)
+	if err != nil {
+		log.Printf("读取变更序号失败: %v", err)
+		return mark
+	}
+	mark.logID = logID
+	if !wsHub.IsUserConnected(int64(userID)) {
+		return mark
+	}
+	if mark.seq, err = db.LatestChangeSeq(db.DB, userID); err != nil {
+		log.Printf("读取变更序号失败: %v", err)
+		mark.seq = -1
+	}
+	return mark
+}
+
+// pushTaskChanges 通过 WebSocket 向执行者推送 mark 之后的任务与项目变更
+// 写入涉及共享项目时，同时向在线的其他成员推送各自变更日志中新增的变更；新产生的系统事件推送给订阅了任务动态的客户端
+// 这些用户的视图成员数随之重新计算，发生变化时一并推送
+func pushTaskChanges(wsHub *wsclient.Hub, userID int, mark changeMark) {
+	pushActivitySince(wsHub, userID, mark.activityID)
+	pushUserChanges(wsHub, userID, mark.seq)
+	pushViewCounts(wsHub, userID, false)
+	if mark.logID < 0 {
+		return
+	}
+	members, err := db.ChangedUsersSince(db.DB, mark.logID, userID)
+	if err != nil {
+		log.Printf("读取共享变更失败: %v", err)
+		return
+	}
+	for memberID, afterSeq := range members {
+		pushUserChanges(wsHub, memberID, afterSeq)
+		pushViewCounts(wsHub, memberID, false)
+	}
+}
+
+// pushActivitySince 向任务动态的订阅者推送执行者在 afterID 之后产生的系统事件
+func pushActivitySince(wsHub *wsclient.Hub, userID int, afterID int64) {
+	if afterID < 0 {
+		return
+	}
+	items, err := db.GetActivitySince(userID, afterID)
+	if err != nil {
+		log.Printf("读取任务动态失败: %v", err)
+		return
+	}
+	for _, item := range items {
+		pushTaskActivity(wsHub, "created", item)
+	}
+}
+
+// pushTaskActivity 向订阅了任务动态的客户端推送一条动态，action 为 created、updated 或 deleted
+// 推送前重新检查订阅者的查看权限
+func pushTaskActivity(wsHub *wsclient.Hub, action string, item types.ActivityItem) {
+	wsHub.BroadcastToTaskWatchers(item.TaskID, wsclient.Message{
+		Type: "task_activity",
+		Data: map[string]interface{}{
+			"task_id": item.TaskID,
+			"action":  action,
+			"item":    item,
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	}, func(watcherID int64) bool {
+		return db.CheckTaskAccess(int(watcherID), item.TaskID, types.ProjectRoleViewer) == nil
+	})
+}
+
+// pushUserChanges 通过 WebSocket 推送用户 afterSeq 之后的任务、项目、视图与工时记录变更
+// 客户端本地游标等于 after_seq 时可直接应用并将游标前移到 last_seq，否则（或 more 为 true 时）应调用 /sync 补齐
+func pushUserChanges(wsHub *wsclient.Hub, userID int, afterSeq int) {
+	if afterSeq < 0 || !wsHub.IsUserConnected(int64(userID)) {
+		return
+	}
+
+	changes, lastSeq, more, err := db.GetTaskChangesSince(db.DB, userID, afterSeq, maxPushedChanges)
+	if err != nil {
+		log.Printf("读取任务变更失败: %v", err)
+		return
+	}
+	// 任务变更被截断时只推送不超过已推送任务序号的项目、视图与工时记录变更，其余留待客户端拉取
+	untilSeq := 0
+	if more {
+		untilSeq = lastSeq
+	}
+	projects, projectSeq, err := db.GetProjectChangesSince(db.DB, userID, afterSeq, untilSeq)
+	if err != nil {
+		log.Printf("读取项目变更失败: %v", err)
+		return
+	}
+	views, viewSeq, err := db.GetViewChangesSince(db.DB, userID, afterSeq, untilSeq)
+	if err != nil {
+		log.Printf("读取视图变更失败: %v", err)
+		return
+	}
+	entries, entrySeq, err := db.GetTimeEntryChangesSince(db.DB, userID, afterSeq, untilSeq)
+	if err != nil {
+		log.Printf("读取工时记录变更失败: %v", err)
+		return
+	}
+	if len(changes) == 0 && len(projects) == 0 && len(views) == 0 && len(entries) == 0 {
+		return
+	}
+	if lastSeq < afterSeq {
+		lastSeq = afterSeq
+	}
+	if projectSeq > lastSeq {
+		lastSeq = projectSeq
+	}
+	if viewSeq > lastSeq {
+		lastSeq = viewSeq
+	}
+	if entrySeq > lastSeq {
+		lastSeq = entrySeq
+	}
+
+	err = wsHub.BroadcastToUser(int64(userID), wsclient.Message{
+		Type: "sync_changes",
+		Data: map[string]interface{}{
+			"after_seq":    afterSeq,
+			"last_seq":     lastSeq,
+			"changes":      changes,
+			"projects":     projects,
+			"views":        views,
+			"time_entries": entries,
+			"more":         more,
+		},
+		Timestamp: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		log.Printf("Failed to push task changes via WebSocket: %v", err)
+	}
+}
+
 // ============ WebSocket Handlers ============
 
 // handleWebSocket WebSocket连接处理
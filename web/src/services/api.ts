@@ -184,7 +184,7 @@ class ApiService {
   }
 
   // Sync operations
-  async sync(lastSyncAt: string, changes: DeltaChange[]): Promise<{
+  async sync(lastSyncAt: string, lastSeq: number | undefined, deviceId: string, changes: DeltaChange[]): Promise<{
     server_changes: Array<{
       id: number;
       server_version: number;
@@ -198,6 +198,7 @@ class ApiService {
       op: string;
     }>;
     last_sync_at: string;
+    last_seq: number;
     conflicts: Array<{
       local_id: string;
       server_id: number;
@@ -207,6 +208,8 @@ class ApiService {
   }> {
     const response = await this.client.post('/sync', {
       last_sync_at: lastSyncAt,
+      last_seq: lastSeq,
+      device_id: deviceId,
       changes,
     });
     return response.data;
@@ -1,6 +1,18 @@
 import { indexedDBService, Task, DeltaChange } from './indexedDB';
 import { apiService } from './api';
 
+const DEVICE_ID_KEY = 'todoapp_device_id';
+
+// 本浏览器的设备标识，服务器按设备记录同步进度
+function getDeviceId(): string {
+  let deviceId = localStorage.getItem(DEVICE_ID_KEY);
+  if (!deviceId) {
+    deviceId = `web-${Date.now()}-${Math.random().toString(36).substr(2, 9)}`;
+    localStorage.setItem(DEVICE_ID_KEY, deviceId);
+  }
+  return deviceId;
+}
+
 class SyncManager {
   private syncInProgress = false;
   private syncIntervalId: number | null = null;
@@ -23,9 +35,11 @@ class SyncManager {
       // 获取同步元数据
       const syncMeta = await indexedDBService.getSyncMeta('current-user');
       const lastSyncAt = syncMeta?.last_sync_at || new Date(0).toISOString();
+      // 尚未同步过时不传游标，由服务器按设备记录的进度继续
+      const lastSeq = syncMeta?.last_sync_at ? syncMeta.last_server_version : undefined;
 
       // 执行同步
-      const response = await apiService.sync(lastSyncAt, pendingDeltas);
+      const response = await apiService.sync(lastSyncAt, lastSeq, getDeviceId(), pendingDeltas);
 
       // 应用服务器更改
       for (const serverChange of response.server_changes) {
@@ -63,7 +77,7 @@ class SyncManager {
       // 更新同步元数据
       await indexedDBService.updateSyncMeta('current-user', {
         last_sync_at: response.last_sync_at,
-        last_server_version: 0, // 可以跟踪实际的服务器版本
+        last_server_version: response.last_seq,
       });
 
       console.log('同步成功完成');